@@ -46,6 +46,7 @@
                 gnused
                 gnutar
                 gnutls
+                go
                 harfbuzz
                 jansson
                 jq
@@ -0,0 +1,109 @@
+package bundle
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// App provides paths to commonly used locations within a Emacs.app bundle.
+type App struct {
+	Path string
+}
+
+// NewApp returns a App for the application bundle at given path, ensuring it
+// exists.
+func NewApp(path string) (*App, error) {
+	if !strings.HasSuffix(path, ".app") {
+		return nil, fmt.Errorf("%s is not a .app application bundle", path)
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", path)
+	}
+
+	return &App{Path: path}, nil
+}
+
+// Contents returns the path to the Contents directory.
+func (s *App) Contents() string {
+	return filepath.Join(s.Path, "Contents")
+}
+
+// InvocationDir returns the directory which @executable_path refers to.
+func (s *App) InvocationDir() string {
+	return filepath.Join(s.Contents(), "MacOS")
+}
+
+// Binary returns the path to the main Emacs executable. Depending on the
+// version of Emacs, this is either Contents/MacOS/Emacs-bin with a launcher
+// script at Contents/MacOS/Emacs, or the Contents/MacOS/Emacs binary itself.
+func (s *App) Binary() string {
+	bin := filepath.Join(s.InvocationDir(), "Emacs")
+	if fi, err := os.Stat(bin + "-bin"); err == nil && fi.Mode().IsRegular() {
+		return bin + "-bin"
+	}
+
+	return bin
+}
+
+// LibDir returns the directory shared libraries are bundled into.
+func (s *App) LibDir() string {
+	return filepath.Join(s.Contents(), "Frameworks")
+}
+
+// Rel returns given path relative to the application bundle, for use in log
+// output. Paths outside of the bundle are returned as is.
+func (s *App) Rel(path string) string {
+	if !s.Contains(path) {
+		return path
+	}
+
+	rel, err := filepath.Rel(s.Path, path)
+	if err != nil {
+		return path
+	}
+
+	return rel
+}
+
+// Contains reports if given absolute path is located within the bundle.
+func (s *App) Contains(path string) bool {
+	return path == s.Path ||
+		strings.HasPrefix(path, s.Path+string(filepath.Separator))
+}
+
+// ElnFiles returns all native-compilation *.eln files within the bundle.
+func (s *App) ElnFiles() ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(
+		s.Contents(),
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.Type().IsRegular() && strings.HasSuffix(path, ".eln") {
+				files = append(files, path)
+			}
+
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
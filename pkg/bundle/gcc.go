@@ -0,0 +1,222 @@
+package bundle
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho"
+)
+
+// Directories within the application bundle's Frameworks directory GCC
+// libraries are copied to.
+var (
+	GccTargetLibDir       = filepath.Join("gcc", "lib")
+	GccTargetDarwinLibDir = filepath.Join("gcc", "lib", "apple-darwin")
+)
+
+type GccLibsOptions struct {
+	// LibDir is the GCC lib directory to copy, like
+	// "/opt/homebrew/opt/gcc/lib/gcc/14".
+	LibDir string
+
+	// DarwinLibDir is the directory of apple-darwin specific GCC libraries
+	// to copy, like "<LibDir>/gcc/aarch64-apple-darwin23/14".
+	DarwinLibDir string
+
+	// DryRun logs what would be copied without modifying any files.
+	DryRun bool
+}
+
+// GccLibs copies GCC libraries needed by native-compilation into the
+// application bundle's Frameworks directory, with LibDir copied to
+// GccTargetLibDir, and DarwinLibDir to GccTargetDarwinLibDir. Nothing is done
+// if libgcc is already bundled.
+//
+// All rpaths except for @loader_path are removed from the copied libraries,
+// as any other rpaths will potentially cause issues.
+func GccLibs(
+	ctx context.Context,
+	appBundle string,
+	opts *GccLibsOptions,
+) error {
+	logger := hclog.FromContext(ctx).Named("bundle")
+
+	app, err := NewApp(appBundle)
+	if err != nil {
+		return err
+	}
+
+	target := filepath.Join(app.LibDir(), GccTargetLibDir)
+	darwinTarget := filepath.Join(app.LibDir(), GccTargetDarwinLibDir)
+
+	existing, err := filepath.Glob(filepath.Join(target, "libgcc*"))
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		logger.Info("libgccjit already bundled", "app", app.Path)
+
+		return nil
+	}
+
+	logger.Info("bundling libgccjit", "app", app.Path,
+		"lib_dir", opts.LibDir, "darwin_lib_dir", opts.DarwinLibDir,
+	)
+	if opts.DryRun {
+		logger.Info("would copy",
+			"source", opts.LibDir, "target", app.Rel(target),
+		)
+		logger.Info("would copy",
+			"source", opts.DarwinLibDir, "target", app.Rel(darwinTarget),
+		)
+
+		return nil
+	}
+
+	// The lib entry is a symlink to itself when using Nix, and the gcc
+	// directory holds the apple-darwin libraries, which are copied separately.
+	err = copyTree(opts.LibDir, target, func(name string) bool {
+		return name == "lib" || name == "gcc"
+	})
+	if err != nil {
+		return err
+	}
+
+	err = copyTree(opts.DarwinLibDir, darwinTarget, nil)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(
+		target,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case d.Name() == ".DS_Store":
+				return os.Remove(path)
+			case !d.Type().IsRegular():
+				return nil
+			}
+
+			ext := filepath.Ext(path)
+			if ext != ".dylib" && ext != ".so" {
+				return nil
+			}
+
+			return tidyLibRpaths(logger, app, path)
+		},
+	)
+}
+
+// tidyLibRpaths removes all rpaths except for @loader_path from given shared
+// library. Files which are not Mach-O files are ignored.
+func tidyLibRpaths(logger hclog.Logger, app *App, path string) error {
+	mf, err := macho.Open(path)
+	if errors.Is(err, macho.ErrNotMachO) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = deleteRpaths(mf)
+	if err != nil {
+		return err
+	}
+	if mf.Changed() {
+		logger.Debug("tidied up rpaths", "file", app.Rel(path))
+	}
+
+	return mf.Save()
+}
+
+// deleteRpaths removes all rpaths except for @loader_path.
+func deleteRpaths(mf *macho.File) error {
+	for _, r := range mf.Rpaths() {
+		if r == loaderPath {
+			continue
+		}
+
+		err := mf.DeleteRpath(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := mf.RemoveDuplicateRpaths()
+
+	return err
+}
+
+// copyTree recursively copies the content of directory source into target,
+// keeping symlinks as is, and making all files writable by their owner.
+// Entries for which skip returns true are not copied, at any depth.
+func copyTree(source, target string, skip func(name string) bool) error {
+	source, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(
+		source,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(source, path)
+			if err != nil {
+				return err
+			}
+			if rel != "." && skip != nil && skip(d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(target, rel)
+
+			switch {
+			case d.IsDir():
+				err = os.MkdirAll(dst, fi.Mode().Perm()|0o700)
+				if err != nil {
+					return err
+				}
+
+				return os.Chmod(dst, fi.Mode().Perm()|0o200)
+			case d.Type()&fs.ModeSymlink != 0:
+				link, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				err = os.Remove(dst)
+				if err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+
+				return os.Symlink(link, dst)
+			case d.Type().IsRegular():
+				err = copyFile(path, dst)
+				if err != nil {
+					return err
+				}
+
+				return os.Chmod(dst, fi.Mode().Perm()|0o200)
+			default:
+				return nil
+			}
+		},
+	)
+}
@@ -0,0 +1,144 @@
+package bundle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho"
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho/machotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGccFixture creates a fake GCC lib directory and Emacs.app bundle:
+//
+//	gcc/14/libgccjit.0.dylib with rpaths to gcc/14 and @loader_path
+//	gcc/14/libgcc_s.1.dylib -> libgcc_s.1.1.dylib
+//	gcc/14/libfoo.so which is not a Mach-O file
+//	gcc/14/lib -> .
+//	gcc/14/.DS_Store
+//	gcc/14/gcc/aarch64-apple-darwin23/14/libemutls_w.dylib
+//	gcc/14/gcc/aarch64-apple-darwin23/14/crt3.o
+func newGccFixture(t *testing.T) (libDir, darwinDir, app string) {
+	t.Helper()
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+
+	libDir = filepath.Join(dir, "gcc/14")
+	darwinDir = filepath.Join(libDir, "gcc/aarch64-apple-darwin23/14")
+	app = filepath.Join(dir, "Emacs.app")
+
+	for file, opts := range map[string]*machotest.Options{
+		filepath.Join(libDir, "libgccjit.0.dylib"): {
+			ID:     filepath.Join(libDir, "libgccjit.0.dylib"),
+			Rpaths: []string{libDir, "@loader_path", "@loader_path"},
+		},
+		filepath.Join(libDir, "libgcc_s.1.1.dylib"): {
+			ID: filepath.Join(libDir, "libgcc_s.1.1.dylib"),
+		},
+		filepath.Join(darwinDir, "libemutls_w.dylib"): {
+			ID:     filepath.Join(darwinDir, "libemutls_w.dylib"),
+			Rpaths: []string{darwinDir},
+		},
+	} {
+		require.NoError(t, machotest.WriteFile(file, opts))
+		require.NoError(t, os.Chmod(file, 0o444))
+	}
+	for file, content := range map[string]string{
+		filepath.Join(libDir, "libfoo.so"): "not a Mach-O file\n",
+		filepath.Join(libDir, ".DS_Store"): "\x00",
+		filepath.Join(darwinDir, "crt3.o"): "object\n",
+	} {
+		require.NoError(t, os.WriteFile(file, []byte(content), 0o444))
+	}
+	require.NoError(t, os.Symlink(
+		"libgcc_s.1.1.dylib", filepath.Join(libDir, "libgcc_s.1.dylib"),
+	))
+	require.NoError(t, os.Symlink(".", filepath.Join(libDir, "lib")))
+	require.NoError(t, os.MkdirAll(
+		filepath.Join(app, "Contents/Frameworks"), 0o755,
+	))
+
+	return libDir, darwinDir, app
+}
+
+func TestGccLibs(t *testing.T) {
+	libDir, darwinDir, app := newGccFixture(t)
+	target := filepath.Join(app, "Contents/Frameworks/gcc/lib")
+
+	err := GccLibs(context.Background(), app, &GccLibsOptions{
+		LibDir:       libDir,
+		DarwinLibDir: darwinDir,
+	})
+	require.NoError(t, err)
+
+	var files []string
+	require.NoError(t, filepath.Walk(target,
+		func(path string, fi os.FileInfo, err error) error {
+			require.NoError(t, err)
+			rel, err := filepath.Rel(target, path)
+			require.NoError(t, err)
+			files = append(files, rel)
+			if fi.Mode().IsRegular() {
+				assert.Equal(t, os.FileMode(0o644), fi.Mode().Perm(), rel)
+			}
+
+			return nil
+		},
+	))
+	assert.Equal(t, []string{
+		".",
+		"apple-darwin",
+		"apple-darwin/crt3.o",
+		"apple-darwin/libemutls_w.dylib",
+		"libfoo.so",
+		"libgcc_s.1.1.dylib",
+		"libgcc_s.1.dylib",
+		"libgccjit.0.dylib",
+	}, files)
+
+	link, err := os.Readlink(filepath.Join(target, "libgcc_s.1.dylib"))
+	require.NoError(t, err)
+	assert.Equal(t, "libgcc_s.1.1.dylib", link)
+
+	for rel, want := range map[string][]string{
+		"libgccjit.0.dylib":              {"@loader_path"},
+		"apple-darwin/libemutls_w.dylib": nil,
+	} {
+		mf, err := macho.Open(filepath.Join(target, rel))
+		require.NoError(t, err)
+		assert.Equal(t, want, mf.Rpaths(), rel)
+	}
+
+	// Source libraries must not be modified.
+	src, err := macho.Open(filepath.Join(libDir, "libgccjit.0.dylib"))
+	require.NoError(t, err)
+	assert.Equal(t,
+		[]string{libDir, "@loader_path", "@loader_path"}, src.Rpaths(),
+	)
+
+	// Already bundled libraries are left as is.
+	require.NoError(t, os.Remove(filepath.Join(target, "libfoo.so")))
+	err = GccLibs(context.Background(), app, &GccLibsOptions{
+		LibDir:       libDir,
+		DarwinLibDir: darwinDir,
+	})
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(target, "libfoo.so"))
+}
+
+func TestGccLibs_dryRun(t *testing.T) {
+	libDir, darwinDir, app := newGccFixture(t)
+
+	err := GccLibs(context.Background(), app, &GccLibsOptions{
+		LibDir:       libDir,
+		DarwinLibDir: darwinDir,
+		DryRun:       true,
+	})
+	require.NoError(t, err)
+
+	assert.NoDirExists(t, filepath.Join(app, "Contents/Frameworks/gcc"))
+}
@@ -0,0 +1,517 @@
+package bundle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho"
+)
+
+// Errors
+var (
+	Err           = errors.New("bundle")
+	ErrUnresolved = fmt.Errorf("%w: could not resolve path", Err)
+)
+
+const (
+	executablePath = "@executable_path"
+	loaderPath     = "@loader_path"
+	rpathPrefix    = "@rpath"
+)
+
+// DefaultLibSources is the default list of directories shared libraries are
+// bundled from.
+var DefaultLibSources = []string{"/opt/homebrew", "/usr/local", "/nix/store"}
+
+type LibsOptions struct {
+	// LibSources is a list of directory prefixes. Only linked shared
+	// libraries which resolve to a path within one of them are copied into
+	// the bundle.
+	LibSources []string
+
+	// ExtraLibs is a list of additional shared libraries to copy into the
+	// bundle along with their dependencies, even if nothing links to them.
+	ExtraLibs []string
+
+	// RelinkElnFiles also bundles shared libraries used by native-compilation
+	// *.eln files within the bundle.
+	RelinkElnFiles bool
+
+	// DryRun creates and logs the plan without modifying any files.
+	DryRun bool
+}
+
+// Copy is a shared library to copy into the application bundle.
+type Copy struct {
+	Source  string `yaml:"source" json:"source"`
+	Target  string `yaml:"target" json:"target"`
+	DylibID string `yaml:"dylib_id,omitempty" json:"dylib_id,omitempty"`
+}
+
+// Relink is a change of a linked shared library's install name within a
+// Mach-O file.
+type Relink struct {
+	File string `yaml:"file" json:"file"`
+	Old  string `yaml:"old" json:"old"`
+	New  string `yaml:"new" json:"new"`
+}
+
+// LibsPlan describes all changes needed to bundle shared libraries into a
+// application bundle.
+type LibsPlan struct {
+	App    *App      `yaml:"-" json:"-"`
+	Binary string    `yaml:"binary" json:"binary"`
+	Rpath  string    `yaml:"rpath" json:"rpath"`
+	Copy   []*Copy   `yaml:"copy" json:"copy"`
+	Relink []*Relink `yaml:"relink" json:"relink"`
+}
+
+// Libs copies shared libraries the Emacs binary, any extra libraries, and
+// optionally *.eln files depend on into the application bundle's Frameworks
+// directory, and relinks everything to load them from there.
+func Libs(ctx context.Context, appBundle string, opts *LibsOptions) error {
+	logger := hclog.FromContext(ctx).Named("bundle")
+
+	app, err := NewApp(appBundle)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("bundling shared libraries", "app", app.Path)
+	plan, err := PlanLibs(ctx, app, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		for _, c := range plan.Copy {
+			logger.Info("would copy",
+				"source", c.Source, "target", app.Rel(c.Target),
+				"dylib_id", c.DylibID,
+			)
+		}
+		for _, r := range plan.Relink {
+			logger.Info("would relink",
+				"file", app.Rel(r.File), "old", r.Old, "new", r.New,
+			)
+		}
+		logger.Info("would add rpath",
+			"file", app.Rel(plan.Binary), "rpath", plan.Rpath,
+		)
+
+		return nil
+	}
+
+	return plan.Apply(ctx)
+}
+
+// PlanLibs calculates which shared libraries need to be copied into the
+// application bundle, and which load commands need to be rewritten.
+func PlanLibs(
+	ctx context.Context,
+	app *App,
+	opts *LibsOptions,
+) (*LibsPlan, error) {
+	logger := hclog.FromContext(ctx).Named("bundle")
+
+	binary := app.Binary()
+	rel, err := filepath.Rel(filepath.Dir(binary), app.LibDir())
+	if err != nil {
+		return nil, err
+	}
+
+	p := &planner{
+		app:     app,
+		sources: opts.LibSources,
+		logger:  logger,
+		seen:    map[string]bool{},
+		relinks: map[Relink]bool{},
+		plan: &LibsPlan{
+			App:    app,
+			Binary: binary,
+			Rpath:  executablePath + "/" + filepath.ToSlash(rel),
+		},
+	}
+	if len(p.sources) == 0 {
+		p.sources = DefaultLibSources
+	}
+
+	err = p.add(binary, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lib := range opts.ExtraLibs {
+		lib, err = filepath.Abs(lib)
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.add(lib, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.RelinkElnFiles {
+		elnFiles, err := app.ElnFiles()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(elnFiles) > 0 {
+			logger.Info(fmt.Sprintf(
+				"bundling shared libraries for %d *.eln files",
+				len(elnFiles),
+			))
+		}
+		for _, f := range elnFiles {
+			err = p.add(f, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return p.plan, nil
+}
+
+type planner struct {
+	app     *App
+	sources []string
+	logger  hclog.Logger
+	plan    *LibsPlan
+	seen    map[string]bool
+	relinks map[Relink]bool
+}
+
+func (s *planner) add(file string, copyFile bool) error {
+	s.logger.Debug("calculating bundling instructions", "file", s.app.Rel(file))
+
+	mf, err := macho.Open(file)
+	if err != nil {
+		return err
+	}
+
+	loaderDir := filepath.Dir(file)
+	var rpaths []string
+	for _, r := range mf.Rpaths() {
+		resolved, err := s.resolve(r, loaderDir, []string{loaderDir})
+		if err != nil {
+			return err
+		}
+		rpaths = append(rpaths, resolved)
+	}
+	rpaths = append(rpaths, loaderDir)
+
+	relinkFile := file
+	if copyFile {
+		base := filepath.Base(file)
+		relinkFile = filepath.Join(s.app.LibDir(), base)
+		s.addCopy(&Copy{
+			Source:  file,
+			Target:  relinkFile,
+			DylibID: rpathPrefix + "/" + base,
+		})
+	}
+
+	for _, dylib := range mf.Dylibs() {
+		s.logger.Debug("processing shared library", "dylib", dylib)
+
+		libPath, err := s.resolve(dylib, loaderDir, rpaths)
+		if err != nil {
+			return err
+		}
+		if libPath != dylib {
+			s.logger.Debug("resolved", "dylib", dylib, "path", libPath)
+		}
+
+		if !s.fromSources(libPath) {
+			s.logger.Debug("skipping, not from lib sources", "path", libPath)
+
+			continue
+		}
+
+		if _, err = os.Stat(libPath); err != nil {
+			s.logger.Warn("skipping, shared library does not exist",
+				"path", libPath,
+			)
+
+			continue
+		}
+
+		base := filepath.Base(libPath)
+		s.addRelink(&Relink{
+			File: relinkFile,
+			Old:  dylib,
+			New:  rpathPrefix + "/" + base,
+		})
+
+		if s.seen[libPath] {
+			continue
+		}
+		s.seen[libPath] = true
+
+		err = s.add(libPath, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *planner) addCopy(c *Copy) {
+	for _, existing := range s.plan.Copy {
+		if *existing == *c {
+			return
+		}
+	}
+
+	s.plan.Copy = append(s.plan.Copy, c)
+}
+
+func (s *planner) addRelink(r *Relink) {
+	if s.relinks[*r] {
+		return
+	}
+
+	s.relinks[*r] = true
+	s.plan.Relink = append(s.plan.Relink, r)
+}
+
+func (s *planner) fromSources(path string) bool {
+	for _, src := range s.sources {
+		if strings.HasPrefix(path, src) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolve expands @executable_path, @loader_path and @rpath references within
+// given path, returning the real path of the result.
+func (s *planner) resolve(
+	path string,
+	loaderDir string,
+	rpaths []string,
+) (string, error) {
+	return ResolvePath(path, s.app.InvocationDir(), loaderDir, rpaths)
+}
+
+// ResolvePath expands @executable_path, @loader_path and @rpath references
+// within a install name or rpath. The first rpath which yields a existing
+// file is used to resolve @rpath. Symlinks are resolved when the resulting
+// file exists.
+func ResolvePath(
+	path, executableDir, loaderDir string,
+	rpaths []string,
+) (string, error) {
+	abs := strings.ReplaceAll(path, executablePath, executableDir)
+	if loaderDir != "" {
+		abs = strings.ReplaceAll(abs, loaderPath, loaderDir)
+	}
+
+	if strings.Contains(abs, rpathPrefix) {
+		found := ""
+		for _, r := range rpaths {
+			candidate := strings.ReplaceAll(abs, rpathPrefix, r)
+			if _, err := os.Stat(candidate); err == nil {
+				found = candidate
+
+				break
+			}
+		}
+		if found == "" {
+			return "", fmt.Errorf("%w: %s", ErrUnresolved, path)
+		}
+		abs = found
+	}
+
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real, nil
+	}
+
+	return filepath.Clean(abs), nil
+}
+
+// Apply copies and relinks shared libraries as described by the plan.
+func (s *LibsPlan) Apply(ctx context.Context) error {
+	logger := hclog.FromContext(ctx).Named("bundle")
+
+	for _, c := range s.Copy {
+		err := s.copyLib(logger, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	var files []string
+	relinks := map[string][]*Relink{}
+	for _, r := range s.Relink {
+		if _, ok := relinks[r.File]; !ok {
+			files = append(files, r.File)
+		}
+		relinks[r.File] = append(relinks[r.File], r)
+	}
+
+	for _, file := range files {
+		err := s.relinkFile(logger, file, relinks[file])
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Rpath == "" {
+		return nil
+	}
+
+	mf, err := macho.Open(s.Binary)
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("setting rpath",
+		"file", s.App.Rel(s.Binary), "rpath", s.Rpath,
+	)
+	err = mf.AddRpath(s.Rpath)
+	if err != nil {
+		return err
+	}
+
+	removed, err := mf.RemoveDuplicateRpaths()
+	if err != nil {
+		return err
+	}
+	if len(removed) > 0 {
+		logger.Info("removed duplicate rpaths",
+			"file", s.App.Rel(s.Binary), "rpaths", removed,
+		)
+	}
+
+	return mf.Save()
+}
+
+func (s *LibsPlan) copyLib(logger hclog.Logger, c *Copy) error {
+	if _, err := os.Stat(c.Target); err == nil {
+		return nil
+	}
+
+	logger.Debug("copying",
+		"source", c.Source, "target", s.App.Rel(c.Target),
+		"dylib_id", c.DylibID,
+	)
+	err := copyFile(c.Source, c.Target)
+	if err != nil {
+		return err
+	}
+
+	if c.DylibID == "" {
+		return nil
+	}
+
+	mf, err := macho.Open(c.Target)
+	if err != nil {
+		return err
+	}
+
+	err = mf.ChangeID(c.DylibID)
+	if err != nil {
+		return err
+	}
+
+	// Any rpaths other than @loader_path present in embedded libraries will
+	// potentially cause issues.
+	err = deleteRpaths(mf)
+	if err != nil {
+		return err
+	}
+
+	return mf.Save()
+}
+
+func (s *LibsPlan) relinkFile(
+	logger hclog.Logger,
+	file string,
+	relinks []*Relink,
+) error {
+	logger.Debug("changing linked dylibs", "file", s.App.Rel(file))
+
+	mf, err := macho.Open(file)
+	if err != nil {
+		return err
+	}
+
+	done := map[string]bool{}
+	for _, r := range relinks {
+		if done[r.Old] {
+			continue
+		}
+		done[r.Old] = true
+
+		logger.Debug("relinking", "old", r.Old, "new", r.New)
+		err = mf.ChangeInstallName(r.Old, r.New)
+		if errors.Is(err, macho.ErrNotLinked) {
+			logger.Warn("skipping, not linked", "dylib", r.Old)
+
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return mf.Save()
+}
+
+// copyFile copies source to target, following symlinks, and preserving file
+// mode and modification time.
+func copyFile(source, target string) error {
+	err := os.MkdirAll(filepath.Dir(target), 0o755)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(
+		target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm()|0o200,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+
+		return err
+	}
+
+	err = dst.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(target, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(target, fi.ModTime(), fi.ModTime())
+}
@@ -0,0 +1,251 @@
+package bundle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho"
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho/machotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type libsFixture struct {
+	dir  string
+	brew string
+	app  string
+}
+
+// newLibsFixture creates a fake Emacs.app bundle and Homebrew prefix:
+//
+//	brew/lib/libgnutls.30.dylib -> ../Cellar/gnutls/lib/libgnutls.30.dylib
+//	brew/Cellar/gnutls/lib/libgnutls.30.dylib links libnettle, @rpath/libgmp
+//	brew/Cellar/nettle/lib/libnettle.8.dylib
+//	brew/opt/gmp/lib/libgmp.10.dylib
+//	Emacs.app/Contents/MacOS/Emacs links libgnutls and libSystem
+//	Emacs.app/Contents/Resources/native-lisp/foo.eln links libnettle
+func newLibsFixture(t *testing.T) *libsFixture {
+	t.Helper()
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+
+	f := &libsFixture{
+		dir:  dir,
+		brew: filepath.Join(dir, "brew"),
+		app:  filepath.Join(dir, "Emacs.app"),
+	}
+
+	gnutls := filepath.Join(f.brew, "Cellar/gnutls/lib/libgnutls.30.dylib")
+	nettle := filepath.Join(f.brew, "Cellar/nettle/lib/libnettle.8.dylib")
+	gmp := filepath.Join(f.brew, "opt/gmp/lib/libgmp.10.dylib")
+
+	files := map[string]*machotest.Options{
+		gnutls: {
+			ID: filepath.Join(f.brew, "lib/libgnutls.30.dylib"),
+			Dylibs: []string{
+				"/usr/lib/libSystem.B.dylib",
+				nettle,
+				"@rpath/libgmp.10.dylib",
+			},
+			Rpaths: []string{
+				filepath.Join(f.brew, "opt/gmp/lib"),
+				"@loader_path",
+			},
+		},
+		nettle: {
+			ID:     nettle,
+			Dylibs: []string{"/usr/lib/libSystem.B.dylib"},
+		},
+		gmp: {
+			ID:     "@rpath/libgmp.10.dylib",
+			Dylibs: []string{"/usr/lib/libSystem.B.dylib"},
+			Rpaths: []string{"@loader_path", "@loader_path"},
+		},
+		filepath.Join(f.app, "Contents/MacOS/Emacs"): {
+			Dylibs: []string{
+				filepath.Join(f.brew, "lib/libgnutls.30.dylib"),
+				"/usr/lib/libSystem.B.dylib",
+			},
+		},
+		filepath.Join(f.app, "Contents/Resources/native-lisp/foo.eln"): {
+			Type:   0x8, // MH_BUNDLE
+			Dylibs: []string{nettle},
+		},
+	}
+	for file, opts := range files {
+		require.NoError(t, machotest.WriteFile(file, opts))
+	}
+
+	require.NoError(t, os.MkdirAll(filepath.Join(f.brew, "lib"), 0o755))
+	require.NoError(t, os.Symlink(
+		"../Cellar/gnutls/lib/libgnutls.30.dylib",
+		filepath.Join(f.brew, "lib/libgnutls.30.dylib"),
+	))
+
+	return f
+}
+
+func TestPlanLibs(t *testing.T) {
+	f := newLibsFixture(t)
+	app, err := NewApp(f.app)
+	require.NoError(t, err)
+
+	frameworks := filepath.Join(f.app, "Contents/Frameworks")
+	emacs := filepath.Join(f.app, "Contents/MacOS/Emacs")
+	eln := filepath.Join(f.app, "Contents/Resources/native-lisp/foo.eln")
+	cellar := filepath.Join(f.brew, "Cellar")
+
+	got, err := PlanLibs(context.Background(), app, &LibsOptions{
+		LibSources:     []string{f.brew},
+		RelinkElnFiles: true,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, emacs, got.Binary)
+	assert.Equal(t, "@executable_path/../Frameworks", got.Rpath)
+	assert.Equal(t, []*Copy{
+		{
+			Source:  filepath.Join(cellar, "gnutls/lib/libgnutls.30.dylib"),
+			Target:  filepath.Join(frameworks, "libgnutls.30.dylib"),
+			DylibID: "@rpath/libgnutls.30.dylib",
+		},
+		{
+			Source:  filepath.Join(cellar, "nettle/lib/libnettle.8.dylib"),
+			Target:  filepath.Join(frameworks, "libnettle.8.dylib"),
+			DylibID: "@rpath/libnettle.8.dylib",
+		},
+		{
+			Source:  filepath.Join(f.brew, "opt/gmp/lib/libgmp.10.dylib"),
+			Target:  filepath.Join(frameworks, "libgmp.10.dylib"),
+			DylibID: "@rpath/libgmp.10.dylib",
+		},
+	}, got.Copy)
+	assert.Equal(t, []*Relink{
+		{
+			File: emacs,
+			Old:  filepath.Join(f.brew, "lib/libgnutls.30.dylib"),
+			New:  "@rpath/libgnutls.30.dylib",
+		},
+		{
+			File: filepath.Join(frameworks, "libgnutls.30.dylib"),
+			Old:  filepath.Join(cellar, "nettle/lib/libnettle.8.dylib"),
+			New:  "@rpath/libnettle.8.dylib",
+		},
+		{
+			File: filepath.Join(frameworks, "libgnutls.30.dylib"),
+			Old:  "@rpath/libgmp.10.dylib",
+			New:  "@rpath/libgmp.10.dylib",
+		},
+		{
+			File: eln,
+			Old:  filepath.Join(cellar, "nettle/lib/libnettle.8.dylib"),
+			New:  "@rpath/libnettle.8.dylib",
+		},
+	}, got.Relink)
+}
+
+func TestLibs(t *testing.T) {
+	f := newLibsFixture(t)
+	frameworks := filepath.Join(f.app, "Contents/Frameworks")
+
+	err := Libs(context.Background(), f.app, &LibsOptions{
+		LibSources:     []string{f.brew},
+		RelinkElnFiles: true,
+	})
+	require.NoError(t, err)
+
+	emacs, err := macho.Open(filepath.Join(f.app, "Contents/MacOS/Emacs"))
+	require.NoError(t, err)
+	assert.Equal(t,
+		[]string{"@rpath/libgnutls.30.dylib", "/usr/lib/libSystem.B.dylib"},
+		emacs.Dylibs(),
+	)
+	assert.Equal(t, []string{"@executable_path/../Frameworks"}, emacs.Rpaths())
+
+	gnutls, err := macho.Open(filepath.Join(frameworks, "libgnutls.30.dylib"))
+	require.NoError(t, err)
+	assert.Equal(t, "@rpath/libgnutls.30.dylib", gnutls.ID())
+	assert.Equal(t, []string{
+		"/usr/lib/libSystem.B.dylib",
+		"@rpath/libnettle.8.dylib",
+		"@rpath/libgmp.10.dylib",
+	}, gnutls.Dylibs())
+	assert.Equal(t, []string{"@loader_path"}, gnutls.Rpaths())
+
+	gmp, err := macho.Open(filepath.Join(frameworks, "libgmp.10.dylib"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"@loader_path"}, gmp.Rpaths())
+
+	eln, err := macho.Open(
+		filepath.Join(f.app, "Contents/Resources/native-lisp/foo.eln"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"@rpath/libnettle.8.dylib"}, eln.Dylibs())
+
+	// Source libraries must not be modified.
+	src, err := macho.Open(filepath.Join(f.brew, "lib/libgnutls.30.dylib"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(f.brew, "lib/libgnutls.30.dylib"), src.ID())
+}
+
+func TestResolvePath(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "b"), 0o755))
+	require.NoError(t,
+		os.WriteFile(filepath.Join(dir, "b/lib.dylib"), nil, 0o644),
+	)
+
+	tests := []struct {
+		name    string
+		path    string
+		rpaths  []string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "absolute",
+			path: "/usr/lib/libSystem.B.dylib",
+			want: "/usr/lib/libSystem.B.dylib",
+		},
+		{
+			name: "executable path",
+			path: "@executable_path/../Frameworks/lib.dylib",
+			want: "/app/Contents/Frameworks/lib.dylib",
+		},
+		{
+			name: "loader path",
+			path: "@loader_path/lib.dylib",
+			want: "/loader/lib.dylib",
+		},
+		{
+			name:   "rpath",
+			path:   "@rpath/lib.dylib",
+			rpaths: []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")},
+			want:   filepath.Join(dir, "b/lib.dylib"),
+		},
+		{
+			name:    "unresolvable rpath",
+			path:    "@rpath/lib.dylib",
+			rpaths:  []string{filepath.Join(dir, "a")},
+			wantErr: ErrUnresolved,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolvePath(
+				tt.path, "/app/Contents/MacOS", "/loader", tt.rpaths,
+			)
+
+			assert.Equal(t, tt.want, got)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
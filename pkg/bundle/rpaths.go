@@ -0,0 +1,60 @@
+package bundle
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho"
+)
+
+// CleanRpaths removes duplicate rpaths from the Mach-O file at given path, as
+// macOS 15.4 and later refuse to load binaries and shared libraries with
+// duplicate rpaths. If backup is true, the file is copied to "<file>.bak"
+// before it is modified, unless that backup already exists. The removed
+// rpaths are returned.
+func CleanRpaths(
+	ctx context.Context,
+	file string,
+	backup bool,
+) ([]string, error) {
+	logger := hclog.FromContext(ctx).Named("bundle")
+
+	mf, err := macho.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := mf.RemoveDuplicateRpaths()
+	if err != nil {
+		return nil, err
+	}
+	if len(removed) == 0 {
+		logger.Debug("no duplicate rpaths found", "file", file)
+
+		return removed, nil
+	}
+
+	if backup {
+		backupFile := file + ".bak"
+		_, err = os.Lstat(backupFile)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			logger.Debug("backing up", "file", file, "backup", backupFile)
+			err = copyFile(file, backupFile)
+			if err != nil {
+				return nil, err
+			}
+		case err != nil:
+			return nil, err
+		default:
+			logger.Debug("backup already exists", "backup", backupFile)
+		}
+	}
+
+	logger.Info("removing duplicate rpaths", "file", file, "rpaths", removed)
+
+	return removed, mf.Save()
+}
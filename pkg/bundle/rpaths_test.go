@@ -0,0 +1,59 @@
+package bundle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho"
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho/machotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanRpaths(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "libgccjit.0.dylib")
+	require.NoError(t, machotest.WriteFile(file, &machotest.Options{
+		ID:     "@rpath/libgccjit.0.dylib",
+		Rpaths: []string{"@loader_path", "/nix/store/gcc/lib", "@loader_path"},
+	}))
+	require.NoError(t, os.Chmod(file, 0o555))
+	original, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	removed, err := CleanRpaths(ctx, file, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"@loader_path"}, removed)
+
+	mf, err := macho.Open(file)
+	require.NoError(t, err)
+	assert.Equal(t,
+		[]string{"@loader_path", "/nix/store/gcc/lib"}, mf.Rpaths(),
+	)
+
+	fi, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o555), fi.Mode().Perm())
+
+	backup, err := os.ReadFile(file + ".bak")
+	require.NoError(t, err)
+	assert.Equal(t, original, backup)
+
+	removed, err = CleanRpaths(ctx, file, true)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+}
+
+func TestCleanRpaths_noBackup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "Emacs")
+	require.NoError(t, machotest.WriteFile(file, &machotest.Options{
+		Rpaths: []string{"/a", "/a"},
+	}))
+
+	removed, err := CleanRpaths(context.Background(), file, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/a"}, removed)
+	assert.NoFileExists(t, file+".bak")
+}
@@ -0,0 +1,465 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho"
+)
+
+// ErrUniversalMismatch is returned when application bundles can not be merged
+// into a universal bundle, as they differ in more than their Mach-O files.
+var ErrUniversalMismatch = fmt.Errorf(
+	"%w: application bundles do not match", Err,
+)
+
+// Mismatch is a difference between two application bundles, which prevents
+// them from being merged.
+type Mismatch struct {
+	Path   string `yaml:"path" json:"path"`
+	Reason string `yaml:"reason" json:"reason"`
+}
+
+// UniversalReport is the result of merging two application bundles.
+type UniversalReport struct {
+	Output       string      `yaml:"output" json:"output"`
+	Merged       int         `yaml:"merged" json:"merged"`
+	Copied       int         `yaml:"copied" json:"copied"`
+	ArchSpecific int         `yaml:"arch_specific" json:"arch_specific"`
+	Mismatches   []*Mismatch `yaml:"mismatches" json:"mismatches"`
+}
+
+// OK reports if no mismatches were found.
+func (s *UniversalReport) OK() bool {
+	return len(s.Mismatches) == 0
+}
+
+// Universal merges a arm64 and a x86_64 application bundle into a universal
+// bundle at output, which must not already exist. Mach-O files are merged into
+// universal binaries, while all other files, directories and symlinks must be
+// identical in both bundles, except for those specific to the Emacs executable
+// of each architecture:
+//
+//   - Natively compiled Lisp files within native-lisp and eln-cache
+//     directories which only exist in one bundle, as their directory names
+//     include a hash of the Emacs executable, are copied from that bundle.
+//   - Portable dump files within Contents/MacOS/libexec which differ are
+//     copied from both bundles as Emacs-<fingerprint>.pdmp, where the
+//     fingerprint is read from the dump. Emacs 28 and later look for a dump
+//     matching their own fingerprint before falling back to Emacs.pdmp.
+//
+// Any other differences are returned as mismatches in the report. The bundle
+// is assembled in a temporary directory next to output, which is only renamed
+// to output when no mismatches were found, so nothing is left behind when the
+// bundles can not be merged.
+func Universal(
+	ctx context.Context,
+	arm64App, x86App, output string,
+) (*UniversalReport, error) {
+	logger := hclog.FromContext(ctx).Named("universal")
+
+	arm64, err := NewApp(arm64App)
+	if err != nil {
+		return nil, err
+	}
+	x86, err := NewApp(x86App)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err = filepath.Abs(output)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Lstat(output); err == nil {
+		return nil, fmt.Errorf("%w: %s already exists", Err, output)
+	}
+
+	armFiles, err := bundleEntries(arm64.Path)
+	if err != nil {
+		return nil, err
+	}
+	x86Files, err := bundleEntries(x86.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(output), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp(
+		filepath.Dir(output), "."+filepath.Base(output)+".tmp-*",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &merger{
+		arm64:  arm64.Path,
+		x86:    x86.Path,
+		output: tmp,
+		logger: logger,
+		report: &UniversalReport{Output: output, Mismatches: []*Mismatch{}},
+	}
+
+	logger.Info("merging application bundles",
+		"arm64", arm64.Path, "x86_64", x86.Path, "output", output,
+	)
+	err = m.merge(armFiles, x86Files)
+	if err != nil || !m.report.OK() {
+		os.RemoveAll(tmp)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("merge complete",
+		"merged", m.report.Merged, "copied", m.report.Copied,
+		"arch_specific", m.report.ArchSpecific,
+		"mismatches", len(m.report.Mismatches),
+	)
+	if !m.report.OK() {
+		return m.report, nil
+	}
+
+	err = os.Chmod(tmp, armFiles["."].Perm())
+	if err == nil {
+		err = os.Rename(tmp, output)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+
+		return nil, err
+	}
+
+	return m.report, nil
+}
+
+// bundleEntries returns the file modes of all files, directories and symlinks
+// within a application bundle, keyed by their path relative to it.
+func bundleEntries(root string) (map[string]fs.FileMode, error) {
+	entries := map[string]fs.FileMode{}
+	err := filepath.WalkDir(
+		root,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			entries[rel] = fi.Mode()
+
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func sortedKeys(m map[string]fs.FileMode) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+type merger struct {
+	arm64  string
+	x86    string
+	output string
+	logger hclog.Logger
+	report *UniversalReport
+}
+
+// merge merges all entries of both bundles into the output directory.
+// Directories sort before their contents, so they are always created before
+// anything within them.
+func (s *merger) merge(armFiles, x86Files map[string]fs.FileMode) error {
+	for _, rel := range sortedKeys(armFiles) {
+		x86Mode, ok := x86Files[rel]
+		if !ok {
+			err := s.only(s.arm64, rel, armFiles[rel], "arm64")
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err := s.entry(rel, armFiles[rel], x86Mode)
+		if err != nil {
+			return err
+		}
+	}
+	for _, rel := range sortedKeys(x86Files) {
+		if _, ok := armFiles[rel]; ok {
+			continue
+		}
+
+		err := s.only(s.x86, rel, x86Files[rel], "x86_64")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *merger) mismatch(rel, reason string) {
+	s.logger.Error("bundles differ", "path", rel, "reason", reason)
+	s.report.Mismatches = append(s.report.Mismatches, &Mismatch{
+		Path:   rel,
+		Reason: reason,
+	})
+}
+
+func (s *merger) entry(rel string, armMode, x86Mode fs.FileMode) error {
+	if armMode.Type() != x86Mode.Type() {
+		s.mismatch(rel, "file type differs")
+
+		return nil
+	}
+
+	target := filepath.Join(s.output, rel)
+	switch {
+	case armMode.IsDir():
+		return os.MkdirAll(target, armMode.Perm())
+	case armMode&fs.ModeSymlink != 0:
+		return s.symlink(rel, target)
+	case armMode.IsRegular():
+		return s.file(rel, target, armMode.Perm())
+	default:
+		s.mismatch(rel, "unsupported file type")
+
+		return nil
+	}
+}
+
+// only handles a entry which only exists in the bundle at root, which is
+// copied if it is natively compiled Lisp, and a mismatch otherwise.
+func (s *merger) only(root, rel string, mode fs.FileMode, arch string) error {
+	if !nativeLisp(rel) {
+		s.mismatch(rel, "only in "+arch+" bundle")
+
+		return nil
+	}
+
+	source := filepath.Join(root, rel)
+	target := filepath.Join(s.output, rel)
+	switch {
+	case mode.IsDir():
+		return os.MkdirAll(target, mode.Perm())
+	case mode&fs.ModeSymlink != 0:
+		link, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		s.report.ArchSpecific++
+
+		return os.Symlink(link, target)
+	case mode.IsRegular():
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		s.report.ArchSpecific++
+
+		return os.WriteFile(target, data, mode.Perm())
+	default:
+		s.mismatch(rel, "unsupported file type")
+
+		return nil
+	}
+}
+
+// nativeLisp reports if given path is within a native-lisp or eln-cache
+// directory.
+func nativeLisp(rel string) bool {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for _, p := range parts[:len(parts)-1] {
+		if p == "native-lisp" || p == "eln-cache" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *merger) symlink(rel, target string) error {
+	armLink, err := os.Readlink(filepath.Join(s.arm64, rel))
+	if err != nil {
+		return err
+	}
+	x86Link, err := os.Readlink(filepath.Join(s.x86, rel))
+	if err != nil {
+		return err
+	}
+
+	if armLink != x86Link {
+		s.mismatch(rel, "symlink targets differ")
+
+		return nil
+	}
+
+	s.report.Copied++
+
+	return os.Symlink(armLink, target)
+}
+
+func (s *merger) file(rel, target string, perm fs.FileMode) error {
+	armData, err := os.ReadFile(filepath.Join(s.arm64, rel))
+	if err != nil {
+		return err
+	}
+	x86Data, err := os.ReadFile(filepath.Join(s.x86, rel))
+	if err != nil {
+		return err
+	}
+
+	// Identical files need no merging, this includes Mach-O files which are
+	// already universal binaries in both bundles.
+	if bytes.Equal(armData, x86Data) {
+		s.report.Copied++
+
+		return os.WriteFile(target, armData, perm)
+	}
+
+	if filepath.Ext(rel) == ".pdmp" {
+		return s.dump(rel, perm, armData, x86Data)
+	}
+
+	armFile, err := macho.NewFile(armData)
+	if errors.Is(err, macho.ErrNotMachO) {
+		s.mismatch(rel, "content differs")
+
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	x86File, err := macho.NewFile(x86Data)
+	if errors.Is(err, macho.ErrNotMachO) {
+		s.mismatch(rel, "Mach-O file only in arm64 bundle")
+
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	data, err := macho.Merge(armFile, x86File)
+	if errors.Is(err, macho.ErrDuplicateArch) {
+		s.mismatch(rel, err.Error())
+
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	s.logger.Debug("merged Mach-O file", "path", rel,
+		"arm64", armFile.Arches(), "x86_64", x86File.Arches(),
+	)
+	s.report.Merged++
+
+	return os.WriteFile(target, data, perm)
+}
+
+// dumpMagic is the start of the header of Emacs portable dump files, which is
+// followed by the fingerprint of the Emacs executable the dump belongs to.
+var dumpMagic = []byte("DUMPEDGNUEMACS")
+
+const (
+	dumpMagicSize       = 16
+	dumpFingerprintSize = 32
+)
+
+// dumpLibexecDir is the directory Emacs looks for fingerprinted dump files in.
+var dumpLibexecDir = filepath.Join("Contents", "MacOS", "libexec")
+
+// dump writes differing portable dump files of both bundles to the output,
+// named after the fingerprint of their Emacs executable.
+func (s *merger) dump(
+	rel string,
+	perm fs.FileMode,
+	armData, x86Data []byte,
+) error {
+	if filepath.Dir(rel) != dumpLibexecDir {
+		s.mismatch(rel, "dump file differs outside of "+dumpLibexecDir)
+
+		return nil
+	}
+
+	armFingerprint, ok := dumpFingerprint(armData)
+	if !ok {
+		s.mismatch(rel, "invalid dump file in arm64 bundle")
+
+		return nil
+	}
+	x86Fingerprint, ok := dumpFingerprint(x86Data)
+	if !ok {
+		s.mismatch(rel, "invalid dump file in x86_64 bundle")
+
+		return nil
+	}
+	if armFingerprint == x86Fingerprint {
+		s.mismatch(rel, "dump files differ with same fingerprint")
+
+		return nil
+	}
+
+	for _, d := range []struct {
+		fingerprint string
+		data        []byte
+	}{
+		{fingerprint: armFingerprint, data: armData},
+		{fingerprint: x86Fingerprint, data: x86Data},
+	} {
+		name := filepath.Join(s.output, dumpLibexecDir,
+			"Emacs-"+d.fingerprint+".pdmp",
+		)
+		s.logger.Debug("writing dump file", "path", rel, "file", name)
+		err := os.WriteFile(name, d.data, perm)
+		if err != nil {
+			return err
+		}
+		s.report.ArchSpecific++
+	}
+
+	return nil
+}
+
+// dumpFingerprint returns the hex encoded fingerprint of the Emacs executable
+// given portable dump belongs to.
+func dumpFingerprint(data []byte) (string, bool) {
+	if len(data) < dumpMagicSize+dumpFingerprintSize ||
+		!bytes.HasPrefix(data, dumpMagic) {
+		return "", false
+	}
+
+	return hex.EncodeToString(
+		data[dumpMagicSize : dumpMagicSize+dumpFingerprintSize],
+	), true
+}
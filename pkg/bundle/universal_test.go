@@ -0,0 +1,264 @@
+package bundle
+
+import (
+	"context"
+	stdmacho "debug/macho"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho"
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho/machotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newUniversalFixture(t *testing.T, dir string, cpu stdmacho.Cpu) string {
+	t.Helper()
+
+	app := filepath.Join(dir, "Emacs.app")
+	write := func(rel, content string) {
+		t.Helper()
+		path := filepath.Join(app, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	require.NoError(t, machotest.WriteFile(
+		filepath.Join(app, "Contents/MacOS/Emacs"),
+		&machotest.Options{
+			Cpu:    cpu,
+			Dylibs: []string{"/usr/lib/libSystem.B.dylib"},
+		},
+	))
+	require.NoError(t, machotest.WriteFile(
+		filepath.Join(app, "Contents/Frameworks/libgmp.10.dylib"),
+		&machotest.Options{Cpu: cpu, ID: "@rpath/libgmp.10.dylib"},
+	))
+	write("Contents/Info.plist", "<plist/>\n")
+	write("Contents/Resources/lisp/simple.el", ";;; simple.el\n")
+	require.NoError(t, os.Symlink(
+		"../Resources/lisp", filepath.Join(app, "Contents/MacOS/lisp"),
+	))
+
+	return app
+}
+
+func TestUniversal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	arm64 := newUniversalFixture(t, filepath.Join(dir, "arm64"), 0)
+	x86 := newUniversalFixture(
+		t, filepath.Join(dir, "x86_64"), stdmacho.CpuAmd64,
+	)
+	output := filepath.Join(dir, "universal", "Emacs.app")
+
+	got, err := Universal(ctx, arm64, x86, output)
+	require.NoError(t, err)
+
+	assert.True(t, got.OK())
+	assert.Equal(t, output, got.Output)
+	assert.Equal(t, 2, got.Merged)
+	assert.Equal(t, 3, got.Copied)
+
+	for _, rel := range []string{
+		"Contents/MacOS/Emacs",
+		"Contents/Frameworks/libgmp.10.dylib",
+	} {
+		f, err := macho.Open(filepath.Join(output, rel))
+		require.NoError(t, err)
+		assert.True(t, f.Fat(), rel)
+		assert.Equal(t, []string{"x86_64", "arm64"}, f.Arches(), rel)
+	}
+
+	b, err := os.ReadFile(filepath.Join(output, "Contents/Info.plist"))
+	require.NoError(t, err)
+	assert.Equal(t, "<plist/>\n", string(b))
+
+	link, err := os.Readlink(filepath.Join(output, "Contents/MacOS/lisp"))
+	require.NoError(t, err)
+	assert.Equal(t, "../Resources/lisp", link)
+
+	_, err = Universal(ctx, arm64, x86, output)
+	assert.ErrorIs(t, err, Err)
+}
+
+func TestUniversal_mismatches(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	arm64 := newUniversalFixture(t, filepath.Join(dir, "arm64"), 0)
+	x86 := newUniversalFixture(
+		t, filepath.Join(dir, "x86_64"), stdmacho.CpuAmd64,
+	)
+
+	require.NoError(t, os.WriteFile(
+		filepath.Join(x86, "Contents/Info.plist"), []byte("<x/>\n"), 0o644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(arm64, "Contents/Resources/arm64-only.txt"),
+		[]byte("hi\n"), 0o644,
+	))
+	require.NoError(t, machotest.WriteFile(
+		filepath.Join(x86, "Contents/Frameworks/libgmp.10.dylib"),
+		&machotest.Options{
+			ID:     "@rpath/libgmp.10.dylib",
+			Dylibs: []string{"/usr/lib/libSystem.B.dylib"},
+		},
+	))
+	require.NoError(t, os.Remove(filepath.Join(x86, "Contents/MacOS/lisp")))
+	require.NoError(t, os.Symlink(
+		"../Resources", filepath.Join(x86, "Contents/MacOS/lisp"),
+	))
+
+	output := filepath.Join(dir, "universal", "Emacs.app")
+	got, err := Universal(ctx, arm64, x86, output)
+	require.NoError(t, err)
+
+	assert.False(t, got.OK())
+	assert.Equal(t, 1, got.Merged)
+	assert.Equal(t, []*Mismatch{
+		{
+			Path:   "Contents/Frameworks/libgmp.10.dylib",
+			Reason: "macho: duplicate architecture: arm64",
+		},
+		{Path: "Contents/Info.plist", Reason: "content differs"},
+		{Path: "Contents/MacOS/lisp", Reason: "symlink targets differ"},
+		{
+			Path:   "Contents/Resources/arm64-only.txt",
+			Reason: "only in arm64 bundle",
+		},
+	}, got.Mismatches)
+
+	entries, err := os.ReadDir(filepath.Dir(output))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, os.Remove(
+		filepath.Join(arm64, "Contents/Resources/arm64-only.txt"),
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(x86, "Contents/Info.plist"), []byte("<plist/>\n"), 0o644,
+	))
+	require.NoError(t, os.Remove(filepath.Join(x86, "Contents/MacOS/lisp")))
+	require.NoError(t, os.Symlink(
+		"../Resources/lisp", filepath.Join(x86, "Contents/MacOS/lisp"),
+	))
+	require.NoError(t, machotest.WriteFile(
+		filepath.Join(x86, "Contents/Frameworks/libgmp.10.dylib"),
+		&machotest.Options{
+			Cpu: stdmacho.CpuAmd64, ID: "@rpath/libgmp.10.dylib",
+		},
+	))
+
+	got, err = Universal(ctx, arm64, x86, output)
+	require.NoError(t, err)
+	assert.True(t, got.OK())
+	assert.DirExists(t, output)
+}
+
+// testDump returns the content of a portable dump file, with a header
+// containing given fingerprint byte.
+func testDump(fingerprint byte) []byte {
+	data := make([]byte, 64)
+	copy(data, "DUMPEDGNUEMACS")
+	for i := 16; i < 48; i++ {
+		data[i] = fingerprint
+	}
+
+	return data
+}
+
+func TestUniversal_archSpecific(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	arm64 := newUniversalFixture(t, filepath.Join(dir, "arm64"), 0)
+	x86 := newUniversalFixture(
+		t, filepath.Join(dir, "x86_64"), stdmacho.CpuAmd64,
+	)
+
+	const nativeLisp = "Contents/MacOS/lib/emacs/30-1/native-lisp"
+	for app, files := range map[string]map[string][]byte{
+		arm64: {
+			"Contents/MacOS/libexec/Emacs.pdmp":      testDump(0xaa),
+			nativeLisp + "/30-1-aaaaaaaa/simple.eln": []byte("arm64"),
+		},
+		x86: {
+			"Contents/MacOS/libexec/Emacs.pdmp":      testDump(0xbb),
+			nativeLisp + "/30-1-bbbbbbbb/simple.eln": []byte("x86_64"),
+		},
+	} {
+		for rel, data := range files {
+			path := filepath.Join(app, rel)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+			require.NoError(t, os.WriteFile(path, data, 0o644))
+		}
+		require.NoError(t, os.Symlink(
+			"MacOS/lib/emacs/30-1/native-lisp",
+			filepath.Join(app, "Contents/native-lisp"),
+		))
+	}
+
+	output := filepath.Join(dir, "universal", "Emacs.app")
+	got, err := Universal(ctx, arm64, x86, output)
+	require.NoError(t, err)
+
+	assert.True(t, got.OK(), got.Mismatches)
+	assert.Equal(t, 4, got.Copied)
+	assert.Equal(t, 4, got.ArchSpecific)
+
+	libexec := filepath.Join(output, "Contents/MacOS/libexec")
+	entries, err := os.ReadDir(libexec)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{
+		"Emacs-" + strings.Repeat("aa", 32) + ".pdmp",
+		"Emacs-" + strings.Repeat("bb", 32) + ".pdmp",
+	}, names)
+
+	b, err := os.ReadFile(filepath.Join(libexec, names[1]))
+	require.NoError(t, err)
+	assert.Equal(t, testDump(0xbb), b)
+
+	for dir, want := range map[string]string{
+		"30-1-aaaaaaaa": "arm64",
+		"30-1-bbbbbbbb": "x86_64",
+	} {
+		b, err := os.ReadFile(
+			filepath.Join(output, nativeLisp, dir, "simple.eln"),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(b))
+	}
+}
+
+func TestUniversal_invalidDump(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	arm64 := newUniversalFixture(t, filepath.Join(dir, "arm64"), 0)
+	x86 := newUniversalFixture(
+		t, filepath.Join(dir, "x86_64"), stdmacho.CpuAmd64,
+	)
+
+	for app, data := range map[string][]byte{
+		arm64: testDump(0xaa),
+		x86:   []byte("not a dump"),
+	} {
+		path := filepath.Join(app, "Contents/MacOS/libexec/Emacs.pdmp")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+	}
+
+	output := filepath.Join(dir, "universal", "Emacs.app")
+	got, err := Universal(ctx, arm64, x86, output)
+	require.NoError(t, err)
+
+	assert.Equal(t, []*Mismatch{{
+		Path:   "Contents/MacOS/libexec/Emacs.pdmp",
+		Reason: "invalid dump file in x86_64 bundle",
+	}}, got.Mismatches)
+	assert.NoDirExists(t, output)
+}
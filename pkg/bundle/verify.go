@@ -0,0 +1,330 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrVerifyFailed is returned when a verification report contains issues.
+var ErrVerifyFailed = fmt.Errorf("%w: verification failed", Err)
+
+// DefaultSystemPaths is the default list of path prefixes which linked
+// libraries are allowed to reference outside of the application bundle.
+var DefaultSystemPaths = []string{"/usr/lib/", "/System/Library/"}
+
+// IssueType describes the kind of problem found with a load command.
+type IssueType string
+
+// Issue types
+const (
+	OutsideBundle  IssueType = "outside-bundle"
+	Missing        IssueType = "missing"
+	Unresolved     IssueType = "unresolved"
+	DuplicateRpath IssueType = "duplicate-rpath"
+)
+
+// LoadType is the type of load command an issue relates to.
+type LoadType string
+
+// Load types
+const (
+	LoadDylib LoadType = "dylib"
+	LoadRpath LoadType = "rpath"
+)
+
+type VerifyOptions struct {
+	// SystemPaths is a list of path prefixes which are allowed to be
+	// referenced outside of the bundle. These are not checked for existence,
+	// as system libraries live in the dyld shared cache on modern macOS.
+	SystemPaths []string
+}
+
+// Report is the result of verifying a application bundle.
+type Report struct {
+	App    string   `yaml:"app" json:"app"`
+	Files  int      `yaml:"files" json:"files"`
+	Issues []*Issue `yaml:"issues" json:"issues"`
+}
+
+// Issue is a single problem found with a load command of a Mach-O file.
+type Issue struct {
+	File     string    `yaml:"file" json:"file"`
+	Load     LoadType  `yaml:"load" json:"load"`
+	Path     string    `yaml:"path" json:"path"`
+	Resolved string    `yaml:"resolved,omitempty" json:"resolved,omitempty"`
+	Type     IssueType `yaml:"type" json:"type"`
+}
+
+// OK reports if no issues were found.
+func (s *Report) OK() bool {
+	return len(s.Issues) == 0
+}
+
+// WriteYAML writes report in YAML format to given io.Writer.
+func (s *Report) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	return enc.Encode(s)
+}
+
+// YAML returns report in YAML format.
+func (s *Report) YAML() (string, error) {
+	var buf bytes.Buffer
+	err := s.WriteYAML(&buf)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// WriteJSON writes report in JSON format to given io.Writer.
+func (s *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(s)
+}
+
+// JSON returns report in JSON format.
+func (s *Report) JSON() (string, error) {
+	var buf bytes.Buffer
+	err := s.WriteJSON(&buf)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// FindApp returns the path to a Emacs.app bundle given either the bundle
+// itself, or a directory containing it, like the source directory of a disk
+// image.
+func FindApp(path string) (string, error) {
+	if strings.HasSuffix(strings.TrimSuffix(path, "/"), ".app") {
+		return path, nil
+	}
+
+	app := filepath.Join(path, "Emacs.app")
+	_, err := os.Stat(app)
+	if err != nil {
+		return "", err
+	}
+
+	return app, nil
+}
+
+// Verify checks that every Mach-O file within a application bundle only links
+// against libraries within the bundle or system paths, that all linked
+// libraries exist, and that rpaths do not point outside of the bundle.
+func Verify(
+	ctx context.Context,
+	path string,
+	opts *VerifyOptions,
+) (*Report, error) {
+	logger := hclog.FromContext(ctx).Named("verify")
+
+	appPath, err := FindApp(path)
+	if err != nil {
+		return nil, err
+	}
+
+	app, err := NewApp(appPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if real, err2 := filepath.EvalSymlinks(app.Path); err2 == nil {
+		app.Path = real
+	}
+
+	v := &verifier{
+		app:         app,
+		systemPaths: opts.SystemPaths,
+		logger:      logger,
+		report:      &Report{App: app.Path, Issues: []*Issue{}},
+	}
+	if len(v.systemPaths) == 0 {
+		v.systemPaths = DefaultSystemPaths
+	}
+
+	// Everything in the bundle is loaded by the main executable, so its
+	// rpaths are also used when resolving @rpath references in other files.
+	if mf, err2 := macho.Open(app.Binary()); err2 == nil {
+		v.mainRpaths = v.resolveRpaths(mf, app.InvocationDir())
+	}
+
+	logger.Info("verifying linkage", "app", app.Path)
+	err = filepath.WalkDir(
+		app.Contents(),
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			return v.file(path)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("verification complete",
+		"files", v.report.Files, "issues", len(v.report.Issues),
+	)
+
+	return v.report, nil
+}
+
+type verifier struct {
+	app         *App
+	systemPaths []string
+	mainRpaths  []string
+	logger      hclog.Logger
+	report      *Report
+}
+
+func (s *verifier) file(path string) error {
+	ok, err := macho.IsMachO(path)
+	if err != nil || !ok {
+		return err
+	}
+
+	mf, err := macho.Open(path)
+	if errors.Is(err, macho.ErrNotMachO) {
+		s.logger.Warn("skipping unparsable file", "file", s.app.Rel(path))
+
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	s.logger.Debug("checking", "file", s.app.Rel(path))
+	s.report.Files++
+
+	loaderDir := filepath.Dir(path)
+	seen := map[string]bool{}
+	for _, r := range mf.Rpaths() {
+		if seen[r] {
+			s.add(path, LoadRpath, r, "", DuplicateRpath)
+
+			continue
+		}
+		seen[r] = true
+
+		// Rpaths can not themselves be resolved through other rpaths.
+		if strings.HasPrefix(r, rpathPrefix) {
+			continue
+		}
+
+		resolved, err := ResolvePath(r, s.app.InvocationDir(), loaderDir, nil)
+		if err != nil {
+			return err
+		}
+
+		if !s.app.Contains(resolved) && !s.isSystem(resolved) {
+			if resolved == filepath.Clean(r) {
+				resolved = ""
+			}
+			s.add(path, LoadRpath, r, resolved, OutsideBundle)
+		}
+	}
+
+	rpaths := append(s.resolveRpaths(mf, loaderDir), s.mainRpaths...)
+	weak := map[string]bool{}
+	for _, d := range mf.WeakDylibs() {
+		weak[d] = true
+	}
+
+	for _, dylib := range mf.Dylibs() {
+		if s.isSystem(dylib) {
+			continue
+		}
+
+		resolved, err := ResolvePath(
+			dylib, s.app.InvocationDir(), loaderDir, rpaths,
+		)
+		if errors.Is(err, ErrUnresolved) {
+			if !weak[dylib] {
+				s.add(path, LoadDylib, dylib, "", Unresolved)
+			}
+
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !s.app.Contains(resolved) {
+			s.add(path, LoadDylib, dylib, resolved, OutsideBundle)
+
+			continue
+		}
+
+		if _, err := os.Stat(resolved); err != nil && !weak[dylib] {
+			s.add(path, LoadDylib, dylib, resolved, Missing)
+		}
+	}
+
+	return nil
+}
+
+func (s *verifier) resolveRpaths(mf *macho.File, loaderDir string) []string {
+	var rpaths []string
+	for _, r := range mf.Rpaths() {
+		resolved, err := ResolvePath(
+			r, s.app.InvocationDir(), loaderDir, []string{loaderDir},
+		)
+		if err != nil {
+			continue
+		}
+		rpaths = append(rpaths, resolved)
+	}
+
+	return rpaths
+}
+
+func (s *verifier) isSystem(path string) bool {
+	for _, p := range s.systemPaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *verifier) add(
+	file string,
+	load LoadType,
+	path, resolved string,
+	typ IssueType,
+) {
+	issue := &Issue{
+		File:     s.app.Rel(file),
+		Load:     load,
+		Path:     path,
+		Resolved: resolved,
+		Type:     typ,
+	}
+
+	s.logger.Error("linkage issue",
+		"file", issue.File, "load", load, "path", path, "type", typ,
+	)
+	s.report.Issues = append(s.report.Issues, issue)
+}
@@ -0,0 +1,124 @@
+package bundle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho/machotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	f := newLibsFixture(t)
+	ctx := context.Background()
+
+	gnutls := filepath.Join(f.brew, "lib/libgnutls.30.dylib")
+	nettle := filepath.Join(f.brew, "Cellar/nettle/lib/libnettle.8.dylib")
+	realGnutls := filepath.Join(f.brew, "Cellar/gnutls/lib/libgnutls.30.dylib")
+
+	// Before bundling, Emacs and *.eln files link against Homebrew.
+	got, err := Verify(ctx, f.dir, &VerifyOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, f.app, got.App)
+	assert.Equal(t, 2, got.Files)
+	assert.Equal(t, []*Issue{
+		{
+			File:     "Contents/MacOS/Emacs",
+			Load:     LoadDylib,
+			Path:     gnutls,
+			Resolved: realGnutls,
+			Type:     OutsideBundle,
+		},
+		{
+			File:     "Contents/Resources/native-lisp/foo.eln",
+			Load:     LoadDylib,
+			Path:     nettle,
+			Resolved: nettle,
+			Type:     OutsideBundle,
+		},
+	}, got.Issues)
+	assert.False(t, got.OK())
+
+	err = Libs(ctx, f.app, &LibsOptions{
+		LibSources:     []string{f.brew},
+		RelinkElnFiles: true,
+	})
+	require.NoError(t, err)
+
+	got, err = Verify(ctx, f.app, &VerifyOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, got.Files)
+	assert.Equal(t, []*Issue{}, got.Issues)
+	assert.True(t, got.OK())
+
+	// Break the bundle in a variety of ways.
+	frameworks := filepath.Join(f.app, "Contents/Frameworks")
+	require.NoError(t, os.Remove(filepath.Join(frameworks, "libgmp.10.dylib")))
+	require.NoError(t, machotest.WriteFile(
+		filepath.Join(frameworks, "libbroken.dylib"),
+		&machotest.Options{
+			ID: "@rpath/libbroken.dylib",
+			Dylibs: []string{
+				"@loader_path/libnope.dylib",
+				"/nix/store/abc-zlib/lib/libz.dylib",
+				"/usr/lib/libSystem.B.dylib",
+			},
+			WeakDylibs: []string{"@rpath/libweak.dylib"},
+			Rpaths: []string{
+				"@loader_path", "/nix/store", "@loader_path/../../..",
+				"@loader_path",
+			},
+		},
+	))
+
+	got, err = Verify(ctx, f.app, &VerifyOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, got.Files)
+	assert.Equal(t, []*Issue{
+		{
+			File: "Contents/Frameworks/libbroken.dylib",
+			Load: LoadRpath,
+			Path: "/nix/store",
+			Type: OutsideBundle,
+		},
+		{
+			File:     "Contents/Frameworks/libbroken.dylib",
+			Load:     LoadRpath,
+			Path:     "@loader_path/../../..",
+			Resolved: filepath.Dir(f.app),
+			Type:     OutsideBundle,
+		},
+		{
+			File: "Contents/Frameworks/libbroken.dylib",
+			Load: LoadRpath,
+			Path: "@loader_path",
+			Type: DuplicateRpath,
+		},
+		{
+			File:     "Contents/Frameworks/libbroken.dylib",
+			Load:     LoadDylib,
+			Path:     "@loader_path/libnope.dylib",
+			Resolved: filepath.Join(frameworks, "libnope.dylib"),
+			Type:     Missing,
+		},
+		{
+			File:     "Contents/Frameworks/libbroken.dylib",
+			Load:     LoadDylib,
+			Path:     "/nix/store/abc-zlib/lib/libz.dylib",
+			Resolved: "/nix/store/abc-zlib/lib/libz.dylib",
+			Type:     OutsideBundle,
+		},
+		{
+			File: "Contents/Frameworks/libgnutls.30.dylib",
+			Load: LoadDylib,
+			Path: "@rpath/libgmp.10.dylib",
+			Type: Unresolved,
+		},
+	}, got.Issues)
+}
@@ -1,5 +1,25 @@
 package cask
 
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+)
+
+// ErrNoLiveCheckRegex is returned when a cask template has no livecheck regex
+// to match release names with.
+var ErrNoLiveCheckRegex = fmt.Errorf(
+	"%w: no livecheck regex in cask template", Err,
+)
+
 type LiveCheck struct {
 	Cask    string           `json:"cask"`
 	Version LiveCheckVersion `json:"version"`
@@ -11,3 +31,248 @@ type LiveCheckVersion struct {
 	Outdated          bool   `json:"outdated"`
 	NewerThanUpstream bool   `json:"newer_than_upstream"`
 }
+
+// Downgrade reports if the latest version sorts before the current version,
+// which happens when livecheck picks up a older release. Versions which can
+// not be parsed are never considered downgrades.
+func (s *LiveCheckVersion) Downgrade() bool {
+	current, err := release.ParseVersion(s.Current)
+	if err != nil {
+		return false
+	}
+	latest, err := release.ParseVersion(s.Latest)
+	if err != nil {
+		return false
+	}
+
+	return latest.Compare(current) < 0
+}
+
+var (
+	// caskVersionMatcher matches the version stanza of a cask.
+	caskVersionMatcher = regexp.MustCompile(
+		`(?m)^\s*version\s+"([^"]+)"`,
+	)
+
+	// liveCheckRegexMatcher matches the regex of a livecheck block, like
+	// `regex(/^Emacs\.(\d{4}-\d{2}-\d{2}\.\h+\.master)$/i)`.
+	liveCheckRegexMatcher = regexp.MustCompile(
+		`(?m)^\s*regex\(?\s*/((?:\\.|[^/\\\n])*)/([a-z]*)`,
+	)
+)
+
+// LiveCheck does what "brew livecheck --cask --json" does for given casks,
+// without requiring Homebrew. Casks default to all casks with a template.
+//
+// The latest version of each cask is taken from the newest published release
+// whose name matches the livecheck regex in the cask's template, using the
+// first capture group as the version. The current version is read from the
+// cask file in OutputDir when set, and otherwise from the tap repository.
+func (s *Updater) LiveCheck(
+	ctx context.Context,
+	casks []string,
+) ([]*LiveCheck, error) {
+	if s.TapRepo == nil && s.OutputDir == "" {
+		return nil, ErrNoTapOrOutput
+	}
+
+	if len(casks) == 0 {
+		var err error
+		casks, err = s.templateCasks()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.logger.Info("listing releases")
+	releases, err := s.BuildsStore.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var checks []*LiveCheck
+	for _, cask := range casks {
+		re, err := s.liveCheckRegex(cask)
+		if err != nil {
+			return nil, err
+		}
+
+		latest := latestVersion(releases, re)
+		if latest == "" {
+			s.logger.Warn("skipping", "cask", cask,
+				"reason", "no release matches livecheck regex",
+				"regex", re.String(),
+			)
+
+			continue
+		}
+
+		current, err := s.currentVersion(ctx, cask)
+		if err != nil {
+			return nil, err
+		}
+
+		chk := &LiveCheck{
+			Cask:    cask,
+			Version: newLiveCheckVersion(current, latest),
+		}
+		s.logger.Info("livecheck", "cask", cask,
+			"current", current, "latest", latest,
+			"outdated", chk.Version.Outdated,
+		)
+		checks = append(checks, chk)
+	}
+
+	return checks, nil
+}
+
+// templateCasks returns the names of all casks with a template.
+func (s *Updater) templateCasks() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.TemplatesDir, "*.rb.tpl"))
+	if err != nil {
+		return nil, err
+	}
+
+	casks := make([]string, 0, len(files))
+	for _, f := range files {
+		casks = append(casks, strings.TrimSuffix(filepath.Base(f), ".rb.tpl"))
+	}
+	sort.Strings(casks)
+
+	return casks, nil
+}
+
+// liveCheckRegex returns the livecheck regex of given cask's template,
+// translated from Ruby to Go syntax.
+func (s *Updater) liveCheckRegex(cask string) (*regexp.Regexp, error) {
+	b, err := os.ReadFile(filepath.Join(s.TemplatesDir, cask+".rb.tpl"))
+	if err != nil {
+		return nil, err
+	}
+
+	m := liveCheckRegexMatcher.FindStringSubmatch(string(b))
+	if m == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoLiveCheckRegex, cask)
+	}
+
+	re, err := rubyRegexp(m[1], m[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrNoLiveCheckRegex, cask, err)
+	}
+
+	return re, nil
+}
+
+// rubyRegexp compiles a Ruby regular expression literal's source and flags.
+// Only the "i" and "m" flags, and the "\h" hex digit class, are translated,
+// which covers the regexes used by livecheck blocks.
+func rubyRegexp(src, flags string) (*regexp.Regexp, error) {
+	src = strings.ReplaceAll(src, `\/`, `/`)
+	src = strings.ReplaceAll(src, `\h`, `[0-9a-fA-F]`)
+
+	var prefix string
+	if strings.Contains(flags, "i") {
+		prefix += "i"
+	}
+	// Ruby's multiline flag makes "." match newlines.
+	if strings.Contains(flags, "m") {
+		prefix += "s"
+	}
+	if prefix != "" {
+		src = "(?" + prefix + ")" + src
+	}
+
+	return regexp.Compile(src)
+}
+
+// latestVersion returns the newest version extracted from the names of
+// published releases with given regex, or an empty string if no release
+// matches.
+func latestVersion(releases []*release.Release, re *regexp.Regexp) string {
+	var latest string
+	var latestVersion *release.Version
+	for _, rel := range releases {
+		if rel.Draft {
+			continue
+		}
+
+		m := re.FindStringSubmatch(rel.Name)
+		if m == nil {
+			continue
+		}
+		version := m[0]
+		if len(m) > 1 {
+			version = m[1]
+		}
+
+		v, err := release.ParseVersion(version)
+		if err != nil {
+			continue
+		}
+		if latestVersion == nil || v.Compare(latestVersion) > 0 {
+			latest, latestVersion = version, v
+		}
+	}
+
+	return latest
+}
+
+// currentVersion returns the version of given cask's existing cask file, or
+// an empty string if the cask does not exist yet.
+func (s *Updater) currentVersion(
+	ctx context.Context,
+	cask string,
+) (string, error) {
+	var content string
+	if s.OutputDir != "" {
+		b, err := os.ReadFile(filepath.Join(s.OutputDir, cask+".rb"))
+		if os.IsNotExist(err) {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+		content = string(b)
+	} else {
+		repoContent, _, resp, err := s.gh.Repositories.GetContents(
+			ctx, s.TapRepo.Owner(), s.TapRepo.Name(),
+			filepath.Join("Casks", cask+".rb"),
+			&github.RepositoryContentGetOptions{Ref: s.Ref},
+		)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+
+		content, err = repoContent.GetContent()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	m := caskVersionMatcher.FindStringSubmatch(content)
+	if m == nil {
+		return "", nil
+	}
+
+	return m[1], nil
+}
+
+// newLiveCheckVersion compares current and latest versions the way brew
+// livecheck does. Casks without a current version are always outdated.
+func newLiveCheckVersion(current, latest string) LiveCheckVersion {
+	v := LiveCheckVersion{Current: current, Latest: latest}
+
+	c, cErr := release.ParseVersion(current)
+	l, lErr := release.ParseVersion(latest)
+	if cErr != nil || lErr != nil {
+		v.Outdated = current != latest
+
+		return v
+	}
+
+	v.Outdated = l.Compare(c) > 0
+	v.NewerThanUpstream = c.Compare(l) > 0
+
+	return v
+}
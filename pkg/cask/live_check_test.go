@@ -0,0 +1,206 @@
+package cask
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveCheckVersion_Downgrade(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		latest  string
+		want    bool
+	}{
+		{name: "newer stable", current: "29.4", latest: "30.1", want: false},
+		{name: "older stable", current: "30.1", latest: "29.4", want: true},
+		{
+			name:    "stable after release candidate",
+			current: "30.1-rc1",
+			latest:  "30.1",
+			want:    false,
+		},
+		{
+			name:    "pretest after stable",
+			current: "30.1",
+			latest:  "30.0.93-pretest",
+			want:    true,
+		},
+		{
+			name:    "newer nightly",
+			current: "2024-06-01.abcdef0.master",
+			latest:  "2024-06-02.1234567.master",
+			want:    false,
+		},
+		{
+			name:    "older nightly",
+			current: "2024-06-02.1234567.master",
+			latest:  "2024-06-01.abcdef0.master",
+			want:    true,
+		},
+		{name: "unparsable", current: "latest", latest: "29.4", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &LiveCheckVersion{Current: tt.current, Latest: tt.latest}
+
+			assert.Equal(t, tt.want, v.Downgrade())
+		})
+	}
+}
+
+const nightlyTemplate = `cask "emacs-app-nightly" do
+  version "{{ .Version }}"
+
+  livecheck do
+    url "https://github.com/jimeh/emacs-builds.git"
+    regex(/^Emacs\.(\d{4}-\d{2}-\d{2}\.\h{7}\.master)$/i)
+  end
+end
+`
+
+const stableTemplate = `cask "emacs-app" do
+  version "{{ .Version }}"
+
+  livecheck do
+    url "https://github.com/jimeh/emacs-builds.git"
+    regex(/^Emacs-(\d+\.\d+(?:\.\d+)?)$/i)
+  end
+end
+`
+
+func TestUpdater_LiveCheck(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := release.NewDirStore(t.TempDir(), "")
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "Emacs.dmg")
+	require.NoError(t, os.WriteFile(file, []byte("dmg"), 0o644))
+	for _, rel := range []*release.Release{
+		{Name: "Emacs-29.3"},
+		{Name: "Emacs-29.4"},
+		{Name: "Emacs-30.1", Draft: true},
+		{Name: "Emacs-30.0.93-pretest"},
+		{Name: "Emacs.2024-06-01.abcdef0.master"},
+		{Name: "Emacs.2024-06-30.1234567.master"},
+		{Name: "Emacs.2024-07-01.7654321.emacs-30"},
+	} {
+		_, err := store.Publish(
+			ctx, rel, []string{file}, &release.PublishOptions{},
+		)
+		require.NoError(t, err)
+	}
+
+	templates := t.TempDir()
+	for name, content := range map[string]string{
+		"emacs-app.rb.tpl":         stableTemplate,
+		"emacs-app-nightly.rb.tpl": nightlyTemplate,
+		"_helpers.tpl":             `{{ define "x" }}{{ end }}`,
+	} {
+		require.NoError(t, os.WriteFile(
+			filepath.Join(templates, name), []byte(content), 0o644,
+		))
+	}
+
+	output := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(output, "emacs-app.rb"),
+		[]byte("cask \"emacs-app\" do\n  version \"29.4\"\nend\n"), 0o644,
+	))
+
+	updater := &Updater{
+		BuildsStore:  store,
+		OutputDir:    output,
+		TemplatesDir: templates,
+		logger:       hclog.NewNullLogger(),
+	}
+
+	got, err := updater.LiveCheck(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*LiveCheck{
+		{
+			Cask: "emacs-app",
+			Version: LiveCheckVersion{
+				Current: "29.4",
+				Latest:  "29.4",
+			},
+		},
+		{
+			Cask: "emacs-app-nightly",
+			Version: LiveCheckVersion{
+				Latest:   "2024-06-30.1234567.master",
+				Outdated: true,
+			},
+		},
+	}, got)
+
+	_, err = updater.LiveCheck(ctx, []string{"emacs-app-missing"})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, os.WriteFile(
+		filepath.Join(templates, "emacs-app-plain.rb.tpl"),
+		[]byte(`cask "emacs-app-plain" do`), 0o644,
+	))
+	_, err = updater.LiveCheck(ctx, []string{"emacs-app-plain"})
+	assert.ErrorIs(t, err, ErrNoLiveCheckRegex)
+}
+
+func TestNewLiveCheckVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		latest  string
+		want    LiveCheckVersion
+	}{
+		{
+			name:    "outdated",
+			current: "29.3",
+			latest:  "29.4",
+			want: LiveCheckVersion{
+				Current: "29.3", Latest: "29.4", Outdated: true,
+			},
+		},
+		{
+			name:    "up to date",
+			current: "29.4",
+			latest:  "29.4",
+			want:    LiveCheckVersion{Current: "29.4", Latest: "29.4"},
+		},
+		{
+			name:    "newer than upstream",
+			current: "2024-06-30.1234567.master",
+			latest:  "2024-06-01.abcdef0.master",
+			want: LiveCheckVersion{
+				Current:           "2024-06-30.1234567.master",
+				Latest:            "2024-06-01.abcdef0.master",
+				NewerThanUpstream: true,
+			},
+		},
+		{
+			name:    "no current version",
+			current: "",
+			latest:  "29.4",
+			want:    LiveCheckVersion{Latest: "29.4", Outdated: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, newLiveCheckVersion(tt.current, tt.latest))
+		})
+	}
+}
+
+func TestRubyRegexp(t *testing.T) {
+	re, err := rubyRegexp(`^emacs\.(\h+)\/x$`, "i")
+	require.NoError(t, err)
+
+	assert.Equal(t, `(?i)^emacs\.([0-9a-fA-F]+)/x$`, re.String())
+	assert.True(t, re.MatchString("Emacs.BEEF/x"))
+}
@@ -0,0 +1,468 @@
+package cask
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/hexops/gotextdiff"
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+)
+
+// ErrAutoMerge is returned when auto-merge could not be enabled for a pull
+// request.
+var ErrAutoMerge = fmt.Errorf("%w: failed to enable auto-merge", Err)
+
+// DefaultPullRequestBranch is the default branch of the tap repository which
+// cask changes are committed to in pull request mode.
+const DefaultPullRequestBranch = "cask-updates"
+
+// maxPullRequestBody is the length beyond which diffs are left out of pull
+// request descriptions, as GitHub limits them to 65536 characters.
+const maxPullRequestBody = 60000
+
+type PullRequestOptions struct {
+	// Branch is the branch cask changes are committed to. It is reset to the
+	// base branch on every update, so it only ever holds a single commit.
+	// Defaults to DefaultPullRequestBranch.
+	Branch string
+
+	// AutoMerge enables auto-merge of the pull request, so it is merged once
+	// all required checks pass.
+	AutoMerge bool
+
+	// MergeMethod is the method used by auto-merge, one of "merge", "squash"
+	// or "rebase". Defaults to "squash".
+	MergeMethod string
+}
+
+// mergeMethod returns the GraphQL merge method of the options, validating it
+// before any changes are made to the tap repository.
+func (s *PullRequestOptions) mergeMethod() (string, error) {
+	method := strings.ToUpper(s.MergeMethod)
+	switch method {
+	case "":
+		return "SQUASH", nil
+	case "MERGE", "SQUASH", "REBASE":
+		return method, nil
+	default:
+		return "", fmt.Errorf(
+			"%w: unknown merge method %q", ErrAutoMerge, s.MergeMethod,
+		)
+	}
+}
+
+// caskChange is a change to a cask file which is part of a pull request.
+type caskChange struct {
+	chk      *LiveCheck
+	filename string
+	content  []byte
+	created  bool
+	diff     string
+}
+
+// UpdatePullRequest commits changes to all given casks as a single commit on
+// a branch of the tap repository, and opens a pull request from it against
+// Ref, or the default branch if Ref is empty. An existing open pull request
+// from the branch is updated instead.
+func (s *Updater) UpdatePullRequest(
+	ctx context.Context,
+	checks []*LiveCheck,
+	force bool,
+	opts *PullRequestOptions,
+) error {
+	if s.TapRepo == nil {
+		return ErrNoTapOrOutput
+	}
+
+	var method string
+	if opts.AutoMerge {
+		var err error
+		method, err = opts.mergeMethod()
+		if err != nil {
+			return err
+		}
+	}
+
+	branch := opts.Branch
+	if branch == "" {
+		branch = DefaultPullRequestBranch
+	}
+
+	base, err := s.baseBranch(ctx)
+	if err != nil {
+		return err
+	}
+
+	baseRef, _, err := s.gh.Git.GetRef(
+		ctx, s.TapRepo.Owner(), s.TapRepo.Name(), "heads/"+base,
+	)
+	if err != nil {
+		return err
+	}
+	baseSHA := baseRef.GetObject().GetSHA()
+
+	var changes []*caskChange
+	for _, chk := range checks {
+		if s.skip(chk, force) {
+			continue
+		}
+
+		change, err := s.caskChange(ctx, chk, baseSHA)
+		if err != nil {
+			return err
+		}
+		if change != nil {
+			changes = append(changes, change)
+		}
+	}
+
+	if len(changes) == 0 {
+		s.logger.Info("no cask changes, skipping pull request")
+
+		return nil
+	}
+
+	title, message := commitMessage(changes)
+	commitSHA, err := s.commitChanges(ctx, baseSHA, message, changes)
+	if err != nil {
+		return err
+	}
+
+	err = s.pushBranch(ctx, branch, commitSHA)
+	if err != nil {
+		return err
+	}
+
+	pr, err := s.openPullRequest(
+		ctx, base, branch, title, pullRequestBody(changes),
+	)
+	if err != nil {
+		return err
+	}
+
+	if opts.AutoMerge {
+		err = s.enableAutoMerge(ctx, pr, method)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// baseBranch returns the branch pull requests are opened against.
+func (s *Updater) baseBranch(ctx context.Context) (string, error) {
+	if s.Ref != "" {
+		return strings.TrimPrefix(s.Ref, "refs/heads/"), nil
+	}
+
+	repo, _, err := s.gh.Repositories.Get(
+		ctx, s.TapRepo.Owner(), s.TapRepo.Name(),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return repo.GetDefaultBranch(), nil
+}
+
+// caskChange renders given cask, and returns the change to its cask file at
+// given commit, or nil if the cask is unchanged.
+func (s *Updater) caskChange(
+	ctx context.Context,
+	chk *LiveCheck,
+	ref string,
+) (*caskChange, error) {
+	content, err := s.renderCask(ctx, chk)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := filepath.Join("Casks", chk.Cask+".rb")
+	repoContent, _, resp, err := s.gh.Repositories.GetContents(
+		ctx, s.TapRepo.Owner(), s.TapRepo.Name(), filename,
+		&github.RepositoryContentGetOptions{Ref: ref},
+	)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return nil, err
+	}
+
+	change := &caskChange{
+		chk:      chk,
+		filename: filename,
+		content:  content,
+		created:  repoContent == nil,
+	}
+
+	var existing string
+	if repoContent != nil {
+		existing, err = repoContent.GetContent()
+		if err != nil {
+			return nil, err
+		}
+
+		if existing == string(content) {
+			s.logger.Info(
+				"skip update: no change to cask content",
+				"cask", chk.Cask, "file", filename,
+			)
+
+			return nil, nil
+		}
+	}
+
+	edits := myers.ComputeEdits(
+		span.URIFromPath(filename), existing, string(content),
+	)
+	change.diff = fmt.Sprint(gotextdiff.ToUnified(
+		filename, filename, existing, edits,
+	))
+
+	s.logger.Info(
+		"updating cask",
+		"cask", chk.Cask, "version", chk.Version.Latest, "file", filename,
+		"diff", change.diff,
+	)
+
+	return change, nil
+}
+
+// commitChanges creates a commit of all changes on top of given commit, and
+// returns its SHA.
+func (s *Updater) commitChanges(
+	ctx context.Context,
+	parentSHA string,
+	message string,
+	changes []*caskChange,
+) (string, error) {
+	owner, name := s.TapRepo.Owner(), s.TapRepo.Name()
+
+	parent, _, err := s.gh.Git.GetCommit(ctx, owner, name, parentSHA)
+	if err != nil {
+		return "", err
+	}
+
+	entries := make([]*github.TreeEntry, 0, len(changes))
+	for _, c := range changes {
+		entries = append(entries, &github.TreeEntry{
+			Path:    github.String(filepath.ToSlash(c.filename)),
+			Mode:    github.String("100644"),
+			Type:    github.String("blob"),
+			Content: github.String(string(c.content)),
+		})
+	}
+
+	tree, _, err := s.gh.Git.CreateTree(
+		ctx, owner, name, parent.GetTree().GetSHA(), entries,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	commit, _, err := s.gh.Git.CreateCommit(ctx, owner, name, &github.Commit{
+		Message: github.String(message),
+		Tree:    &github.Tree{SHA: tree.SHA},
+		Parents: []*github.Commit{{SHA: github.String(parentSHA)}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.logger.Info("new commit created",
+		"commit", commit.GetSHA(), "casks", len(changes),
+	)
+
+	return commit.GetSHA(), nil
+}
+
+// pushBranch points given branch at given commit, creating the branch if it
+// does not exist.
+func (s *Updater) pushBranch(
+	ctx context.Context,
+	branch string,
+	sha string,
+) error {
+	owner, name := s.TapRepo.Owner(), s.TapRepo.Name()
+	ref := &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: github.String(sha)},
+	}
+
+	_, resp, err := s.gh.Git.GetRef(ctx, owner, name, "heads/"+branch)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		s.logger.Info("creating branch", "branch", branch, "commit", sha)
+		_, _, err = s.gh.Git.CreateRef(ctx, owner, name, ref)
+
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	s.logger.Info("updating branch", "branch", branch, "commit", sha)
+	_, _, err = s.gh.Git.UpdateRef(ctx, owner, name, ref, true)
+
+	return err
+}
+
+// openPullRequest creates a pull request from given branch, or updates the
+// title and description of an already open one.
+func (s *Updater) openPullRequest(
+	ctx context.Context,
+	base string,
+	branch string,
+	title string,
+	body string,
+) (*github.PullRequest, error) {
+	owner, name := s.TapRepo.Owner(), s.TapRepo.Name()
+
+	prs, _, err := s.gh.PullRequests.List(ctx, owner, name,
+		&github.PullRequestListOptions{
+			State: "open",
+			Head:  owner + ":" + branch,
+			Base:  base,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(prs) > 0 {
+		pr, _, err := s.gh.PullRequests.Edit(
+			ctx, owner, name, prs[0].GetNumber(), &github.PullRequest{
+				Title: github.String(title),
+				Body:  github.String(body),
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		s.logger.Info("updated pull request", "url", pr.GetHTMLURL())
+
+		return pr, nil
+	}
+
+	pr, _, err := s.gh.PullRequests.Create(ctx, owner, name,
+		&github.NewPullRequest{
+			Title: github.String(title),
+			Head:  github.String(branch),
+			Base:  github.String(base),
+			Body:  github.String(body),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("created pull request", "url", pr.GetHTMLURL())
+
+	return pr, nil
+}
+
+type autoMergeResponse struct {
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// enableAutoMerge enables auto-merge of given pull request, which is only
+// available through the GraphQL API.
+func (s *Updater) enableAutoMerge(
+	ctx context.Context,
+	pr *github.PullRequest,
+	method string,
+) error {
+	req, err := s.gh.NewRequest(http.MethodPost, "graphql", map[string]any{
+		"query": `mutation($id: ID!, $method: PullRequestMergeMethod) {
+  enablePullRequestAutoMerge(
+    input: {pullRequestId: $id, mergeMethod: $method}
+  ) { clientMutationId }
+}`,
+		"variables": map[string]string{
+			"id":     pr.GetNodeID(),
+			"method": method,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp := &autoMergeResponse{}
+	_, err = s.gh.Do(ctx, req, resp)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAutoMerge, err)
+	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("%w: %s", ErrAutoMerge, resp.Errors[0].Message)
+	}
+
+	s.logger.Info("enabled auto-merge",
+		"url", pr.GetHTMLURL(), "method", strings.ToLower(method),
+	)
+
+	return nil
+}
+
+// commitMessage returns the title and full commit message for given changes.
+func commitMessage(changes []*caskChange) (string, string) {
+	lines := make([]string, 0, len(changes))
+	for _, c := range changes {
+		verb := "update %s to version %s"
+		if c.created {
+			verb = "create %s with version %s"
+		}
+		lines = append(lines,
+			fmt.Sprintf(verb, c.chk.Cask, c.chk.Version.Latest),
+		)
+	}
+
+	if len(lines) == 1 {
+		title := "feat(cask): " + lines[0]
+
+		return title, title
+	}
+
+	title := fmt.Sprintf("feat(cask): update %d casks", len(changes))
+
+	return title, title + "\n\n- " + strings.Join(lines, "\n- ")
+}
+
+// pullRequestBody returns a summary of version bumps and diffs of given
+// changes. Diffs are left out if they make the summary too long.
+func pullRequestBody(changes []*caskChange) string {
+	var b strings.Builder
+	b.WriteString("Updates Homebrew casks to the latest builds.\n\n")
+	b.WriteString("| Cask | Current | Latest |\n")
+	b.WriteString("| ---- | ------- | ------ |\n")
+	for _, c := range changes {
+		current := c.chk.Version.Current
+		if c.created || current == "" {
+			current = "-"
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s |\n",
+			c.chk.Cask, current, c.chk.Version.Latest,
+		)
+	}
+
+	var diffs strings.Builder
+	for _, c := range changes {
+		fmt.Fprintf(&diffs,
+			"\n<details>\n<summary><code>%s</code></summary>\n\n"+
+				"```diff\n%s```\n\n</details>\n",
+			c.filename, c.diff,
+		)
+	}
+
+	if b.Len()+diffs.Len() > maxPullRequestBody {
+		b.WriteString("\nDiffs are left out, as they are too large.\n")
+	} else {
+		b.WriteString(diffs.String())
+	}
+
+	return b.String()
+}
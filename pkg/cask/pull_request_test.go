@@ -0,0 +1,310 @@
+package cask
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTap is a minimal stand-in for the GitHub API of a tap repository.
+type fakeTap struct {
+	t        *testing.T
+	mux      sync.Mutex
+	files    map[string]string
+	branches map[string]string
+	prs      []map[string]any
+	requests []string
+	bodies   map[string]map[string]any
+}
+
+func (s *fakeTap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	req := r.Method + " " + r.URL.Path
+	s.requests = append(s.requests, req)
+
+	var body map[string]any
+	if r.Body != nil {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(s.t, err)
+		if len(b) > 0 {
+			require.NoError(s.t, json.Unmarshal(b, &body))
+			s.bodies[req] = body
+		}
+	}
+
+	const prefix = "/repos/jimeh/homebrew-emacs-builds"
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case req == "GET "+prefix:
+		s.write(w, map[string]any{"default_branch": "main"})
+	case r.Method == "GET" && strings.HasPrefix(path, "/git/ref/heads/"):
+		branch := strings.TrimPrefix(path, "/git/ref/heads/")
+		sha, ok := s.branches[branch]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			s.write(w, map[string]any{"message": "Not Found"})
+
+			return
+		}
+		s.write(w, map[string]any{
+			"ref": "refs/heads/" + branch, "object": map[string]any{"sha": sha},
+		})
+	case r.Method == "GET" && strings.HasPrefix(path, "/contents/"):
+		assert.Equal(s.t, "base", r.URL.Query().Get("ref"))
+		content, ok := s.files[strings.TrimPrefix(path, "/contents/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			s.write(w, map[string]any{"message": "Not Found"})
+
+			return
+		}
+		s.write(w, map[string]any{
+			"type":     "file",
+			"encoding": "base64",
+			"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+		})
+	case req == "GET "+prefix+"/git/commits/base":
+		s.write(w, map[string]any{
+			"sha": "base", "tree": map[string]any{"sha": "base-tree"},
+		})
+	case req == "POST "+prefix+"/git/trees":
+		s.write(w, map[string]any{"sha": "new-tree"})
+	case req == "POST "+prefix+"/git/commits":
+		s.write(w, map[string]any{"sha": "new-commit"})
+	case req == "POST "+prefix+"/git/refs",
+		r.Method == "PATCH" && strings.HasPrefix(path, "/git/refs/heads/"):
+		s.write(w, map[string]any{})
+	case req == "GET "+prefix+"/pulls":
+		assert.Equal(s.t, url.Values{
+			"state": {"open"},
+			"head":  {"jimeh:cask-updates"},
+			"base":  {"main"},
+		}, r.URL.Query())
+		s.write(w, s.prs)
+	case req == "POST "+prefix+"/pulls", req == "PATCH "+prefix+"/pulls/7":
+		s.write(w, map[string]any{
+			"number": 7, "node_id": "PR_7", "html_url": "https://pr/7",
+		})
+	case req == "POST /graphql":
+		s.write(w, map[string]any{"data": map[string]any{}})
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (s *fakeTap) write(w http.ResponseWriter, v any) {
+	require.NoError(s.t, json.NewEncoder(w).Encode(v))
+}
+
+func testPullRequestUpdater(t *testing.T, fake *fakeTap) *Updater {
+	t.Helper()
+	ctx := context.Background()
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	store, err := release.NewDirStore(t.TempDir(), "")
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "Emacs-29.4-1.macOS-14.arm64.dmg")
+	require.NoError(t, os.WriteFile(file, []byte("dmg"), 0o644))
+	_, err = store.Publish(ctx, &release.Release{Name: "Emacs-29.4"},
+		[]string{file}, &release.PublishOptions{},
+	)
+	require.NoError(t, err)
+
+	templates := t.TempDir()
+	for _, cask := range []string{"emacs-app", "emacs-app-good"} {
+		require.NoError(t, os.WriteFile(
+			filepath.Join(templates, cask+".rb.tpl"),
+			[]byte(`version "{{ .Version }}"`+"\n"), 0o644,
+		))
+	}
+
+	tap, err := repository.NewGitHub("jimeh/homebrew-emacs-builds")
+	require.NoError(t, err)
+
+	client := github.NewClient(srv.Client())
+	client.BaseURL, err = url.Parse(srv.URL + "/")
+	require.NoError(t, err)
+
+	return &Updater{
+		BuildsStore:  store,
+		TapRepo:      tap,
+		TemplatesDir: templates,
+		logger:       hclog.NewNullLogger(),
+		gh:           client,
+	}
+}
+
+func TestUpdater_UpdatePullRequest(t *testing.T) {
+	fake := &fakeTap{
+		t:        t,
+		files:    map[string]string{"Casks/emacs-app.rb": "version \"29.3\"\n"},
+		branches: map[string]string{"main": "base"},
+		bodies:   map[string]map[string]any{},
+	}
+	updater := testPullRequestUpdater(t, fake)
+
+	err := updater.UpdatePullRequest(context.Background(), []*LiveCheck{
+		{
+			Cask: "emacs-app",
+			Version: LiveCheckVersion{
+				Current: "29.3", Latest: "29.4", Outdated: true,
+			},
+		},
+		{
+			Cask:    "emacs-app-good",
+			Version: LiveCheckVersion{Latest: "29.4", Outdated: true},
+		},
+		{
+			Cask:    "emacs-app-nightly",
+			Version: LiveCheckVersion{Current: "29.4", Latest: "29.4"},
+		},
+	}, false, &PullRequestOptions{AutoMerge: true})
+	require.NoError(t, err)
+
+	const prefix = "/repos/jimeh/homebrew-emacs-builds"
+	assert.Equal(t, []string{
+		"GET " + prefix,
+		"GET " + prefix + "/git/ref/heads/main",
+		"GET " + prefix + "/contents/Casks/emacs-app.rb",
+		"GET " + prefix + "/contents/Casks/emacs-app-good.rb",
+		"GET " + prefix + "/git/commits/base",
+		"POST " + prefix + "/git/trees",
+		"POST " + prefix + "/git/commits",
+		"GET " + prefix + "/git/ref/heads/cask-updates",
+		"POST " + prefix + "/git/refs",
+		"GET " + prefix + "/pulls",
+		"POST " + prefix + "/pulls",
+		"POST /graphql",
+	}, fake.requests)
+
+	tree := fake.bodies["POST "+prefix+"/git/trees"]
+	assert.Equal(t, "base-tree", tree["base_tree"])
+	assert.Len(t, tree["tree"], 2)
+
+	commit := fake.bodies["POST "+prefix+"/git/commits"]
+	assert.Equal(t,
+		"feat(cask): update 2 casks\n\n"+
+			"- update emacs-app to version 29.4\n"+
+			"- create emacs-app-good with version 29.4",
+		commit["message"],
+	)
+
+	ref := fake.bodies["POST "+prefix+"/git/refs"]
+	assert.Equal(t, "refs/heads/cask-updates", ref["ref"])
+	assert.Equal(t, "new-commit", ref["sha"])
+
+	pr := fake.bodies["POST "+prefix+"/pulls"]
+	assert.Equal(t, "feat(cask): update 2 casks", pr["title"])
+	assert.Equal(t, "cask-updates", pr["head"])
+	assert.Equal(t, "main", pr["base"])
+	assert.Contains(t, pr["body"], "| `emacs-app` | 29.3 | 29.4 |")
+	assert.Contains(t, pr["body"], "| `emacs-app-good` | - | 29.4 |")
+	assert.Contains(t, pr["body"], "-version \"29.3\"\n+version \"29.4\"")
+
+	graphql := fake.bodies["POST /graphql"]
+	assert.Equal(t, map[string]any{"id": "PR_7", "method": "SQUASH"},
+		graphql["variables"],
+	)
+}
+
+func TestUpdater_UpdatePullRequest_existing(t *testing.T) {
+	fake := &fakeTap{
+		t:     t,
+		files: map[string]string{"Casks/emacs-app.rb": "version \"29.3\"\n"},
+		branches: map[string]string{
+			"main": "base", "cask-updates": "old-commit",
+		},
+		prs:    []map[string]any{{"number": 7}},
+		bodies: map[string]map[string]any{},
+	}
+	updater := testPullRequestUpdater(t, fake)
+	updater.Ref = "refs/heads/main"
+
+	err := updater.UpdatePullRequest(context.Background(), []*LiveCheck{{
+		Cask: "emacs-app",
+		Version: LiveCheckVersion{
+			Current: "29.3", Latest: "29.4", Outdated: true,
+		},
+	}}, false, &PullRequestOptions{})
+	require.NoError(t, err)
+
+	const prefix = "/repos/jimeh/homebrew-emacs-builds"
+	assert.Contains(t, fake.requests,
+		"PATCH "+prefix+"/git/refs/heads/cask-updates",
+	)
+	assert.Equal(t, map[string]any{"sha": "new-commit", "force": true},
+		fake.bodies["PATCH "+prefix+"/git/refs/heads/cask-updates"],
+	)
+	assert.Equal(t,
+		"feat(cask): update emacs-app to version 29.4",
+		fake.bodies["PATCH "+prefix+"/pulls/7"]["title"],
+	)
+	assert.NotContains(t, fake.requests, "POST "+prefix+"/pulls")
+	assert.NotContains(t, fake.requests, "POST /graphql")
+}
+
+func TestUpdater_UpdatePullRequest_unchanged(t *testing.T) {
+	fake := &fakeTap{
+		t:        t,
+		files:    map[string]string{"Casks/emacs-app.rb": "version \"29.4\"\n"},
+		branches: map[string]string{"main": "base"},
+		bodies:   map[string]map[string]any{},
+	}
+	updater := testPullRequestUpdater(t, fake)
+
+	err := updater.UpdatePullRequest(context.Background(), []*LiveCheck{{
+		Cask: "emacs-app",
+		Version: LiveCheckVersion{
+			Current: "29.3", Latest: "29.4", Outdated: true,
+		},
+	}}, false, &PullRequestOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"GET /repos/jimeh/homebrew-emacs-builds",
+		"GET /repos/jimeh/homebrew-emacs-builds/git/ref/heads/main",
+		"GET /repos/jimeh/homebrew-emacs-builds/contents/Casks/emacs-app.rb",
+	}, fake.requests)
+}
+
+func TestUpdater_UpdatePullRequest_invalidMergeMethod(t *testing.T) {
+	fake := &fakeTap{
+		t:        t,
+		files:    map[string]string{"Casks/emacs-app.rb": "version \"29.3\"\n"},
+		branches: map[string]string{"main": "base"},
+		bodies:   map[string]map[string]any{},
+	}
+	updater := testPullRequestUpdater(t, fake)
+
+	err := updater.UpdatePullRequest(context.Background(), []*LiveCheck{{
+		Cask: "emacs-app",
+		Version: LiveCheckVersion{
+			Current: "29.3", Latest: "29.4", Outdated: true,
+		},
+	}}, false, &PullRequestOptions{AutoMerge: true, MergeMethod: "sqash"})
+
+	assert.ErrorIs(t, err, ErrAutoMerge)
+	assert.Empty(t, fake.requests)
+}
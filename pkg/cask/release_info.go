@@ -3,12 +3,17 @@ package cask
 import (
 	"sort"
 	"strings"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/manifest"
 )
 
 type ReleaseInfo struct {
 	Name    string
 	Version string
 	Assets  map[string]*ReleaseAsset
+
+	// Manifests are the manifests of builds in the release, if any.
+	Manifests []*manifest.Manifest
 }
 
 func (s *ReleaseInfo) Asset(needles ...string) *ReleaseAsset {
@@ -19,12 +24,17 @@ func (s *ReleaseInfo) Asset(needles ...string) *ReleaseAsset {
 	}
 
 	// Dirty and inefficient way to ensure assets are searched in a predictable
-	// order.
+	// order. Assets described by a manifest are preferred over those which
+	// are not.
 	var assets []*ReleaseAsset
 	for _, a := range s.Assets {
 		assets = append(assets, a)
 	}
 	sort.SliceStable(assets, func(i, j int) bool {
+		if (assets[i].Manifest == nil) != (assets[j].Manifest == nil) {
+			return assets[i].Manifest != nil
+		}
+
 		return assets[i].Filename < assets[j].Filename
 	})
 
@@ -42,6 +52,29 @@ assetsLoop:
 	return nil
 }
 
+// Build returns the disk image of the build targeting given architecture and
+// macOS version, as described by the release's manifests. The version matches
+// builds for the same or a more specific version, so "14" matches builds for
+// "14.5". It returns nil if no manifest describes a matching build.
+func (s *ReleaseInfo) Build(arch, osVersion string) *ReleaseAsset {
+	for _, m := range s.Manifests {
+		if m.OS == nil || m.OS.Arch != arch ||
+			(m.OS.Version != osVersion &&
+				!strings.HasPrefix(m.OS.Version, osVersion+".")) {
+			continue
+		}
+
+		for _, ma := range m.Assets {
+			if a, ok := s.Assets[ma.Filename]; ok &&
+				strings.HasSuffix(ma.Filename, ".dmg") {
+				return a
+			}
+		}
+	}
+
+	return nil
+}
+
 func (s *ReleaseInfo) DownloadURL(needles ...string) string {
 	a := s.Asset(needles...)
 	if a == nil {
@@ -60,8 +93,29 @@ func (s *ReleaseInfo) SHA256(needles ...string) string {
 	return a.SHA256
 }
 
+// addManifest adds a build manifest to the release info, and uses it for the
+// checksum and size of the assets it describes.
+func (s *ReleaseInfo) addManifest(m *manifest.Manifest) {
+	s.Manifests = append(s.Manifests, m)
+
+	for _, ma := range m.Assets {
+		a, ok := s.Assets[ma.Filename]
+		if !ok {
+			continue
+		}
+
+		a.SHA256 = ma.SHA256
+		a.Size = ma.Size
+		a.Manifest = m
+	}
+}
+
 type ReleaseAsset struct {
 	Filename    string
 	DownloadURL string
 	SHA256      string
+	Size        int64
+
+	// Manifest is the manifest of the build the asset belongs to, if any.
+	Manifest *manifest.Manifest
 }
@@ -3,6 +3,8 @@ package cask
 import (
 	"testing"
 
+	"github.com/jimeh/build-emacs-for-macos/pkg/manifest"
+	"github.com/jimeh/build-emacs-for-macos/pkg/osinfo"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -58,6 +60,23 @@ func TestAsset(t *testing.T) {
 			needles: []string{"rar", "asset3"},
 			want:    nil,
 		},
+		{
+			name: "prefers assets with manifest",
+			release: ReleaseInfo{
+				Assets: map[string]*ReleaseAsset{
+					"a.arm64.dmg": {Filename: "a.arm64.dmg"},
+					"b.arm64.dmg": {
+						Filename: "b.arm64.dmg",
+						Manifest: &manifest.Manifest{},
+					},
+				},
+			},
+			needles: []string{"arm64", ".dmg"},
+			want: &ReleaseAsset{
+				Filename: "b.arm64.dmg",
+				Manifest: &manifest.Manifest{},
+			},
+		},
 		{
 			name: "no needles",
 			release: ReleaseInfo{
@@ -78,3 +97,86 @@ func TestAsset(t *testing.T) {
 		})
 	}
 }
+
+func TestReleaseInfo_addManifest(t *testing.T) {
+	info := &ReleaseInfo{
+		Assets: map[string]*ReleaseAsset{
+			"Emacs.arm64.dmg": {
+				Filename:    "Emacs.arm64.dmg",
+				DownloadURL: "https://example.com/Emacs.arm64.dmg",
+			},
+			"Emacs.x86_64.dmg": {
+				Filename:    "Emacs.x86_64.dmg",
+				DownloadURL: "https://example.com/Emacs.x86_64.dmg",
+			},
+		},
+	}
+	m := &manifest.Manifest{
+		Assets: []*manifest.Asset{
+			{Filename: "Emacs.arm64.dmg", Size: 42, SHA256: "abc123"},
+			{Filename: "Emacs.missing.dmg", Size: 1, SHA256: "def456"},
+		},
+	}
+
+	info.addManifest(m)
+
+	assert.Equal(t, []*manifest.Manifest{m}, info.Manifests)
+	assert.Equal(t, &ReleaseAsset{
+		Filename:    "Emacs.arm64.dmg",
+		DownloadURL: "https://example.com/Emacs.arm64.dmg",
+		SHA256:      "abc123",
+		Size:        42,
+		Manifest:    m,
+	}, info.Assets["Emacs.arm64.dmg"])
+	assert.Equal(t, &ReleaseAsset{
+		Filename:    "Emacs.x86_64.dmg",
+		DownloadURL: "https://example.com/Emacs.x86_64.dmg",
+	}, info.Assets["Emacs.x86_64.dmg"])
+	assert.NotContains(t, info.Assets, "Emacs.missing.dmg")
+}
+
+func TestReleaseInfo_Build(t *testing.T) {
+	info := &ReleaseInfo{Assets: map[string]*ReleaseAsset{}}
+	for _, osInfo := range []*osinfo.OSInfo{
+		{Name: "macOS", Version: "14.5", Arch: "arm64"},
+		{Name: "macOS", Version: "13.6", Arch: "x86_64"},
+		{Name: "macOS", Version: "11", Arch: "x86_64"},
+	} {
+		name := "Emacs.macOS-" + osInfo.Version + "." + osInfo.Arch
+		for _, f := range []string{name + ".dmg", name + ".tbz"} {
+			info.Assets[f] = &ReleaseAsset{Filename: f}
+		}
+		info.addManifest(&manifest.Manifest{
+			OS: osInfo,
+			Assets: []*manifest.Asset{
+				{Filename: name + ".tbz"},
+				{Filename: name + ".dmg"},
+			},
+		})
+	}
+
+	tests := []struct {
+		arch      string
+		osVersion string
+		want      string
+	}{
+		{arch: "arm64", osVersion: "14", want: "Emacs.macOS-14.5.arm64.dmg"},
+		{arch: "arm64", osVersion: "14.5", want: "Emacs.macOS-14.5.arm64.dmg"},
+		{arch: "x86_64", osVersion: "13", want: "Emacs.macOS-13.6.x86_64.dmg"},
+		{arch: "x86_64", osVersion: "11", want: "Emacs.macOS-11.x86_64.dmg"},
+		{arch: "x86_64", osVersion: "1"},
+		{arch: "x86_64", osVersion: "14"},
+		{arch: "arm64", osVersion: "13"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arch+" "+tt.osVersion, func(t *testing.T) {
+			got := info.Build(tt.arch, tt.osVersion)
+
+			if tt.want == "" {
+				assert.Nil(t, got)
+			} else if assert.NotNil(t, got) {
+				assert.Equal(t, tt.want, got.Filename)
+			}
+		})
+	}
+}
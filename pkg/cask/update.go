@@ -11,7 +11,6 @@ import (
 	"path/filepath"
 	"strings"
 	"text/template"
-	"time"
 
 	"github.com/google/go-github/v35/github"
 	"github.com/hashicorp/go-hclog"
@@ -19,6 +18,7 @@ import (
 	"github.com/hexops/gotextdiff/myers"
 	"github.com/hexops/gotextdiff/span"
 	"github.com/jimeh/build-emacs-for-macos/pkg/gh"
+	"github.com/jimeh/build-emacs-for-macos/pkg/manifest"
 	"github.com/jimeh/build-emacs-for-macos/pkg/release"
 	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
 )
@@ -43,6 +43,10 @@ type UpdateOptions struct {
 	// BuildsRepo is the GitHub repository containing binary releases.
 	BuildsRepo *repository.Repository
 
+	// BuildsStore is the release store containing binary releases. Defaults
+	// to GitHub Releases of BuildsRepo.
+	BuildsStore release.Store
+
 	// TapRepo is the GitHub repository to update the casks in.
 	TapRepo *repository.Repository
 
@@ -63,13 +67,25 @@ type UpdateOptions struct {
 	// TemplatesDir is the directory where cask templates are located.
 	TemplatesDir string
 
+	// LiveChecks are the "brew livecheck" results of casks to update. When
+	// nil, live checks are performed natively, see Updater.LiveCheck.
 	LiveChecks []*LiveCheck
 
+	// PullRequest enables committing all cask changes to a single branch of
+	// the tap repository, and opening a pull request for them, rather than
+	// committing each change directly to Ref. Ignored when OutputDir is set.
+	PullRequest *PullRequestOptions
+
+	// Casks limits native live checks to given casks. All casks with a
+	// template are checked when empty.
+	Casks []string
+
 	GithubToken string
 }
 
 type Updater struct {
 	BuildsRepo   *repository.Repository
+	BuildsStore  release.Store
 	TapRepo      *repository.Repository
 	Ref          string
 	OutputDir    string
@@ -82,6 +98,7 @@ type Updater struct {
 func Update(ctx context.Context, opts *UpdateOptions) error {
 	updater := &Updater{
 		BuildsRepo:   opts.BuildsRepo,
+		BuildsStore:  opts.BuildsStore,
 		TapRepo:      opts.TapRepo,
 		Ref:          opts.Ref,
 		OutputDir:    opts.OutputDir,
@@ -89,8 +106,28 @@ func Update(ctx context.Context, opts *UpdateOptions) error {
 		logger:       hclog.FromContext(ctx).Named("cask"),
 		gh:           gh.New(ctx, opts.GithubToken),
 	}
+	if updater.BuildsStore == nil {
+		updater.BuildsStore = release.NewGitHubStore(
+			ctx, opts.BuildsRepo, opts.GithubToken,
+		)
+	}
+
+	liveChecks := opts.LiveChecks
+	if liveChecks == nil {
+		var err error
+		liveChecks, err = updater.LiveCheck(ctx, opts.Casks)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.PullRequest != nil && opts.OutputDir == "" {
+		return updater.UpdatePullRequest(
+			ctx, liveChecks, opts.Force, opts.PullRequest,
+		)
+	}
 
-	for _, chk := range opts.LiveChecks {
+	for _, chk := range liveChecks {
 		err := updater.Update(ctx, chk, opts.Force)
 		if err != nil {
 			return err
@@ -109,9 +146,7 @@ func (s *Updater) Update(
 		return ErrNoTapOrOutput
 	}
 
-	if !force && !chk.Version.Outdated {
-		s.logger.Info("skipping", "cask", chk.Cask, "reason", "up to date")
-
+	if s.skip(chk, force) {
 		return nil
 	}
 
@@ -144,6 +179,31 @@ func (s *Updater) Update(
 	return nil
 }
 
+// skip reports if given cask should not be updated, as it is up to date, or
+// the latest version is older than the current one, unless force is set.
+func (s *Updater) skip(chk *LiveCheck, force bool) bool {
+	if force {
+		return false
+	}
+
+	if !chk.Version.Outdated {
+		s.logger.Info("skipping", "cask", chk.Cask, "reason", "up to date")
+
+		return true
+	}
+
+	if chk.Version.Downgrade() {
+		s.logger.Info("skipping", "cask", chk.Cask,
+			"reason", "latest version is older than current",
+			"current", chk.Version.Current, "latest", chk.Version.Latest,
+		)
+
+		return true
+	}
+
+	return false
+}
+
 func (s *Updater) putFile(
 	_ context.Context,
 	chk *LiveCheck,
@@ -360,78 +420,27 @@ func (s *Updater) updateRepoFile(
 	return true, nil
 }
 
-//nolint:funlen
 func (s *Updater) renderCask(
 	ctx context.Context,
 	chk *LiveCheck,
 ) ([]byte, error) {
-	releaseName, err := release.VersionToName(chk.Version.Latest)
+	version, err := release.ParseVersion(chk.Version.Latest)
 	if err != nil {
 		return nil, err
 	}
+	releaseName := version.ReleaseName()
 
-	s.logger.Info("fetching release details",
-		"release", releaseName, "repo", s.BuildsRepo.URL(),
-	)
-	release, resp, err := s.gh.Repositories.GetReleaseByTag(
-		ctx, s.BuildsRepo.Owner(), s.BuildsRepo.Name(), releaseName,
-	)
-	if err != nil {
-		return nil, err
-	}
-	if release == nil || resp.StatusCode == http.StatusNotFound {
+	s.logger.Info("fetching release details", "release", releaseName)
+	rel, err := s.BuildsStore.Get(ctx, releaseName)
+	if errors.Is(err, release.ErrNotFound) {
 		return nil, fmt.Errorf("%w: %s", ErrReleaseNotFound, releaseName)
+	} else if err != nil {
+		return nil, err
 	}
 
-	info := &ReleaseInfo{
-		Name:    release.GetName(),
-		Version: chk.Version.Latest,
-		Assets:  map[string]*ReleaseAsset{},
-	}
-
-	s.logger.Info("processing release assets")
-	for _, asset := range release.Assets {
-		filename := asset.GetName()
-		s.logger.Debug("processing asset", "filename", filename)
-
-		filename = strings.TrimSuffix(filename, ".sha256")
-
-		if _, ok := info.Assets[filename]; !ok {
-			info.Assets[filename] = &ReleaseAsset{
-				Filename: filename,
-			}
-		}
-
-		if strings.HasSuffix(asset.GetName(), ".sha256") {
-			s.logger.Debug("downloading *.sha256 asset to extract SHA256 value")
-			r, err2 := s.downloadAssetContent(ctx, asset)
-			if err2 != nil {
-				return nil, err2
-			}
-			defer r.Close()
-
-			content := make([]byte, 64)
-			n, err2 := io.ReadAtLeast(r, content, 64)
-			if err2 != nil {
-				return nil, err2
-			}
-			if n < 64 {
-				return nil, fmt.Errorf(
-					"%w: %s", ErrFailedSHA256Parse, asset.GetName(),
-				)
-			}
-
-			sha := string(content)[0:64]
-			if sha == "" {
-				return nil, fmt.Errorf(
-					"%w: %s", ErrFailedSHA256Parse, asset.GetName(),
-				)
-			}
-
-			info.Assets[filename].SHA256 = sha
-		} else {
-			info.Assets[filename].DownloadURL = asset.GetBrowserDownloadURL()
-		}
+	info, err := s.releaseInfo(ctx, rel, chk.Version.Latest)
+	if err != nil {
+		return nil, err
 	}
 
 	tplContent, err := os.ReadFile(
@@ -466,39 +475,119 @@ func (s *Updater) renderCask(
 	return buf.Bytes(), nil
 }
 
-func (s *Updater) downloadAssetContent(
+// releaseInfo collects the assets of a release. Checksums and build details
+// are taken from build manifests when the release has them, and otherwise
+// from the release store, or the *.sha256 asset of each file.
+func (s *Updater) releaseInfo(
 	ctx context.Context,
-	asset *github.ReleaseAsset,
-) (io.ReadCloser, error) {
-	httpClient := &http.Client{Timeout: 60 * time.Second}
+	rel *release.Release,
+	version string,
+) (*ReleaseInfo, error) {
+	info := &ReleaseInfo{
+		Name:    rel.Title,
+		Version: version,
+		Assets:  map[string]*ReleaseAsset{},
+	}
 
-	r, downloadURL, err := s.gh.Repositories.DownloadReleaseAsset(
-		ctx, s.BuildsRepo.Owner(), s.BuildsRepo.Name(),
-		asset.GetID(), httpClient,
-	)
-	if err != nil {
-		return nil, err
+	s.logger.Info("processing release assets")
+	var sumAssets []*release.Asset
+	var manifestAssets []*release.Asset
+	for _, asset := range rel.Assets {
+		filename := asset.Name
+		s.logger.Debug("processing asset", "filename", filename)
+
+		switch {
+		case strings.HasSuffix(filename, manifest.Suffix):
+			manifestAssets = append(manifestAssets, asset)
+		case strings.HasSuffix(filename, ".sha256"):
+			sumAssets = append(sumAssets, asset)
+		default:
+			info.Assets[filename] = &ReleaseAsset{
+				Filename:    filename,
+				DownloadURL: asset.URL,
+				Size:        asset.Size,
+				SHA256:      asset.SHA256,
+			}
+		}
 	}
 
-	if r == nil && downloadURL != "" {
-		req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	for _, asset := range manifestAssets {
+		m, err := s.downloadManifest(ctx, rel, asset)
 		if err != nil {
-			return nil, err
+			s.logger.Warn("ignoring manifest",
+				"filename", asset.Name, "error", err,
+			)
+
+			continue
+		}
+		info.addManifest(m)
+	}
+
+	for _, asset := range sumAssets {
+		filename := strings.TrimSuffix(asset.Name, ".sha256")
+		a, ok := info.Assets[filename]
+		if !ok {
+			a = &ReleaseAsset{Filename: filename}
+			info.Assets[filename] = a
+		}
+		if a.SHA256 != "" {
+			s.logger.Debug("using SHA256 value from manifest or store",
+				"filename", filename,
+			)
+
+			continue
 		}
 
-		//nolint:bodyclose
-		resp, err := httpClient.Do(req)
+		s.logger.Debug("downloading *.sha256 asset to extract SHA256 value")
+		sha, err := s.downloadSHA256(ctx, rel, asset)
 		if err != nil {
 			return nil, err
 		}
-		r = resp.Body
+		a.SHA256 = sha
 	}
 
-	if r == nil {
-		return nil, fmt.Errorf(
-			"%s: %s", ErrFailedSHA256Download, asset.GetName(),
-		)
+	return info, nil
+}
+
+func (s *Updater) downloadSHA256(
+	ctx context.Context,
+	rel *release.Release,
+	asset *release.Asset,
+) (string, error) {
+	r, err := s.BuildsStore.Open(ctx, rel, asset)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	content := make([]byte, 64)
+	n, err := io.ReadAtLeast(r, content, 64)
+	if err != nil {
+		return "", err
+	}
+	if n < 64 {
+		return "", fmt.Errorf("%w: %s", ErrFailedSHA256Parse, asset.Name)
+	}
+
+	return string(content)[0:64], nil
+}
+
+func (s *Updater) downloadManifest(
+	ctx context.Context,
+	rel *release.Release,
+	asset *release.Asset,
+) (*manifest.Manifest, error) {
+	s.logger.Debug("downloading manifest", "filename", asset.Name)
+	r, err := s.BuildsStore.Open(ctx, rel, asset)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
 
-	return r, nil
+	return manifest.Parse(b)
 }
@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/bundle"
+	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	cli2 "github.com/urfave/cli/v2"
+)
+
+func bundleLibsCmd() *cli2.Command {
+	return &cli2.Command{
+		Name: "bundle-libs",
+		Usage: "copy shared libraries into a Emacs.app bundle and relink " +
+			"binaries to use them",
+		ArgsUsage: "<emacs-app>",
+		Flags: []cli2.Flag{
+			&cli2.StringSliceFlag{
+				Name:    "lib-source",
+				Aliases: []string{"s"},
+				Usage: "directory prefix shared libraries are bundled " +
+					"from, can be specified multiple times",
+				Value: cli2.NewStringSlice(bundle.DefaultLibSources...),
+			},
+			&cli2.StringSliceFlag{
+				Name: "extra-lib",
+				Usage: "additional shared library to bundle, can be " +
+					"specified multiple times",
+				TakesFile: true,
+			},
+			&cli2.BoolFlag{
+				Name:  "relink-eln",
+				Usage: "bundle shared libraries used by *.eln files",
+				Value: true,
+			},
+			&cli2.StringFlag{
+				Name: "gcc-lib-dir",
+				Usage: "GCC lib directory to bundle libgccjit and related " +
+					"libraries from, used by native-compilation",
+				TakesFile: true,
+			},
+			&cli2.StringFlag{
+				Name: "gcc-darwin-lib-dir",
+				Usage: "directory of apple-darwin specific GCC libraries " +
+					"to bundle, required with --gcc-lib-dir",
+				TakesFile: true,
+			},
+			&cli2.BoolFlag{
+				Name:  "dry-run",
+				Usage: "log planned changes without modifying any files",
+			},
+			&cli2.StringFlag{
+				Name: "plan",
+				Usage: "path to build plan YAML file produced by " +
+					"emacs-builder plan",
+				Aliases:   []string{"p"},
+				EnvVars:   []string{"EMACS_BUILDER_PLAN"},
+				TakesFile: true,
+			},
+		},
+		Action: actionWrapper(bundleLibsAction),
+	}
+}
+
+func bundleLibsAction(c *cli2.Context, _ *Options) error {
+	libsOpts := &bundle.LibsOptions{
+		LibSources:     c.StringSlice("lib-source"),
+		ExtraLibs:      c.StringSlice("extra-lib"),
+		RelinkElnFiles: c.Bool("relink-eln"),
+		DryRun:         c.Bool("dry-run"),
+	}
+
+	app := c.Args().Get(0)
+
+	if f := c.String("plan"); f != "" {
+		p, err := plan.Load(f)
+		if err != nil {
+			return err
+		}
+
+		if p.Output != nil && p.Build != nil {
+			app = filepath.Join(
+				p.Output.Directory, p.Build.Name, "Emacs.app",
+			)
+		}
+	}
+
+	if c.String("gcc-lib-dir") != "" && c.String("gcc-darwin-lib-dir") == "" {
+		return errors.New("--gcc-darwin-lib-dir is required with --gcc-lib-dir")
+	}
+
+	err := bundle.Libs(c.Context, app, libsOpts)
+	if err != nil {
+		return err
+	}
+
+	if c.String("gcc-lib-dir") == "" {
+		return nil
+	}
+
+	return bundle.GccLibs(c.Context, app, &bundle.GccLibsOptions{
+		LibDir:       c.String("gcc-lib-dir"),
+		DarwinLibDir: c.String("gcc-darwin-lib-dir"),
+		DryRun:       c.Bool("dry-run"),
+	})
+}
+
+func cleanRpathsCmd() *cli2.Command {
+	return &cli2.Command{
+		Name: "clean-rpaths",
+		Usage: "remove duplicate rpaths from Mach-O files, which macOS " +
+			"15.4 and later refuse to load",
+		ArgsUsage: "<file>...",
+		Flags: []cli2.Flag{
+			&cli2.BoolFlag{
+				Name:  "backup",
+				Usage: "copy each modified file to <file>.bak first",
+				Value: true,
+			},
+		},
+		Action: actionWrapper(cleanRpathsAction),
+	}
+}
+
+func cleanRpathsAction(c *cli2.Context, _ *Options) error {
+	if c.NArg() == 0 {
+		return errors.New("no file arguments given")
+	}
+
+	for _, file := range c.Args().Slice() {
+		_, err := bundle.CleanRpaths(c.Context, file, c.Bool("backup"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,16 +2,17 @@ package cli
 
 import (
 	"encoding/json"
-	"errors"
 	"os"
 
 	"github.com/jimeh/build-emacs-for-macos/pkg/cask"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
 	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
 	cli2 "github.com/urfave/cli/v2"
 )
 
 type caskOptions struct {
 	BuildsRepo  *repository.Repository
+	BuildsStore string
 	GithubToken string
 }
 
@@ -32,6 +33,13 @@ func caskCmd() *cli2.Command {
 				EnvVars: []string{"EMACS_BUILDS_REPOSITORY"},
 				Value:   "jimeh/emacs-builds",
 			},
+			&cli2.StringFlag{
+				Name: "builds-store",
+				Usage: "release store containing builds, see " +
+					"\"release --store\" for supported values",
+				EnvVars: []string{"EMACS_BUILDS_STORE"},
+				Value:   "github",
+			},
 			&cli2.StringFlag{
 				Name:        "github-token",
 				Usage:       "GitHub API Token",
@@ -51,6 +59,7 @@ func caskActionWrapper(
 ) func(*cli2.Context) error {
 	return actionWrapper(func(c *cli2.Context, opts *Options) error {
 		rOpts := &caskOptions{
+			BuildsStore: c.String("builds-store"),
 			GithubToken: c.String("github-token"),
 		}
 
@@ -68,9 +77,10 @@ func caskActionWrapper(
 
 func caskUpdateCmd() *cli2.Command {
 	return &cli2.Command{
-		Name:      "update",
-		Usage:     "update casks based on brew livecheck result in JSON format",
-		ArgsUsage: "<livecheck.json>",
+		Name: "update",
+		Usage: "update casks based on brew livecheck result in JSON " +
+			"format, or a native live check if none is given",
+		ArgsUsage: "[<livecheck.json>]",
 		Flags: []cli2.Flag{
 			&cli2.StringFlag{
 				Name: "ref",
@@ -97,6 +107,35 @@ func caskUpdateCmd() *cli2.Command {
 				EnvVars:  []string{"CASK_TEMPLATE_DIR"},
 				Required: true,
 			},
+			&cli2.BoolFlag{
+				Name:    "pull-request",
+				Aliases: []string{"pr"},
+				Usage: "commit all cask changes to a single branch and " +
+					"open or update a pull request against --ref, " +
+					"instead of committing directly to --ref",
+			},
+			&cli2.StringFlag{
+				Name:  "branch",
+				Usage: "(with --pull-request) branch to commit changes to",
+				Value: cask.DefaultPullRequestBranch,
+			},
+			&cli2.BoolFlag{
+				Name: "auto-merge",
+				Usage: "(with --pull-request) enable auto-merge, merging " +
+					"the pull request once required checks pass",
+			},
+			&cli2.StringFlag{
+				Name: "merge-method",
+				Usage: "(with --auto-merge) merge method, one of " +
+					"\"merge\", \"squash\" or \"rebase\"",
+				Value: "squash",
+			},
+			&cli2.StringSliceFlag{
+				Name: "cask",
+				Usage: "cask to live check natively when no livecheck " +
+					"argument is given, can be specified multiple times, " +
+					"defaults to all casks with a template",
+			},
 			&cli2.BoolFlag{
 				Name:    "force",
 				Aliases: []string{"f"},
@@ -122,27 +161,44 @@ func caskUpdateAction(
 		OutputDir:    c.String("output"),
 		Force:        c.Bool("force"),
 		TemplatesDir: c.String("templates-dir"),
+		Casks:        c.StringSlice("cask"),
+	}
+
+	if c.Bool("pull-request") {
+		updateOpts.PullRequest = &cask.PullRequestOptions{
+			Branch:      c.String("branch"),
+			AutoMerge:   c.Bool("auto-merge"),
+			MergeMethod: c.String("merge-method"),
+		}
+	}
+
+	var err error
+	updateOpts.BuildsStore, err = release.OpenStore(
+		c.Context, cOpts.BuildsStore, &release.StoreOptions{
+			Repository:  cOpts.BuildsRepo,
+			GithubToken: cOpts.GithubToken,
+		},
+	)
+	if err != nil {
+		return err
 	}
 
 	if r := c.String("tap-repository"); r != "" {
-		var err error
 		updateOpts.TapRepo, err = repository.NewGitHub(r)
 		if err != nil {
 			return err
 		}
 	}
 
-	arg := c.Args().First()
-	if arg == "" {
-		return errors.New("no livecheck argument given")
-	}
-
-	if arg == "-" {
+	switch arg := c.Args().First(); arg {
+	case "":
+		// Perform live checks natively.
+	case "-":
 		err := json.NewDecoder(c.App.Reader).Decode(&updateOpts.LiveChecks)
 		if err != nil {
 			return err
 		}
-	} else {
+	default:
 		f, err := os.Open(arg)
 		if err != nil {
 			return err
@@ -45,12 +45,21 @@ func New(version, commit, date string) *CLI {
 			},
 			Commands: []*cli2.Command{
 				planCmd(),
+				profilesCmd(),
+				sourceCmd(),
+				patchesCmd(),
+				bundleLibsCmd(),
+				cleanRpathsCmd(),
+				verifyCmd(),
+				universalCmd(),
 				signCmd(),
 				signFilesCmd(),
+				signUpdateCmd(),
 				notarizeCmd(),
 				packageCmd(),
 				releaseCmd(),
 				caskCmd(),
+				indexCmd(),
 				{
 					Name:    "version",
 					Usage:   "print the version",
@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/index"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	cli2 "github.com/urfave/cli/v2"
+)
+
+func indexCmd() *cli2.Command {
+	return &cli2.Command{
+		Name:  "index",
+		Usage: "manage static index and update feeds of published builds",
+		Subcommands: []*cli2.Command{
+			indexGenerateCmd(),
+		},
+	}
+}
+
+func indexGenerateCmd() *cli2.Command {
+	tokenDefaultText := ""
+	if len(os.Getenv("GITHUB_TOKEN")) > 0 {
+		tokenDefaultText = "***"
+	}
+
+	return &cli2.Command{
+		Name: "generate",
+		Usage: "generate JSON index, RSS and Atom feeds, and Sparkle " +
+			"appcasts of published builds",
+		Flags: []cli2.Flag{
+			&cli2.StringFlag{
+				Name:    "repository",
+				Aliases: []string{"repo", "r"},
+				Usage:   "owner/name of GitHub repo containing builds",
+				EnvVars: []string{"EMACS_BUILDS_REPOSITORY"},
+				Value:   "jimeh/emacs-builds",
+			},
+			&cli2.StringFlag{
+				Name: "store",
+				Usage: "release store containing builds, see " +
+					"\"release --store\" for supported values",
+				EnvVars: []string{"EMACS_BUILDS_STORE"},
+				Value:   "github",
+			},
+			&cli2.StringFlag{
+				Name:        "github-token",
+				Usage:       "GitHub API Token",
+				EnvVars:     []string{"GITHUB_TOKEN"},
+				DefaultText: tokenDefaultText,
+			},
+			&cli2.StringFlag{
+				Name:      "output",
+				Aliases:   []string{"o"},
+				Usage:     "directory to write index and feed files to",
+				Value:     "index",
+				TakesFile: true,
+			},
+			&cli2.StringSliceFlag{
+				Name: "channel",
+				Usage: "only index releases of given channel (stable, " +
+					"release-candidate, pretest or nightly), can be " +
+					"specified multiple times",
+			},
+			&cli2.BoolFlag{
+				Name: "manifests",
+				Usage: "read build manifests of releases for checksums, " +
+					"commit and signing details",
+				Value: true,
+			},
+			&cli2.StringFlag{
+				Name:  "title",
+				Usage: "title of feeds",
+				Value: "Emacs Builds",
+			},
+			&cli2.StringFlag{
+				Name:  "link",
+				Usage: "URL of website which feeds belong to",
+			},
+			&cli2.StringFlag{
+				Name: "base-url",
+				Usage: "URL which generated files are published at, used " +
+					"for self-referencing links in feeds",
+			},
+			&cli2.IntFlag{
+				Name: "feed-items",
+				Usage: "maximum number of releases in feeds, and builds " +
+					"per channel in appcasts",
+				Value: index.DefaultFeedItems,
+			},
+		},
+		Action: actionWrapper(indexGenerateAction),
+	}
+}
+
+func indexGenerateAction(c *cli2.Context, opts *Options) error {
+	storeOpts := &release.StoreOptions{
+		GithubToken: c.String("github-token"),
+	}
+	if r := c.String("repository"); r != "" {
+		var err error
+		storeOpts.Repository, err = repository.NewGitHub(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	store, err := release.OpenStore(c.Context, c.String("store"), storeOpts)
+	if err != nil {
+		return err
+	}
+
+	channels, err := releaseChannels(c.StringSlice("channel"))
+	if err != nil {
+		return err
+	}
+
+	idx, err := index.Generate(c.Context, &index.Options{
+		Store:     store,
+		Channels:  channels,
+		Manifests: c.Bool("manifests"),
+		Title:     c.String("title"),
+		Link:      c.String("link"),
+		BaseURL:   c.String("base-url"),
+		FeedItems: c.Int("feed-items"),
+	})
+	if err != nil {
+		return err
+	}
+
+	dir := c.String("output")
+	err = idx.Write(dir)
+	if err != nil {
+		return err
+	}
+
+	if !opts.quiet {
+		fmt.Printf(
+			"Wrote index of %d builds to %s\n",
+			len(idx.Builds()), filepath.Clean(dir),
+		)
+	}
+
+	return nil
+}
@@ -47,3 +47,40 @@ func actionWrapper(
 		return f(c, opts)
 	}
 }
+
+// flags concatenates lists of flags.
+func flags(lists ...[]cli2.Flag) []cli2.Flag {
+	r := []cli2.Flag{}
+	for _, l := range lists {
+		r = append(r, l...)
+	}
+
+	return r
+}
+
+func apiTokenFlags() []cli2.Flag {
+	tokenDefaultText := ""
+	if len(os.Getenv("GITHUB_TOKEN")) > 0 {
+		tokenDefaultText = "***"
+	}
+
+	gitlabTokenDefaultText := ""
+	if len(os.Getenv("GITLAB_TOKEN")) > 0 {
+		gitlabTokenDefaultText = "***"
+	}
+
+	return []cli2.Flag{
+		&cli2.StringFlag{
+			Name:        "github-token",
+			Usage:       "GitHub API Token",
+			EnvVars:     []string{"GITHUB_TOKEN"},
+			DefaultText: tokenDefaultText,
+		},
+		&cli2.StringFlag{
+			Name:        "gitlab-token",
+			Usage:       "GitLab API Token",
+			EnvVars:     []string{"GITLAB_TOKEN"},
+			DefaultText: gitlabTokenDefaultText,
+		},
+	}
+}
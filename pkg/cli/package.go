@@ -12,6 +12,7 @@ import (
 	"github.com/jimeh/build-emacs-for-macos/pkg/notarize"
 	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
 	"github.com/jimeh/build-emacs-for-macos/pkg/sign"
+	"github.com/jimeh/build-emacs-for-macos/pkg/sparkle"
 	cli2 "github.com/urfave/cli/v2"
 )
 
@@ -43,6 +44,14 @@ func packageCmd() *cli2.Command {
 				Aliases: []string{"s"},
 				Value:   true,
 			},
+			&cli2.StringFlag{
+				Name: "ed-key",
+				Usage: "file containing base64 encoded Ed25519 private key " +
+					"to sign output dmg with, writing signature to " +
+					"<dmg>" + sparkle.SignatureSuffix,
+				EnvVars:   []string{"EMACS_BUILDER_ED_KEY_FILE"},
+				TakesFile: true,
+			},
 			&cli2.BoolFlag{
 				Name: "remove-source-dir",
 				Usage: "remove source directory after successfully " +
@@ -193,6 +202,21 @@ func packageAction(c *cli2.Context, opts *Options) error {
 		}
 	}
 
+	if p != nil {
+		if p.Output == nil {
+			p.Output = &plan.Output{}
+		}
+		p.Output.Signed = boolPtr(doSign)
+		p.Output.Notarized = boolPtr(doSign)
+
+		planFile := c.String("plan")
+		logger.Info("recording package status in plan", "file", planFile)
+		err = p.Save(planFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	if c.Bool("sha256") {
 		sumFile := outputDMG + ".sha256"
 
@@ -211,6 +235,18 @@ func packageAction(c *cli2.Context, opts *Options) error {
 		logger.Info("wrote checksum", "file", sumFile)
 	}
 
+	if keyFile := c.String("ed-key"); keyFile != "" {
+		key, err := sparkle.LoadPrivateKey(keyFile)
+		if err != nil {
+			return err
+		}
+
+		_, err = signUpdate(c, outputDMG, key, true)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
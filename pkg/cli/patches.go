@@ -0,0 +1,251 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/patch"
+	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	cli2 "github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+func patchesCmd() *cli2.Command {
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = ""
+	}
+
+	return &cli2.Command{
+		Name:  "patches",
+		Usage: "manage patches applied to Emacs sources",
+		Flags: []cli2.Flag{
+			&cli2.StringFlag{
+				Name:      "manifest",
+				Aliases:   []string{"m"},
+				Usage:     "path to patch manifest YAML or JSON file",
+				EnvVars:   []string{"EMACS_BUILDER_PATCH_MANIFEST"},
+				Value:     filepath.Join(wd, "patches", "manifest.yml"),
+				TakesFile: true,
+			},
+		},
+		Subcommands: []*cli2.Command{
+			patchesListCmd(),
+			patchesApplyCmd(),
+			patchesPinCmd(),
+		},
+	}
+}
+
+func patchesListCmd() *cli2.Command {
+	return &cli2.Command{
+		Name: "list",
+		Usage: "list patches which apply to a build plan, and record them " +
+			"in the plan",
+		Flags: []cli2.Flag{
+			&cli2.StringFlag{
+				Name: "plan",
+				Usage: "path to build plan YAML file produced by " +
+					"emacs-builder plan",
+				Aliases:   []string{"p"},
+				EnvVars:   []string{"EMACS_BUILDER_PLAN"},
+				TakesFile: true,
+				Required:  true,
+			},
+			&cli2.StringSliceFlag{
+				Name: "enable",
+				Usage: "enable optional patches with given flag name, can " +
+					"be specified multiple times",
+			},
+			&cli2.BoolFlag{
+				Name:  "record",
+				Usage: "record resolved patches in the plan file",
+				Value: true,
+			},
+			&cli2.StringFlag{
+				Name:    "format",
+				Aliases: []string{"f"},
+				Usage:   "output format of patch list (yaml or json)",
+				Value:   "yaml",
+			},
+		},
+		Action: actionWrapper(patchesListAction),
+	}
+}
+
+func patchesListAction(c *cli2.Context, opts *Options) error {
+	logger := hclog.FromContext(c.Context).Named("patches")
+
+	planFile := c.String("plan")
+	p, err := plan.Load(planFile)
+	if err != nil {
+		return err
+	}
+
+	m, err := patch.Load(c.String("manifest"))
+	if err != nil {
+		return err
+	}
+
+	target := &patch.Target{Flags: c.StringSlice("enable")}
+	// The xwidgets patch follows the build option of the same name.
+	if p.Build != nil && p.Build.Options != nil &&
+		p.Build.Options.XWidgets != nil && *p.Build.Options.XWidgets {
+		target.Flags = append(target.Flags, "xwidgets")
+	}
+	if p.Source != nil {
+		target.Ref = p.Source.Ref
+		if p.Source.Commit != nil {
+			target.Date = p.Source.Commit.Date
+		}
+	}
+
+	patches := m.Resolve(target)
+	logger.Info("resolved patches",
+		"ref", target.Ref, "version", m.MajorVersion(target.Ref),
+		"count", len(patches),
+	)
+	if c.Bool("record") {
+		p.Patches = patches
+		logger.Info("updating plan", "file", planFile)
+		err = p.Save(planFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.quiet {
+		return nil
+	}
+
+	return writeReport(c.String("format"), patches)
+}
+
+func patchesApplyCmd() *cli2.Command {
+	return &cli2.Command{
+		Name: "apply",
+		Usage: "apply patches recorded in a build plan to a Emacs " +
+			"source directory",
+		ArgsUsage: "<source-dir>",
+		Flags: []cli2.Flag{
+			&cli2.StringFlag{
+				Name: "plan",
+				Usage: "path to build plan YAML file with patches recorded " +
+					"by emacs-builder patches list",
+				Aliases:   []string{"p"},
+				EnvVars:   []string{"EMACS_BUILDER_PLAN"},
+				TakesFile: true,
+			},
+			&cli2.StringSliceFlag{
+				Name: "patch",
+				Usage: "additional patch file or URL to apply, URLs " +
+					"must end with #sha256=<digest>, can be specified " +
+					"multiple times",
+			},
+			&cli2.BoolFlag{
+				Name: "dry-run",
+				Usage: "check that all patches apply and report the " +
+					"result of each hunk without modifying any files",
+			},
+			&cli2.StringFlag{
+				Name:    "format",
+				Aliases: []string{"f"},
+				Usage: "output format of report printed on dry-run or " +
+					"conflicts (json or yaml)",
+				Value: "json",
+			},
+		},
+		Action: actionWrapper(patchesApplyAction),
+	}
+}
+
+func patchesApplyAction(c *cli2.Context, _ *Options) error {
+	dir := c.Args().Get(0)
+	if dir == "" {
+		return fmt.Errorf("source directory argument is required")
+	}
+
+	var patches []*patch.Patch
+	if f := c.String("plan"); f != "" {
+		p, err := plan.Load(f)
+		if err != nil {
+			return err
+		}
+		patches = append(patches, p.Patches...)
+	}
+
+	for _, s := range c.StringSlice("patch") {
+		p := &patch.Patch{Name: filepath.Base(s)}
+		if u, err := url.Parse(s); err == nil && u.Scheme != "" {
+			if d, ok := strings.CutPrefix(u.Fragment, "sha256="); ok {
+				p.SHA256 = d
+				u.Fragment = ""
+			}
+			p.URL = u.String()
+			p.Name = path.Base(u.Path)
+		} else {
+			p.Path = s
+		}
+		patches = append(patches, p)
+	}
+
+	report, err := patch.Apply(c.Context, dir, patches, &patch.ApplyOptions{
+		DryRun: c.Bool("dry-run"),
+	})
+	if report != nil && (c.Bool("dry-run") || !report.OK()) {
+		reportErr := writeReport(c.String("format"), report)
+		if reportErr != nil {
+			return reportErr
+		}
+	}
+
+	return err
+}
+
+func patchesPinCmd() *cli2.Command {
+	return &cli2.Command{
+		Name: "pin",
+		Usage: "download all patches in the manifest, and set their " +
+			"sha256 digests to that of their current content",
+		Action: actionWrapper(patchesPinAction),
+	}
+}
+
+func patchesPinAction(c *cli2.Context, _ *Options) error {
+	logger := hclog.FromContext(c.Context).Named("patches")
+
+	filename := c.String("manifest")
+	changed, err := patch.Pin(c.Context, filename, nil)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("pinned patches", "manifest", filename,
+		"changed", len(changed),
+	)
+
+	return nil
+}
+
+func writeReport(format string, v interface{}) error {
+	switch format {
+	case "yaml", "yml":
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+
+		return enc.Encode(v)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(v)
+	default:
+		return fmt.Errorf("--format must be yaml or json")
+	}
+}
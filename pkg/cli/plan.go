@@ -1,12 +1,19 @@
 package cli
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/matrix"
+	"github.com/jimeh/build-emacs-for-macos/pkg/osinfo"
 	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	"github.com/jimeh/build-emacs-for-macos/pkg/profile"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
 	cli2 "github.com/urfave/cli/v2"
 )
 
@@ -16,24 +23,44 @@ func planCmd() *cli2.Command {
 		wd = ""
 	}
 
-	tokenDefaultText := ""
-	if len(os.Getenv("GITHUB_TOKEN")) > 0 {
-		tokenDefaultText = "***"
-	}
-
 	return &cli2.Command{
 		Name:      "plan",
 		Usage:     "plan a Emacs.app bundle with codeplan",
 		ArgsUsage: "<branch/tag>",
-		Flags: []cli2.Flag{
+		Flags: flags([]cli2.Flag{
 			&cli2.StringFlag{
 				Name: "emacs-repo",
-				Usage: "GitHub repository to get Emacs commit info and " +
-					"tarball from",
+				Usage: "repository to get Emacs commit info and tarball " +
+					"from; GitHub \"owner/name\", \"gitlab:group/name\", " +
+					"\"savannah\", \"cgit:<url>\", a repository URL, or a " +
+					"path to a local git checkout",
 				Aliases: []string{"e"},
 				EnvVars: []string{"EMACS_REPO"},
 				Value:   "emacs-mirror/emacs",
 			},
+			&cli2.StringFlag{
+				Name: "source-dir",
+				Usage: "local git checkout of Emacs to read commit info from " +
+					"and create source tarball with, instead of using the " +
+					"network; the checked out emacs-* tag or branch is used " +
+					"when no branch/tag is given",
+				EnvVars:   []string{"EMACS_SOURCE_DIR"},
+				TakesFile: true,
+			},
+			&cli2.StringFlag{
+				Name: "tarball-dir",
+				Usage: "directory source tarballs are written to when " +
+					"using --source-dir or a local --emacs-repo",
+				Value: filepath.Join(wd, "tarballs"),
+			},
+			&cli2.StringFlag{
+				Name: "profile",
+				Usage: "name of build profile in --profiles-dir, or path " +
+					"to a profile YAML file, to take build settings from; " +
+					"explicitly given flags take precedence",
+				EnvVars: []string{"EMACS_BUILDER_PROFILE"},
+			},
+			profilesDirFlag(wd),
 			&cli2.StringFlag{
 				Name:  "sha",
 				Usage: "override commit SHA of specified git branch/tag",
@@ -71,38 +98,388 @@ func planCmd() *cli2.Command {
 				Usage: "type of release when doing a test-build " +
 					"(prerelease or draft)",
 			},
+			&cli2.BoolFlag{
+				Name: "skip-existing",
+				Usage: "check if the planned build is already published " +
+					"to --store, and report a skip outcome via " +
+					"--skip-exit-code and GITHUB_OUTPUT if it is",
+			},
+			&cli2.IntFlag{
+				Name: "skip-exit-code",
+				Usage: "exit code used with --skip-existing when the " +
+					"build is already published",
+				Value: 78,
+			},
 			&cli2.StringFlag{
-				Name:        "github-token",
-				Usage:       "GitHub API Token",
-				EnvVars:     []string{"GITHUB_TOKEN"},
-				DefaultText: tokenDefaultText,
+				Name:    "repository",
+				Aliases: []string{"repo", "r"},
+				Usage: "owner/name of GitHub repo builds are published " +
+					"to, required by --skip-existing with the github store",
+				EnvVars: []string{"GITHUB_REPOSITORY"},
 			},
-		},
+			planStoreFlag(),
+		}, planTargetFlags(), planBuildOptionFlags(), apiTokenFlags()),
 		Action: actionWrapper(planAction),
+		Subcommands: []*cli2.Command{
+			planMatrixCmd(),
+			planValidateCmd(),
+			planSchemaCmd(),
+		},
 	}
 }
 
-func planAction(c *cli2.Context, opts *Options) error {
+func planTargetFlags() []cli2.Flag {
+	return []cli2.Flag{
+		&cli2.StringFlag{
+			Name: "target-os-version",
+			Usage: "macOS version to plan build for instead of the " +
+				"host's version",
+		},
+		&cli2.StringFlag{
+			Name: "target-sdk-version",
+			Usage: "macOS SDK version to plan build for instead of the " +
+				"host's SDK version",
+		},
+		&cli2.StringFlag{
+			Name: "target-arch",
+			Usage: "architecture to plan build for instead of the " +
+				"host's architecture (arm64, x86_64 or universal); host " +
+				"OS details are not used when given with either version",
+		},
+	}
+}
+
+func planBuildOptionFlags() []cli2.Flag {
+	return []cli2.Flag{
+		&cli2.BoolFlag{
+			Name: "native-comp",
+			Usage: "enable native-comp, detected based on Emacs " +
+				"version when not set",
+			DefaultText: "auto",
+		},
+		&cli2.BoolFlag{
+			Name:  "native-full-aot",
+			Usage: "enable ahead of time native compilation",
+		},
+		&cli2.BoolFlag{
+			Name: "relink-eln-files",
+			Usage: "re-link shared libraries in bundled *.eln " +
+				"files",
+			Value: true,
+		},
+		&cli2.BoolFlag{
+			Name:  "tree-sitter",
+			Usage: "enable tree-sitter if supported",
+			Value: true,
+		},
+		&cli2.BoolFlag{
+			Name:  "xwidgets",
+			Usage: "enable XWidgets if supported",
+			Value: true,
+		},
+		&cli2.BoolFlag{
+			Name:  "rsvg",
+			Usage: "enable SVG image support via librsvg",
+			Value: true,
+		},
+		&cli2.BoolFlag{
+			Name:  "dbus",
+			Usage: "enable dbus support",
+			Value: true,
+		},
+		&cli2.IntFlag{
+			Name:  "fd-setsize",
+			Usage: "file descriptor (max open files) limit",
+			Value: 10000,
+		},
+		&cli2.StringFlag{
+			Name: "icon-uri",
+			Usage: "local path or URL to a .icns file to replace the " +
+				"default app icon",
+		},
+		&cli2.StringFlag{
+			Name: "tahoe-icon-uri",
+			Usage: "local path or URL to an Assets.car file for " +
+				"macOS 26 icons, requires --tahoe-icon-name",
+		},
+		&cli2.StringFlag{
+			Name:  "tahoe-icon-name",
+			Usage: "name of the icon in Assets.car to use",
+		},
+		&cli2.StringSliceFlag{
+			Name: "configure-arg",
+			Usage: "extra argument to pass to ./configure, can be " +
+				"specified multiple times",
+		},
+	}
+}
+
+// planFlagBuildOptions returns build options of command line flags which were
+// explicitly given.
+func planFlagBuildOptions(c *cli2.Context) *plan.BuildOptions {
+	bo := &plan.BuildOptions{
+		ConfigureArgs: c.StringSlice("configure-arg"),
+	}
+	for name, opt := range map[string]**bool{
+		"native-comp":      &bo.NativeComp,
+		"native-full-aot":  &bo.NativeFullAOT,
+		"relink-eln-files": &bo.RelinkEln,
+		"tree-sitter":      &bo.TreeSitter,
+		"xwidgets":         &bo.XWidgets,
+		"rsvg":             &bo.Rsvg,
+		"dbus":             &bo.DBus,
+	} {
+		if c.IsSet(name) {
+			*opt = boolPtr(c.Bool(name))
+		}
+	}
+	if c.IsSet("fd-setsize") {
+		bo.FDSetSize = c.Int("fd-setsize")
+	}
+
+	icon := &plan.Icon{
+		URI:       c.String("icon-uri"),
+		TahoeURI:  c.String("tahoe-icon-uri"),
+		TahoeName: c.String("tahoe-icon-name"),
+	}
+	if *icon != (plan.Icon{}) {
+		bo.Icon = icon
+	}
+
+	return bo
+}
+
+// planStoreFlag returns the flag selecting the release store which is checked
+// for published builds.
+func planStoreFlag() *cli2.StringFlag {
+	return &cli2.StringFlag{
+		Name: "store",
+		Usage: "release store builds are published to, see " +
+			"\"release --store\" for supported values",
+		EnvVars: []string{"EMACS_BUILDER_RELEASE_STORE"},
+		Value:   "github",
+	}
+}
+
+// publishedStore opens the release store given by --store, which is checked
+// for published builds.
+func publishedStore(c *cli2.Context) (release.Store, error) {
+	storeOpts := &release.StoreOptions{
+		GithubToken: c.String("github-token"),
+	}
+	if r := c.String("repository"); r != "" {
+		var err error
+		storeOpts.Repository, err = repository.NewGitHub(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return release.OpenStore(c.Context, c.String("store"), storeOpts)
+}
+
+func planMatrixCmd() *cli2.Command {
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = ""
+	}
+
+	return &cli2.Command{
+		Name: "matrix",
+		Usage: "plan builds for all combinations of refs, profiles and " +
+			"architectures in a matrix config, and print a GitHub " +
+			"Actions job matrix of them",
+		Flags: append([]cli2.Flag{
+			&cli2.StringFlag{
+				Name:      "config",
+				Aliases:   []string{"c"},
+				Usage:     "path to matrix config YAML file",
+				EnvVars:   []string{"EMACS_BUILDER_MATRIX"},
+				Value:     filepath.Join(wd, "matrix.yml"),
+				TakesFile: true,
+			},
+			&cli2.StringFlag{
+				Name:  "plan-dir",
+				Usage: "directory to write plan files to",
+				Value: filepath.Join(wd, "plans"),
+			},
+			profilesDirFlag(wd),
+			&cli2.StringFlag{
+				Name:  "output-dir",
+				Usage: "output directory where build results are stored",
+				Value: filepath.Join(wd, "builds"),
+			},
+			&cli2.StringFlag{
+				Name: "output",
+				Usage: "output filename to write matrix JSON to instead of " +
+					"printing to STDOUT",
+				Aliases: []string{"o"},
+			},
+			&cli2.BoolFlag{
+				Name: "skip-published",
+				Usage: "leave out builds which already have a published " +
+					"release with a disk image",
+				Value: true,
+			},
+			&cli2.StringFlag{
+				Name:    "repository",
+				Aliases: []string{"repo", "r"},
+				Usage: "owner/name of GitHub repo builds are published " +
+					"to, required by --skip-published with the github store",
+				EnvVars: []string{"GITHUB_REPOSITORY"},
+			},
+			planStoreFlag(),
+		}, apiTokenFlags()...),
+		Action: actionWrapper(planMatrixAction),
+	}
+}
+
+func planMatrixAction(c *cli2.Context, _ *Options) error {
 	logger := hclog.FromContext(c.Context).Named("plan")
 
-	ref := c.Args().Get(0)
-	if ref == "" {
-		ref = "master"
+	cfg, err := matrix.LoadConfig(c.String("config"))
+	if err != nil {
+		return err
 	}
 
-	planOpts := &plan.Options{
-		EmacsRepo:     c.String("emacs-repo"),
-		Ref:           ref,
-		SHAOverride:   c.String("sha"),
-		BuildVariant:  c.Int("build-variant"),
-		OutputDir:     c.String("output-dir"),
-		TestBuild:     c.String("test-build"),
-		TestBuildType: plan.Prerelease,
-		GithubToken:   c.String("github-token"),
+	matrixOpts := &matrix.Options{
+		Plan: &plan.Options{
+			EmacsRepo:    "emacs-mirror/emacs",
+			OutputDir:    c.String("output-dir"),
+			GithubToken:  c.String("github-token"),
+			GitlabToken:  c.String("gitlab-token"),
+			BuildOptions: plan.DefaultBuildOptions(),
+		},
+		ProfilesDir: c.String("profiles-dir"),
+		PlanDir:     c.String("plan-dir"),
 	}
 
-	if c.String("test-release-type") == "draft" {
-		planOpts.TestBuildType = plan.Draft
+	if c.Bool("skip-published") {
+		store, err := publishedStore(c)
+		if err != nil {
+			return fmt.Errorf("--skip-published: %w", err)
+		}
+		matrixOpts.Exists = func(
+			ctx context.Context,
+			p *plan.Plan,
+		) (bool, error) {
+			return p.Published(ctx, store)
+		}
+	}
+
+	result, err := matrix.Plan(c.Context, cfg, matrixOpts)
+	if err != nil {
+		return err
+	}
+	logger.Info("planned matrix",
+		"builds", len(result.Include), "skipped", len(result.Skipped),
+	)
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if f := c.String("output"); f != "" {
+		logger.Info("writing matrix", "file", f)
+
+		return os.WriteFile(f, b, 0o644) //nolint:gosec
+	}
+
+	_, err = os.Stdout.Write(b)
+
+	return err
+}
+
+func planValidateCmd() *cli2.Command {
+	return &cli2.Command{
+		Name: "validate",
+		Usage: "validate a build plan against the plan schema and check " +
+			"it for inconsistencies",
+		ArgsUsage: "<plan.yml>",
+		Action:    actionWrapper(planValidateAction),
+	}
+}
+
+func planValidateAction(c *cli2.Context, opts *Options) error {
+	filename := c.Args().Get(0)
+	if filename == "" {
+		return fmt.Errorf("%w: no plan file given", plan.ErrInvalid)
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	problems, err := plan.Validate(b)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", filename, p)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf(
+			"%w: %s has %d problems", plan.ErrInvalid, filename, len(problems),
+		)
+	}
+
+	if !opts.quiet {
+		fmt.Printf("%s: valid\n", filename)
+	}
+
+	return nil
+}
+
+func planSchemaCmd() *cli2.Command {
+	return &cli2.Command{
+		Name:  "schema",
+		Usage: "print JSON Schema of build plans",
+		Flags: []cli2.Flag{
+			&cli2.StringFlag{
+				Name: "output",
+				Usage: "output filename to write schema to instead of " +
+					"printing to STDOUT",
+				Aliases: []string{"o"},
+			},
+		},
+		Action: actionWrapper(planSchemaAction),
+	}
+}
+
+func planSchemaAction(c *cli2.Context, _ *Options) error {
+	b, err := plan.SchemaJSON()
+	if err != nil {
+		return err
+	}
+
+	if f := c.String("output"); f != "" {
+		return os.WriteFile(f, b, 0o644) //nolint:gosec
+	}
+
+	_, err = os.Stdout.Write(b)
+
+	return err
+}
+
+func planAction(c *cli2.Context, opts *Options) error {
+	logger := hclog.FromContext(c.Context).Named("plan")
+
+	planOpts, err := planOptions(c)
+	if err != nil {
+		return err
+	}
+
+	var builds release.Store
+	if c.Bool("skip-existing") {
+		builds, err = publishedStore(c)
+		if err != nil {
+			return fmt.Errorf("--skip-existing: %w", err)
+		}
 	}
 
 	if !opts.quiet {
@@ -114,6 +491,14 @@ func planAction(c *cli2.Context, opts *Options) error {
 		return err
 	}
 
+	published := false
+	if builds != nil {
+		published, err = p.Published(c.Context, builds)
+		if err != nil {
+			return err
+		}
+	}
+
 	format := c.String("format")
 	var plan string
 	switch format {
@@ -147,5 +532,107 @@ func planAction(c *cli2.Context, opts *Options) error {
 		return err
 	}
 
+	if builds == nil {
+		return nil
+	}
+
+	return planSkipOutcome(c, p, published)
+}
+
+// planOptions returns plan options from command line flags and the profile
+// given by --profile.
+func planOptions(c *cli2.Context) (*plan.Options, error) {
+	logger := hclog.FromContext(c.Context).Named("plan")
+
+	ref := c.Args().Get(0)
+	if ref == "" && c.String("source-dir") == "" {
+		ref = "master"
+	}
+
+	planOpts := &plan.Options{
+		EmacsRepo:     c.String("emacs-repo"),
+		SourceDir:     c.String("source-dir"),
+		TarballDir:    c.String("tarball-dir"),
+		Ref:           ref,
+		SHAOverride:   c.String("sha"),
+		BuildVariant:  c.Int("build-variant"),
+		OutputDir:     c.String("output-dir"),
+		TestBuild:     c.String("test-build"),
+		TestBuildType: planTestBuildType(c.String("test-release-type")),
+		GithubToken:   c.String("github-token"),
+		GitlabToken:   c.String("gitlab-token"),
+		BuildOptions:  plan.DefaultBuildOptions(),
+		Target: &osinfo.Target{
+			Version:    c.String("target-os-version"),
+			SDKVersion: c.String("target-sdk-version"),
+			Arch:       c.String("target-arch"),
+		},
+	}
+
+	// Profile settings take precedence over defaults, but not over flags
+	// which were explicitly given.
+	if name := c.String("profile"); name != "" {
+		prof, err := profile.Load(c.String("profiles-dir"), name)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("using profile", "name", prof.Name, "file", prof.File())
+		prof.Apply(planOpts)
+
+		if c.IsSet("emacs-repo") {
+			planOpts.EmacsRepo = c.String("emacs-repo")
+		}
+		if c.IsSet("build-variant") {
+			planOpts.BuildVariant = c.Int("build-variant")
+		}
+		if c.IsSet("test-build") {
+			planOpts.TestBuild = c.String("test-build")
+		}
+		if c.IsSet("test-release-type") {
+			planOpts.TestBuildType = planTestBuildType(
+				c.String("test-release-type"),
+			)
+		}
+	}
+	planOpts.BuildOptions = planOpts.BuildOptions.Merge(
+		planFlagBuildOptions(c),
+	)
+
+	return planOpts, nil
+}
+
+// planSkipOutcome reports if the build of a plan should be skipped as it is
+// already published, as GitHub Actions step outputs and the exit code.
+func planSkipOutcome(c *cli2.Context, p *plan.Plan, skip bool) error {
+	logger := hclog.FromContext(c.Context).Named("plan")
+
+	err := p.WriteSkipOutputs(skip, c.String("output"))
+	if err != nil {
+		return err
+	}
+
+	if !skip {
+		return nil
+	}
+
+	logger.Info("build already published, skipping",
+		"release", p.Release.Name, "disk_image", p.Output.DiskImage,
+	)
+	if code := c.Int("skip-exit-code"); code != 0 {
+		return cli2.Exit("", code)
+	}
+
 	return nil
 }
+
+func planTestBuildType(s string) plan.TestBuildType {
+	if s == string(plan.Draft) {
+		return plan.Draft
+	}
+
+	return plan.Prerelease
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
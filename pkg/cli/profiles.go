@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/profile"
+	cli2 "github.com/urfave/cli/v2"
+)
+
+func profilesDirFlag(wd string) *cli2.StringFlag {
+	return &cli2.StringFlag{
+		Name:      "profiles-dir",
+		Usage:     "directory containing build profile YAML files",
+		EnvVars:   []string{"EMACS_BUILDER_PROFILES_DIR"},
+		Value:     filepath.Join(wd, "profiles"),
+		TakesFile: true,
+	}
+}
+
+func profilesCmd() *cli2.Command {
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = ""
+	}
+
+	return &cli2.Command{
+		Name:  "profiles",
+		Usage: "inspect build profiles used by emacs-builder plan",
+		Flags: []cli2.Flag{
+			profilesDirFlag(wd),
+		},
+		Subcommands: []*cli2.Command{
+			{
+				Name:   "list",
+				Usage:  "list available build profiles",
+				Action: actionWrapper(profilesListAction),
+			},
+			{
+				Name:      "show",
+				Usage:     "show settings of a build profile",
+				ArgsUsage: "<name>",
+				Flags: []cli2.Flag{
+					&cli2.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Usage:   "output format of profile (yaml or json)",
+						Value:   "yaml",
+					},
+				},
+				Action: actionWrapper(profilesShowAction),
+			},
+		},
+	}
+}
+
+func profilesListAction(c *cli2.Context, _ *Options) error {
+	profiles, err := profile.List(c.String("profiles-dir"))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, p := range profiles {
+		fmt.Fprintf(w, "%s\t%s\n", p.Name, p.Description)
+	}
+
+	return w.Flush()
+}
+
+func profilesShowAction(c *cli2.Context, _ *Options) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return fmt.Errorf("%w: no profile name given", profile.Err)
+	}
+
+	p, err := profile.Load(c.String("profiles-dir"), name)
+	if err != nil {
+		return err
+	}
+
+	return writeReport(c.String("format"), p)
+}
@@ -5,20 +5,33 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/manifest"
 	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
 	"github.com/jimeh/build-emacs-for-macos/pkg/release"
 	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	"github.com/jimeh/build-emacs-for-macos/pkg/source"
 	cli2 "github.com/urfave/cli/v2"
 )
 
 type releaseOptions struct {
 	Plan        *plan.Plan
 	Repository  *repository.Repository
+	Store       string
 	Name        string
 	GithubToken string
 }
 
+// store opens the release store which check and publish operate on.
+func (s *releaseOptions) store(c *cli2.Context) (release.Store, error) {
+	return release.OpenStore(c.Context, s.Store, &release.StoreOptions{
+		Repository:  s.Repository,
+		GithubToken: s.GithubToken,
+	})
+}
+
 func releaseCmd() *cli2.Command {
 	tokenDefaultText := ""
 	if len(os.Getenv("GITHUB_TOKEN")) > 0 {
@@ -45,6 +58,15 @@ func releaseCmd() *cli2.Command {
 				EnvVars: []string{"GITHUB_REPOSITORY"},
 				Value:   "",
 			},
+			&cli2.StringFlag{
+				Name: "store",
+				Usage: "release store used by check and publish, " +
+					"\"github\" for GitHub Releases of --repository, " +
+					"\"s3://<bucket>/<prefix>\" for S3-compatible " +
+					"storage, or \"file://<path>\" for a local directory",
+				EnvVars: []string{"EMACS_BUILDER_RELEASE_STORE"},
+				Value:   "github",
+			},
 			&cli2.StringFlag{
 				Name:    "name",
 				Aliases: []string{"n"},
@@ -62,6 +84,7 @@ func releaseCmd() *cli2.Command {
 			releaseCheckCmd(),
 			releasePublishCmd(),
 			releaseBulkCmd(),
+			releasePruneCmd(),
 		},
 	}
 }
@@ -71,6 +94,7 @@ func releaseActionWrapper(
 ) func(*cli2.Context) error {
 	return actionWrapper(func(c *cli2.Context, opts *Options) error {
 		rOpts := &releaseOptions{
+			Store:       c.String("store"),
 			Name:        c.String("name"),
 			GithubToken: c.String("github-token"),
 		}
@@ -99,7 +123,7 @@ func releaseActionWrapper(
 func releaseCheckCmd() *cli2.Command {
 	return &cli2.Command{
 		Name: "check",
-		Usage: "check if a GitHub release exists and has specified " +
+		Usage: "check if a release exists and has specified " +
 			"asset files",
 		ArgsUsage: "[<asset-file> ...]",
 		Action:    releaseActionWrapper(releaseCheckAction),
@@ -125,13 +149,19 @@ func releaseCheckAction(
 		rlsOpts.AssetFiles = []string{rOpts.Plan.Output.DiskImage}
 	}
 
+	var err error
+	rlsOpts.Store, err = rOpts.store(c)
+	if err != nil {
+		return err
+	}
+
 	return release.Check(c.Context, rlsOpts)
 }
 
 func releasePublishCmd() *cli2.Command {
 	return &cli2.Command{
 		Name: "publish",
-		Usage: "publish a GitHub release with specified asset " +
+		Usage: "publish a release with specified asset " +
 			"files",
 		ArgsUsage: "[<asset-file> ...]",
 		Flags: []cli2.Flag{
@@ -156,9 +186,50 @@ func releasePublishCmd() *cli2.Command {
 			&cli2.BoolFlag{
 				Name: "asset-size-check",
 				Usage: "Do not replace existing asset files if local and " +
-					"remote file sizes match.",
+					"remote file sizes and SHA256 checksums match.",
 				Value: false,
 			},
+			&cli2.IntFlag{
+				Name:  "upload-concurrency",
+				Usage: "maximum number of asset files uploaded in parallel",
+				Value: release.DefaultUploadConcurrency,
+			},
+			&cli2.IntFlag{
+				Name: "upload-retries",
+				Usage: "number of times failed requests are retried when " +
+					"uploading asset files, -1 to disable",
+				Value: release.DefaultUploadRetries,
+			},
+			&cli2.StringFlag{
+				Name: "body-template",
+				Usage: "path to Go template file to render release body " +
+					"with, instead of the default template",
+				TakesFile: true,
+			},
+			&cli2.BoolFlag{
+				Name: "changelog",
+				Usage: "list source commits since previous release of the " +
+					"same channel in release body",
+				Value: true,
+			},
+			&cli2.BoolFlag{
+				Name: "manifest",
+				Usage: "generate and upload a manifest describing the " +
+					"build, requires a plan",
+				Value: true,
+			},
+			&cli2.BoolFlag{
+				Name: "signed",
+				Usage: "record in manifest if the build was code signed, " +
+					"overriding the status recorded in the plan by " +
+					"emacs-builder package",
+			},
+			&cli2.BoolFlag{
+				Name: "notarized",
+				Usage: "record in manifest if the disk image was " +
+					"notarized, overriding the status recorded in the " +
+					"plan by emacs-builder package",
+			},
 		},
 		Action: releaseActionWrapper(releasePublishAction),
 	}
@@ -177,6 +248,24 @@ func releasePublishAction(
 		AssetFiles:     c.Args().Slice(),
 		AssetSizeCheck: c.Bool("asset-size-check"),
 		GithubToken:    rOpts.GithubToken,
+
+		UploadConcurrency: c.Int("upload-concurrency"),
+		UploadRetries:     c.Int("upload-retries"),
+		Changelog:         c.Bool("changelog"),
+	}
+
+	var err error
+	rlsOpts.Store, err = rOpts.store(c)
+	if err != nil {
+		return err
+	}
+
+	if f := c.String("body-template"); f != "" {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		rlsOpts.BodyTemplate = string(b)
 	}
 
 	rlsType := c.String("type")
@@ -196,6 +285,7 @@ func releasePublishAction(
 	}
 
 	if rOpts.Plan != nil {
+		rlsOpts.Plan = rOpts.Plan
 		rlsOpts.Source = rOpts.Plan.Source
 
 		if rOpts.Plan.Release != nil {
@@ -218,11 +308,48 @@ func releasePublishAction(
 				),
 			}
 		}
+
+		if c.Bool("manifest") && len(rlsOpts.AssetFiles) > 0 &&
+			rOpts.Plan.Build != nil && rOpts.Plan.Release != nil {
+			file, err := writeManifest(c, rOpts.Plan, rlsOpts.AssetFiles)
+			if err != nil {
+				return err
+			}
+			rlsOpts.AssetFiles = append(rlsOpts.AssetFiles, file)
+		}
 	}
 
 	return release.Publish(c.Context, rlsOpts)
 }
 
+// writeManifest writes the manifest of the build described by given plan
+// next to the first asset file, and returns its filename.
+func writeManifest(
+	c *cli2.Context,
+	p *plan.Plan,
+	assetFiles []string,
+) (string, error) {
+	logger := hclog.FromContext(c.Context).Named("release")
+
+	m, err := manifest.New(p, assetFiles)
+	if err != nil {
+		return "", err
+	}
+	if c.IsSet("signed") {
+		m.Signed = boolPtr(c.Bool("signed"))
+	}
+	if c.IsSet("notarized") {
+		m.Notarized = boolPtr(c.Bool("notarized"))
+	}
+
+	file := filepath.Join(
+		filepath.Dir(assetFiles[0]), manifest.Filename(p.Build.Name),
+	)
+	logger.Info("writing manifest", "file", file)
+
+	return file, m.Save(file)
+}
+
 func releaseBulkCmd() *cli2.Command {
 	return &cli2.Command{
 		Name:      "bulk",
@@ -233,11 +360,60 @@ func releaseBulkCmd() *cli2.Command {
 				Name:  "name",
 				Usage: "regexp pattern matching release names to modify",
 			},
+			&cli2.StringSliceFlag{
+				Name: "channel",
+				Usage: "only modify releases of given channel (stable, " +
+					"release-candidate, pretest or nightly), can be " +
+					"specified multiple times",
+			},
 			&cli2.StringFlag{
 				Name: "prerelease",
 				Usage: "change prerelease flag, must be \"true\" or " +
 					"\"false\", otherwise prerelease value is not changed",
 			},
+			&cli2.StringFlag{
+				Name: "draft",
+				Usage: "change draft state, must be \"true\" or " +
+					"\"false\", otherwise draft state is not changed",
+			},
+			&cli2.StringFlag{
+				Name: "title",
+				Usage: "Go template to set release titles to, with " +
+					"{{.Name}}, {{.Tag}}, {{.Title}} and {{.Version}} " +
+					"available",
+			},
+			&cli2.StringFlag{
+				Name: "plan-dir",
+				Usage: "directory of build plan YAML files, bodies of " +
+					"releases with a plan are regenerated",
+				TakesFile: true,
+			},
+			&cli2.StringFlag{
+				Name: "body-template",
+				Usage: "(with --plan-dir) path to Go template file to " +
+					"render release bodies with, instead of the default " +
+					"template",
+				TakesFile: true,
+			},
+			&cli2.BoolFlag{
+				Name: "changelog",
+				Usage: "(with --plan-dir) list source commits since " +
+					"previous release of the same channel in release bodies",
+				Value: true,
+			},
+			&cli2.StringFlag{
+				Name:  "remove-asset",
+				Usage: "regexp pattern matching asset filenames to delete",
+			},
+			&cli2.StringFlag{
+				Name:  "rename-asset",
+				Usage: "regexp pattern matching asset filenames to rename",
+			},
+			&cli2.StringFlag{
+				Name: "rename-to",
+				Usage: "replacement for asset filenames matched by " +
+					"--rename-asset, may refer to submatches like $1",
+			},
 			&cli2.BoolFlag{
 				Name:  "dry-run",
 				Usage: "do not perform any changes",
@@ -252,26 +428,212 @@ func releaseBulkAction(
 	_ *Options,
 	rOpts *releaseOptions,
 ) error {
+	if c.IsSet("rename-asset") != c.IsSet("rename-to") {
+		return errors.New(
+			"--rename-asset and --rename-to must be specified together",
+		)
+	}
+
 	bulkOpts := &release.BulkOptions{
-		Repository:  rOpts.Repository,
-		NamePattern: c.String("name"),
-		DryRun:      c.Bool("dry-run"),
-		GithubToken: rOpts.GithubToken,
+		Repository:     rOpts.Repository,
+		NamePattern:    c.String("name"),
+		TitleTemplate:  c.String("title"),
+		RemoveAssets:   c.String("remove-asset"),
+		RenameAssets:   c.String("rename-asset"),
+		RenameAssetsTo: c.String("rename-to"),
+		Changelog:      c.Bool("changelog"),
+		DryRun:         c.Bool("dry-run"),
+		GithubToken:    rOpts.GithubToken,
 	}
 
-	switch c.String("prerelease") {
+	if f := c.String("body-template"); f != "" {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		bulkOpts.BodyTemplate = string(b)
+	}
+
+	var err error
+	bulkOpts.Channels, err = releaseChannels(c.StringSlice("channel"))
+	if err != nil {
+		return err
+	}
+
+	bulkOpts.Prerelease, err = optionalBool(
+		"prerelease", c.String("prerelease"),
+	)
+	if err != nil {
+		return err
+	}
+
+	bulkOpts.Draft, err = optionalBool("draft", c.String("draft"))
+	if err != nil {
+		return err
+	}
+
+	if dir := c.String("plan-dir"); dir != "" {
+		bulkOpts.Sources, err = planSources(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return release.Bulk(c.Context, bulkOpts)
+}
+
+// optionalBool parses a flag value which must be "true", "false", or empty
+// to leave the value unchanged.
+func optionalBool(flag, value string) (*bool, error) {
+	switch value {
 	case "true":
 		v := true
-		bulkOpts.Prerelease = &v
+
+		return &v, nil
 	case "false":
 		v := false
-		bulkOpts.Prerelease = &v
+
+		return &v, nil
 	case "":
+		return nil, nil
 	default:
-		return errors.New(
-			"--prerelease by me \"true\" or \"false\" when specified",
+		return nil, fmt.Errorf(
+			"--%s must be \"true\" or \"false\" when specified", flag,
 		)
 	}
+}
 
-	return release.Bulk(c.Context, bulkOpts)
+// releaseChannels parses and validates --channel flag values.
+func releaseChannels(values []string) ([]release.Channel, error) {
+	var channels []release.Channel
+	for _, ch := range values {
+		switch channel := release.Channel(ch); channel {
+		case release.Stable, release.RC, release.Pretest, release.Nightly:
+			channels = append(channels, channel)
+		default:
+			return nil, fmt.Errorf("--channel: unknown channel %q", ch)
+		}
+	}
+
+	return channels, nil
+}
+
+// planSources loads all build plans in given directory, and returns their
+// sources keyed by release name.
+func planSources(dir string) (map[string]*source.Source, error) {
+	var files []string
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	sources := map[string]*source.Source{}
+	for _, file := range files {
+		p, err := plan.Load(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if p.Release == nil || p.Release.Name == "" || p.Source == nil {
+			continue
+		}
+
+		sources[p.Release.Name] = p.Source
+	}
+
+	return sources, nil
+}
+
+func releasePruneCmd() *cli2.Command {
+	return &cli2.Command{
+		Name: "prune",
+		Usage: "delete old GitHub releases and their tags according to " +
+			"retention policies",
+		Flags: []cli2.Flag{
+			&cli2.StringSliceFlag{
+				Name: "channel",
+				Usage: "release channel to prune (stable, " +
+					"release-candidate, pretest or nightly), can be " +
+					"specified multiple times",
+				Value: cli2.NewStringSlice(string(release.Nightly)),
+			},
+			&cli2.IntFlag{
+				Name:  "keep-last",
+				Usage: "number of most recent releases to keep per channel",
+				Value: 30,
+			},
+			&cli2.IntFlag{
+				Name: "weekly-after",
+				Usage: "keep latest release of each week for releases " +
+					"older than given number of days, 0 to disable",
+				Value: 60,
+			},
+			&cli2.IntFlag{
+				Name: "monthly-after",
+				Usage: "keep latest release of each month for releases " +
+					"older than given number of days, 0 to disable",
+				Value: 180,
+			},
+			&cli2.StringFlag{
+				Name:    "tap-repository",
+				Aliases: []string{"tap"},
+				Usage: "owner/name of GitHub repo for Homebrew Tap, " +
+					"releases referenced by its casks are never deleted",
+				Value: "jimeh/homebrew-emacs-builds",
+			},
+			&cli2.StringFlag{
+				Name:  "tap-ref",
+				Usage: "git ref of tap repository to read casks from",
+			},
+			&cli2.BoolFlag{
+				Name:  "dry-run",
+				Usage: "list releases which would be deleted",
+			},
+		},
+		Action: releaseActionWrapper(releasePruneAction),
+	}
+}
+
+func releasePruneAction(
+	c *cli2.Context,
+	opts *Options,
+	rOpts *releaseOptions,
+) error {
+	if rOpts.Repository == nil {
+		return errors.New("--repository is required")
+	}
+
+	day := 24 * time.Hour
+	pruneOpts := &release.PruneOptions{
+		Repository: rOpts.Repository,
+		Policy: &release.RetentionPolicy{
+			KeepLast:     c.Int("keep-last"),
+			WeeklyAfter:  time.Duration(c.Int("weekly-after")) * day,
+			MonthlyAfter: time.Duration(c.Int("monthly-after")) * day,
+		},
+		TapRef:      c.String("tap-ref"),
+		DryRun:      c.Bool("dry-run"),
+		GithubToken: rOpts.GithubToken,
+	}
+
+	var err error
+	pruneOpts.Channels, err = releaseChannels(c.StringSlice("channel"))
+	if err != nil {
+		return err
+	}
+
+	if r := c.String("tap-repository"); r != "" {
+		pruneOpts.TapRepository, err = repository.NewGitHub(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !opts.quiet {
+		pruneOpts.Output = os.Stdout
+	}
+
+	return release.Prune(c.Context, pruneOpts)
 }
@@ -0,0 +1,247 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/sparkle"
+	cli2 "github.com/urfave/cli/v2"
+)
+
+func signUpdateCmd() *cli2.Command {
+	return &cli2.Command{
+		Name: "sign-update",
+		Usage: "sign update archive with Ed25519 key, producing " +
+			"sparkle:edSignature and length appcast attributes",
+		ArgsUsage: "<dmg>",
+		Flags: []cli2.Flag{
+			&cli2.StringFlag{
+				Name:      "key",
+				Aliases:   []string{"k"},
+				Usage:     "file containing base64 encoded private key",
+				EnvVars:   []string{"EMACS_BUILDER_ED_KEY_FILE"},
+				TakesFile: true,
+			},
+			&cli2.BoolFlag{
+				Name:    "write",
+				Aliases: []string{"w"},
+				Usage: "write signature to <dmg>" + sparkle.SignatureSuffix +
+					" file",
+			},
+		},
+		Subcommands: []*cli2.Command{
+			signUpdateGenerateKeyCmd(),
+			signUpdateVerifyCmd(),
+		},
+		Action: actionWrapper(signUpdateAction),
+	}
+}
+
+func signUpdateAction(c *cli2.Context, _ *Options) error {
+	file := c.Args().First()
+	if file == "" {
+		return errors.New("no file argument given")
+	}
+
+	if c.String("key") == "" {
+		return errors.New("--key is required")
+	}
+
+	key, err := sparkle.LoadPrivateKey(c.String("key"))
+	if err != nil {
+		return err
+	}
+
+	sig, err := signUpdate(c, file, key, c.Bool("write"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(sig.String())
+
+	return nil
+}
+
+// signUpdate signs given file, optionally writing the signature next to it.
+func signUpdate(
+	c *cli2.Context,
+	file string,
+	key ed25519.PrivateKey,
+	write bool,
+) (*sparkle.Signature, error) {
+	logger := hclog.FromContext(c.Context).Named("sign-update")
+
+	logger.Info("signing update", "file", file)
+	sig, err := sparkle.SignFile(file, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if write {
+		sigFile := file + sparkle.SignatureSuffix
+		err = sig.Save(sigFile)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("wrote signature", "file", sigFile)
+	}
+
+	return sig, nil
+}
+
+func signUpdateGenerateKeyCmd() *cli2.Command {
+	return &cli2.Command{
+		Name: "generate-key",
+		Usage: "generate Ed25519 key pair, writing private key to " +
+			"given file and printing public key",
+		ArgsUsage: "<key-file>",
+		Flags: []cli2.Flag{
+			&cli2.BoolFlag{
+				Name:    "force",
+				Aliases: []string{"f"},
+				Usage:   "overwrite existing key file",
+			},
+		},
+		Action: actionWrapper(signUpdateGenerateKeyAction),
+	}
+}
+
+func signUpdateGenerateKeyAction(c *cli2.Context, opts *Options) error {
+	file := c.Args().First()
+	if file == "" {
+		return errors.New("no key file argument given")
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if c.Bool("force") {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+
+	pub, key, err := sparkle.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, flags, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(f, sparkle.EncodePrivateKey(key))
+	if err != nil {
+		f.Close()
+
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	if opts.quiet {
+		fmt.Println(sparkle.EncodePublicKey(pub))
+	} else {
+		fmt.Printf(
+			"Wrote private key to %s, keep it secret.\n\n"+
+				"Add the public key to Info.plist as SUPublicEDKey:\n\n%s\n",
+			file, sparkle.EncodePublicKey(pub),
+		)
+	}
+
+	return nil
+}
+
+func signUpdateVerifyCmd() *cli2.Command {
+	return &cli2.Command{
+		Name:      "verify",
+		Usage:     "verify Ed25519 signature of update archive",
+		ArgsUsage: "<dmg>",
+		Flags: []cli2.Flag{
+			&cli2.StringFlag{
+				Name:    "public-key",
+				Aliases: []string{"p"},
+				Usage:   "base64 encoded public key",
+				EnvVars: []string{"EMACS_BUILDER_ED_PUBLIC_KEY"},
+			},
+			&cli2.StringFlag{
+				Name:      "key",
+				Aliases:   []string{"k"},
+				Usage:     "private key file, used if --public-key is not set",
+				EnvVars:   []string{"EMACS_BUILDER_ED_KEY_FILE"},
+				TakesFile: true,
+			},
+			&cli2.StringFlag{
+				Name:    "signature",
+				Aliases: []string{"s"},
+				Usage: "base64 encoded signature, or sign-update output, " +
+					"defaults to reading <dmg>" + sparkle.SignatureSuffix,
+			},
+		},
+		Action: actionWrapper(signUpdateVerifyAction),
+	}
+}
+
+func signUpdateVerifyAction(c *cli2.Context, opts *Options) error {
+	file := c.Args().First()
+	if file == "" {
+		return errors.New("no file argument given")
+	}
+
+	var pub ed25519.PublicKey
+	switch {
+	case c.String("public-key") != "":
+		var err error
+		pub, err = sparkle.ParsePublicKey(c.String("public-key"))
+		if err != nil {
+			return err
+		}
+	case c.String("key") != "":
+		key, err := sparkle.LoadPrivateKey(c.String("key"))
+		if err != nil {
+			return err
+		}
+		pub = key.Public().(ed25519.PublicKey)
+	default:
+		return errors.New("--public-key or --key is required")
+	}
+
+	sig, err := verifySignature(file, c.String("signature"))
+	if err != nil {
+		return err
+	}
+
+	err = sparkle.VerifyFile(file, pub, sig)
+	if err != nil {
+		return err
+	}
+
+	if !opts.quiet {
+		fmt.Printf("%s: signature is valid\n", file)
+	}
+
+	return nil
+}
+
+// verifySignature returns the signature to verify given file against, parsed
+// from s, or read from the file's signature file if s is empty. A bare
+// base64 signature is assumed to be for the file's current size.
+func verifySignature(file, s string) (*sparkle.Signature, error) {
+	if s == "" {
+		return sparkle.LoadSignature(file + sparkle.SignatureSuffix)
+	}
+
+	if sig, err := sparkle.ParseSignature(s); err == nil {
+		return sig, nil
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sparkle.Signature{EdSignature: s, Length: info.Size()}, nil
+}
@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	"github.com/jimeh/build-emacs-for-macos/pkg/source"
+	cli2 "github.com/urfave/cli/v2"
+)
+
+func sourceCmd() *cli2.Command {
+	return &cli2.Command{
+		Name:  "source",
+		Usage: "manage Emacs source tarballs",
+		Subcommands: []*cli2.Command{
+			sourceFetchCmd(),
+		},
+	}
+}
+
+func sourceFetchCmd() *cli2.Command {
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = ""
+	}
+
+	cacheDir := filepath.Join(wd, "tarballs")
+	if dir, err := os.UserCacheDir(); err == nil {
+		cacheDir = filepath.Join(dir, "emacs-builder", "tarballs")
+	}
+
+	return &cli2.Command{
+		Name: "fetch",
+		Usage: "download, verify and extract the source tarball of a " +
+			"build plan, recording its SHA256 digest in the plan",
+		Flags: append([]cli2.Flag{
+			&cli2.StringFlag{
+				Name: "plan",
+				Usage: "path to build plan YAML file produced by " +
+					"emacs-builder plan",
+				Aliases:   []string{"p"},
+				EnvVars:   []string{"EMACS_BUILDER_PLAN"},
+				TakesFile: true,
+				Required:  true,
+			},
+			&cli2.StringFlag{
+				Name:    "cache-dir",
+				Usage:   "directory downloaded tarballs are cached in",
+				EnvVars: []string{"EMACS_BUILDER_CACHE_DIR"},
+				Value:   cacheDir,
+			},
+			&cli2.StringFlag{
+				Name:  "sources-dir",
+				Usage: "directory tarballs are extracted into",
+				Value: filepath.Join(wd, "sources"),
+			},
+			&cli2.IntFlag{
+				Name:  "retries",
+				Usage: "number of times to retry a failed download",
+				Value: 3,
+			},
+		}, apiTokenFlags()...),
+		Action: actionWrapper(sourceFetchAction),
+	}
+}
+
+func sourceFetchAction(c *cli2.Context, _ *Options) error {
+	logger := hclog.FromContext(c.Context).Named("source")
+
+	planFile := c.String("plan")
+	p, err := plan.Load(planFile)
+	if err != nil {
+		return err
+	}
+
+	if p.Source == nil {
+		return source.ErrNoTarball
+	}
+
+	// Record the digest even if extraction fails, so a later run can detect
+	// a corrupted cache.
+	res, err := source.Fetch(c.Context, p.Source, &source.FetchOptions{
+		CacheDir:    c.String("cache-dir"),
+		SourcesDir:  c.String("sources-dir"),
+		Retries:     c.Int("retries"),
+		GithubToken: c.String("github-token"),
+	})
+	if p.Source.Tarball != nil && p.Source.Tarball.SHA256 != "" {
+		logger.Info("updating plan", "file", planFile,
+			"sha256", p.Source.Tarball.SHA256,
+		)
+		if saveErr := p.Save(planFile); saveErr != nil {
+			return saveErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Info("source ready", "dir", res.Directory, "archive", res.Archive)
+
+	return nil
+}
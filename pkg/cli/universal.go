@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/bundle"
+	"github.com/jimeh/build-emacs-for-macos/pkg/osinfo"
+	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	cli2 "github.com/urfave/cli/v2"
+)
+
+func universalCmd() *cli2.Command {
+	return &cli2.Command{
+		Name: "universal",
+		Usage: "merge arm64 and x86_64 Emacs.app bundles into a universal " +
+			"Emacs.app bundle",
+		ArgsUsage: "<arm64-emacs-app> <x86_64-emacs-app>",
+		Flags: []cli2.Flag{
+			&cli2.StringFlag{
+				Name: "output",
+				Usage: "path of universal Emacs.app bundle to create, " +
+					"defaults to location given by plan",
+				Aliases: []string{"o"},
+			},
+			&cli2.StringFlag{
+				Name: "plan",
+				Usage: "path to build plan YAML file of either of the " +
+					"merged builds, used to create the universal plan",
+				Aliases:   []string{"p"},
+				EnvVars:   []string{"EMACS_BUILDER_PLAN"},
+				TakesFile: true,
+			},
+			&cli2.StringFlag{
+				Name: "plan-output",
+				Usage: "filename to write universal build plan to, " +
+					"defaults to <build-name>.yml next to --plan",
+				TakesFile: true,
+			},
+		},
+		Action: actionWrapper(universalAction),
+	}
+}
+
+func universalAction(c *cli2.Context, _ *Options) error {
+	logger := hclog.FromContext(c.Context).Named("universal")
+
+	if c.NArg() != 2 {
+		return errors.New("requires arm64 and x86_64 Emacs.app arguments")
+	}
+	output := c.String("output")
+
+	var universalPlan *plan.Plan
+	var planFile string
+	if f := c.String("plan"); f != "" {
+		p, err := plan.Load(f)
+		if err != nil {
+			return err
+		}
+
+		universalPlan, err = p.ForArch(osinfo.Universal)
+		if err != nil {
+			return err
+		}
+
+		planFile = c.String("plan-output")
+		if planFile == "" {
+			planFile = filepath.Join(
+				filepath.Dir(f), universalPlan.Build.Name+".yml",
+			)
+		}
+
+		if output == "" {
+			output = filepath.Join(
+				universalPlan.Output.Directory,
+				universalPlan.Build.Name,
+				"Emacs.app",
+			)
+		}
+	}
+
+	if output == "" {
+		return errors.New("--output or --plan is required")
+	}
+
+	report, err := bundle.Universal(
+		c.Context, c.Args().Get(0), c.Args().Get(1), output,
+	)
+	if err != nil {
+		return err
+	}
+
+	if !report.OK() {
+		return fmt.Errorf(
+			"%w: %d mismatches found",
+			bundle.ErrUniversalMismatch, len(report.Mismatches),
+		)
+	}
+
+	if universalPlan != nil {
+		logger.Info("writing universal plan", "file", planFile)
+
+		return universalPlan.Save(planFile)
+	}
+
+	return nil
+}
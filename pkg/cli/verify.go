@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/bundle"
+	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	cli2 "github.com/urfave/cli/v2"
+)
+
+func verifyCmd() *cli2.Command {
+	return &cli2.Command{
+		Name: "verify",
+		Usage: "verify all Mach-O files in a Emacs.app bundle only link " +
+			"against bundled or system libraries",
+		ArgsUsage: "<emacs-app|source-dir>",
+		Flags: []cli2.Flag{
+			&cli2.StringSliceFlag{
+				Name: "system-path",
+				Usage: "path prefix which linked libraries may reference " +
+					"outside of the bundle, can be specified multiple times",
+				Value: cli2.NewStringSlice(bundle.DefaultSystemPaths...),
+			},
+			&cli2.StringFlag{
+				Name:    "format",
+				Aliases: []string{"f"},
+				Usage:   "output format of report (yaml or json)",
+				Value:   "yaml",
+			},
+			&cli2.StringFlag{
+				Name: "output",
+				Usage: "output filename to write report to instead of " +
+					"printing to STDOUT",
+				Aliases: []string{"o"},
+			},
+			&cli2.StringFlag{
+				Name: "plan",
+				Usage: "path to build plan YAML file produced by " +
+					"emacs-builder plan",
+				Aliases:   []string{"p"},
+				EnvVars:   []string{"EMACS_BUILDER_PLAN"},
+				TakesFile: true,
+			},
+		},
+		Action: actionWrapper(verifyAction),
+	}
+}
+
+func verifyAction(c *cli2.Context, _ *Options) error {
+	logger := hclog.FromContext(c.Context).Named("verify")
+
+	format := c.String("format")
+	switch format {
+	case "yaml", "yml", "json":
+	default:
+		return fmt.Errorf("--format must be yaml or json")
+	}
+
+	target := c.Args().Get(0)
+
+	if f := c.String("plan"); f != "" {
+		p, err := plan.Load(f)
+		if err != nil {
+			return err
+		}
+
+		if p.Output != nil && p.Build != nil {
+			target = filepath.Join(p.Output.Directory, p.Build.Name)
+		}
+	}
+
+	report, err := bundle.Verify(c.Context, target, &bundle.VerifyOptions{
+		SystemPaths: c.StringSlice("system-path"),
+	})
+	if err != nil {
+		return err
+	}
+
+	var content string
+	if format == "json" {
+		content, err = report.JSON()
+	} else {
+		content, err = report.YAML()
+	}
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if f := c.String("output"); f != "" {
+		logger.Info("writing report", "file", f)
+		out, err = os.Create(f)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	_, err = out.WriteString(content)
+	if err != nil {
+		return err
+	}
+
+	if !report.OK() {
+		return fmt.Errorf(
+			"%w: %d issues found", bundle.ErrVerifyFailed, len(report.Issues),
+		)
+	}
+
+	return nil
+}
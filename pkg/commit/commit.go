@@ -2,6 +2,7 @@ package commit
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v35/github"
@@ -37,6 +38,13 @@ func (s *Commit) ShortSHA() string {
 	return s.SHA[0:7]
 }
 
+// Subject returns the first line of the commit message.
+func (s *Commit) Subject() string {
+	subject, _, _ := strings.Cut(s.Message, "\n")
+
+	return strings.TrimSpace(subject)
+}
+
 func (s *Commit) DateString() string {
 	return s.Date.Format("2006-01-02")
 }
@@ -0,0 +1,37 @@
+package gh
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Output appends key/value pairs to the file given by the GITHUB_OUTPUT
+// environment variable, making them available as step outputs in GitHub
+// Actions. It does nothing when not running in GitHub Actions.
+func Output(pairs ...string) error {
+	filename := os.Getenv("GITHUB_OUTPUT")
+	if filename == "" {
+		return nil
+	}
+
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if strings.ContainsAny(pairs[i+1], "\r\n") {
+			return fmt.Errorf("output %s must be a single line", pairs[i])
+		}
+		fmt.Fprintf(&b, "%s=%s\n", pairs[i], pairs[i+1])
+	}
+
+	f, err := os.OpenFile(
+		filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644,
+	)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(b.String())
+
+	return err
+}
@@ -0,0 +1,33 @@
+package gh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutput(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "output")
+	t.Setenv("GITHUB_OUTPUT", filename)
+
+	require.NoError(t, Output("skip", "false"))
+	require.NoError(t, Output("build-name", "Emacs.foo", "plan", "foo.yml"))
+
+	b, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "skip=false\nbuild-name=Emacs.foo\nplan=foo.yml\n",
+		string(b),
+	)
+
+	err = Output("body", "two\nlines")
+	assert.EqualError(t, err, "output body must be a single line")
+}
+
+func TestOutput_notGitHubActions(t *testing.T) {
+	t.Setenv("GITHUB_OUTPUT", "")
+
+	assert.NoError(t, Output("skip", "true"))
+}
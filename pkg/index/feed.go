@@ -0,0 +1,440 @@
+package index
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+)
+
+// Filenames of generated files.
+const (
+	JSONFile = "index.json"
+	RSSFile  = "feed.rss"
+	AtomFile = "feed.atom"
+)
+
+// AppcastFile returns the filename of the appcast of builds for given macOS
+// version and architecture, like "appcast.macOS-14.arm64.xml".
+func AppcastFile(osVersion, arch string) string {
+	return "appcast.macOS-" + osVersion + "." + arch + ".xml"
+}
+
+// Write writes the JSON index, RSS and Atom feeds, and an appcast for each
+// macOS version and architecture with builds to given directory.
+func (s *Index) Write(dir string) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]func(io.Writer) error{
+		JSONFile: s.WriteJSON,
+		RSSFile:  s.WriteRSS,
+		AtomFile: s.WriteAtom,
+	}
+	for _, t := range s.appcastTargets() {
+		osVersion, arch := t[0], t[1]
+		files[AppcastFile(osVersion, arch)] = func(w io.Writer) error {
+			return s.WriteAppcast(w, osVersion, arch)
+		}
+	}
+
+	for name, write := range files {
+		err := writeFile(filepath.Join(dir, name), write)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeFile(filename string, write func(io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// feedRelease is a release within feeds, with all its builds.
+type feedRelease struct {
+	Name        string
+	Title       string
+	URL         string
+	PublishedAt time.Time
+	Builds      []*Build
+}
+
+// feedReleases returns the newest releases, limited to the configured
+// number of feed items.
+func (s *Index) feedReleases() []*feedRelease {
+	var releases []*feedRelease
+	byName := map[string]*feedRelease{}
+	for _, b := range s.builds {
+		r, ok := byName[b.Release]
+		if !ok {
+			if len(releases) >= s.feedItems() {
+				continue
+			}
+
+			r = &feedRelease{
+				Name:        b.Release,
+				Title:       b.ReleaseTitle,
+				URL:         b.ReleaseURL,
+				PublishedAt: s.publishedAt(b),
+			}
+			if r.Title == "" {
+				r.Title = b.Release
+			}
+			byName[b.Release] = r
+			releases = append(releases, r)
+		}
+		r.Builds = append(r.Builds, b)
+	}
+
+	return releases
+}
+
+// description returns a HTML list of the builds of a release.
+func (s *feedRelease) description() string {
+	var b strings.Builder
+	b.WriteString("<ul>")
+	for _, build := range s.Builds {
+		fmt.Fprintf(&b, `<li><a href="%s">%s</a> (macOS %s, %s)</li>`,
+			html.EscapeString(build.URL), html.EscapeString(build.Filename),
+			html.EscapeString(build.OSVersion), html.EscapeString(build.Arch),
+		)
+	}
+	b.WriteString("</ul>")
+
+	return b.String()
+}
+
+type rss struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+
+	// Sparkle is the namespace of Sparkle elements in appcasts.
+	Sparkle string `xml:"xmlns:sparkle,attr,omitempty"`
+
+	Channel *rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link,omitempty"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link,omitempty"`
+	GUID        *rssGUID      `xml:"guid,omitempty"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description,omitempty"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+
+	// Sparkle appcast elements.
+	Version              string `xml:"sparkle:version,omitempty"`
+	ShortVersionString   string `xml:"sparkle:shortVersionString,omitempty"`
+	Channel              string `xml:"sparkle:channel,omitempty"`
+	MinimumSystemVersion string `xml:"sparkle:minimumSystemVersion,omitempty"`
+	HardwareRequirements string `xml:"sparkle:hardwareRequirements,omitempty"`
+	FullReleaseNotesLink string `xml:"sparkle:fullReleaseNotesLink,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL         string `xml:"url,attr"`
+	Length      int64  `xml:"length,attr"`
+	Type        string `xml:"type,attr"`
+	EdSignature string `xml:"sparkle:edSignature,attr,omitempty"`
+}
+
+// WriteRSS writes a RSS 2.0 feed of the newest releases to given io.Writer.
+func (s *Index) WriteRSS(w io.Writer) error {
+	feed := &rss{
+		Version: "2.0",
+		Channel: &rssChannel{
+			Title:         s.title(),
+			Link:          s.opts.Link,
+			Description:   s.title(),
+			LastBuildDate: s.GeneratedAt.Format(time.RFC1123Z),
+		},
+	}
+
+	for _, r := range s.feedReleases() {
+		feed.Channel.Items = append(feed.Channel.Items, &rssItem{
+			Title:       r.Title,
+			Link:        r.URL,
+			GUID:        &rssGUID{Value: r.Name},
+			PubDate:     r.PublishedAt.Format(time.RFC1123Z),
+			Description: r.description(),
+		})
+	}
+
+	return writeXML(w, feed)
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string       `xml:"title"`
+	ID      string       `xml:"id"`
+	Updated string       `xml:"updated"`
+	Links   []*atomLink  `xml:"link"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string       `xml:"title"`
+	ID      string       `xml:"id"`
+	Updated string       `xml:"updated"`
+	Links   []*atomLink  `xml:"link"`
+	Content *atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// WriteAtom writes an Atom feed of the newest releases to given io.Writer.
+func (s *Index) WriteAtom(w io.Writer) error {
+	feed := &atomFeed{
+		Title:   s.title(),
+		ID:      s.fileURL(AtomFile),
+		Updated: s.GeneratedAt.Format(time.RFC3339),
+	}
+	if feed.ID == "" {
+		feed.ID = "urn:emacs-builds:feed"
+	} else {
+		feed.Links = append(feed.Links, &atomLink{
+			Href: feed.ID, Rel: "self", Type: "application/atom+xml",
+		})
+	}
+	if s.opts.Link != "" {
+		feed.Links = append(feed.Links, &atomLink{Href: s.opts.Link})
+	}
+
+	for _, r := range s.feedReleases() {
+		entry := &atomEntry{
+			Title:   r.Title,
+			ID:      r.URL,
+			Updated: r.PublishedAt.Format(time.RFC3339),
+			Content: &atomContent{Type: "html", Value: r.description()},
+		}
+		if r.URL != "" {
+			entry.Links = []*atomLink{{Href: r.URL, Rel: "alternate"}}
+		} else {
+			entry.ID = "urn:emacs-builds:release:" + url.PathEscape(r.Name)
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return writeXML(w, feed)
+}
+
+// WriteAppcast writes a Sparkle appcast of the builds for given macOS version
+// and architecture to given io.Writer. Builds of channels other than stable
+// are tagged with their channel, so Sparkle only offers them to users who
+// opted into the channel.
+//
+// Sparkle compares sparkle:version against the CFBundleVersion of the
+// installed app, which Emacs sets to its version number, like "29.4" or
+// "30.0.93". Items of numbered builds therefore use the version number, while
+// the full version is used as display version, so variants of a version are
+// not offered as updates of each other.
+//
+// The version number of nightly builds can not be told from their release
+// name, so their items use the build date like "20240630" instead, which
+// increases with each nightly build and sorts after all Emacs version numbers.
+// Nightly builds of different branches on the same day share a version.
+//
+// Each channel is limited to the configured number of feed items, so frequent
+// nightly builds do not push numbered builds out of the appcast.
+func (s *Index) WriteAppcast(w io.Writer, osVersion, arch string) error {
+	feed := &rss{
+		Version: "2.0",
+		Sparkle: "http://www.andymatuschak.org/xml-namespaces/sparkle",
+		Channel: &rssChannel{
+			Title: fmt.Sprintf("%s (macOS %s, %s)",
+				s.title(), osVersion, arch,
+			),
+			Link:          s.opts.Link,
+			Description:   s.title(),
+			LastBuildDate: s.GeneratedAt.Format(time.RFC1123Z),
+		},
+	}
+
+	minimumSystemVersion := osVersion
+	if !strings.Contains(minimumSystemVersion, ".") {
+		minimumSystemVersion += ".0"
+	}
+
+	items := map[release.Channel]int{}
+	for _, b := range s.builds {
+		if !appcastBuild(b) || b.OSVersion != osVersion || b.Arch != arch {
+			continue
+		}
+		if items[b.Channel] >= s.feedItems() {
+			continue
+		}
+		items[b.Channel]++
+
+		item := &rssItem{
+			Title:                "Emacs " + b.Version,
+			Link:                 b.ReleaseURL,
+			PubDate:              s.publishedAt(b).Format(time.RFC1123Z),
+			Version:              appcastVersion(b),
+			ShortVersionString:   b.Version,
+			MinimumSystemVersion: minimumSystemVersion,
+			FullReleaseNotesLink: b.ReleaseURL,
+			Enclosure: &rssEnclosure{
+				URL:         b.URL,
+				Length:      b.Size,
+				Type:        "application/octet-stream",
+				EdSignature: b.EdSignature,
+			},
+		}
+		if b.Channel != release.Stable {
+			item.Channel = string(b.Channel)
+		}
+		// Sparkle only supports requiring Apple silicon.
+		if b.Arch == "arm64" {
+			item.HardwareRequirements = b.Arch
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	return writeXML(w, feed)
+}
+
+// appcastBuild reports if given build can be listed in appcasts.
+func appcastBuild(b *Build) bool {
+	return b.version != nil
+}
+
+// appcastVersion returns the sparkle:version of given build.
+func appcastVersion(b *Build) string {
+	if b.version.Nightly() {
+		return b.version.Date.Format("20060102")
+	}
+
+	return b.version.Number()
+}
+
+// appcastTargets returns all macOS version and architecture pairs with
+// builds which can be listed in appcasts.
+func (s *Index) appcastTargets() [][2]string {
+	seen := map[[2]string]bool{}
+	var targets [][2]string
+	for _, b := range s.builds {
+		if !appcastBuild(b) {
+			continue
+		}
+		t := [2]string{b.OSVersion, b.Arch}
+		if !seen[t] {
+			seen[t] = true
+			targets = append(targets, t)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if c := compareOSVersions(targets[i][0], targets[j][0]); c != 0 {
+			return c < 0
+		}
+
+		return targets[i][1] < targets[j][1]
+	})
+
+	return targets
+}
+
+func (s *Index) publishedAt(b *Build) time.Time {
+	switch {
+	case b.PublishedAt != nil:
+		return *b.PublishedAt
+	case b.version.Nightly():
+		return b.version.Date
+	default:
+		return s.GeneratedAt
+	}
+}
+
+func (s *Index) title() string {
+	if s.opts.Title != "" {
+		return s.opts.Title
+	}
+
+	return "Emacs Builds"
+}
+
+func (s *Index) feedItems() int {
+	if s.opts.FeedItems > 0 {
+		return s.opts.FeedItems
+	}
+
+	return DefaultFeedItems
+}
+
+// fileURL returns the URL of a generated file, or an empty string if no base
+// URL is configured.
+func (s *Index) fileURL(name string) string {
+	if s.opts.BaseURL == "" {
+		return ""
+	}
+
+	u, err := url.JoinPath(s.opts.BaseURL, name)
+	if err != nil {
+		return ""
+	}
+
+	return u
+}
+
+func writeXML(w io.Writer, v any) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+
+	return err
+}
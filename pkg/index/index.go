@@ -0,0 +1,358 @@
+// Package index generates a static, machine-readable index of published
+// builds, along with RSS and Atom feeds, and Sparkle appcasts.
+package index
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/manifest"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+)
+
+//nolint:golint
+var (
+	Err        = errors.New("index")
+	ErrNoStore = fmt.Errorf("%w: no release store specified", Err)
+)
+
+// SchemaVersion is the version of the JSON index format.
+const SchemaVersion = 1
+
+// DefaultFeedItems is the default number of items in feeds.
+const DefaultFeedItems = 50
+
+// diskImageMatcher extracts the target OS version and architecture from disk
+// image filenames like "Emacs.2024-06-30.abcdef0.master.macOS-14.arm64.dmg".
+var diskImageMatcher = regexp.MustCompile(
+	`\.macOS-(\d+(?:\.\d+)?)\.([a-z0-9_]+)\.dmg$`,
+)
+
+type Options struct {
+	// Store is the release store to index.
+	Store release.Store
+
+	// Channels limits the index to releases of given channels. All channels
+	// are indexed when empty.
+	Channels []release.Channel
+
+	// Manifests enables downloading build manifests of releases, for build
+	// details not available from release assets, like checksums.
+	Manifests bool
+
+	// Title is the title of feeds, defaults to "Emacs Builds".
+	Title string
+
+	// Link is the URL of the website feeds belong to.
+	Link string
+
+	// BaseURL is the URL which the generated files are published at, used
+	// for self-referencing links within feeds.
+	BaseURL string
+
+	// FeedItems is the maximum number of releases in feeds, and builds per
+	// channel in appcasts, defaults to DefaultFeedItems.
+	FeedItems int
+}
+
+// Build is a disk image of a release, targeting a specific macOS version and
+// architecture.
+type Build struct {
+	Name         string          `json:"name"`
+	Release      string          `json:"release"`
+	ReleaseTitle string          `json:"release_title,omitempty"`
+	ReleaseURL   string          `json:"release_url,omitempty"`
+	Version      string          `json:"version"`
+	Channel      release.Channel `json:"channel"`
+	OSVersion    string          `json:"os_version"`
+	Arch         string          `json:"arch"`
+	Filename     string          `json:"filename"`
+	URL          string          `json:"url"`
+	Size         int64           `json:"size,omitempty"`
+	SHA256       string          `json:"sha256,omitempty"`
+	EdSignature  string          `json:"ed_signature,omitempty"`
+	Commit       string          `json:"commit,omitempty"`
+	Signed       bool            `json:"signed,omitempty"`
+	Notarized    bool            `json:"notarized,omitempty"`
+	PublishedAt  *time.Time      `json:"published_at,omitempty"`
+
+	version *release.Version
+}
+
+// Index lists builds grouped by channel, macOS version and architecture, with
+// the newest build first in each group. The latest nightly build for macOS 14
+// on arm64 is found at Channels["nightly"]["14"]["arm64"][0].
+type Index struct {
+	SchemaVersion int       `json:"schema_version"`
+	GeneratedAt   time.Time `json:"generated_at"`
+
+	Channels map[release.Channel]map[string]map[string][]*Build `json:"channels"`
+
+	// builds lists all builds, newest first.
+	builds []*Build
+	opts   *Options
+}
+
+// Generate lists all published releases in the store, and returns an index
+// of their builds. Drafts, and releases which are not a version, like test
+// builds, are skipped.
+func Generate(ctx context.Context, opts *Options) (*Index, error) {
+	logger := hclog.FromContext(ctx).Named("index")
+
+	if opts.Store == nil {
+		return nil, ErrNoStore
+	}
+
+	logger.Info("listing releases")
+	releases, err := opts.Store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{
+		SchemaVersion: SchemaVersion,
+		GeneratedAt:   time.Now().UTC(),
+		Channels:      map[release.Channel]map[string]map[string][]*Build{},
+		opts:          opts,
+	}
+
+	for _, rel := range releases {
+		if rel.Draft {
+			continue
+		}
+
+		v, err := release.ParseVersion(rel.Name)
+		if err != nil {
+			logger.Debug("skipping release", "name", rel.Name, "error", err)
+
+			continue
+		}
+		if len(opts.Channels) > 0 &&
+			!slices.Contains(opts.Channels, v.Channel) {
+			continue
+		}
+
+		var manifests []*manifest.Manifest
+		if opts.Manifests {
+			manifests = releaseManifests(ctx, opts.Store, rel)
+		}
+
+		for _, a := range rel.Assets {
+			b := newBuild(rel, v, a, manifests)
+			if b == nil {
+				continue
+			}
+
+			idx.builds = append(idx.builds, b)
+		}
+	}
+
+	sort.SliceStable(idx.builds, func(i, j int) bool {
+		return compareBuilds(idx.builds[i], idx.builds[j]) < 0
+	})
+
+	for _, b := range idx.builds {
+		osVersions, ok := idx.Channels[b.Channel]
+		if !ok {
+			osVersions = map[string]map[string][]*Build{}
+			idx.Channels[b.Channel] = osVersions
+		}
+		archs, ok := osVersions[b.OSVersion]
+		if !ok {
+			archs = map[string][]*Build{}
+			osVersions[b.OSVersion] = archs
+		}
+		archs[b.Arch] = append(archs[b.Arch], b)
+	}
+
+	logger.Info("indexed builds",
+		"releases", len(releases), "builds", len(idx.builds),
+	)
+
+	return idx, nil
+}
+
+// Builds returns all builds, newest first.
+func (s *Index) Builds() []*Build {
+	return s.builds
+}
+
+// Latest returns the newest build of given channel, macOS version and
+// architecture, or nil if there is none.
+func (s *Index) Latest(
+	channel release.Channel,
+	osVersion string,
+	arch string,
+) *Build {
+	builds := s.Channels[channel][osVersion][arch]
+	if len(builds) == 0 {
+		return nil
+	}
+
+	return builds[0]
+}
+
+// WriteJSON writes the index in JSON format to given io.Writer.
+func (s *Index) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(s)
+}
+
+// newBuild returns the build of given asset, or nil if the asset is not a
+// disk image of a known target.
+func newBuild(
+	rel *release.Release,
+	v *release.Version,
+	a *release.Asset,
+	manifests []*manifest.Manifest,
+) *Build {
+	if !strings.HasSuffix(a.Name, ".dmg") {
+		return nil
+	}
+
+	b := &Build{
+		Name:         strings.TrimSuffix(a.Name, ".dmg"),
+		Release:      rel.Name,
+		ReleaseTitle: rel.Title,
+		ReleaseURL:   rel.URL,
+		Version:      v.String(),
+		Channel:      v.Channel,
+		Filename:     a.Name,
+		URL:          a.URL,
+		Size:         a.Size,
+		SHA256:       a.SHA256,
+		PublishedAt:  rel.PublishedAt,
+		version:      v,
+	}
+	if m := diskImageMatcher.FindStringSubmatch(a.Name); m != nil {
+		b.OSVersion, b.Arch = m[1], m[2]
+	}
+
+	for _, m := range manifests {
+		ma := m.Asset(a.Name)
+		if ma == nil {
+			continue
+		}
+
+		b.Size, b.SHA256 = ma.Size, ma.SHA256
+		b.EdSignature = ma.EdSignature
+		b.Signed = m.Signed != nil && *m.Signed
+		b.Notarized = m.Notarized != nil && *m.Notarized
+		if m.Commit != nil {
+			b.Commit = m.Commit.SHA
+		}
+		if m.OS != nil {
+			b.OSVersion = m.OS.DistinctSDKVersion()
+			if b.OSVersion == "" {
+				b.OSVersion = m.OS.DistinctVersion()
+			}
+			b.Arch = m.OS.Arch
+		}
+
+		break
+	}
+
+	if b.OSVersion == "" || b.Arch == "" {
+		return nil
+	}
+	if b.Commit == "" && v.Nightly() {
+		b.Commit = v.SHA
+	}
+
+	return b
+}
+
+// releaseManifests downloads and parses all build manifests of a release.
+// Manifests which fail to download or parse are ignored.
+func releaseManifests(
+	ctx context.Context,
+	store release.Store,
+	rel *release.Release,
+) []*manifest.Manifest {
+	logger := hclog.FromContext(ctx).Named("index")
+
+	var manifests []*manifest.Manifest
+	for _, a := range rel.Assets {
+		if !strings.HasSuffix(a.Name, manifest.Suffix) {
+			continue
+		}
+
+		m, err := downloadManifest(ctx, store, rel, a)
+		if err != nil {
+			logger.Warn("ignoring manifest", "filename", a.Name, "error", err)
+
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+
+	return manifests
+}
+
+func downloadManifest(
+	ctx context.Context,
+	store release.Store,
+	rel *release.Release,
+	a *release.Asset,
+) (*manifest.Manifest, error) {
+	r, err := store.Open(ctx, rel, a)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest.Parse(b)
+}
+
+// compareBuilds orders builds newest version first, and then by target.
+func compareBuilds(a, b *Build) int {
+	if c := b.version.Compare(a.version); c != 0 {
+		return c
+	}
+	if c := compareOSVersions(b.OSVersion, a.OSVersion); c != 0 {
+		return c
+	}
+
+	return strings.Compare(a.Arch, b.Arch)
+}
+
+// compareOSVersions compares macOS versions like "10.15" and "14"
+// numerically.
+func compareOSVersions(a, b string) int {
+	ap := strings.Split(a, ".")
+	bp := strings.Split(b, ".")
+	for i := 0; i < max(len(ap), len(bp)); i++ {
+		if c := cmp.Compare(versionPart(ap, i), versionPart(bp, i)); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+func versionPart(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	n, _ := strconv.Atoi(parts[i])
+
+	return n
+}
@@ -0,0 +1,313 @@
+package index
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
+	"github.com/jimeh/build-emacs-for-macos/pkg/manifest"
+	"github.com/jimeh/build-emacs-for-macos/pkg/osinfo"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const nightly = "Emacs.2024-06-30.abcdef0.master"
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+// testStore returns a directory store with a few published releases.
+func testStore(t *testing.T) release.Store {
+	t.Helper()
+	ctx := context.Background()
+
+	store, err := release.NewDirStore(
+		t.TempDir(), "https://builds.example.com/",
+	)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	publish := func(rel *release.Release, files map[string]string) {
+		var filenames []string
+		for name, content := range files {
+			f := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(f, []byte(content), 0o644))
+			filenames = append(filenames, f)
+		}
+
+		_, err := store.Publish(ctx, rel, filenames, &release.PublishOptions{})
+		require.NoError(t, err)
+	}
+
+	publish(&release.Release{Name: "Emacs-29.3"}, map[string]string{
+		"Emacs-29.3-1.macOS-14.arm64.dmg": "29.3",
+	})
+	publish(&release.Release{Name: "Emacs-29.4", Title: "Emacs 29.4"},
+		map[string]string{
+			"Emacs-29.4-1.macOS-14.arm64.dmg":        "29.4",
+			"Emacs-29.4-1.macOS-14.arm64.dmg.sha256": "sum",
+			"Emacs-29.4-1.macOS-10.15.x86_64.dmg":    "29.4",
+		},
+	)
+	pretest := "Emacs-30.0.93-pretest.macOS-14.arm64"
+	publish(&release.Release{Name: "Emacs-30.0.93-pretest"},
+		map[string]string{
+			pretest + ".dmg": "30",
+			manifest.Filename(pretest): testManifest(t, &manifest.Manifest{
+				Release: "Emacs-30.0.93-pretest",
+				Channel: release.Pretest,
+				OS:      &osinfo.OSInfo{Version: "14.5", Arch: "arm64"},
+				Assets: []*manifest.Asset{{
+					Filename:    pretest + ".dmg",
+					Size:        2,
+					EdSignature: "cHJldGVzdA==",
+				}},
+			}),
+		},
+	)
+	publish(&release.Release{Name: "Emacs-30.1", Draft: true},
+		map[string]string{"Emacs-30.1-1.macOS-14.arm64.dmg": "30.1"},
+	)
+	publish(&release.Release{Name: "test-builds-foo"},
+		map[string]string{"Emacs.foo.macOS-14.arm64.test.foo.dmg": "foo"},
+	)
+
+	m := &manifest.Manifest{
+		Release: nightly,
+		Channel: release.Nightly,
+		OS: &osinfo.OSInfo{
+			Name: "macOS", Version: "14.5", SDKVersion: "13.3",
+			Arch: "arm64",
+		},
+		Commit:    &commit.Commit{SHA: "abcdef0123456789"},
+		Signed:    boolPtr(true),
+		Notarized: boolPtr(true),
+		Assets: []*manifest.Asset{{
+			Filename:    nightly + ".macOS-13.arm64.dmg",
+			Size:        42,
+			SHA256:      "0123456789abcdef",
+			EdSignature: "c2lnbmF0dXJl",
+		}},
+	}
+	publish(&release.Release{Name: nightly}, map[string]string{
+		nightly + ".macOS-13.arm64.dmg":                "nightly",
+		manifest.Filename(nightly + ".macOS-13.arm64"): testManifest(t, m),
+	})
+
+	return store
+}
+
+func testManifest(t *testing.T, m *manifest.Manifest) string {
+	t.Helper()
+
+	m.SchemaVersion = manifest.SchemaVersion
+	var buf bytes.Buffer
+	require.NoError(t, m.WriteJSON(&buf))
+
+	return buf.String()
+}
+
+func TestGenerate(t *testing.T) {
+	idx, err := Generate(context.Background(), &Options{
+		Store:     testStore(t),
+		Manifests: true,
+	})
+	require.NoError(t, err)
+
+	var names []string
+	for _, b := range idx.Builds() {
+		names = append(names, b.Filename)
+	}
+	assert.Equal(t, []string{
+		nightly + ".macOS-13.arm64.dmg",
+		"Emacs-30.0.93-pretest.macOS-14.arm64.dmg",
+		"Emacs-29.4-1.macOS-14.arm64.dmg",
+		"Emacs-29.4-1.macOS-10.15.x86_64.dmg",
+		"Emacs-29.3-1.macOS-14.arm64.dmg",
+	}, names)
+
+	assert.Len(t, idx.Channels, 3)
+	assert.Len(t, idx.Channels[release.Stable]["14"]["arm64"], 2)
+
+	latest := idx.Latest(release.Stable, "14", "arm64")
+	require.NotNil(t, latest)
+	assert.Equal(t, "Emacs-29.4", latest.Release)
+	assert.Equal(t, "Emacs 29.4", latest.ReleaseTitle)
+	assert.Equal(t, "29.4", latest.Version)
+	assert.Equal(t,
+		"https://builds.example.com/Emacs-29.4/Emacs-29.4-1.macOS-14.arm64.dmg",
+		latest.URL,
+	)
+	assert.Equal(t, int64(4), latest.Size)
+	assert.False(t, latest.Signed)
+
+	latest = idx.Latest(release.Nightly, "13", "arm64")
+	require.NotNil(t, latest)
+	assert.Equal(t, release.Nightly, latest.Channel)
+	assert.Equal(t, "2024-06-30.abcdef0.master", latest.Version)
+	assert.Equal(t, "0123456789abcdef", latest.SHA256)
+	assert.Equal(t, int64(42), latest.Size)
+	assert.Equal(t, "abcdef0123456789", latest.Commit)
+	assert.True(t, latest.Signed)
+	assert.True(t, latest.Notarized)
+	assert.Equal(t, "c2lnbmF0dXJl", latest.EdSignature)
+
+	assert.Nil(t, idx.Latest(release.Nightly, "14", "arm64"))
+}
+
+func TestGenerate_channels(t *testing.T) {
+	idx, err := Generate(context.Background(), &Options{
+		Store:    testStore(t),
+		Channels: []release.Channel{release.Nightly},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, idx.Builds(), 1)
+	// Without manifests, build details come from the filename.
+	b := idx.Builds()[0]
+	assert.Equal(t, "13", b.OSVersion)
+	assert.Equal(t, "arm64", b.Arch)
+	assert.Equal(t, "abcdef0", b.Commit)
+	assert.False(t, b.Signed)
+}
+
+func TestIndex_Write(t *testing.T) {
+	idx, err := Generate(context.Background(), &Options{
+		Store:     testStore(t),
+		Manifests: true,
+		Title:     "Test Builds",
+		BaseURL:   "https://example.com/index/",
+		FeedItems: 3,
+	})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, idx.Write(dir))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var files []string
+	for _, e := range entries {
+		files = append(files, e.Name())
+	}
+	assert.ElementsMatch(t, []string{
+		"index.json",
+		"feed.rss",
+		"feed.atom",
+		"appcast.macOS-10.15.x86_64.xml",
+		"appcast.macOS-13.arm64.xml",
+		"appcast.macOS-14.arm64.xml",
+	}, files)
+
+	var feed rss
+	b, err := os.ReadFile(filepath.Join(dir, RSSFile))
+	require.NoError(t, err)
+	require.NoError(t, xml.Unmarshal(b, &feed))
+	require.Len(t, feed.Channel.Items, 3)
+	assert.Equal(t, nightly, feed.Channel.Items[0].Title)
+	assert.Equal(t, "Emacs 29.4", feed.Channel.Items[2].Title)
+	assert.Contains(t, feed.Channel.Items[2].Description,
+		"Emacs-29.4-1.macOS-10.15.x86_64.dmg",
+	)
+
+	b, err = os.ReadFile(filepath.Join(dir, AtomFile))
+	require.NoError(t, err)
+	assert.Contains(t, string(b),
+		`<link href="https://example.com/index/feed.atom" rel="self"`,
+	)
+	assert.Contains(t, string(b), "<title>Test Builds</title>")
+
+	b, err = os.ReadFile(filepath.Join(dir, "appcast.macOS-14.arm64.xml"))
+	require.NoError(t, err)
+	appcast := string(b)
+	assert.Contains(t, appcast,
+		`xmlns:sparkle="http://www.andymatuschak.org/xml-namespaces/sparkle"`,
+	)
+	items := strings.Split(appcast, "<item>")
+	require.Len(t, items, 4)
+
+	assert.Contains(t, items[1],
+		"<sparkle:version>30.0.93</sparkle:version>",
+	)
+	assert.Contains(t, items[1], "<sparkle:shortVersionString>"+
+		"30.0.93-pretest</sparkle:shortVersionString>",
+	)
+	assert.Contains(t, items[1],
+		"<sparkle:channel>pretest</sparkle:channel>",
+	)
+	assert.Contains(t, items[1],
+		`length="2" type="application/octet-stream" `+
+			`sparkle:edSignature="cHJldGVzdA=="></enclosure>`,
+	)
+
+	assert.Contains(t, items[2], "<sparkle:version>29.4</sparkle:version>")
+	assert.Contains(t, items[2],
+		"<sparkle:minimumSystemVersion>14.0</sparkle:minimumSystemVersion>",
+	)
+	assert.Contains(t, items[2],
+		`<enclosure url="https://builds.example.com/Emacs-29.4/`+
+			`Emacs-29.4-1.macOS-14.arm64.dmg" length="4" `+
+			`type="application/octet-stream"></enclosure>`,
+	)
+	assert.NotContains(t, items[2], "sparkle:channel")
+
+	b, err = os.ReadFile(filepath.Join(dir, "appcast.macOS-13.arm64.xml"))
+	require.NoError(t, err)
+	items = strings.Split(string(b), "<item>")
+	require.Len(t, items, 2)
+
+	assert.Contains(t, items[1],
+		"<sparkle:version>20240630</sparkle:version>",
+	)
+	assert.Contains(t, items[1], "<sparkle:shortVersionString>"+
+		"2024-06-30.abcdef0.master</sparkle:shortVersionString>",
+	)
+	assert.Contains(t, items[1],
+		"<sparkle:channel>nightly</sparkle:channel>",
+	)
+	assert.Contains(t, items[1], `sparkle:edSignature="c2lnbmF0dXJl"`)
+}
+
+func TestIndex_WriteAppcast_feedItems(t *testing.T) {
+	idx, err := Generate(context.Background(), &Options{
+		Store:     testStore(t),
+		Manifests: true,
+		FeedItems: 1,
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, idx.WriteAppcast(&buf, "14", "arm64"))
+
+	// One item per channel, so the stable 29.4 build is not pushed out by
+	// the newer pretest.
+	items := strings.Split(buf.String(), "<item>")
+	require.Len(t, items, 3)
+	assert.Contains(t, items[1], "<sparkle:version>30.0.93</sparkle:version>")
+	assert.Contains(t, items[2], "<sparkle:version>29.4</sparkle:version>")
+}
+
+func TestCompareOSVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "14", b: "14", want: 0},
+		{a: "14", b: "13", want: 1},
+		{a: "10.15", b: "11", want: -1},
+		{a: "10.9", b: "10.15", want: -1},
+		{a: "12.0", b: "12", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.want, compareOSVersions(tt.a, tt.b))
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package macho
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrDuplicateArch is returned when merging files which share a architecture.
+var ErrDuplicateArch = fmt.Errorf("%w: duplicate architecture", Err)
+
+// Page alignments of architecture slices within universal binaries, matching
+// those used by lipo.
+const (
+	alignShiftArm64   = 14
+	alignShiftDefault = 12
+)
+
+// Merge returns a universal binary containing all architectures of given
+// files, like "lipo -create". Files may themselves be universal binaries, but
+// no architecture may be present in more than one file.
+func Merge(files ...*File) ([]byte, error) {
+	type fatArch struct {
+		header macho.FileHeader
+		data   []byte
+		align  uint32
+	}
+
+	var arches []*fatArch
+	seen := map[macho.Cpu]bool{}
+	for _, f := range files {
+		for _, s := range f.slices {
+			if seen[s.header.Cpu] {
+				return nil, fmt.Errorf(
+					"%w: %s", ErrDuplicateArch, CPUName(s.header.Cpu),
+				)
+			}
+			seen[s.header.Cpu] = true
+
+			align := uint32(alignShiftDefault)
+			if s.header.Cpu == macho.CpuArm64 {
+				align = alignShiftArm64
+			}
+			arches = append(arches, &fatArch{
+				header: s.header,
+				data:   f.data[s.offset : s.offset+s.size],
+				align:  align,
+			})
+		}
+	}
+	if len(arches) == 0 {
+		return nil, ErrNotMachO
+	}
+
+	// Like lipo, order slices by alignment to minimize padding.
+	sort.SliceStable(arches, func(i, j int) bool {
+		return arches[i].align < arches[j].align
+	})
+
+	be := binary.BigEndian
+	header := make([]byte, fatHeaderSize+fatArchSize*len(arches))
+	be.PutUint32(header[0:], magicFat)
+	be.PutUint32(header[4:], uint32(len(arches)))
+
+	offset := int64(len(header))
+	for i, a := range arches {
+		align := int64(1) << a.align
+		if r := offset % align; r != 0 {
+			offset += align - r
+		}
+		if offset+int64(len(a.data)) > math.MaxUint32 {
+			return nil, fmt.Errorf(
+				"%w: merged file too large for 32-bit fat header", Err,
+			)
+		}
+
+		h := header[fatHeaderSize+i*fatArchSize:]
+		be.PutUint32(h[0:], uint32(a.header.Cpu))
+		be.PutUint32(h[4:], a.header.SubCpu)
+		be.PutUint32(h[8:], uint32(offset))
+		be.PutUint32(h[12:], uint32(len(a.data)))
+		be.PutUint32(h[16:], a.align)
+
+		offset += int64(len(a.data))
+	}
+
+	data := make([]byte, offset)
+	copy(data, header)
+	for i, a := range arches {
+		h := header[fatHeaderSize+i*fatArchSize:]
+		copy(data[be.Uint32(h[8:]):], a.data)
+	}
+
+	return data, nil
+}
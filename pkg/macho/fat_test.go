@@ -0,0 +1,94 @@
+package macho
+
+import (
+	"bytes"
+	"debug/macho"
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho/machotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	arm64 := machotest.Build(&machotest.Options{
+		Dylibs: []string{"/usr/lib/libSystem.B.dylib"},
+		Rpaths: []string{"@loader_path"},
+	})
+	x86 := machotest.Build(&machotest.Options{
+		Cpu:    macho.CpuAmd64,
+		Dylibs: []string{"/usr/lib/libz.1.dylib"},
+	})
+	i386 := machotest.Build(&machotest.Options{Cpu: macho.Cpu386})
+
+	tests := []struct {
+		name       string
+		inputs     [][]byte
+		wantArches []string
+		wantThin   [][]byte
+		wantErr    error
+	}{
+		{
+			name:       "arm64 and x86_64",
+			inputs:     [][]byte{arm64, x86},
+			wantArches: []string{"x86_64", "arm64"},
+			wantThin:   [][]byte{x86, arm64},
+		},
+		{
+			name:       "x86_64 and arm64",
+			inputs:     [][]byte{x86, arm64},
+			wantArches: []string{"x86_64", "arm64"},
+			wantThin:   [][]byte{x86, arm64},
+		},
+		{
+			name:       "universal and thin",
+			inputs:     [][]byte{machotest.Fat(arm64, x86), i386},
+			wantArches: []string{"x86_64", "i386", "arm64"},
+			wantThin:   [][]byte{x86, i386, arm64},
+		},
+		{
+			name:    "duplicate architecture",
+			inputs:  [][]byte{arm64, machotest.Fat(x86, arm64)},
+			wantErr: ErrDuplicateArch,
+		},
+		{
+			name:    "no files",
+			wantErr: ErrNotMachO,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []*File{}
+			for _, b := range tt.inputs {
+				f, err := NewFile(b)
+				require.NoError(t, err)
+				files = append(files, f)
+			}
+
+			got, err := Merge(files...)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+
+			f, err := NewFile(got)
+			require.NoError(t, err)
+			assert.True(t, f.Fat())
+			assert.Equal(t, tt.wantArches, f.Arches())
+
+			std, err := macho.NewFatFile(bytes.NewReader(got))
+			require.NoError(t, err)
+			require.Len(t, std.Arches, len(tt.wantThin))
+			for i, a := range std.Arches {
+				align := uint32(1) << a.Align
+				assert.Zero(t, a.Offset%align, "slice %d alignment", i)
+				assert.Equal(t, tt.wantThin[i],
+					got[a.Offset:a.Offset+a.Size],
+				)
+			}
+		})
+	}
+}
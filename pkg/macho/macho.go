@@ -0,0 +1,691 @@
+package macho
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Errors
+var (
+	Err            = errors.New("macho")
+	ErrNotMachO    = fmt.Errorf("%w: not a Mach-O file", Err)
+	ErrNotLinked   = fmt.Errorf("%w: dylib not linked", Err)
+	ErrNoDylibID   = fmt.Errorf("%w: file has no dylib ID", Err)
+	ErrHeaderSpace = fmt.Errorf(
+		"%w: not enough header padding for load commands", Err,
+	)
+)
+
+// Load commands which are not defined by the debug/macho package.
+const (
+	loadCmdIDDylib         macho.LoadCmd = 0xd
+	loadCmdLazyLoadDylib   macho.LoadCmd = 0x20
+	loadCmdLoadWeakDylib   macho.LoadCmd = 0x80000018
+	loadCmdReexportDylib   macho.LoadCmd = 0x8000001f
+	loadCmdLoadUpwardDylib macho.LoadCmd = 0x80000023
+)
+
+const (
+	magicFat        uint32 = 0xcafebabe
+	fatHeaderSize          = 8
+	fatArchSize            = 20
+	maxFatArches           = 20
+	dylibCmdSize           = 24
+	rpathCmdSize           = 12
+	header32Size           = 28
+	header64Size           = 32
+	sectionTypeMask uint32 = 0xff
+)
+
+// File is a thin or universal (fat) Mach-O file held in memory. Changes to
+// load commands are applied in place within the existing header padding of
+// each architecture slice, and only written to disk when Save is called.
+type File struct {
+	Path string
+
+	data    []byte
+	fat     bool
+	slices  []*slice
+	changed bool
+}
+
+// Open reads and parses the Mach-O file at given path.
+func Open(filename string) (*File, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := NewFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, filename)
+	}
+	f.Path = filename
+
+	return f, nil
+}
+
+// NewFile parses given bytes as a thin or universal Mach-O file.
+func NewFile(data []byte) (*File, error) {
+	if len(data) < 4 {
+		return nil, ErrNotMachO
+	}
+
+	f := &File{data: data}
+
+	if binary.BigEndian.Uint32(data) != magicFat {
+		s, err := parseSlice(data, 0, int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		f.slices = []*slice{s}
+
+		return f, nil
+	}
+
+	if len(data) < fatHeaderSize {
+		return nil, ErrNotMachO
+	}
+
+	n := binary.BigEndian.Uint32(data[4:])
+	if n == 0 || n > maxFatArches ||
+		len(data) < fatHeaderSize+int(n)*fatArchSize {
+		return nil, ErrNotMachO
+	}
+
+	f.fat = true
+	for i := 0; i < int(n); i++ {
+		h := data[fatHeaderSize+i*fatArchSize:]
+		offset := int64(binary.BigEndian.Uint32(h[8:]))
+		size := int64(binary.BigEndian.Uint32(h[12:]))
+
+		s, err := parseSlice(data, offset, size)
+		if err != nil {
+			return nil, err
+		}
+		f.slices = append(f.slices, s)
+	}
+
+	return f, nil
+}
+
+// IsMachO checks if the file at given path starts with a Mach-O magic number.
+func IsMachO(filename string) (bool, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+
+	b := make([]byte, 8)
+	_, err = io.ReadFull(fh, b)
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return isMachOMagic(b), nil
+}
+
+func isMachOMagic(b []byte) bool {
+	if binary.BigEndian.Uint32(b) == magicFat {
+		// Java class files share the same magic number as universal binaries,
+		// but the next four bytes hold the class file version, which is a
+		// much larger number than any realistic architecture count.
+		n := binary.BigEndian.Uint32(b[4:])
+
+		return n > 0 && n <= maxFatArches
+	}
+
+	return isThinMagic(b)
+}
+
+func isThinMagic(b []byte) bool {
+	switch binary.BigEndian.Uint32(b) {
+	case macho.Magic32, macho.Magic64:
+		return true
+	}
+
+	switch binary.LittleEndian.Uint32(b) {
+	case macho.Magic32, macho.Magic64:
+		return true
+	}
+
+	return false
+}
+
+// Fat reports if the file is a universal binary.
+func (f *File) Fat() bool {
+	return f.fat
+}
+
+// Arches returns the names of all CPU architectures in the file.
+func (f *File) Arches() []string {
+	arches := make([]string, 0, len(f.slices))
+	for _, s := range f.slices {
+		arches = append(arches, CPUName(s.header.Cpu))
+	}
+
+	return arches
+}
+
+// Type returns the Mach-O file type of the first architecture in the file.
+func (f *File) Type() macho.Type {
+	return f.slices[0].header.Type
+}
+
+// ID returns the LC_ID_DYLIB install name of the file, or a empty string if
+// the file is not a dynamic library.
+func (f *File) ID() string {
+	for _, s := range f.slices {
+		for _, l := range s.loads {
+			if s.cmd(l) == loadCmdIDDylib {
+				return s.lcStr(l, 8)
+			}
+		}
+	}
+
+	return ""
+}
+
+// Dylibs returns the install names of all dynamic libraries the file links
+// against, across all architectures.
+func (f *File) Dylibs() []string {
+	return f.collect(func(s *slice, l []byte) (string, bool) {
+		if isDylibLoad(s.cmd(l)) {
+			return s.lcStr(l, 8), true
+		}
+
+		return "", false
+	})
+}
+
+// WeakDylibs returns the install names of weakly linked dynamic libraries.
+// Weak libraries are allowed to be missing at runtime.
+func (f *File) WeakDylibs() []string {
+	return f.collect(func(s *slice, l []byte) (string, bool) {
+		if s.cmd(l) == loadCmdLoadWeakDylib {
+			return s.lcStr(l, 8), true
+		}
+
+		return "", false
+	})
+}
+
+// Rpaths returns all LC_RPATH entries of the file, across all architectures.
+// Duplicate entries within a single architecture are included.
+func (f *File) Rpaths() []string {
+	var rpaths []string
+	seen := map[string]int{}
+
+	for _, s := range f.slices {
+		count := map[string]int{}
+		for _, l := range s.loads {
+			if s.cmd(l) != macho.LoadCmdRpath {
+				continue
+			}
+
+			p := s.lcStr(l, 8)
+			count[p]++
+			if count[p] > seen[p] {
+				seen[p] = count[p]
+				rpaths = append(rpaths, p)
+			}
+		}
+	}
+
+	return rpaths
+}
+
+func (f *File) collect(fn func(*slice, []byte) (string, bool)) []string {
+	var result []string
+	seen := map[string]bool{}
+
+	for _, s := range f.slices {
+		for _, l := range s.loads {
+			if v, ok := fn(s, l); ok && !seen[v] {
+				seen[v] = true
+				result = append(result, v)
+			}
+		}
+	}
+
+	return result
+}
+
+// ChangeID sets the LC_ID_DYLIB install name of the file.
+func (f *File) ChangeID(id string) error {
+	found := false
+	err := f.mutate(func(s *slice, loads [][]byte) bool {
+		changed := false
+		for i, l := range loads {
+			if s.cmd(l) != loadCmdIDDylib {
+				continue
+			}
+
+			found = true
+			if s.lcStr(l, 8) != id {
+				loads[i] = s.dylibCmd(l, id)
+				changed = true
+			}
+		}
+
+		return changed
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return ErrNoDylibID
+	}
+
+	return nil
+}
+
+// ChangeInstallName replaces a linked dylib install name with a new name. It
+// returns ErrNotLinked if no architecture links against oldName.
+func (f *File) ChangeInstallName(oldName, newName string) error {
+	found := false
+	err := f.mutate(func(s *slice, loads [][]byte) bool {
+		changed := false
+		for i, l := range loads {
+			if !isDylibLoad(s.cmd(l)) || s.lcStr(l, 8) != oldName {
+				continue
+			}
+
+			found = true
+			if oldName != newName {
+				loads[i] = s.dylibCmd(l, newName)
+				changed = true
+			}
+		}
+
+		return changed
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("%w: %s", ErrNotLinked, oldName)
+	}
+
+	return nil
+}
+
+// AddRpath appends a LC_RPATH entry to all architectures which do not already
+// have it.
+func (f *File) AddRpath(path string) error {
+	return f.mutate(func(s *slice, loads [][]byte) bool {
+		if s.hasRpath(path) {
+			return false
+		}
+
+		s.pending = append(loads, s.rpathCmd(path))
+
+		return true
+	})
+}
+
+// DeleteRpath removes all LC_RPATH entries matching given path.
+func (f *File) DeleteRpath(path string) error {
+	return f.mutate(func(s *slice, loads [][]byte) bool {
+		kept := loads[:0]
+		for _, l := range loads {
+			if s.cmd(l) == macho.LoadCmdRpath && s.lcStr(l, 8) == path {
+				continue
+			}
+			kept = append(kept, l)
+		}
+		s.pending = kept
+
+		return len(kept) != len(loads)
+	})
+}
+
+// RemoveDuplicateRpaths removes all but the first occurrence of each LC_RPATH
+// entry. macOS 15.4 and later refuse to load binaries with duplicate rpaths.
+// The removed paths are returned.
+func (f *File) RemoveDuplicateRpaths() ([]string, error) {
+	var removed []string
+	err := f.mutate(func(s *slice, loads [][]byte) bool {
+		seen := map[string]bool{}
+		kept := loads[:0]
+		for _, l := range loads {
+			if s.cmd(l) == macho.LoadCmdRpath {
+				p := s.lcStr(l, 8)
+				if seen[p] {
+					removed = append(removed, p)
+
+					continue
+				}
+				seen[p] = true
+			}
+			kept = append(kept, l)
+		}
+		s.pending = kept
+
+		return len(kept) != len(loads)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+// mutate calls fn with a copy of the load commands of each slice, which fn
+// may modify in place, or replace by setting the slice's pending field. fn
+// reports if it changed anything. The new load commands are only applied
+// once they are known to fit within the header padding of every slice, so
+// the file is left untouched when any of them do not.
+func (f *File) mutate(fn func(s *slice, loads [][]byte) bool) error {
+	changed := false
+	for _, s := range f.slices {
+		s.pending = append([][]byte(nil), s.loads...)
+		if fn(s, s.pending) {
+			changed = true
+		}
+	}
+	defer func() {
+		for _, s := range f.slices {
+			s.pending = nil
+		}
+	}()
+
+	if !changed {
+		return nil
+	}
+
+	for _, s := range f.slices {
+		err := s.fits(s.pending)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range f.slices {
+		s.loads = s.pending
+		s.write(f.data)
+	}
+	f.changed = true
+
+	return nil
+}
+
+// Changed reports if any load commands have been modified since the file was
+// opened or last saved.
+func (f *File) Changed() bool {
+	return f.changed
+}
+
+// Bytes returns the full content of the file, including any modifications.
+func (f *File) Bytes() []byte {
+	return f.data
+}
+
+// Save writes the file back to its path if it has been modified. Read-only
+// files are temporarily made writable.
+func (f *File) Save() error {
+	if !f.changed {
+		return nil
+	}
+
+	err := WriteFile(f.Path, f.data)
+	if err != nil {
+		return err
+	}
+	f.changed = false
+
+	return nil
+}
+
+// WriteFile writes data to the existing file at filename, retaining its
+// permissions, even if the file is not writable by its owner.
+func WriteFile(filename string, data []byte) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	mode := fi.Mode().Perm()
+	if mode&0o200 == 0 {
+		err = os.Chmod(filename, mode|0o200)
+		if err != nil {
+			return err
+		}
+		defer os.Chmod(filename, mode) //nolint:errcheck
+	}
+
+	return os.WriteFile(filename, data, mode)
+}
+
+// CPUName returns the name commonly used for given CPU type by Apple's tools.
+func CPUName(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.CpuAmd64:
+		return "x86_64"
+	case macho.Cpu386:
+		return "i386"
+	case macho.CpuArm:
+		return "arm"
+	case macho.CpuPpc:
+		return "ppc"
+	case macho.CpuPpc64:
+		return "ppc64"
+	default:
+		return fmt.Sprintf("cpu%d", uint32(cpu))
+	}
+}
+
+func isDylibLoad(cmd macho.LoadCmd) bool {
+	switch cmd {
+	case macho.LoadCmdDylib, loadCmdLoadWeakDylib, loadCmdReexportDylib,
+		loadCmdLazyLoadDylib, loadCmdLoadUpwardDylib:
+		return true
+	default:
+		return false
+	}
+}
+
+// slice is a single architecture within a Mach-O file.
+type slice struct {
+	offset     int64
+	size       int64
+	header     macho.FileHeader
+	byteOrder  binary.ByteOrder
+	headerSize int
+	cmdsSize   int
+	cmdsLimit  int
+	loads      [][]byte
+
+	// pending holds load commands while they are being modified.
+	pending [][]byte
+}
+
+func parseSlice(data []byte, offset, size int64) (*slice, error) {
+	if offset < 0 || size < 4 || offset+size > int64(len(data)) {
+		return nil, ErrNotMachO
+	}
+
+	if !isThinMagic(data[offset:]) {
+		return nil, ErrNotMachO
+	}
+
+	mf, err := macho.NewFile(bytes.NewReader(data[offset : offset+size]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotMachO, err)
+	}
+
+	s := &slice{
+		offset:     offset,
+		size:       size,
+		header:     mf.FileHeader,
+		byteOrder:  mf.ByteOrder,
+		headerSize: header32Size,
+		cmdsSize:   int(mf.Cmdsz),
+		cmdsLimit:  int(size),
+	}
+	if mf.Magic == macho.Magic64 {
+		s.headerSize = header64Size
+	}
+
+	for _, l := range mf.Loads {
+		raw := l.Raw()
+		s.loads = append(s.loads, raw[:len(raw):len(raw)])
+
+		if seg, ok := l.(*macho.Segment); ok && seg.Offset > 0 &&
+			seg.Filesz > 0 && int(seg.Offset) < s.cmdsLimit {
+			s.cmdsLimit = int(seg.Offset)
+		}
+	}
+
+	for _, sec := range mf.Sections {
+		if isZerofill(sec.Flags) || sec.Offset == 0 || sec.Size == 0 {
+			continue
+		}
+		if int(sec.Offset) < s.cmdsLimit {
+			s.cmdsLimit = int(sec.Offset)
+		}
+	}
+
+	return s, nil
+}
+
+func isZerofill(flags uint32) bool {
+	switch flags & sectionTypeMask {
+	case 0x1, 0xc, 0x12: // S_ZEROFILL, S_GB_ZEROFILL, S_THREAD_LOCAL_ZEROFILL
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *slice) cmd(l []byte) macho.LoadCmd {
+	return macho.LoadCmd(s.byteOrder.Uint32(l))
+}
+
+// lcStr reads a lc_str value, which is an offset relative to the start of
+// the load command stored at pos, pointing to a NUL-terminated string.
+func (s *slice) lcStr(l []byte, pos int) string {
+	if len(l) < pos+4 {
+		return ""
+	}
+
+	off := int(s.byteOrder.Uint32(l[pos:]))
+	if off >= len(l) {
+		return ""
+	}
+
+	str := l[off:]
+	if i := bytes.IndexByte(str, 0); i >= 0 {
+		str = str[:i]
+	}
+
+	return string(str)
+}
+
+func (s *slice) hasRpath(path string) bool {
+	for _, l := range s.loads {
+		if s.cmd(l) == macho.LoadCmdRpath && s.lcStr(l, 8) == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dylibCmd builds a copy of given dylib load command with a new install name.
+func (s *slice) dylibCmd(l []byte, name string) []byte {
+	b := s.withString(l[:dylibCmdSize], name)
+	s.byteOrder.PutUint32(b[8:], dylibCmdSize)
+
+	return b
+}
+
+func (s *slice) rpathCmd(path string) []byte {
+	b := make([]byte, rpathCmdSize)
+	s.byteOrder.PutUint32(b, uint32(macho.LoadCmdRpath))
+	b = s.withString(b, path)
+	s.byteOrder.PutUint32(b[8:], rpathCmdSize)
+
+	return b
+}
+
+// withString returns a new load command consisting of given fixed-size
+// command header followed by a NUL-terminated string, padded to the
+// alignment required by the architecture, and with cmdsize updated.
+func (s *slice) withString(head []byte, str string) []byte {
+	align := 4
+	if s.headerSize == header64Size {
+		align = 8
+	}
+
+	size := len(head) + len(str) + 1
+	if r := size % align; r != 0 {
+		size += align - r
+	}
+
+	b := make([]byte, size)
+	copy(b, head)
+	copy(b[len(head):], str)
+	s.byteOrder.PutUint32(b[4:], uint32(size)) //nolint:gosec
+
+	return b
+}
+
+// fits returns ErrHeaderSpace if given load commands do not fit within the
+// header padding of the slice.
+func (s *slice) fits(loads [][]byte) error {
+	size := 0
+	for _, l := range loads {
+		size += len(l)
+	}
+
+	if s.headerSize+size > s.cmdsLimit {
+		return fmt.Errorf(
+			"%w: need %d bytes, have %d",
+			ErrHeaderSpace, size, s.cmdsLimit-s.headerSize,
+		)
+	}
+
+	return nil
+}
+
+// write serializes the load commands of the slice into given file data. The
+// load commands must fit, see fits.
+func (s *slice) write(data []byte) {
+	var cmds []byte
+	for _, l := range s.loads {
+		cmds = append(cmds, l...)
+	}
+
+	base := data[s.offset : s.offset+s.size]
+	s.header.Ncmd = uint32(len(s.loads)) //nolint:gosec
+	s.header.Cmdsz = uint32(len(cmds))   //nolint:gosec
+	s.byteOrder.PutUint32(base[16:], s.header.Ncmd)
+	s.byteOrder.PutUint32(base[20:], s.header.Cmdsz)
+
+	copy(base[s.headerSize:], cmds)
+	for i := s.headerSize + len(cmds); i < s.headerSize+s.cmdsSize; i++ {
+		base[i] = 0
+	}
+	s.cmdsSize = len(cmds)
+
+	// Re-slice load commands to point at their new location, ensuring
+	// future modifications to the file data do not alias stale copies.
+	pos := s.headerSize
+	for i, l := range s.loads {
+		s.loads[i] = base[pos : pos+len(l) : pos+len(l)]
+		pos += len(l)
+	}
+}
@@ -0,0 +1,256 @@
+package macho
+
+import (
+	"bytes"
+	"debug/macho"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/macho/machotest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		wantArches []string
+		wantID     string
+		wantDylibs []string
+		wantWeak   []string
+		wantRpaths []string
+		wantErr    error
+	}{
+		{
+			name:    "empty",
+			data:    []byte{},
+			wantErr: ErrNotMachO,
+		},
+		{
+			name:    "text file",
+			data:    []byte("#!/bin/sh\necho hello\n"),
+			wantErr: ErrNotMachO,
+		},
+		{
+			name: "executable",
+			data: machotest.Build(&machotest.Options{
+				Dylibs: []string{
+					"/usr/lib/libSystem.B.dylib",
+					"@rpath/libgnutls.30.dylib",
+				},
+				WeakDylibs: []string{"/opt/homebrew/lib/libfoo.dylib"},
+				Rpaths:     []string{"@executable_path/../Frameworks"},
+			}),
+			wantArches: []string{"arm64"},
+			wantDylibs: []string{
+				"/usr/lib/libSystem.B.dylib",
+				"@rpath/libgnutls.30.dylib",
+				"/opt/homebrew/lib/libfoo.dylib",
+			},
+			wantWeak:   []string{"/opt/homebrew/lib/libfoo.dylib"},
+			wantRpaths: []string{"@executable_path/../Frameworks"},
+		},
+		{
+			name: "dylib with duplicate rpaths",
+			data: machotest.Build(&machotest.Options{
+				ID:     "/opt/homebrew/opt/gmp/lib/libgmp.10.dylib",
+				Dylibs: []string{"/usr/lib/libSystem.B.dylib"},
+				Rpaths: []string{"@loader_path", "/foo", "@loader_path"},
+			}),
+			wantArches: []string{"arm64"},
+			wantID:     "/opt/homebrew/opt/gmp/lib/libgmp.10.dylib",
+			wantDylibs: []string{"/usr/lib/libSystem.B.dylib"},
+			wantRpaths: []string{"@loader_path", "/foo", "@loader_path"},
+		},
+		{
+			name: "universal",
+			data: machotest.Fat(
+				machotest.Build(&machotest.Options{
+					Dylibs: []string{"/usr/lib/libSystem.B.dylib"},
+				}),
+				machotest.Build(&machotest.Options{
+					Cpu:    macho.CpuAmd64,
+					Dylibs: []string{"/usr/lib/libz.1.dylib"},
+				}),
+			),
+			wantArches: []string{"arm64", "x86_64"},
+			wantDylibs: []string{
+				"/usr/lib/libSystem.B.dylib",
+				"/usr/lib/libz.1.dylib",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFile(tt.data)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantArches, f.Arches())
+			assert.Equal(t, tt.wantID, f.ID())
+			assert.Equal(t, tt.wantDylibs, f.Dylibs())
+			assert.Equal(t, tt.wantWeak, f.WeakDylibs())
+			assert.Equal(t, tt.wantRpaths, f.Rpaths())
+			assert.False(t, f.Changed())
+		})
+	}
+}
+
+func TestFile_modifications(t *testing.T) {
+	data := machotest.Fat(
+		machotest.Build(&machotest.Options{
+			ID: "/opt/homebrew/lib/libgnutls.30.dylib",
+			Dylibs: []string{
+				"/usr/lib/libSystem.B.dylib",
+				"/opt/homebrew/opt/nettle/lib/libnettle.8.dylib",
+			},
+			Rpaths: []string{
+				"@loader_path", "/opt/homebrew/lib", "@loader_path",
+			},
+		}),
+		machotest.Build(&machotest.Options{
+			Cpu: macho.CpuAmd64,
+			ID:  "/usr/local/lib/libgnutls.30.dylib",
+			Dylibs: []string{
+				"/usr/lib/libSystem.B.dylib",
+				"/opt/homebrew/opt/nettle/lib/libnettle.8.dylib",
+			},
+			Rpaths: []string{"@loader_path", "/opt/homebrew/lib"},
+		}),
+	)
+
+	f, err := NewFile(data)
+	require.NoError(t, err)
+
+	require.NoError(t, f.ChangeID("@rpath/libgnutls.30.dylib"))
+	require.NoError(t, f.ChangeInstallName(
+		"/opt/homebrew/opt/nettle/lib/libnettle.8.dylib",
+		"@rpath/a-much-longer-install-name-than-before/libnettle.8.dylib",
+	))
+	removed, err := f.RemoveDuplicateRpaths()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"@loader_path"}, removed)
+	require.NoError(t, f.DeleteRpath("/opt/homebrew/lib"))
+	require.NoError(t, f.AddRpath("@executable_path/../Frameworks"))
+	require.NoError(t, f.AddRpath("@loader_path"))
+
+	err = f.ChangeInstallName("/nope.dylib", "/other.dylib")
+	assert.ErrorIs(t, err, ErrNotLinked)
+
+	assert.True(t, f.Changed())
+
+	// Re-parse modified content to ensure it is valid.
+	got, err := NewFile(f.Bytes())
+	require.NoError(t, err)
+
+	assert.Equal(t, "@rpath/libgnutls.30.dylib", got.ID())
+	assert.Equal(t, []string{
+		"/usr/lib/libSystem.B.dylib",
+		"@rpath/a-much-longer-install-name-than-before/libnettle.8.dylib",
+	}, got.Dylibs())
+	assert.Equal(t,
+		[]string{"@loader_path", "@executable_path/../Frameworks"},
+		got.Rpaths(),
+	)
+
+	// Ensure debug/macho agrees with the result for each architecture.
+	ff, err := macho.NewFatFile(bytes.NewReader(f.Bytes()))
+	require.NoError(t, err)
+	for _, arch := range ff.Arches {
+		libs, err := arch.ImportedLibraries()
+		require.NoError(t, err)
+		assert.Contains(t, libs,
+			"@rpath/a-much-longer-install-name-than-before/libnettle.8.dylib",
+		)
+
+		text := arch.Section("__text")
+		require.NotNil(t, text)
+		content, err := text.Data()
+		require.NoError(t, err)
+		assert.Len(t, content, 16)
+		assert.Equal(t, byte(arch.Cpu), content[0])
+	}
+}
+
+func TestFile_headerSpace(t *testing.T) {
+	data := machotest.Build(&machotest.Options{
+		ID:      "/opt/homebrew/lib/libfoo.dylib",
+		Padding: 16,
+	})
+	f, err := NewFile(append([]byte(nil), data...))
+	require.NoError(t, err)
+
+	err = f.ChangeID("@rpath/libfoo-with-a-very-long-name-that-wont-fit.dylib")
+	assert.ErrorIs(t, err, ErrHeaderSpace)
+
+	err = f.AddRpath("@executable_path/../Frameworks/with/a/long/path")
+	assert.ErrorIs(t, err, ErrHeaderSpace)
+
+	// Failed changes leave the file untouched.
+	assert.False(t, f.Changed())
+	assert.Equal(t, data, f.Bytes())
+	assert.Equal(t, "/opt/homebrew/lib/libfoo.dylib", f.ID())
+	assert.Empty(t, f.Rpaths())
+}
+
+func TestFile_Save(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "libfoo.dylib")
+
+	err := machotest.WriteFile(file, &machotest.Options{
+		ID: "/opt/homebrew/lib/libfoo.dylib",
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.Chmod(file, 0o444))
+
+	f, err := Open(file)
+	require.NoError(t, err)
+	require.NoError(t, f.ChangeID("@rpath/libfoo.dylib"))
+	require.NoError(t, f.Save())
+	assert.False(t, f.Changed())
+
+	got, err := Open(file)
+	require.NoError(t, err)
+	assert.Equal(t, "@rpath/libfoo.dylib", got.ID())
+
+	fi, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o444), fi.Mode().Perm())
+}
+
+func TestIsMachO(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"thin":  machotest.Build(&machotest.Options{}),
+		"fat":   machotest.Fat(machotest.Build(&machotest.Options{})),
+		"java":  {0xca, 0xfe, 0xba, 0xbe, 0x00, 0x00, 0x00, 0x34},
+		"text":  []byte("hello world\n"),
+		"short": {0xcf},
+	}
+	want := map[string]bool{
+		"thin":  true,
+		"fat":   true,
+		"java":  false,
+		"text":  false,
+		"short": false,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(file, content, 0o644))
+
+			got, err := IsMachO(file)
+			require.NoError(t, err)
+
+			assert.Equal(t, want[name], got)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Package machotest builds minimal Mach-O files for use in tests, allowing
+// code which inspects and rewrites load commands to be tested on any
+// platform.
+package machotest
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+)
+
+const (
+	headerSize    = 32
+	segmentSize   = 72
+	sectionSize   = 80
+	textSize      = 16
+	idDylibCmd    = 0xd
+	weakDylibCmd  = 0x80000018
+	defaultPad    = 1024
+	cmdAlignment  = 8
+	fatHeaderSize = 8
+	fatArchSize   = 20
+	fatAlignShift = 14
+)
+
+// Options describes the content of a Mach-O file to build.
+type Options struct {
+	// Cpu is the CPU type of the file, defaults to arm64.
+	Cpu macho.Cpu
+
+	// Type is the Mach-O file type, defaults to a dynamic library if ID is
+	// set, or an executable otherwise.
+	Type macho.Type
+
+	// ID is the LC_ID_DYLIB install name.
+	ID string
+
+	// Dylibs are linked with LC_LOAD_DYLIB load commands.
+	Dylibs []string
+
+	// WeakDylibs are linked with LC_LOAD_WEAK_DYLIB load commands.
+	WeakDylibs []string
+
+	// Rpaths are added as LC_RPATH load commands.
+	Rpaths []string
+
+	// Padding is the number of bytes of free space after the load commands,
+	// defaults to 1024.
+	Padding int
+
+	// Text is the content of the __TEXT,__text section. Defaults to 16 bytes
+	// derived from the CPU type.
+	Text []byte
+}
+
+// Build returns the content of a 64-bit little-endian Mach-O file with a
+// single __TEXT segment, as described by given Options.
+func Build(opts *Options) []byte {
+	cpu := opts.Cpu
+	if cpu == 0 {
+		cpu = macho.CpuArm64
+	}
+
+	typ := opts.Type
+	if typ == 0 {
+		typ = macho.TypeExec
+		if opts.ID != "" {
+			typ = macho.TypeDylib
+		}
+	}
+
+	pad := opts.Padding
+	if pad == 0 {
+		pad = defaultPad
+	}
+
+	text := opts.Text
+	if text == nil {
+		text = make([]byte, textSize)
+		for i := range text {
+			text[i] = byte(cpu) + byte(i)
+		}
+	}
+
+	var cmds [][]byte
+	if opts.ID != "" {
+		cmds = append(cmds, strCmd(idDylibCmd, 24, opts.ID))
+	}
+	for _, d := range opts.Dylibs {
+		cmds = append(cmds, strCmd(uint32(macho.LoadCmdDylib), 24, d))
+	}
+	for _, d := range opts.WeakDylibs {
+		cmds = append(cmds, strCmd(weakDylibCmd, 24, d))
+	}
+	for _, r := range opts.Rpaths {
+		cmds = append(cmds, strCmd(uint32(macho.LoadCmdRpath), 12, r))
+	}
+
+	cmdsSize := segmentSize + sectionSize
+	for _, c := range cmds {
+		cmdsSize += len(c)
+	}
+
+	textOffset := headerSize + cmdsSize + pad
+	total := textOffset + len(text)
+	le := binary.LittleEndian
+
+	b := make([]byte, total)
+	le.PutUint32(b[0:], macho.Magic64)
+	le.PutUint32(b[4:], uint32(cpu))
+	le.PutUint32(b[12:], uint32(typ))
+	le.PutUint32(b[16:], uint32(len(cmds)+1))
+	le.PutUint32(b[20:], uint32(cmdsSize))
+
+	// LC_SEGMENT_64 __TEXT with a single __text section.
+	seg := b[headerSize:]
+	le.PutUint32(seg[0:], uint32(macho.LoadCmdSegment64))
+	le.PutUint32(seg[4:], segmentSize+sectionSize)
+	copy(seg[8:], "__TEXT")
+	le.PutUint64(seg[32:], uint64(total))
+	le.PutUint64(seg[48:], uint64(total))
+	le.PutUint32(seg[56:], 5)
+	le.PutUint32(seg[60:], 5)
+	le.PutUint32(seg[64:], 1)
+
+	sec := seg[segmentSize:]
+	copy(sec[0:], "__text")
+	copy(sec[16:], "__TEXT")
+	le.PutUint64(sec[32:], uint64(textOffset))
+	le.PutUint64(sec[40:], uint64(len(text)))
+	le.PutUint32(sec[48:], uint32(textOffset))
+
+	pos := headerSize + segmentSize + sectionSize
+	for _, c := range cmds {
+		copy(b[pos:], c)
+		pos += len(c)
+	}
+
+	copy(b[textOffset:], text)
+
+	return b
+}
+
+// Fat returns a universal binary containing given thin Mach-O files.
+func Fat(thin ...[]byte) []byte {
+	align := 1 << fatAlignShift
+	offset := align
+	be := binary.BigEndian
+
+	header := make([]byte, fatHeaderSize+fatArchSize*len(thin))
+	be.PutUint32(header[0:], macho.MagicFat)
+	be.PutUint32(header[4:], uint32(len(thin)))
+
+	b := make([]byte, offset)
+	for i, t := range thin {
+		h := header[fatHeaderSize+i*fatArchSize:]
+		be.PutUint32(h[0:], binary.LittleEndian.Uint32(t[4:]))
+		be.PutUint32(h[4:], binary.LittleEndian.Uint32(t[8:]))
+		be.PutUint32(h[8:], uint32(len(b)))
+		be.PutUint32(h[12:], uint32(len(t)))
+		be.PutUint32(h[16:], fatAlignShift)
+
+		b = append(b, t...)
+		if r := len(b) % align; r != 0 && i < len(thin)-1 {
+			b = append(b, make([]byte, align-r)...)
+		}
+	}
+	copy(b, header)
+
+	return b
+}
+
+// WriteFile builds a Mach-O file and writes it to given path, creating any
+// missing parent directories.
+func WriteFile(filename string, opts *Options) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0o755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, Build(opts), 0o644) //nolint:gosec
+}
+
+func strCmd(cmd uint32, headSize int, str string) []byte {
+	size := headSize + len(str) + 1
+	if r := size % cmdAlignment; r != 0 {
+		size += cmdAlignment - r
+	}
+
+	b := make([]byte, size)
+	binary.LittleEndian.PutUint32(b[0:], cmd)
+	binary.LittleEndian.PutUint32(b[4:], uint32(size))
+	binary.LittleEndian.PutUint32(b[8:], uint32(headSize))
+	copy(b[headSize:], str)
+
+	return b
+}
@@ -0,0 +1,210 @@
+// Package manifest describes the contents of a build published to a release,
+// so consumers do not need to infer build details from asset filenames.
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
+	"github.com/jimeh/build-emacs-for-macos/pkg/osinfo"
+	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+	"github.com/jimeh/build-emacs-for-macos/pkg/sparkle"
+)
+
+//nolint:golint
+var (
+	Err                   = errors.New("manifest")
+	ErrInvalid            = fmt.Errorf("%w: invalid manifest", Err)
+	ErrUnsupportedVersion = fmt.Errorf("%w: unsupported schema version", Err)
+)
+
+// SchemaVersion is the version of the manifest format.
+const SchemaVersion = 1
+
+// Suffix is appended to build names to form manifest filenames.
+const Suffix = ".manifest.json"
+
+// Manifest describes a single build published to a release. Each build within
+// a release has its own manifest, named after the build.
+type Manifest struct {
+	SchemaVersion int `json:"schema_version"`
+
+	// Release is the name of the release's git tag.
+	Release string          `json:"release"`
+	Version string          `json:"version,omitempty"`
+	Channel release.Channel `json:"channel,omitempty"`
+
+	OS     *osinfo.OSInfo `json:"os,omitempty"`
+	Commit *commit.Commit `json:"commit,omitempty"`
+
+	// Signed and Notarized report if the build was code signed, and if its
+	// disk image was notarized. They are omitted when not known.
+	Signed    *bool `json:"signed,omitempty"`
+	Notarized *bool `json:"notarized,omitempty"`
+
+	Assets []*Asset `json:"assets"`
+
+	// Plan is the build plan the build was produced from.
+	Plan *plan.Plan `json:"plan,omitempty"`
+}
+
+// Asset is a release asset file which belongs to a build.
+type Asset struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+
+	// EdSignature is the base64 encoded Ed25519 signature of the asset, used
+	// by updaters to verify downloads.
+	EdSignature string `json:"ed_signature,omitempty"`
+}
+
+// New returns a manifest for the build described by given plan, with given
+// asset files. Checksum files with a ".sha256" extension and signature files
+// with a ".edsig" extension are skipped, as the checksum and signature of each
+// asset is part of the manifest.
+func New(p *plan.Plan, assetFiles []string) (*Manifest, error) {
+	if p == nil || p.Build == nil || p.Release == nil {
+		return nil, fmt.Errorf(
+			"%w: plan with build and release is required", ErrInvalid,
+		)
+	}
+
+	m := &Manifest{
+		SchemaVersion: SchemaVersion,
+		Release:       p.Release.Name,
+		Channel:       p.Release.Channel,
+		OS:            p.OS,
+		Assets:        []*Asset{},
+		Plan:          p,
+	}
+
+	if p.Source != nil {
+		m.Commit = p.Source.Commit
+	}
+
+	if p.Output != nil {
+		m.Signed = p.Output.Signed
+		m.Notarized = p.Output.Notarized
+	}
+
+	if v, err := release.ParseVersion(p.Release.Name); err == nil {
+		m.Version = v.String()
+		if m.Channel == "" {
+			m.Channel = v.Channel
+		}
+	}
+
+	for _, file := range assetFiles {
+		if strings.HasSuffix(file, ".sha256") ||
+			strings.HasSuffix(file, sparkle.SignatureSuffix) ||
+			strings.HasSuffix(file, Suffix) {
+			continue
+		}
+
+		a, err := newAsset(file)
+		if err != nil {
+			return nil, err
+		}
+		m.Assets = append(m.Assets, a)
+	}
+
+	return m, nil
+}
+
+func newAsset(filename string) (*Asset, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Asset{
+		Filename: filepath.Base(filename),
+		Size:     size,
+		SHA256:   fmt.Sprintf("%x", h.Sum(nil)),
+	}
+
+	sig, err := sparkle.LoadSignature(filename + sparkle.SignatureSuffix)
+	if err == nil {
+		if sig.Length != size {
+			return nil, fmt.Errorf(
+				"%w: signature of %s is for %d bytes, file is %d bytes",
+				ErrInvalid, a.Filename, sig.Length, size,
+			)
+		}
+		a.EdSignature = sig.EdSignature
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Filename returns the filename of the manifest for given build name.
+func Filename(buildName string) string {
+	return buildName + Suffix
+}
+
+// Parse parses a manifest in JSON format.
+func Parse(b []byte) (*Manifest, error) {
+	m := &Manifest{}
+	err := json.Unmarshal(b, m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	if m.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf(
+			"%w: version %d, newest supported is %d",
+			ErrUnsupportedVersion, m.SchemaVersion, SchemaVersion,
+		)
+	}
+
+	return m, nil
+}
+
+// Asset returns the asset with given filename, or nil if there is none.
+func (s *Manifest) Asset(filename string) *Asset {
+	for _, a := range s.Assets {
+		if a.Filename == filename {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// Save writes manifest in JSON format to given filename.
+func (s *Manifest) Save(filename string) error {
+	var buf bytes.Buffer
+	err := s.WriteJSON(&buf)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, buf.Bytes(), 0o644) //nolint:gosec
+}
+
+// WriteJSON writes manifest in JSON format to given io.Writer.
+func (s *Manifest) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(s)
+}
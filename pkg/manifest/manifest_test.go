@@ -0,0 +1,170 @@
+package manifest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
+	"github.com/jimeh/build-emacs-for-macos/pkg/osinfo"
+	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+	"github.com/jimeh/build-emacs-for-macos/pkg/source"
+	"github.com/jimeh/build-emacs-for-macos/pkg/sparkle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+func testPlan() *plan.Plan {
+	date := time.Date(2024, 6, 30, 10, 0, 0, 0, time.UTC)
+
+	return &plan.Plan{
+		SchemaVersion: plan.SchemaVersion,
+		Build: &plan.Build{
+			Name: "Emacs.2024-06-30.abcdef0.master.macOS-14.arm64",
+		},
+		Source: &source.Source{
+			Ref: "master",
+			Commit: &commit.Commit{
+				SHA:  "abcdef0123456789abcdef0123456789abcdef01",
+				Date: &date,
+			},
+		},
+		OS: &osinfo.OSInfo{
+			Name:       "macOS",
+			Version:    "14.5",
+			SDKVersion: "14.4",
+			Arch:       "arm64",
+		},
+		Release: &plan.Release{
+			Name:       "Emacs.2024-06-30.abcdef0.master",
+			Prerelease: true,
+		},
+		Output: &plan.Output{
+			Directory: "builds",
+			DiskImage: "Emacs.2024-06-30.abcdef0.master.macOS-14.arm64.dmg",
+		},
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	dmg := filepath.Join(
+		dir, "Emacs.2024-06-30.abcdef0.master.macOS-14.arm64.dmg",
+	)
+	require.NoError(t, os.WriteFile(dmg, []byte("hello world\n"), 0o644))
+	require.NoError(t, os.WriteFile(dmg+".sha256", []byte("sum"), 0o644))
+
+	p := testPlan()
+	m, err := New(p, []string{dmg, dmg + ".sha256"})
+	require.NoError(t, err)
+
+	assert.Equal(t, &Manifest{
+		SchemaVersion: SchemaVersion,
+		Release:       "Emacs.2024-06-30.abcdef0.master",
+		Version:       "2024-06-30.abcdef0.master",
+		Channel:       release.Nightly,
+		OS:            p.OS,
+		Commit:        p.Source.Commit,
+		Assets: []*Asset{
+			{
+				Filename: filepath.Base(dmg),
+				Size:     12,
+				SHA256: "a948904f2f0f479b8f8197694b30184b" +
+					"0d2ed1c1cd2a1ec0fb85d299a192a447",
+			},
+		},
+		Plan: p,
+	}, m)
+	assert.Equal(t, m.Assets[0], m.Asset(filepath.Base(dmg)))
+	assert.Nil(t, m.Asset("missing.dmg"))
+}
+
+func TestNew_packageStatus(t *testing.T) {
+	p := testPlan()
+	p.Output.Signed = boolPtr(true)
+	p.Output.Notarized = boolPtr(false)
+
+	m, err := New(p, nil)
+	require.NoError(t, err)
+	assert.Equal(t, boolPtr(true), m.Signed)
+	assert.Equal(t, boolPtr(false), m.Notarized)
+
+	var buf bytes.Buffer
+	require.NoError(t, m.WriteJSON(&buf))
+	assert.Contains(t, buf.String(), `"signed": true`)
+	assert.Contains(t, buf.String(), `"notarized": false`)
+
+	m, err = New(testPlan(), nil)
+	require.NoError(t, err)
+	assert.Nil(t, m.Signed)
+	assert.Nil(t, m.Notarized)
+
+	buf.Reset()
+	require.NoError(t, m.WriteJSON(&buf))
+	assert.NotContains(t, buf.String(), `"signed"`)
+	assert.NotContains(t, buf.String(), `"notarized"`)
+}
+
+func TestNew_signature(t *testing.T) {
+	dir := t.TempDir()
+	dmg := filepath.Join(dir, "Emacs.dmg")
+	require.NoError(t, os.WriteFile(dmg, []byte("hello world\n"), 0o644))
+
+	sig := &sparkle.Signature{EdSignature: "c2lnbmF0dXJl", Length: 12}
+	require.NoError(t, sig.Save(dmg+sparkle.SignatureSuffix))
+
+	m, err := New(testPlan(), []string{dmg, dmg + sparkle.SignatureSuffix})
+	require.NoError(t, err)
+	require.Len(t, m.Assets, 1)
+	assert.Equal(t, "c2lnbmF0dXJl", m.Assets[0].EdSignature)
+
+	sig.Length = 11
+	require.NoError(t, sig.Save(dmg+sparkle.SignatureSuffix))
+
+	_, err = New(testPlan(), []string{dmg})
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestNew_invalid(t *testing.T) {
+	_, err := New(&plan.Plan{}, nil)
+
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestParse(t *testing.T) {
+	m, err := New(testPlan(), nil)
+	require.NoError(t, err)
+	m.Signed = boolPtr(true)
+
+	var buf bytes.Buffer
+	require.NoError(t, m.WriteJSON(&buf))
+
+	got, err := Parse(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, m.Release, got.Release)
+	assert.Equal(t, m.Channel, got.Channel)
+	assert.Equal(t, m.OS, got.OS)
+	assert.Equal(t, boolPtr(true), got.Signed)
+	assert.Nil(t, got.Notarized)
+	assert.Equal(t, m.Plan.Build, got.Plan.Build)
+
+	_, err = Parse([]byte(`{"schema_version": 2}`))
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	_, err = Parse([]byte(`{`))
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestFilename(t *testing.T) {
+	assert.Equal(t,
+		"Emacs.2024-06-30.abcdef0.master.macOS-14.arm64.manifest.json",
+		Filename("Emacs.2024-06-30.abcdef0.master.macOS-14.arm64"),
+	)
+}
@@ -0,0 +1,315 @@
+// Package matrix expands a matrix of refs, profiles and architectures into
+// build plans, and a GitHub Actions job matrix to build them with.
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/osinfo"
+	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	"github.com/jimeh/build-emacs-for-macos/pkg/profile"
+	"gopkg.in/yaml.v3"
+)
+
+//nolint:golint
+var (
+	Err        = errors.New("matrix")
+	ErrInvalid = fmt.Errorf("%w: invalid config", Err)
+)
+
+// Config describes which builds to plan.
+type Config struct {
+	// EmacsRepo is the repository to build Emacs from, unless overridden by
+	// a profile.
+	EmacsRepo string `yaml:"emacs_repo,omitempty" json:"emacs_repo,omitempty"`
+
+	// Refs are the git branches and tags to build.
+	Refs []string `yaml:"refs" json:"refs"`
+
+	// Profiles are the names of build profiles to build each ref with. When
+	// empty, refs are built with default build options.
+	Profiles []string `yaml:"profiles,omitempty" json:"profiles,omitempty"`
+
+	// Arches are the architectures to build each ref and profile for. When
+	// empty, the architecture of the host is used.
+	Arches []string `yaml:"arches,omitempty" json:"arches,omitempty"`
+
+	// OSVersion and SDKVersion set the macOS and SDK versions builds target,
+	// unless set for the architecture in Targets.
+	OSVersion  string `yaml:"os_version,omitempty" json:"os_version,omitempty"`
+	SDKVersion string `yaml:"sdk_version,omitempty" json:"sdk_version,omitempty"`
+
+	// Targets maps architectures to the macOS and SDK versions builds of the
+	// architecture target, which should match their runner. When every
+	// architecture has a version, plans can be created on any OS.
+	Targets map[string]*Target `yaml:"targets,omitempty" json:"targets,omitempty"`
+
+	// Runners maps architectures to the GitHub Actions runner label builds
+	// of the architecture run on.
+	Runners map[string]string `yaml:"runners,omitempty" json:"runners,omitempty"`
+}
+
+// Target is the macOS and SDK version builds of an architecture target.
+type Target struct {
+	OSVersion  string `yaml:"os_version,omitempty" json:"os_version,omitempty"`
+	SDKVersion string `yaml:"sdk_version,omitempty" json:"sdk_version,omitempty"`
+}
+
+// LoadConfig loads a matrix config YAML file.
+func LoadConfig(filename string) (*Config, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err = dec.Decode(c)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalid, filename, err)
+	}
+
+	if len(c.Refs) == 0 {
+		return nil, fmt.Errorf("%w: %s: no refs given", ErrInvalid, filename)
+	}
+
+	return c, nil
+}
+
+// Entry is a single combination of ref, profile and architecture.
+type Entry struct {
+	Ref     string
+	Profile string
+	Arch    string
+}
+
+// Expand returns all combinations of refs, profiles and architectures.
+func (s *Config) Expand() []*Entry {
+	profiles := s.Profiles
+	if len(profiles) == 0 {
+		profiles = []string{""}
+	}
+	arches := s.Arches
+	if len(arches) == 0 {
+		arches = []string{""}
+	}
+
+	entries := []*Entry{}
+	for _, ref := range s.Refs {
+		for _, prof := range profiles {
+			for _, arch := range arches {
+				entries = append(entries, &Entry{
+					Ref:     ref,
+					Profile: prof,
+					Arch:    arch,
+				})
+			}
+		}
+	}
+
+	return entries
+}
+
+// planOptions returns the options to create the plan of a matrix entry with,
+// based on given base options.
+func (s *Config) planOptions(
+	base *plan.Options,
+	e *Entry,
+	prof *profile.Profile,
+) *plan.Options {
+	opts := &plan.Options{}
+	if base != nil {
+		*opts = *base
+	}
+	if s.EmacsRepo != "" {
+		opts.EmacsRepo = s.EmacsRepo
+	}
+	if opts.BuildOptions == nil {
+		opts.BuildOptions = plan.DefaultBuildOptions()
+	}
+	opts.Ref = e.Ref
+
+	target := &osinfo.Target{}
+	if opts.Target != nil {
+		*target = *opts.Target
+	}
+	for _, t := range []*Target{
+		{OSVersion: s.OSVersion, SDKVersion: s.SDKVersion},
+		s.Targets[e.Arch],
+	} {
+		if t == nil {
+			continue
+		}
+		if t.OSVersion != "" {
+			target.Version = t.OSVersion
+		}
+		if t.SDKVersion != "" {
+			target.SDKVersion = t.SDKVersion
+		}
+	}
+	if e.Arch != "" {
+		target.Arch = e.Arch
+	}
+	opts.Target = target
+
+	if prof != nil {
+		prof.Apply(opts)
+	}
+
+	return opts
+}
+
+// Item is a single job in the GitHub Actions matrix.
+type Item struct {
+	Ref       string `json:"ref" yaml:"ref"`
+	Profile   string `json:"profile,omitempty" yaml:"profile,omitempty"`
+	Arch      string `json:"arch,omitempty" yaml:"arch,omitempty"`
+	RunsOn    string `json:"runs-on,omitempty" yaml:"runs-on,omitempty"`
+	SHA       string `json:"sha" yaml:"sha"`
+	BuildName string `json:"build-name" yaml:"build-name"`
+	Release   string `json:"release" yaml:"release"`
+	Plan      string `json:"plan" yaml:"plan"`
+}
+
+// Result is the outcome of planning a matrix. It marshals to JSON as a GitHub
+// Actions matrix, with an include entry for each build.
+type Result struct {
+	Include []*Item `json:"include" yaml:"include"`
+
+	// Skipped lists builds which were left out, as they are already
+	// published or duplicate other entries.
+	Skipped []*Item `json:"-" yaml:"skipped,omitempty"`
+}
+
+// buildKey identifies a build independently of the ref it is built from.
+type buildKey struct {
+	sha       string
+	arch      string
+	variant   int
+	testBuild string
+}
+
+// Options configures how a matrix is planned.
+type Options struct {
+	// Plan holds the base options used to create each plan, before matrix
+	// entry and profile settings are applied.
+	Plan *plan.Options
+
+	// ProfilesDir is the directory to load profiles from.
+	ProfilesDir string
+
+	// PlanDir is the directory plan files are written to.
+	PlanDir string
+
+	// Exists reports if a plan's build is already published, in which case
+	// it is skipped. When nil, no builds are skipped.
+	Exists func(ctx context.Context, p *plan.Plan) (bool, error)
+
+	// Create creates a plan, defaults to plan.Create.
+	Create func(ctx context.Context, opts *plan.Options) (*plan.Plan, error)
+}
+
+// Plan creates and writes a plan file for each entry of the matrix config,
+// and returns the resulting GitHub Actions matrix.
+func Plan(ctx context.Context, cfg *Config, opts *Options) (*Result, error) {
+	logger := hclog.FromContext(ctx).Named("matrix")
+
+	create := opts.Create
+	if create == nil {
+		create = plan.Create
+	}
+
+	profiles := map[string]*profile.Profile{}
+	for _, name := range cfg.Profiles {
+		p, err := profile.Load(opts.ProfilesDir, name)
+		if err != nil {
+			return nil, err
+		}
+		profiles[name] = p
+	}
+
+	err := os.MkdirAll(opts.PlanDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Include: []*Item{}}
+	seen := map[buildKey]*Entry{}
+	for _, e := range cfg.Expand() {
+		planOpts := cfg.planOptions(opts.Plan, e, profiles[e.Profile])
+		p, err := create(ctx, planOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		item := &Item{
+			Ref:       e.Ref,
+			Profile:   e.Profile,
+			Arch:      e.Arch,
+			RunsOn:    cfg.Runners[e.Arch],
+			SHA:       p.Source.Commit.SHA,
+			BuildName: p.Build.Name,
+			Release:   p.Release.Name,
+		}
+
+		// Refs may resolve to the same commit, like a release branch and
+		// its latest tag, which only needs to be built once. Profiles which
+		// would produce the same build can not be told apart though.
+		key := buildKey{
+			sha:       item.SHA,
+			arch:      e.Arch,
+			variant:   planOpts.BuildVariant,
+			testBuild: planOpts.TestBuild,
+		}
+		if prev, ok := seen[key]; ok {
+			if prev.Profile != e.Profile {
+				return nil, fmt.Errorf(
+					"%w: profiles %q and %q produce the same build of %s, "+
+						"give them different build variants",
+					ErrInvalid, prev.Profile, e.Profile, e.Ref,
+				)
+			}
+
+			logger.Info("skipping duplicate build",
+				"ref", e.Ref, "duplicates", prev.Ref, "sha", item.SHA,
+			)
+			result.Skipped = append(result.Skipped, item)
+
+			continue
+		}
+		seen[key] = e
+
+		if opts.Exists != nil {
+			exists, err := opts.Exists(ctx, p)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				logger.Info("skipping published build",
+					"ref", e.Ref, "sha", item.SHA, "release", item.Release,
+				)
+				result.Skipped = append(result.Skipped, item)
+
+				continue
+			}
+		}
+
+		item.Plan = filepath.Join(opts.PlanDir, p.Build.Name+".yml")
+		logger.Info("writing plan", "ref", e.Ref, "file", item.Plan)
+		err = p.Save(item.Plan)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Include = append(result.Include, item)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,291 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
+	"github.com/jimeh/build-emacs-for-macos/pkg/osinfo"
+	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	"github.com/jimeh/build-emacs-for-macos/pkg/sanitize"
+	"github.com/jimeh/build-emacs-for-macos/pkg/source"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Expand(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		want []*Entry
+	}{
+		{
+			name: "refs only",
+			cfg:  &Config{Refs: []string{"master", "emacs-30"}},
+			want: []*Entry{{Ref: "master"}, {Ref: "emacs-30"}},
+		},
+		{
+			name: "all dimensions",
+			cfg: &Config{
+				Refs:     []string{"master"},
+				Profiles: []string{"full", "minimal"},
+				Arches:   []string{"arm64", "x86_64"},
+			},
+			want: []*Entry{
+				{Ref: "master", Profile: "full", Arch: "arm64"},
+				{Ref: "master", Profile: "full", Arch: "x86_64"},
+				{Ref: "master", Profile: "minimal", Arch: "arm64"},
+				{Ref: "master", Profile: "minimal", Arch: "x86_64"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.cfg.Expand())
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+
+		return filename
+	}
+
+	cfg, err := LoadConfig(write("typo.yml",
+		"refs: [master]\narches: [arm64]\nruners: {}\n",
+	))
+	assert.ErrorIs(t, err, ErrInvalid, "unknown keys are rejected")
+	assert.Nil(t, cfg)
+
+	_, err = LoadConfig(write("empty.yml", "profiles: [full]\n"))
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	cfg, err = LoadConfig(filepath.Join("..", "..", "matrix.yml"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, cfg.Expand())
+
+	// Every architecture targets a fixed macOS version, so plans do not
+	// depend on the host they are created on.
+	for _, arch := range cfg.Arches {
+		require.Contains(t, cfg.Targets, arch)
+		assert.NotEmpty(t, cfg.Targets[arch].OSVersion, arch)
+	}
+}
+
+func TestConfig_planOptions(t *testing.T) {
+	cfg := &Config{
+		Refs:       []string{"master"},
+		Arches:     []string{"arm64", "x86_64", "universal"},
+		OSVersion:  "12",
+		SDKVersion: "12.3",
+		Targets: map[string]*Target{
+			"arm64":  {OSVersion: "14"},
+			"x86_64": {OSVersion: "13", SDKVersion: "13.3"},
+		},
+	}
+
+	targets := map[string]*osinfo.Target{}
+	for _, e := range cfg.Expand() {
+		opts := cfg.planOptions(&plan.Options{}, e, nil)
+		targets[e.Arch] = opts.Target
+	}
+
+	assert.Equal(t, map[string]*osinfo.Target{
+		"arm64":     {Version: "14", SDKVersion: "12.3", Arch: "arm64"},
+		"x86_64":    {Version: "13", SDKVersion: "13.3", Arch: "x86_64"},
+		"universal": {Version: "12", SDKVersion: "12.3", Arch: "universal"},
+	}, targets)
+}
+
+// fakeCreate creates plans named like plan.Create does, with refs resolving
+// to the commit given in shas.
+func fakeCreate(
+	shas map[string]string,
+) func(context.Context, *plan.Options) (*plan.Plan, error) {
+	return func(_ context.Context, opts *plan.Options) (*plan.Plan, error) {
+		sha := shas[opts.Ref]
+		name := "Emacs." + sha[:7] + "." + sanitize.String(opts.Ref)
+		if opts.BuildVariant != 0 {
+			name += "-" + strconv.Itoa(opts.BuildVariant)
+		}
+		if opts.TestBuild != "" {
+			name = "Emacs." + sha[:7] + "." + opts.TestBuild
+		}
+
+		return &plan.Plan{
+			SchemaVersion: plan.SchemaVersion,
+			Build: &plan.Build{
+				Name:    name + "." + opts.Target.Arch,
+				Profile: opts.Profile,
+				Options: opts.BuildOptions,
+			},
+			Source: &source.Source{
+				Ref:    opts.Ref,
+				Commit: &commit.Commit{SHA: sha},
+			},
+			Release: &plan.Release{Name: name},
+			Output: &plan.Output{
+				Directory: opts.OutputDir,
+				DiskImage: name + "." + opts.Target.Arch + ".dmg",
+			},
+		}, nil
+	}
+}
+
+func TestPlan(t *testing.T) {
+	profilesDir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(profilesDir, "minimal.yml"),
+		[]byte("build_variant: 1\noptions:\n  native_comp: false\n"),
+		0o644,
+	))
+	planDir := filepath.Join(t.TempDir(), "plans")
+
+	cfg := &Config{
+		Refs:     []string{"master", "emacs-31", "emacs-30"},
+		Profiles: []string{"minimal"},
+		Arches:   []string{"arm64", "x86_64"},
+		Runners:  map[string]string{"arm64": "macos-14"},
+	}
+	shas := map[string]string{
+		"master":   "1111111111111111111111111111111111111111",
+		"emacs-31": "1111111111111111111111111111111111111111",
+		"emacs-30": "3333333333333333333333333333333333333333",
+	}
+
+	got, err := Plan(context.Background(), cfg, &Options{
+		Plan:        &plan.Options{OutputDir: "/builds"},
+		ProfilesDir: profilesDir,
+		PlanDir:     planDir,
+		Create:      fakeCreate(shas),
+		Exists: func(_ context.Context, p *plan.Plan) (bool, error) {
+			dmg := "Emacs.3333333.emacs-30-1.x86_64.dmg"
+
+			return p.Output.DiskImage == dmg, nil
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []*Item{
+		{
+			Ref: "master", Profile: "minimal", Arch: "arm64",
+			RunsOn:    "macos-14",
+			SHA:       shas["master"],
+			BuildName: "Emacs.1111111.master-1.arm64",
+			Release:   "Emacs.1111111.master-1",
+			Plan: filepath.Join(
+				planDir, "Emacs.1111111.master-1.arm64.yml",
+			),
+		},
+		{
+			Ref: "master", Profile: "minimal", Arch: "x86_64",
+			SHA:       shas["master"],
+			BuildName: "Emacs.1111111.master-1.x86_64",
+			Release:   "Emacs.1111111.master-1",
+			Plan: filepath.Join(
+				planDir, "Emacs.1111111.master-1.x86_64.yml",
+			),
+		},
+		{
+			Ref: "emacs-30", Profile: "minimal", Arch: "arm64",
+			RunsOn:    "macos-14",
+			SHA:       shas["emacs-30"],
+			BuildName: "Emacs.3333333.emacs-30-1.arm64",
+			Release:   "Emacs.3333333.emacs-30-1",
+			Plan: filepath.Join(
+				planDir, "Emacs.3333333.emacs-30-1.arm64.yml",
+			),
+		},
+	}, got.Include)
+
+	skipped := []string{}
+	for _, item := range got.Skipped {
+		skipped = append(skipped, item.Ref+" "+item.Arch)
+	}
+	assert.Equal(t,
+		[]string{"emacs-31 arm64", "emacs-31 x86_64", "emacs-30 x86_64"},
+		skipped,
+	)
+
+	p, err := plan.Load(got.Include[0].Plan)
+	require.NoError(t, err)
+	assert.Equal(t, "minimal", p.Build.Profile)
+	assert.Equal(t, "/builds", p.Output.Directory)
+	require.NotNil(t, p.Build.Options.NativeComp)
+	assert.False(t, *p.Build.Options.NativeComp)
+	assert.True(t, *p.Build.Options.XWidgets)
+
+	b, err := json.Marshal(got)
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "skipped")
+	assert.Contains(t, string(b), `{"include":[{"ref":"master",`)
+}
+
+func TestPlan_duplicates(t *testing.T) {
+	profilesDir := t.TempDir()
+	for name, content := range map[string]string{
+		"full":    "",
+		"minimal": "build_variant: 1\n",
+		"lean":    "build_variant: 1\n",
+		"test":    "build_variant: 1\ntest_build:\n  name: foo\n",
+	} {
+		require.NoError(t, os.WriteFile(
+			filepath.Join(profilesDir, name+".yml"), []byte(content), 0o644,
+		))
+	}
+	shas := map[string]string{
+		"emacs-30":   "3333333333333333333333333333333333333333",
+		"emacs-30.1": "3333333333333333333333333333333333333333",
+	}
+
+	t.Run("branch and tag", func(t *testing.T) {
+		got, err := Plan(context.Background(), &Config{
+			Refs:     []string{"emacs-30", "emacs-30.1"},
+			Profiles: []string{"full", "minimal", "test"},
+			Arches:   []string{"arm64"},
+		}, &Options{
+			ProfilesDir: profilesDir,
+			PlanDir:     t.TempDir(),
+			Create:      fakeCreate(shas),
+		})
+		require.NoError(t, err)
+
+		var included, skipped []string
+		for _, item := range got.Include {
+			included = append(included, item.BuildName)
+		}
+		for _, item := range got.Skipped {
+			skipped = append(skipped, item.BuildName)
+		}
+		assert.Equal(t, []string{
+			"Emacs.3333333.emacs-30.arm64",
+			"Emacs.3333333.emacs-30-1.arm64",
+			"Emacs.3333333.foo.arm64",
+		}, included)
+		assert.Equal(t, []string{
+			"Emacs.3333333.emacs-30-1.arm64",
+			"Emacs.3333333.emacs-30-1-1.arm64",
+			"Emacs.3333333.foo.arm64",
+		}, skipped)
+	})
+
+	t.Run("profiles with same build", func(t *testing.T) {
+		_, err := Plan(context.Background(), &Config{
+			Refs:     []string{"emacs-30"},
+			Profiles: []string{"minimal", "lean"},
+		}, &Options{
+			ProfilesDir: profilesDir,
+			PlanDir:     t.TempDir(),
+			Create:      fakeCreate(shas),
+		})
+		assert.ErrorIs(t, err, ErrInvalid)
+	})
+}
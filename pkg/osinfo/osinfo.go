@@ -1,12 +1,31 @@
 package osinfo
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 )
 
+//nolint:golint
+var (
+	Err            = errors.New("osinfo")
+	ErrInvalidArch = fmt.Errorf("%w: invalid architecture", Err)
+)
+
+// Architectures which builds can target.
+const (
+	ARM64     = "arm64"
+	X86_64    = "x86_64" //nolint:revive,stylecheck
+	Universal = "universal"
+)
+
+// Arches lists all architectures which builds can target.
+var Arches = []string{ARM64, X86_64, Universal}
+
 type OSInfo struct {
 	Name       string `yaml:"name" json:"name"`
 	Version    string `yaml:"version" json:"version"`
@@ -14,6 +33,106 @@ type OSInfo struct {
 	Arch       string `yaml:"arch" json:"arch"`
 }
 
+// Provider provides information about the OS a build targets.
+type Provider interface {
+	OSInfo(ctx context.Context) (*OSInfo, error)
+}
+
+// ProviderFunc is a function which implements Provider.
+type ProviderFunc func(ctx context.Context) (*OSInfo, error)
+
+// OSInfo calls the function.
+func (f ProviderFunc) OSInfo(ctx context.Context) (*OSInfo, error) {
+	return f(ctx)
+}
+
+// Host is a Provider which returns information about the macOS host.
+var Host Provider = ProviderFunc(func(_ context.Context) (*OSInfo, error) {
+	return New()
+})
+
+// Static returns a Provider which always returns a copy of given info.
+func Static(info *OSInfo) Provider {
+	return ProviderFunc(func(_ context.Context) (*OSInfo, error) {
+		i := *info
+
+		return &i, nil
+	})
+}
+
+// Target overrides parts of the OS information of a Provider, to plan builds
+// for other OS versions and architectures than the host's.
+type Target struct {
+	Version    string
+	SDKVersion string
+	Arch       string
+}
+
+// Validate checks that the target architecture is supported.
+func (s *Target) Validate() error {
+	if s.Arch == "" {
+		return nil
+	}
+	for _, a := range Arches {
+		if s.Arch == a {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(
+		"%w: %q, must be one of: %s",
+		ErrInvalidArch, s.Arch, strings.Join(Arches, ", "),
+	)
+}
+
+// complete reports if the target specifies enough of the OS information to
+// name builds, which is the architecture and either version, so the provider
+// it overrides need not be used.
+func (s *Target) complete() bool {
+	return s.Arch != "" && (s.Version != "" || s.SDKVersion != "")
+}
+
+// WithTarget returns a Provider which overrides information from p with the
+// fields set in target. If target sets the architecture and either version, p
+// is never called, so plans for any target can be created on hosts other than
+// macOS. The SDK version is then left empty if not set, and the macOS version
+// defaults to the SDK version.
+func WithTarget(p Provider, target *Target) Provider {
+	if target == nil {
+		return p
+	}
+
+	return ProviderFunc(func(ctx context.Context) (*OSInfo, error) {
+		err := target.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		info := &OSInfo{Name: "macOS"}
+		if !target.complete() {
+			info, err = p.OSInfo(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if target.Version != "" {
+			info.Version = target.Version
+		}
+		if target.SDKVersion != "" {
+			info.SDKVersion = target.SDKVersion
+		}
+		if target.Arch != "" {
+			info.Arch = target.Arch
+		}
+		if info.Version == "" {
+			info.Version = info.SDKVersion
+		}
+
+		return info, nil
+	})
+}
+
 func New() (*OSInfo, error) {
 	version, err := exec.Command("sw_vers", "-productVersion").Output()
 	if err != nil {
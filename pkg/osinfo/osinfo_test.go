@@ -0,0 +1,156 @@
+package osinfo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOSInfo_DistinctVersion(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{version: "10.15.7", want: "10.15"},
+		{version: "10.15", want: "10.15"},
+		{version: "11.6.1", want: "11"},
+		{version: "14.5", want: "14"},
+		{version: "26", want: "26"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			t.Parallel()
+
+			info := &OSInfo{Version: tt.version, SDKVersion: tt.version}
+
+			assert.Equal(t, tt.want, info.DistinctVersion())
+			assert.Equal(t, tt.want, info.DistinctSDKVersion())
+		})
+	}
+}
+
+func TestWithTarget(t *testing.T) {
+	t.Parallel()
+
+	host := &OSInfo{
+		Name:       "macOS",
+		Version:    "14.5",
+		SDKVersion: "14.4",
+		Arch:       "arm64",
+	}
+	errHost := errors.New("host called")
+
+	tests := []struct {
+		name    string
+		target  *Target
+		hostErr error
+		want    *OSInfo
+		wantErr error
+	}{
+		{
+			name:   "nil target",
+			target: nil,
+			want:   host,
+		},
+		{
+			name:   "arch only",
+			target: &Target{Arch: X86_64},
+			want: &OSInfo{
+				Name:       "macOS",
+				Version:    "14.5",
+				SDKVersion: "14.4",
+				Arch:       "x86_64",
+			},
+		},
+		{
+			name:   "versions only",
+			target: &Target{Version: "12.7", SDKVersion: "12.3"},
+			want: &OSInfo{
+				Name:       "macOS",
+				Version:    "12.7",
+				SDKVersion: "12.3",
+				Arch:       "arm64",
+			},
+		},
+		{
+			name: "complete target does not use host",
+			target: &Target{
+				Version:    "15.1",
+				SDKVersion: "15.0",
+				Arch:       Universal,
+			},
+			hostErr: errHost,
+			want: &OSInfo{
+				Name:       "macOS",
+				Version:    "15.1",
+				SDKVersion: "15.0",
+				Arch:       "universal",
+			},
+		},
+		{
+			name:    "version and arch do not use host",
+			target:  &Target{Version: "14.5", Arch: ARM64},
+			hostErr: errHost,
+			want: &OSInfo{
+				Name:    "macOS",
+				Version: "14.5",
+				Arch:    "arm64",
+			},
+		},
+		{
+			name:    "SDK version and arch do not use host",
+			target:  &Target{SDKVersion: "13.3", Arch: X86_64},
+			hostErr: errHost,
+			want: &OSInfo{
+				Name:       "macOS",
+				Version:    "13.3",
+				SDKVersion: "13.3",
+				Arch:       "x86_64",
+			},
+		},
+		{
+			name:    "versions without arch use host",
+			target:  &Target{Version: "15.1", SDKVersion: "15.0"},
+			hostErr: errHost,
+			wantErr: errHost,
+		},
+		{
+			name:    "incomplete target uses host",
+			target:  &Target{Arch: ARM64},
+			hostErr: errHost,
+			wantErr: errHost,
+		},
+		{
+			name:    "invalid arch",
+			target:  &Target{Arch: "i386"},
+			wantErr: ErrInvalidArch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := Static(host)
+			if tt.hostErr != nil {
+				p = ProviderFunc(func(_ context.Context) (*OSInfo, error) {
+					return nil, tt.hostErr
+				})
+			}
+
+			got, err := WithTarget(p, tt.target).OSInfo(context.Background())
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
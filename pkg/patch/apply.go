@@ -0,0 +1,542 @@
+package patch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+//nolint:golint
+var (
+	// ErrConflict is returned when one or more hunks fail to apply.
+	ErrConflict = fmt.Errorf("%w: conflict", Err)
+
+	// ErrUnpinned is returned when applying a downloaded patch which has no
+	// SHA256 digest to verify it against.
+	ErrUnpinned = fmt.Errorf("%w: patch is not pinned", Err)
+)
+
+// MaxFuzz is the maximum number of leading and trailing context lines which
+// are ignored when a hunk does not match, the same as the GNU patch default.
+const MaxFuzz = 2
+
+// HunkStatus is the outcome of applying a single hunk.
+type HunkStatus string
+
+// Hunk statuses
+const (
+	Applied        HunkStatus = "applied"
+	Conflict       HunkStatus = "conflict"
+	AlreadyApplied HunkStatus = "already-applied"
+)
+
+type ApplyOptions struct {
+	// DryRun checks if all patches apply without modifying any files.
+	DryRun bool
+
+	// HTTPClient is used to download patches. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Report describes the outcome of applying a list of patches.
+type Report struct {
+	Dir     string         `yaml:"dir" json:"dir"`
+	DryRun  bool           `yaml:"dry_run" json:"dry_run"`
+	Patches []*PatchReport `yaml:"patches" json:"patches"`
+}
+
+// PatchReport describes the outcome of applying a single patch.
+type PatchReport struct {
+	Name   string        `yaml:"name" json:"name"`
+	Source string        `yaml:"source" json:"source"`
+	Files  []*FileReport `yaml:"files" json:"files"`
+}
+
+// FileReport describes the outcome of applying a single file diff.
+type FileReport struct {
+	Path   string        `yaml:"path" json:"path"`
+	Create bool          `yaml:"create,omitempty" json:"create,omitempty"`
+	Delete bool          `yaml:"delete,omitempty" json:"delete,omitempty"`
+	Error  string        `yaml:"error,omitempty" json:"error,omitempty"`
+	Hunks  []*HunkReport `yaml:"hunks" json:"hunks"`
+}
+
+// HunkReport describes the outcome of applying a single hunk.
+type HunkReport struct {
+	Hunk     int        `yaml:"hunk" json:"hunk"`
+	OldStart int        `yaml:"old_start" json:"old_start"`
+	OldLines int        `yaml:"old_lines" json:"old_lines"`
+	Status   HunkStatus `yaml:"status" json:"status"`
+
+	// Line is where the hunk was applied, and Offset the difference between
+	// that and the line given in the hunk header.
+	Line   int `yaml:"line,omitempty" json:"line,omitempty"`
+	Offset int `yaml:"offset,omitempty" json:"offset,omitempty"`
+
+	// Fuzz is the number of leading and trailing context lines ignored to
+	// apply the hunk, like "patch --fuzz".
+	Fuzz int `yaml:"fuzz,omitempty" json:"fuzz,omitempty"`
+
+	// IgnoredWhitespace is set when the hunk only matched when ignoring
+	// differences in whitespace, like "patch -l".
+	IgnoredWhitespace bool `yaml:"ignored_whitespace,omitempty" json:"ignored_whitespace,omitempty"`
+}
+
+// OK reports if all patches applied cleanly.
+func (s *Report) OK() bool {
+	return s.Conflicts() == 0
+}
+
+// Conflicts returns the number of hunks and files which failed to apply.
+func (s *Report) Conflicts() int {
+	n := 0
+	for _, p := range s.Patches {
+		for _, f := range p.Files {
+			if f.Error != "" {
+				n++
+			}
+			for _, h := range f.Hunks {
+				if h.Status != Applied {
+					n++
+				}
+			}
+		}
+	}
+
+	return n
+}
+
+// Apply applies patches in order to the source tree in dir. All patches are
+// applied in memory first, and files are only written if every hunk applied
+// cleanly, so a failed run never leaves a partially patched tree behind.
+//
+// Patches downloaded from a URL must have a SHA256 digest, otherwise
+// ErrUnpinned is returned before anything is downloaded.
+//
+// A report is returned when patches could be parsed, even if they conflict, in
+// which case the error wraps ErrConflict.
+func Apply(
+	ctx context.Context,
+	dir string,
+	patches []*Patch,
+	opts *ApplyOptions,
+) (*Report, error) {
+	logger := hclog.FromContext(ctx).Named("patch")
+	if opts == nil {
+		opts = &ApplyOptions{}
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", Err, dir)
+	}
+
+	for _, p := range patches {
+		if p.URL != "" && p.SHA256 == "" {
+			return nil, fmt.Errorf("%w: %s: %s", ErrUnpinned, p.Name, p.URL)
+		}
+	}
+
+	tree := &tree{dir: dir, files: map[string]*file{}}
+	report := &Report{Dir: dir, DryRun: opts.DryRun}
+
+	for _, p := range patches {
+		data, src, err := Read(ctx, p, opts.HTTPClient)
+		if err != nil {
+			return nil, err
+		}
+
+		diffs, err := ParseDiff(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, p.Name)
+		}
+
+		logger.Info("applying patch", "name", p.Name, "source", src)
+		pr := &PatchReport{Name: p.Name, Source: src}
+		for _, d := range diffs {
+			fr, err := tree.apply(d)
+			if err != nil {
+				return nil, err
+			}
+			for _, h := range fr.Hunks {
+				if h.Fuzz > 0 {
+					logger.Warn("hunk applied with fuzz",
+						"patch", p.Name, "file", fr.Path, "hunk", h.Hunk,
+						"fuzz", h.Fuzz, "line", h.Line,
+					)
+				}
+				if h.Status != Applied {
+					logger.Error("hunk failed",
+						"patch", p.Name, "file", fr.Path, "hunk", h.Hunk,
+						"status", h.Status,
+					)
+				}
+			}
+			if fr.Error != "" {
+				logger.Error("file failed",
+					"patch", p.Name, "file", fr.Path, "error", fr.Error,
+				)
+			}
+			pr.Files = append(pr.Files, fr)
+		}
+		report.Patches = append(report.Patches, pr)
+	}
+
+	if !report.OK() {
+		return report, fmt.Errorf(
+			"%w: %d hunks failed to apply", ErrConflict, report.Conflicts(),
+		)
+	}
+
+	if opts.DryRun {
+		logger.Info("all patches apply cleanly", "dry_run", true)
+
+		return report, nil
+	}
+
+	err = tree.write()
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("all patches applied", "files", len(tree.files))
+
+	return report, nil
+}
+
+// tree holds the in-memory state of files modified by patches.
+type tree struct {
+	dir   string
+	files map[string]*file
+	order []string
+}
+
+type file struct {
+	lines     []string
+	noNewline bool
+	exists    bool
+	mode      fs.FileMode
+}
+
+func (s *tree) get(path string) (*file, error) {
+	if f, ok := s.files[path]; ok {
+		return f, nil
+	}
+
+	f := &file{mode: 0o644}
+	b, err := os.ReadFile(filepath.Join(s.dir, path))
+	switch {
+	case err == nil:
+		fi, err := os.Stat(filepath.Join(s.dir, path))
+		if err != nil {
+			return nil, err
+		}
+		f.exists = true
+		f.mode = fi.Mode().Perm()
+		f.lines, f.noNewline = splitLines(b)
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	s.files[path] = f
+	s.order = append(s.order, path)
+
+	return f, nil
+}
+
+func (s *tree) apply(d *FileDiff) (*FileReport, error) {
+	fr := &FileReport{
+		Path:   filepath.ToSlash(d.Path),
+		Create: d.Create,
+		Delete: d.Delete,
+		Hunks:  []*HunkReport{},
+	}
+
+	f, err := s.get(d.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case d.Create && f.exists && len(f.lines) > 0:
+		fr.Error = "file to be created already exists"
+
+		return fr, nil
+	case !d.Create && !f.exists:
+		fr.Error = "file does not exist"
+
+		return fr, nil
+	}
+
+	// Hunks are applied to a copy, so a file is left untouched if any of its
+	// hunks conflict.
+	lines := append([]string{}, f.lines...)
+	noNewline := f.noNewline
+	delta := 0
+	drift := 0
+	minLine := 0
+	failed := false
+	for i, h := range d.Hunks {
+		hr := &HunkReport{
+			Hunk:     i + 1,
+			OldStart: h.OldStart,
+			OldLines: h.OldLines,
+		}
+		fr.Hunks = append(fr.Hunks, hr)
+
+		expected := h.OldStart - 1 + delta
+		if h.OldLines == 0 {
+			// Pure additions are inserted after the given line.
+			expected = h.OldStart + delta
+		}
+
+		m, ok := locate(lines, h, expected+drift, minLine)
+		if !ok {
+			hr.Status = Conflict
+			newLines := h.New()
+			if _, _, ok := find(lines, newLines, expected+drift, minLine); ok &&
+				len(newLines) > 0 {
+				hr.Status = AlreadyApplied
+			}
+			failed = true
+
+			continue
+		}
+
+		pos := m.pos
+		old := m.hunk.Old()
+		hr.Status = Applied
+		hr.Line = max(pos-m.lead, 0) + 1
+		hr.Offset = hr.Line - 1 - expected
+		hr.Fuzz = m.fuzz
+		hr.IgnoredWhitespace = m.loose
+
+		newLines := m.hunk.apply(lines[pos : pos+len(old)])
+		lines = append(
+			lines[:pos],
+			append(newLines, lines[pos+len(old):]...)...,
+		)
+		delta += len(newLines) - len(old)
+		drift = hr.Offset
+		minLine = pos + len(newLines)
+
+		if pos+len(newLines) == len(lines) {
+			switch {
+			case m.hunk.NewNoNewline:
+				noNewline = true
+			case m.hunk.OldNoNewline || len(newLines) > 0:
+				noNewline = false
+			}
+		}
+	}
+
+	if failed {
+		return fr, nil
+	}
+
+	f.lines = lines
+	f.noNewline = noNewline
+	f.exists = !d.Delete
+
+	return fr, nil
+}
+
+// apply returns the lines replacing matched, which are the lines of the file
+// matching the old side of the hunk. Context lines are taken from the file
+// rather than the hunk, so whitespace differences ignored when matching are
+// preserved.
+func (s *Hunk) apply(matched []string) []string {
+	lines := []string{}
+	i := 0
+	for _, l := range s.Lines {
+		switch l[0] {
+		case ' ':
+			lines = append(lines, matched[i])
+			i++
+		case '-':
+			i++
+		case '+':
+			lines = append(lines, l[1:])
+		}
+	}
+
+	return lines
+}
+
+// match is where a hunk applies to a file.
+type match struct {
+	// hunk is the hunk that matched, without any context lines ignored by
+	// fuzz.
+	hunk *Hunk
+
+	// pos is the index of the first line matching the hunk, and lead the
+	// number of leading context lines of the original hunk ignored by fuzz.
+	pos  int
+	lead int
+
+	fuzz  int
+	loose bool
+}
+
+// locate finds where hunk h applies in lines, like find. If the hunk does not
+// match, it is retried ignoring up to MaxFuzz leading and trailing context
+// lines, like GNU patch does.
+func locate(lines []string, h *Hunk, want, from int) (*match, bool) {
+	lead, trail := h.context()
+	for fuzz := 0; fuzz <= MaxFuzz; fuzz++ {
+		if fuzz > 0 && fuzz > lead && fuzz > trail {
+			break
+		}
+
+		m := &match{hunk: h, fuzz: fuzz}
+		if fuzz > 0 {
+			m.lead = min(fuzz, lead)
+			m.hunk = h.trim(m.lead, min(fuzz, trail))
+		}
+
+		var ok bool
+		m.pos, m.loose, ok = find(lines, m.hunk.Old(), want+m.lead, from)
+		if ok {
+			return m, true
+		}
+	}
+
+	return nil, false
+}
+
+// context returns the number of leading and trailing context lines of the
+// hunk.
+func (s *Hunk) context() (int, int) {
+	lead := 0
+	for lead < len(s.Lines) && s.Lines[lead][0] == ' ' {
+		lead++
+	}
+	if lead == len(s.Lines) {
+		return lead, 0
+	}
+
+	trail := 0
+	for s.Lines[len(s.Lines)-1-trail][0] == ' ' {
+		trail++
+	}
+
+	return lead, trail
+}
+
+// trim returns a copy of the hunk without its first lead and last trail lines.
+func (s *Hunk) trim(lead, trail int) *Hunk {
+	h := *s
+	h.Lines = s.Lines[lead : len(s.Lines)-trail]
+	if trail > 0 {
+		h.OldNoNewline, h.NewNoNewline = false, false
+	}
+
+	return &h
+}
+
+// find locates old lines in lines, searching outwards from the wanted index,
+// but never before min. Exact matches are preferred over matches ignoring
+// whitespace.
+func find(lines, old []string, want, min int) (int, bool, bool) {
+	if want < min {
+		want = min
+	}
+	if want > len(lines) {
+		want = len(lines)
+	}
+
+	for _, loose := range []bool{false, true} {
+		for d := 0; want-d >= min || want+d <= len(lines); d++ {
+			for _, pos := range []int{want - d, want + d} {
+				if pos < min || pos+len(old) > len(lines) {
+					continue
+				}
+				if matches(lines[pos:pos+len(old)], old, loose) {
+					return pos, loose, true
+				}
+				if d == 0 {
+					break
+				}
+			}
+		}
+	}
+
+	return 0, false, false
+}
+
+func matches(a, b []string, loose bool) bool {
+	for i := range b {
+		if a[i] == b[i] {
+			continue
+		}
+		if !loose ||
+			strings.Join(strings.Fields(a[i]), " ") !=
+				strings.Join(strings.Fields(b[i]), " ") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *tree) write() error {
+	for _, path := range s.order {
+		f := s.files[path]
+		target := filepath.Join(s.dir, path)
+
+		if !f.exists {
+			err := os.Remove(target)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+
+			continue
+		}
+
+		err := os.MkdirAll(filepath.Dir(target), 0o755)
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(target, joinLines(f.lines, f.noNewline), f.mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func splitLines(b []byte) ([]string, bool) {
+	if len(b) == 0 {
+		return []string{}, false
+	}
+
+	noNewline := !bytes.HasSuffix(b, []byte("\n"))
+	s := strings.TrimSuffix(string(b), "\n")
+
+	return strings.Split(s, "\n"), noNewline
+}
+
+func joinLines(lines []string, noNewline bool) []byte {
+	if len(lines) == 0 {
+		return []byte{}
+	}
+
+	s := strings.Join(lines, "\n")
+	if !noNewline {
+		s += "\n"
+	}
+
+	return []byte(s)
+}
@@ -0,0 +1,404 @@
+package patch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// copyTree copies the fixture directory src to a new temporary directory.
+func copyTree(t *testing.T, src string) string {
+	t.Helper()
+
+	dst := t.TempDir()
+	err := filepath.WalkDir(
+		src,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return os.MkdirAll(filepath.Join(dst, rel), 0o755)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			return os.WriteFile(filepath.Join(dst, rel), b, 0o644)
+		},
+	)
+	require.NoError(t, err)
+
+	return dst
+}
+
+// readTree returns the content of all files in dir, keyed by relative path.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	err := filepath.WalkDir(
+		dir,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			b, err := os.ReadFile(path)
+			files[filepath.ToSlash(rel)] = string(b)
+
+			return err
+		},
+	)
+	require.NoError(t, err)
+
+	return files
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		offsets []int
+		fuzz    []int
+		loose   bool
+	}{
+		{name: "modify", offsets: []int{2, 2, 0, 2}},
+		{name: "fuzz", offsets: []int{3, 3}, fuzz: []int{1, 2}},
+		{name: "create-delete", offsets: []int{0, 0}},
+		{name: "no-newline", offsets: []int{0, 0}},
+		{name: "whitespace", offsets: []int{0}, loose: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture := filepath.Join("testdata", "apply", tt.name)
+			dir := copyTree(t, filepath.Join(fixture, "before"))
+			patches := []*Patch{
+				{Name: tt.name, Path: filepath.Join(fixture, "patch.diff")},
+			}
+
+			// Dry run reports hunks without modifying anything.
+			report, err := Apply(context.Background(), dir, patches,
+				&ApplyOptions{DryRun: true},
+			)
+			require.NoError(t, err)
+			assert.True(t, report.OK())
+			assert.True(t, report.DryRun)
+			assert.Equal(t,
+				readTree(t, filepath.Join(fixture, "before")),
+				readTree(t, dir),
+			)
+
+			report, err = Apply(context.Background(), dir, patches, nil)
+			require.NoError(t, err)
+
+			offsets := []int{}
+			fuzz := []int{}
+			for _, f := range report.Patches[0].Files {
+				for _, h := range f.Hunks {
+					assert.Equal(t, Applied, h.Status)
+					assert.Equal(t, tt.loose, h.IgnoredWhitespace)
+					offsets = append(offsets, h.Offset)
+					fuzz = append(fuzz, h.Fuzz)
+				}
+			}
+			assert.Equal(t, tt.offsets, offsets)
+			if tt.fuzz == nil {
+				tt.fuzz = make([]int, len(offsets))
+			}
+			assert.Equal(t, tt.fuzz, fuzz)
+			assert.Equal(t,
+				readTree(t, filepath.Join(fixture, "after")),
+				readTree(t, dir),
+			)
+		})
+	}
+}
+
+func TestApply_conflict(t *testing.T) {
+	fixture := filepath.Join("testdata", "apply", "conflict")
+	before := readTree(t, filepath.Join(fixture, "before"))
+	patches := []*Patch{
+		{Name: "conflict", Path: filepath.Join(fixture, "patch.diff")},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		dir := copyTree(t, filepath.Join(fixture, "before"))
+
+		report, err := Apply(context.Background(), dir, patches,
+			&ApplyOptions{DryRun: dryRun},
+		)
+
+		assert.ErrorIs(t, err, ErrConflict)
+		require.NotNil(t, report)
+		assert.False(t, report.OK())
+		assert.Equal(t, 3, report.Conflicts())
+		assert.Equal(t, before, readTree(t, dir))
+
+		files := report.Patches[0].Files
+		require.Len(t, files, 2)
+		assert.Equal(t, "src/nsterm.m", files[0].Path)
+		assert.Equal(t, []*HunkReport{
+			{Hunk: 1, OldStart: 1, OldLines: 3, Status: AlreadyApplied},
+			{Hunk: 2, OldStart: 5, OldLines: 3, Status: Conflict},
+			{
+				Hunk: 3, OldStart: 6, OldLines: 2, Status: Applied,
+				Line: 6,
+			},
+		}, files[0].Hunks)
+		assert.Equal(t, "src/missing.m", files[1].Path)
+		assert.Equal(t, "file does not exist", files[1].Error)
+	}
+}
+
+func TestApply_fuzz(t *testing.T) {
+	fixture := filepath.Join("testdata", "apply", "fuzz")
+	dir := copyTree(t, filepath.Join(fixture, "before"))
+
+	report, err := Apply(context.Background(), dir, []*Patch{
+		{Name: "fuzz", Path: filepath.Join(fixture, "patch.diff")},
+	}, &ApplyOptions{DryRun: true})
+	require.NoError(t, err)
+
+	// Lines and offsets match what GNU patch reports for the same hunks.
+	assert.Equal(t, []*HunkReport{
+		{
+			Hunk: 1, OldStart: 2, OldLines: 7, Status: Applied,
+			Line: 5, Offset: 3, Fuzz: 1,
+		},
+		{
+			Hunk: 2, OldStart: 17, OldLines: 7, Status: Applied,
+			Line: 20, Offset: 3, Fuzz: 2,
+		},
+	}, report.Patches[0].Files[0].Hunks)
+
+	// Hunks are not applied with more than MaxFuzz lines of drifted context.
+	file := filepath.Join(dir, "src", "nsterm.m")
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+	b = []byte(strings.Replace(
+		string(b), "int v4 = 4;", "int v4 = 4; /* changed */", 1,
+	))
+	b = []byte(strings.Replace(
+		string(b), "int v3 = 3;", "int v3 = 3; /* changed */", 1,
+	))
+	require.NoError(t, os.WriteFile(file, b, 0o644))
+
+	report, err = Apply(context.Background(), dir, []*Patch{
+		{Name: "fuzz", Path: filepath.Join(fixture, "patch.diff")},
+	}, nil)
+	assert.ErrorIs(t, err, ErrConflict)
+	require.NotNil(t, report)
+	assert.Equal(t, Conflict, report.Patches[0].Files[0].Hunks[0].Status)
+	assert.Equal(t, Applied, report.Patches[0].Files[0].Hunks[1].Status)
+}
+
+func TestApply_series(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t,
+		os.WriteFile(filepath.Join(dir, "file"), []byte("a\nb\nc\n"), 0o644),
+	)
+	first := filepath.Join(dir, "first.patch")
+	require.NoError(t, os.WriteFile(first, []byte(
+		"--- a/file\n+++ b/file\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+	), 0o644))
+	second := filepath.Join(dir, "second.patch")
+	require.NoError(t, os.WriteFile(second, []byte(
+		"--- a/file\n+++ b/file\n@@ -1,3 +1,3 @@\n a\n-B\n+BB\n c\n",
+	), 0o644))
+
+	// Later patches apply on top of the result of earlier ones.
+	_, err := Apply(context.Background(), dir, []*Patch{
+		{Name: "first", Path: first},
+		{Name: "second", Path: second},
+	}, nil)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(dir, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "a\nBB\nc\n", string(b))
+}
+
+func TestApply_unpinned(t *testing.T) {
+	_, err := Apply(context.Background(), t.TempDir(), []*Patch{
+		{Name: "remote", URL: "http://127.0.0.1:0/remote.patch"},
+	}, nil)
+
+	assert.ErrorIs(t, err, ErrUnpinned)
+}
+
+func TestRead(t *testing.T) {
+	content := []byte("--- a/file\n+++ b/file\n@@ -1 +1 @@\n-a\n+b\n")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/raw/patches/emacs-29/fix.patch",
+		func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write(content)
+		},
+	)
+	mux.HandleFunc("/raw/patches/emacs-30/fix.patch",
+		func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("../emacs-29/fix.patch"))
+		},
+	)
+	mux.HandleFunc("/raw/patches/emacs-31/fix.patch",
+		func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("../emacs-30/fix.patch\n"))
+		},
+	)
+	mux.HandleFunc("/raw/patches/loop.patch",
+		func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("loop.patch"))
+		},
+	)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		patch   *Patch
+		wantSrc string
+		wantErr error
+	}{
+		{
+			name:    "direct",
+			patch:   &Patch{URL: srv.URL + "/raw/patches/emacs-29/fix.patch"},
+			wantSrc: srv.URL + "/raw/patches/emacs-29/fix.patch",
+		},
+		{
+			name: "symlink",
+			patch: &Patch{
+				URL:    srv.URL + "/raw/patches/emacs-30/fix.patch",
+				SHA256: digest,
+			},
+			wantSrc: srv.URL + "/raw/patches/emacs-29/fix.patch",
+		},
+		{
+			name:    "nested symlink",
+			patch:   &Patch{URL: srv.URL + "/raw/patches/emacs-31/fix.patch"},
+			wantSrc: srv.URL + "/raw/patches/emacs-29/fix.patch",
+		},
+		{
+			name:    "symlink loop",
+			patch:   &Patch{URL: srv.URL + "/raw/patches/loop.patch"},
+			wantErr: ErrDownload,
+		},
+		{
+			name:    "not found",
+			patch:   &Patch{URL: srv.URL + "/raw/patches/nope.patch"},
+			wantErr: ErrDownload,
+		},
+		{
+			name: "checksum mismatch",
+			patch: &Patch{
+				URL:    srv.URL + "/raw/patches/emacs-29/fix.patch",
+				SHA256: "0000" + digest[4:],
+			},
+			wantErr: ErrChecksum,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, src, err := Read(context.Background(), tt.patch, nil)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, content, got)
+			assert.Equal(t, tt.wantSrc, src)
+		})
+	}
+}
+
+func TestParseDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		diff    string
+		want    []*FileDiff
+		wantErr string
+	}{
+		{
+			name: "default hunk line counts",
+			diff: "--- a/f\n+++ b/f\n@@ -3 +3 @@\n-x\n+y\n",
+			want: []*FileDiff{
+				{
+					Path: "f",
+					Hunks: []*Hunk{
+						{
+							OldStart: 3, OldLines: 1,
+							NewStart: 3, NewLines: 1,
+							Lines: []string{"-x", "+y"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "stripped empty context line",
+			diff: "--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n a\n\n-x\n+y\n",
+			want: []*FileDiff{
+				{
+					Path: "f",
+					Hunks: []*Hunk{
+						{
+							OldStart: 1, OldLines: 3,
+							NewStart: 1, NewLines: 3,
+							Lines: []string{" a", " ", "-x", "+y"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "no diffs",
+			diff:    "Subject: nothing here\n",
+			wantErr: "no file diffs found",
+		},
+		{
+			name:    "truncated hunk",
+			diff:    "--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n a\n",
+			wantErr: "hunk is truncated",
+		},
+		{
+			name:    "unsafe path",
+			diff:    "--- a/../f\n+++ b/../f\n@@ -1 +1 @@\n-x\n+y\n",
+			wantErr: "unsafe path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDiff([]byte(tt.diff))
+
+			if tt.wantErr != "" {
+				assert.ErrorIs(t, err, ErrParse)
+				assert.ErrorContains(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrParse is returned when a diff cannot be parsed.
+var ErrParse = fmt.Errorf("%w: unable to parse diff", Err)
+
+const devNull = "/dev/null"
+
+var hunkHeader = regexp.MustCompile(
+	`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`,
+)
+
+// FileDiff is the set of changes to a single file within a unified diff.
+type FileDiff struct {
+	// Path is the path of the file relative to the source directory, with
+	// the first path component stripped like "patch -p1".
+	Path string
+
+	// Create and Delete indicate if the file is created or removed.
+	Create bool
+	Delete bool
+
+	Hunks []*Hunk
+}
+
+// Hunk is a single hunk within a file diff.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+
+	// Lines of the hunk, each prefixed by ' ', '-' or '+'.
+	Lines []string
+
+	// OldNoNewline and NewNoNewline indicate that the last line of the old
+	// or new side is not terminated by a newline.
+	OldNoNewline bool
+	NewNoNewline bool
+}
+
+// Old returns the context and removed lines of the hunk.
+func (s *Hunk) Old() []string {
+	return s.side('-')
+}
+
+// New returns the context and added lines of the hunk.
+func (s *Hunk) New() []string {
+	return s.side('+')
+}
+
+func (s *Hunk) side(op byte) []string {
+	lines := []string{}
+	for _, l := range s.Lines {
+		if l[0] == ' ' || l[0] == op {
+			lines = append(lines, l[1:])
+		}
+	}
+
+	return lines
+}
+
+// ParseDiff parses a unified diff, as produced by "diff -u", "git diff" or
+// "git format-patch". Any text outside of file diffs, like commit messages, is
+// ignored. Multiple diffs of the same file are returned in order.
+func ParseDiff(data []byte) ([]*FileDiff, error) {
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		lines = append(lines, strings.TrimSuffix(sc.Text(), "\r"))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrParse, err)
+	}
+
+	var files []*FileDiff
+	var file *FileDiff
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) &&
+			strings.HasPrefix(lines[i+1], "+++ "):
+			var err error
+			file, err = parseFileHeader(line[4:], lines[i+1][4:])
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, file)
+			i++
+		case strings.HasPrefix(line, "@@ ") && file != nil:
+			hunk, n, err := parseHunk(lines[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: line %d: %w",
+					ErrParse, file.Path, i+1, err,
+				)
+			}
+			file.Hunks = append(file.Hunks, hunk)
+			i += n - 1
+		case strings.HasPrefix(line, "diff "):
+			file = nil
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: no file diffs found", ErrParse)
+	}
+
+	return files, nil
+}
+
+func parseFileHeader(oldName, newName string) (*FileDiff, error) {
+	oldName = headerPath(oldName)
+	newName = headerPath(newName)
+
+	f := &FileDiff{
+		Create: oldName == devNull,
+		Delete: newName == devNull,
+	}
+
+	name := newName
+	if f.Delete {
+		name = oldName
+	}
+	if f.Create && f.Delete {
+		return nil, fmt.Errorf("%w: both sides are %s", ErrParse, devNull)
+	}
+
+	// Strip the first path component, like "patch -p1".
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("%w: unsafe path %q", ErrParse, name)
+	}
+	f.Path = filepath.FromSlash(name)
+
+	return f, nil
+}
+
+// headerPath returns the path from a "---" or "+++" header line, without any
+// trailing timestamp.
+func headerPath(s string) string {
+	if i := strings.Index(s, "\t"); i >= 0 {
+		s = s[:i]
+	}
+	if strings.HasPrefix(s, `"`) {
+		if u, err := strconv.Unquote(s); err == nil {
+			s = u
+		}
+	}
+
+	return strings.TrimSpace(s)
+}
+
+// parseHunk parses a hunk starting at the first line, and returns it along
+// with the number of lines consumed.
+func parseHunk(lines []string) (*Hunk, int, error) {
+	m := hunkHeader.FindStringSubmatch(lines[0])
+	if m == nil {
+		return nil, 0, fmt.Errorf("invalid hunk header %q", lines[0])
+	}
+
+	h := &Hunk{
+		OldStart: atoi(m[1], 0),
+		OldLines: atoi(m[2], 1),
+		NewStart: atoi(m[3], 0),
+		NewLines: atoi(m[4], 1),
+	}
+
+	oldN, newN := 0, 0
+	n := 1
+	for ; n < len(lines) && (oldN < h.OldLines || newN < h.NewLines); n++ {
+		line := lines[n]
+		if line == "" {
+			// Some tools strip trailing whitespace of empty context lines.
+			line = " "
+		}
+
+		switch line[0] {
+		case ' ':
+			oldN++
+			newN++
+		case '-':
+			oldN++
+		case '+':
+			newN++
+		case '\\':
+			h.markNoNewline()
+
+			continue
+		default:
+			return nil, 0, fmt.Errorf("unexpected line %q in hunk", line)
+		}
+		h.Lines = append(h.Lines, line)
+	}
+
+	if oldN != h.OldLines || newN != h.NewLines {
+		return nil, 0, fmt.Errorf("hunk is truncated")
+	}
+
+	// A "\ No newline at end of file" marker may follow the last line.
+	if n < len(lines) && strings.HasPrefix(lines[n], `\`) {
+		h.markNoNewline()
+		n++
+	}
+
+	return h, n, nil
+}
+
+func (s *Hunk) markNoNewline() {
+	if len(s.Lines) == 0 {
+		return
+	}
+
+	switch s.Lines[len(s.Lines)-1][0] {
+	case '-':
+		s.OldNoNewline = true
+	case '+':
+		s.NewNoNewline = true
+	default:
+		s.OldNoNewline = true
+		s.NewNoNewline = true
+	}
+}
+
+func atoi(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+
+	return n
+}
@@ -0,0 +1,248 @@
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//nolint:golint
+var (
+	Err         = errors.New("patch")
+	ErrManifest = fmt.Errorf("%w: invalid manifest", Err)
+)
+
+var (
+	sha256Matcher   = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	majorVersionRef = regexp.MustCompile(`^emacs-(\d+)`)
+)
+
+// DateFormat is the format of the after and before dates of manifest entries.
+// Full RFC 3339 timestamps are also accepted.
+const DateFormat = "2006-01-02"
+
+// Manifest is a declarative list of patches, and the conditions under which
+// they apply to a build.
+type Manifest struct {
+	// DefaultVersion is the major version used for refs which are not an
+	// emacs-* branch or tag, like master.
+	DefaultVersion int      `yaml:"default_version" json:"default_version"`
+	Patches        []*Entry `yaml:"patches" json:"patches"`
+
+	dir string
+}
+
+// Entry is a single patch in a manifest.
+type Entry struct {
+	// Name identifies the patch in logs and plans.
+	Name string `yaml:"name" json:"name"`
+
+	// URL to download the patch from. Mutually exclusive with Path.
+	URL string `yaml:"url,omitempty" json:"url,omitempty"`
+
+	// Path to patch file, relative to the directory of the manifest.
+	// Mutually exclusive with URL.
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+
+	// Versions is the list of major Emacs versions the patch applies to.
+	Versions []int `yaml:"versions" json:"versions"`
+
+	// After and Before limit the patch to source commits made on or after,
+	// and before the given dates respectively.
+	After  string `yaml:"after,omitempty" json:"after,omitempty"`
+	Before string `yaml:"before,omitempty" json:"before,omitempty"`
+
+	// SHA256 is the hex encoded SHA256 digest of the patch, as set by Pin.
+	// Required, so patches can not change without the manifest changing.
+	SHA256 string `yaml:"sha256" json:"sha256"`
+
+	// Flag makes the patch optional, only applying it when the flag of the
+	// given name is enabled.
+	Flag string `yaml:"flag,omitempty" json:"flag,omitempty"`
+
+	after  time.Time
+	before time.Time
+}
+
+// Patch is a patch resolved from a manifest for a specific build, as recorded
+// in build plans.
+type Patch struct {
+	Name   string `yaml:"name" json:"name"`
+	URL    string `yaml:"url,omitempty" json:"url,omitempty"`
+	Path   string `yaml:"path,omitempty" json:"path,omitempty"`
+	SHA256 string `yaml:"sha256,omitempty" json:"sha256,omitempty"`
+}
+
+// Target describes the build patches are resolved for.
+type Target struct {
+	// Ref is the git branch or tag being built.
+	Ref string
+
+	// Date is the date of the source commit being built.
+	Date *time.Time
+
+	// Flags is the list of enabled optional patch flags.
+	Flags []string
+}
+
+// Load reads and validates a manifest in YAML or JSON format.
+func Load(filename string) (*Manifest, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	err = yaml.Unmarshal(b, m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrManifest, filename, err)
+	}
+
+	m.dir, err = filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, filename)
+	}
+
+	return m, nil
+}
+
+// Validate checks that all manifest entries are complete and well formed.
+func (s *Manifest) Validate() error {
+	for i, e := range s.Patches {
+		name := e.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i)
+		}
+		err := e.validate()
+		if err != nil {
+			return fmt.Errorf("%w: patch %s: %w", ErrManifest, name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Entry) validate() error {
+	var err error
+	switch {
+	case s.Name == "":
+		return errors.New("name is required")
+	case s.URL == "" && s.Path == "":
+		return errors.New("url or path is required")
+	case s.URL != "" && s.Path != "":
+		return errors.New("url and path are mutually exclusive")
+	case s.Path != "" && !filepath.IsLocal(s.Path):
+		return fmt.Errorf("path %q is outside of manifest directory", s.Path)
+	case len(s.Versions) == 0:
+		return errors.New("versions is required")
+	case s.SHA256 == "":
+		return errors.New(
+			"sha256 is required, set it with: emacs-builder patches pin",
+		)
+	case !sha256Matcher.MatchString(s.SHA256):
+		return fmt.Errorf("sha256 %q is not a hex encoded SHA256", s.SHA256)
+	}
+
+	s.after, err = parseDate(s.After)
+	if err != nil {
+		return fmt.Errorf("after: %w", err)
+	}
+	s.before, err = parseDate(s.Before)
+	if err != nil {
+		return fmt.Errorf("before: %w", err)
+	}
+
+	return nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(DateFormat, s)
+	if err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// MajorVersion returns the major Emacs version of given git ref, or
+// DefaultVersion if the ref is not an emacs-* branch or tag.
+func (s *Manifest) MajorVersion(ref string) int {
+	if m := majorVersionRef.FindStringSubmatch(ref); len(m) > 1 {
+		if n, err := strconv.Atoi(m[1]); err == nil {
+			return n
+		}
+	}
+
+	return s.DefaultVersion
+}
+
+// Resolve returns the patches which apply to given target, in manifest order.
+func (s *Manifest) Resolve(t *Target) []*Patch {
+	version := s.MajorVersion(t.Ref)
+	flags := map[string]bool{}
+	for _, f := range t.Flags {
+		flags[f] = true
+	}
+
+	patches := []*Patch{}
+	for _, e := range s.Patches {
+		if !e.appliesTo(version, t.Date, flags) {
+			continue
+		}
+
+		p := &Patch{Name: e.Name, URL: e.URL, SHA256: e.SHA256}
+		if e.Path != "" {
+			p.Path = filepath.Join(s.dir, e.Path)
+		}
+		patches = append(patches, p)
+	}
+
+	return patches
+}
+
+func (s *Entry) appliesTo(
+	version int,
+	date *time.Time,
+	flags map[string]bool,
+) bool {
+	if s.Flag != "" && !flags[s.Flag] {
+		return false
+	}
+
+	found := false
+	for _, v := range s.Versions {
+		if v == version {
+			found = true
+
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	// Patches limited to a date window never apply to unknown dates.
+	if !s.after.IsZero() && (date == nil || date.Before(s.after)) {
+		return false
+	}
+	if !s.before.IsZero() && (date == nil || !date.Before(s.before)) {
+		return false
+	}
+
+	return true
+}
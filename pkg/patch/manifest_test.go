@@ -0,0 +1,299 @@
+package patch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jimeh/undent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sumA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	sumB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+func writeManifest(t *testing.T, content string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "patches", "manifest.yml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0o755))
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+
+	return filename
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "valid YAML",
+			content: undent.String(`
+				default_version: 31
+				patches:
+				  - name: foo
+				    url: https://example.com/foo.patch
+				    versions: [30, 31]
+				    before: 2025-07-31
+				    sha256: ` + sumA + `
+				  - name: bar
+				    path: emacs-29/bar.patch
+				    versions: [29]
+				    after: "2024-01-01T10:00:00Z"
+				    sha256: ` + sumB + `
+				    flag: bar`,
+			),
+		},
+		{
+			name: "valid JSON",
+			content: `{"default_version": 31, "patches": [{"name": "foo", ` +
+				`"url": "https://example.com/foo.patch", "versions": [31], ` +
+				`"sha256": "` + sumA + `"}]}`,
+		},
+		{
+			name: "missing name",
+			content: undent.String(`
+				patches:
+				  - url: https://example.com/foo.patch
+				    versions: [31]
+				    sha256: ` + sumA,
+			),
+			wantErr: "patch #0: name is required",
+		},
+		{
+			name: "missing source",
+			content: undent.String(`
+				patches:
+				  - name: foo
+				    versions: [31]
+				    sha256: ` + sumA,
+			),
+			wantErr: "patch foo: url or path is required",
+		},
+		{
+			name: "url and path",
+			content: undent.String(`
+				patches:
+				  - name: foo
+				    url: https://example.com/foo.patch
+				    path: foo.patch
+				    versions: [31]
+				    sha256: ` + sumA,
+			),
+			wantErr: "patch foo: url and path are mutually exclusive",
+		},
+		{
+			name: "path outside manifest directory",
+			content: undent.String(`
+				patches:
+				  - name: foo
+				    path: ../foo.patch
+				    versions: [31]
+				    sha256: ` + sumA,
+			),
+			wantErr: "patch foo: path \"../foo.patch\" is outside",
+		},
+		{
+			name: "missing versions",
+			content: undent.String(`
+				patches:
+				  - name: foo
+				    url: https://example.com/foo.patch
+				    sha256: ` + sumA,
+			),
+			wantErr: "patch foo: versions is required",
+		},
+		{
+			name: "missing sha256",
+			content: undent.String(`
+				patches:
+				  - name: foo
+				    url: https://example.com/foo.patch
+				    versions: [31]`,
+			),
+			wantErr: "patch foo: sha256 is required, set it with: " +
+				"emacs-builder patches pin",
+		},
+		{
+			name: "invalid sha256",
+			content: undent.String(`
+				patches:
+				  - name: foo
+				    url: https://example.com/foo.patch
+				    versions: [31]
+				    sha256: abc`,
+			),
+			wantErr: "patch foo: sha256 \"abc\" is not a hex encoded SHA256",
+		},
+		{
+			name: "invalid date",
+			content: undent.String(`
+				patches:
+				  - name: foo
+				    url: https://example.com/foo.patch
+				    versions: [31]
+				    after: July 2025
+				    sha256: ` + sumA,
+			),
+			wantErr: "patch foo: after: parsing time",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeManifest(t, tt.content))
+
+			if tt.wantErr != "" {
+				assert.ErrorIs(t, err, ErrManifest)
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestManifest_Resolve(t *testing.T) {
+	filename := writeManifest(t, undent.String(`
+		default_version: 31
+		patches:
+		  - name: fix-window-role
+		    url: https://example.com/emacs-30/fix-window-role.patch
+		    versions: [29, 30]
+		    sha256: `+sumA+`
+		  - name: fix-window-role
+		    url: https://example.com/old/emacs-31/fix-window-role.patch
+		    versions: [31]
+		    before: 2025-07-31
+		    sha256: `+sumA+`
+		  - name: alpha-background
+		    path: emacs-29/alpha-background.patch
+		    versions: [29]
+		    sha256: `+sumB+`
+		    flag: alpha-background`,
+	))
+	m, err := Load(filename)
+	require.NoError(t, err)
+
+	date := func(s string) *time.Time {
+		d, err := time.Parse(DateFormat, s)
+		require.NoError(t, err)
+
+		return &d
+	}
+	dir := filepath.Dir(filename)
+
+	tests := []struct {
+		name   string
+		target *Target
+		want   []string
+	}{
+		{
+			name:   "stable release",
+			target: &Target{Ref: "emacs-30.1", Date: date("2025-02-23")},
+			want: []string{
+				"https://example.com/emacs-30/fix-window-role.patch",
+			},
+		},
+		{
+			name:   "master before date cutoff",
+			target: &Target{Ref: "master", Date: date("2025-07-30")},
+			want: []string{
+				"https://example.com/old/emacs-31/fix-window-role.patch",
+			},
+		},
+		{
+			name:   "master on date cutoff",
+			target: &Target{Ref: "master", Date: date("2025-07-31")},
+			want:   []string{},
+		},
+		{
+			name:   "unknown date",
+			target: &Target{Ref: "master"},
+			want:   []string{},
+		},
+		{
+			name: "optional flag enabled",
+			target: &Target{
+				Ref:   "emacs-29",
+				Date:  date("2024-01-01"),
+				Flags: []string{"alpha-background"},
+			},
+			want: []string{
+				"https://example.com/emacs-30/fix-window-role.patch",
+				filepath.Join(dir, "emacs-29/alpha-background.patch"),
+			},
+		},
+		{
+			name:   "optional flag disabled",
+			target: &Target{Ref: "emacs-29", Date: date("2024-01-01")},
+			want: []string{
+				"https://example.com/emacs-30/fix-window-role.patch",
+			},
+		},
+		{
+			name:   "unsupported version",
+			target: &Target{Ref: "emacs-26.3", Date: date("2019-08-28")},
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, p := range m.Resolve(tt.target) {
+				got = append(got, p.URL+p.Path)
+			}
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestManifest_MajorVersion(t *testing.T) {
+	m := &Manifest{DefaultVersion: 31}
+
+	tests := map[string]int{
+		"master":          31,
+		"feature/foo":     31,
+		"emacs-29":        29,
+		"emacs-29.4":      29,
+		"emacs-30.1-rc1":  30,
+		"emacs-30.0.93":   30,
+		"emacs-pretest-x": 31,
+	}
+	for ref, want := range tests {
+		t.Run(ref, func(t *testing.T) {
+			assert.Equal(t, want, m.MajorVersion(ref))
+		})
+	}
+}
+
+// TestRepositoryManifest ensures the manifest shipped in the repository is
+// valid, and that all local patches have correct digests and can be parsed.
+func TestRepositoryManifest(t *testing.T) {
+	m, err := Load("../../patches/manifest.yml")
+	require.NoError(t, err)
+
+	for _, e := range m.Patches {
+		if e.Path == "" {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(m.dir, e.Path))
+		require.NoError(t, err)
+		sum := sha256.Sum256(b)
+		assert.Equal(t, e.SHA256, hex.EncodeToString(sum[:]),
+			"digest of %s", e.Path,
+		)
+
+		_, err = ParseDiff(b)
+		assert.NoError(t, err, "parsing %s", e.Path)
+	}
+}
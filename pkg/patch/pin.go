@@ -0,0 +1,178 @@
+package patch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"gopkg.in/yaml.v3"
+)
+
+// Pin sets the SHA256 digest of every patch in given manifest file to the
+// digest of its current content, downloading patches as needed. The manifest
+// is edited in place, keeping its comments. The names of patches whose digest
+// was added or changed are returned.
+func Pin(
+	ctx context.Context,
+	filename string,
+	client *http.Client,
+) ([]string, error) {
+	logger := hclog.FromContext(ctx).Named("patch")
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &yaml.Node{}
+	err = yaml.Unmarshal(b, doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrManifest, filename, err)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := patchNodes(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, filename)
+	}
+
+	changed := []string{}
+	for _, n := range nodes {
+		e := &Entry{}
+		err = n.Decode(e)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrManifest, filename, err)
+		}
+		if e.Path != "" && !filepath.IsLocal(e.Path) {
+			return nil, fmt.Errorf(
+				"%w: patch %s: path %q is outside of manifest directory",
+				ErrManifest, e.Name, e.Path,
+			)
+		}
+
+		p := &Patch{Name: e.Name, URL: e.URL}
+		if e.Path != "" {
+			p.Path = filepath.Join(dir, e.Path)
+		}
+		data, src, err := Read(ctx, p, client)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(data)
+		digest := hex.EncodeToString(sum[:])
+		if digest == e.SHA256 {
+			continue
+		}
+
+		logger.Info("pinning patch", "name", e.Name, "source", src,
+			"sha256", digest,
+		)
+		setValue(n, "sha256", digest)
+		changed = append(changed, e.Name)
+	}
+
+	if len(changed) == 0 {
+		return changed, nil
+	}
+
+	b, err = encodeManifest(doc, filepath.Ext(filename) == ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(filename, b, 0o644) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+
+	return changed, nil
+}
+
+// encodeManifest encodes a manifest document as YAML, or as JSON if asked.
+// Comments are only kept in YAML.
+func encodeManifest(doc *yaml.Node, asJSON bool) ([]byte, error) {
+	var buf bytes.Buffer
+	if asJSON {
+		m := &Manifest{}
+		err := doc.Decode(m)
+		if err != nil {
+			return nil, err
+		}
+
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(m)
+
+		return buf.Bytes(), err
+	}
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(doc)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.Close()
+
+	return buf.Bytes(), err
+}
+
+// patchNodes returns the mapping nodes of all patches in a manifest document.
+func patchNodes(doc *yaml.Node) ([]*yaml.Node, error) {
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%w: not a mapping", ErrManifest)
+	}
+
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "patches" {
+			continue
+		}
+
+		list := root.Content[i+1]
+		if list.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("%w: patches is not a list", ErrManifest)
+		}
+		for _, n := range list.Content {
+			if n.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf(
+					"%w: patches must be mappings", ErrManifest,
+				)
+			}
+		}
+
+		return list.Content, nil
+	}
+
+	return nil, nil
+}
+
+// setValue sets the string value of given key in a mapping node, adding the
+// key if it does not exist.
+func setValue(mapping *yaml.Node, key, value string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			v := mapping.Content[i+1]
+			v.Kind, v.Tag, v.Value, v.Style = yaml.ScalarNode, "!!str", value, 0
+
+			return
+		}
+	}
+
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value},
+	)
+}
@@ -0,0 +1,101 @@
+package patch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jimeh/undent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPin(t *testing.T) {
+	remote := []byte("--- a/file\n+++ b/file\n@@ -1 +1 @@\n-a\n+b\n")
+	local := []byte("--- a/file\n+++ b/file\n@@ -1 +1 @@\n-b\n+c\n")
+	digest := func(b []byte) string {
+		sum := sha256.Sum256(b)
+
+		return hex.EncodeToString(sum[:])
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write(remote)
+		},
+	))
+	defer srv.Close()
+
+	filename := writeManifest(t, undent.String(`
+		# Patches.
+		default_version: 31
+		patches:
+		  # Remote patch.
+		  - name: foo
+		    url: `+srv.URL+`/foo.patch
+		    versions: [31]
+		  - name: bar
+		    path: emacs-31/bar.patch
+		    versions: [31]
+		    sha256: `+sumA+`
+		  - name: baz
+		    path: emacs-31/bar.patch
+		    versions: [30]
+		    sha256: `+digest(local)+`
+		    flag: baz`,
+	))
+	path := filepath.Join(filepath.Dir(filename), "emacs-31", "bar.patch")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, local, 0o644))
+
+	changed, err := Pin(context.Background(), filename, srv.Client())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, changed)
+
+	b, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, undent.String(`
+		# Patches.
+		default_version: 31
+		patches:
+		  # Remote patch.
+		  - name: foo
+		    url: `+srv.URL+`/foo.patch
+		    versions: [31]
+		    sha256: `+digest(remote)+`
+		  - name: bar
+		    path: emacs-31/bar.patch
+		    versions: [31]
+		    sha256: `+digest(local)+`
+		  - name: baz
+		    path: emacs-31/bar.patch
+		    versions: [30]
+		    sha256: `+digest(local)+`
+		    flag: baz`,
+	)+"\n", string(b))
+
+	m, err := Load(filename)
+	require.NoError(t, err)
+	assert.Equal(t, digest(remote), m.Patches[0].SHA256)
+
+	changed, err = Pin(context.Background(), filename, srv.Client())
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+}
+
+func TestPin_outsidePath(t *testing.T) {
+	filename := writeManifest(t, undent.String(`
+		patches:
+		  - name: foo
+		    path: ../foo.patch
+		    versions: [31]`,
+	))
+
+	_, err := Pin(context.Background(), filename, nil)
+	assert.ErrorIs(t, err, ErrManifest)
+}
@@ -0,0 +1,127 @@
+package patch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+//nolint:golint
+var (
+	ErrChecksum = fmt.Errorf("%w: SHA256 digest mismatch", Err)
+	ErrDownload = fmt.Errorf("%w: download failed", Err)
+)
+
+// maxSymlinkRedirects limits how many GitHub symlink redirects are followed.
+const maxSymlinkRedirects = 5
+
+// Read returns the content of a patch from its local path or URL, along with
+// where it was read from. If the patch has a SHA256 digest, the content is
+// verified against it.
+//
+// When GitHub serves a raw file which is a symlink, the response body is the
+// relative path the symlink points to rather than the patch itself. Such
+// single line responses are resolved relative to the URL and followed, and
+// the digest is checked against the final patch content.
+func Read(
+	ctx context.Context,
+	p *Patch,
+	client *http.Client,
+) ([]byte, string, error) {
+	var data []byte
+	var src string
+	var err error
+
+	switch {
+	case p.Path != "":
+		src = p.Path
+		data, err = os.ReadFile(p.Path)
+	case p.URL != "":
+		data, src, err = download(ctx, client, p.URL)
+	default:
+		err = fmt.Errorf("%w: patch %s has no url or path", Err, p.Name)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if p.SHA256 != "" {
+		sum := sha256.Sum256(data)
+		if digest := hex.EncodeToString(sum[:]); digest != p.SHA256 {
+			return nil, "", fmt.Errorf(
+				"%w: patch %s from %s is %s, expected %s",
+				ErrChecksum, p.Name, src, digest, p.SHA256,
+			)
+		}
+	}
+
+	return data, src, nil
+}
+
+func download(
+	ctx context.Context,
+	client *http.Client,
+	rawURL string,
+) ([]byte, string, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for i := 0; i <= maxSymlinkRedirects; i++ {
+		req, err := http.NewRequestWithContext(
+			ctx, http.MethodGet, rawURL, nil,
+		)
+		if err != nil {
+			return nil, "", err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %w", ErrDownload, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %w", ErrDownload, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, "", fmt.Errorf(
+				"%w: GET %s: %s", ErrDownload, rawURL, resp.Status,
+			)
+		}
+
+		target, ok := symlinkTarget(data)
+		if !ok {
+			return data, rawURL, nil
+		}
+
+		ref, err := url.Parse(target)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %w", ErrDownload, err)
+		}
+		base, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %w", ErrDownload, err)
+		}
+		rawURL = base.ResolveReference(ref).String()
+	}
+
+	return nil, "", fmt.Errorf("%w: too many symlink redirects", ErrDownload)
+}
+
+// symlinkTarget returns the symlink target if data is a single line, which is
+// what GitHub returns for raw files which are symlinks.
+func symlinkTarget(data []byte) (string, bool) {
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 || bytes.IndexByte(data, '\n') >= 0 {
+		return "", false
+	}
+
+	return string(bytes.TrimSpace(data)), true
+}
@@ -0,0 +1,7 @@
+int a = 1;
+int b = 20;
+int c = 3;
+int d = 4;
+int e = 5;
+int f = 6;
+int g = 7;
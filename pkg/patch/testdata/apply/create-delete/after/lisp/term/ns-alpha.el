@@ -0,0 +1,2 @@
+;;; ns-alpha.el
+(provide (quote ns-alpha))
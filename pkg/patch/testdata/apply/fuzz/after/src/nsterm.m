@@ -0,0 +1,34 @@
+/* header */
+/* header */
+/* header */
+int v1 = 1;
+int v2 = 2; /* changed */
+int v3 = 3;
+int v4 = 4;
+int v5 = 50;
+int v6 = 6;
+int v7 = 7;
+int v8 = 8;
+int v9 = 9;
+int v10 = 10;
+int v11 = 11;
+int v12 = 12;
+int v13 = 13;
+int v14 = 14;
+int v15 = 15;
+int v16 = 16;
+int v17 = 17;
+int v18 = 18;
+int v19 = 19;
+int v20 = 200;
+int v20b = 201;
+int v21 = 21;
+int v22 = 22; /* changed */
+int v23 = 23; /* changed */
+int v24 = 24;
+int v25 = 25;
+int v26 = 26;
+int v27 = 27;
+int v28 = 28;
+int v29 = 29;
+int v30 = 30;
@@ -0,0 +1,15 @@
+// Extra header line
+// Another one
+int a = 1;
+int b = 20;
+int c = 3;
+int d = 4;
+int e = 50;
+int f = 6;
+int g = 7;
+int h = 8;
+int i = 9;
+int j = 10;
+int k = 110;
+int kk = 111;
+int l = 12;
@@ -0,0 +1,14 @@
+// Extra header line
+// Another one
+int a = 1;
+int b = 2;
+int c = 3;
+int d = 4;
+int e = 5;
+int f = 6;
+int g = 7;
+int h = 8;
+int i = 9;
+int j = 10;
+int k = 11;
+int l = 12;
@@ -0,0 +1,3 @@
+one
+two
+three
\ No newline at end of file
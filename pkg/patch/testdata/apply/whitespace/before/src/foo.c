@@ -0,0 +1,6 @@
+static void
+foo (void)
+{
+	int x = 1;
+	return;
+}
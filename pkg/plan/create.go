@@ -2,15 +2,12 @@ package plan
 
 import (
 	"context"
-	"fmt"
 	"io"
-	"regexp"
-	"strconv"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/hashicorp/go-hclog"
-	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
-	"github.com/jimeh/build-emacs-for-macos/pkg/gh"
 	"github.com/jimeh/build-emacs-for-macos/pkg/osinfo"
 	"github.com/jimeh/build-emacs-for-macos/pkg/release"
 	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
@@ -18,10 +15,6 @@ import (
 	"github.com/jimeh/build-emacs-for-macos/pkg/source"
 )
 
-var gitTagMatcher = regexp.MustCompile(
-	`^emacs(-.*)?-((\d+\.\d+)(?:\.(\d+))?(-rc\d+)?(.+)?)$`,
-)
-
 type TestBuildType string
 
 //nolint:golint
@@ -32,108 +25,128 @@ const (
 
 type Options struct {
 	GithubToken   string
+	GitlabToken   string
 	EmacsRepo     string
+	SourceDir     string
+	TarballDir    string
 	Ref           string
 	SHAOverride   string
 	BuildVariant  int
 	OutputDir     string
 	TestBuild     string
 	TestBuildType TestBuildType
-	Output        io.Writer
+	Profile       string
+	BuildOptions  *BuildOptions
+
+	// OSInfo provides information about the OS the build targets, defaults
+	// to osinfo.Host.
+	OSInfo osinfo.Provider
+
+	// Target overrides parts of the OS information from OSInfo.
+	Target *osinfo.Target
+
+	Output io.Writer
 }
 
 func Create(ctx context.Context, opts *Options) (*Plan, error) { //nolint:funlen
 	logger := hclog.FromContext(ctx).Named("plan")
 
-	repo, err := repository.NewGitHub(opts.EmacsRepo)
-	if err != nil {
-		return nil, err
+	if opts.Target != nil {
+		err := opts.Target.Validate()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	gh := gh.New(ctx, opts.GithubToken)
-
-	lookupRef := opts.Ref
-	if opts.SHAOverride != "" {
-		lookupRef = opts.SHAOverride
+	if opts.BuildOptions != nil {
+		err := opts.BuildOptions.Validate()
+		if err != nil {
+			return nil, err
+		}
 	}
-	logger.Info("fetching commit info", "ref", lookupRef)
 
-	repoCommit, _, err := gh.Repositories.GetCommit(
-		ctx, repo.Owner(), repo.Name(), lookupRef,
-	)
+	repo, err := repository.Parse(opts.EmacsRepo)
 	if err != nil {
 		return nil, err
 	}
 
-	commitInfo := commit.New(repoCommit)
-	osInfo, err := osinfo.New()
+	ref := opts.Ref
+	providerRepo := repo
+	if opts.SourceDir != "" {
+		providerRepo, err = repository.NewLocal(opts.SourceDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ref == "" && providerRepo.Type == repository.Local {
+		ref, err = repository.LocalRef(ctx, providerRepo.Source)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	provider, err := repository.NewProvider(
+		ctx, providerRepo, &repository.ProviderOptions{
+			GitHubToken: opts.GithubToken,
+			GitLabToken: opts.GitlabToken,
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	absoluteVersion := fmt.Sprintf(
-		"%s.%s.%s",
-		commitInfo.DateString(),
-		commitInfo.ShortSHA(),
-		sanitize.String(opts.Ref),
+	lookupRef := ref
+	if opts.SHAOverride != "" {
+		lookupRef = opts.SHAOverride
+	}
+	logger.Info("fetching commit info",
+		"repo", providerRepo.Source, "type", providerRepo.Type,
+		"ref", lookupRef,
 	)
 
-	version, channel, err := parseGitRef(opts.Ref)
+	commitInfo, err := provider.Commit(ctx, lookupRef)
 	if err != nil {
 		return nil, err
 	}
 
-	var releaseName string
-	switch channel {
-	case release.Stable, release.RC:
-		releaseName = "Emacs-" + version
-	case release.Pretest:
-		version += "-pretest"
-		absoluteVersion += "-pretest"
-		releaseName = "Emacs-" + version
-	default:
-		version = absoluteVersion
-		releaseName = "Emacs." + version
+	osInfoProvider := opts.OSInfo
+	if osInfoProvider == nil {
+		osInfoProvider = osinfo.Host
 	}
-
-	if opts.BuildVariant != 0 {
-		variant := strconv.Itoa(opts.BuildVariant)
-		absoluteVersion += "-" + variant
-		releaseName += "-" + variant
+	osInfo, err := osinfo.WithTarget(osInfoProvider, opts.Target).OSInfo(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	// Attempt to get the macOS SDK version from the environment, if it's not
-	// available, use the version from the system.
-	targetMacOSVersion := osInfo.DistinctSDKVersion()
-	if targetMacOSVersion == "" {
-		targetMacOSVersion = osInfo.DistinctVersion()
+	version, err := release.ParseGitRef(ref)
+	if err != nil {
+		return nil, err
 	}
+	version.Variant = opts.BuildVariant
+	version.Date = *commitInfo.Date
+	version.SHA = commitInfo.ShortSHA()
+	version.Ref = sanitize.String(ref)
 
-	buildName := fmt.Sprintf(
-		"Emacs.%s.%s.%s",
-		absoluteVersion,
-		sanitize.String(osInfo.Name+"-"+targetMacOSVersion),
-		sanitize.String(osInfo.Arch),
-	)
+	buildName := "Emacs." + version.Absolute() + "." + targetName(osInfo)
 	diskImage := buildName + ".dmg"
 
 	plan := &Plan{
+		SchemaVersion: SchemaVersion,
 		Build: &Build{
-			Name: buildName,
+			Name:    buildName,
+			Profile: opts.Profile,
+			Options: opts.BuildOptions,
 		},
 		Source: &source.Source{
-			Ref:        opts.Ref,
+			Ref:        ref,
 			Repository: repo,
 			Commit:     commitInfo,
-			Tarball: &source.Tarball{
-				URL: repo.TarballURL(commitInfo.SHA),
-			},
 		},
 		OS: osInfo,
 		Release: &Release{
-			Name:       releaseName,
-			Prerelease: channel != release.Stable,
-			Channel:    channel,
+			Name:       version.ReleaseName(),
+			Prerelease: version.Prerelease(),
+			Channel:    version.Channel,
 		},
 		Output: &Output{
 			Directory: opts.OutputDir,
@@ -141,6 +154,20 @@ func Create(ctx context.Context, opts *Options) (*Plan, error) { //nolint:funlen
 		},
 	}
 
+	// Local repositories have no tarball URL, so the build script is given
+	// an archive of the commit instead.
+	if providerRepo.Type == repository.Local {
+		archive, err := createArchive(
+			ctx, providerRepo, plan.Source, opts.TarballDir,
+		)
+		if err != nil {
+			return nil, err
+		}
+		plan.Source.Tarball = &source.Tarball{Path: archive}
+	} else if u := repo.TarballURL(commitInfo.SHA); u != "" {
+		plan.Source.Tarball = &source.Tarball{URL: u}
+	}
+
 	if opts.TestBuild != "" {
 		testName := sanitize.String(opts.TestBuild)
 
@@ -163,35 +190,50 @@ func Create(ctx context.Context, opts *Options) (*Plan, error) { //nolint:funlen
 	return plan, nil
 }
 
-func parseGitRef(ref string) (string, release.Channel, error) {
-	m := gitTagMatcher.FindStringSubmatch(ref)
-
-	if len(m) == 0 {
-		return "", release.Nightly, nil
+// targetName returns the part of build names which identifies the OS version
+// and architecture a build targets.
+func targetName(osInfo *osinfo.OSInfo) string {
+	// Attempt to get the macOS SDK version from the environment, if it's not
+	// available, use the version from the system.
+	targetMacOSVersion := osInfo.DistinctSDKVersion()
+	if targetMacOSVersion == "" {
+		targetMacOSVersion = osInfo.DistinctVersion()
 	}
 
-	if strings.Contains(m[1], "pretest") {
-		return m[2], release.Pretest, nil
-	}
+	return sanitize.String(osInfo.Name+"-"+targetMacOSVersion) + "." +
+		sanitize.String(osInfo.Arch)
+}
 
-	if m[4] != "" {
-		n, err := strconv.Atoi(m[4])
-		if err != nil {
-			return "", "", err
-		}
+// createArchive creates a tarball of given commit from a local git repository,
+// named and laid out like the tarballs GitHub produces, so the build script
+// can use it in place of downloading one.
+func createArchive(
+	ctx context.Context,
+	local *repository.Repository,
+	src *source.Source,
+	dir string,
+) (string, error) {
+	logger := hclog.FromContext(ctx).Named("plan")
 
-		if n >= 90 {
-			return m[2], release.Pretest, nil
-		}
+	prefix := src.ArchiveName()
+	filename, err := filepath.Abs(filepath.Join(dir, prefix+".tgz"))
+	if err != nil {
+		return "", err
 	}
 
-	if strings.HasPrefix(m[5], "-rc") {
-		return m[2], release.RC, nil
+	if _, err := os.Stat(filename); err == nil {
+		logger.Info("using existing source archive", "file", filename)
+
+		return filename, nil
 	}
 
-	if m[2] == m[3] {
-		return m[2], release.Stable, nil
+	logger.Info("creating source archive", "file", filename)
+	err = repository.Archive(
+		ctx, local.Source, src.Commit.SHA, prefix, filename,
+	)
+	if err != nil {
+		return "", err
 	}
 
-	return "", "", nil
+	return filename, nil
 }
@@ -1,421 +1,270 @@
 package plan
 
 import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
-	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+	"github.com/jimeh/build-emacs-for-macos/pkg/osinfo"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func Test_parseGitRef(t *testing.T) {
-	t.Parallel()
+// newSourceRepo creates a local git repository with a single commit, tagged
+// with given tags.
+func newSourceRepo(t *testing.T, tags ...string) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
 
-	type args struct {
-		ref string
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Jane Doe",
+			"GIT_AUTHOR_EMAIL=jane@example.com",
+			"GIT_AUTHOR_DATE=2021-05-01T10:20:30Z",
+			"GIT_COMMITTER_NAME=John Doe",
+			"GIT_COMMITTER_EMAIL=john@example.com",
+			"GIT_COMMITTER_DATE=2021-05-02T11:22:33Z",
+		)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
 	}
-	type want struct {
-		version string
-		channel release.Channel
-		err     string
+	run("init", "--quiet", "--initial-branch=master")
+	require.NoError(t,
+		os.WriteFile(filepath.Join(dir, "README"), []byte("hi\n"), 0o644),
+	)
+	run("add", "README")
+	run("commit", "--quiet", "-m", "Initial commit")
+	for _, tag := range tags {
+		run("tag", tag)
 	}
+
+	return dir
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+
+	dir := newSourceRepo(t, "emacs-30.1", "emacs-30.0.90", "emacs-30.1-rc1")
+	host := osinfo.Static(&osinfo.OSInfo{
+		Name:       "macOS",
+		Version:    "14.5",
+		SDKVersion: "14.4",
+		Arch:       "arm64",
+	})
+
 	tests := []struct {
-		name string
-		args args
-		want want
+		name        string
+		opts        *Options
+		wantBuild   string
+		wantRelease string
+		wantDMG     string
+		wantErr     error
 	}{
 		{
-			name: "master",
-			args: args{ref: "master"},
-			want: want{version: "", channel: release.Nightly, err: ""},
-		},
-		{
-			name: "emacs-28",
-			args: args{ref: "emacs-28"},
-			want: want{version: "", channel: release.Nightly, err: ""},
-		},
-		{
-			name: "emacs-27",
-			args: args{ref: "emacs-27"},
-			want: want{version: "", channel: release.Nightly, err: ""},
-		},
-		{
-			name: "emacs-26",
-			args: args{ref: "emacs-26"},
-			want: want{version: "", channel: release.Nightly, err: ""},
-		},
-		{
-			name: "emacs-24",
-			args: args{ref: "emacs-24"},
-			want: want{version: "", channel: release.Nightly, err: ""},
-		},
-		{
-			name: "feature/native-comp",
-			args: args{ref: "feature/native-comp"},
-			want: want{version: "", channel: release.Nightly, err: ""},
-		},
-		{
-			name: "feature/pgtk",
-			args: args{ref: "feature/pgtk"},
-			want: want{version: "", channel: release.Nightly, err: ""},
-		},
-		{
-			name: "emacs-19.34",
-			args: args{ref: "emacs-19.34"},
-			want: want{version: "19.34", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-20.4",
-			args: args{ref: "emacs-20.4"},
-			want: want{version: "20.4", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-22.3",
-			args: args{ref: "emacs-22.3"},
-			want: want{version: "22.3", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-23.4",
-			args: args{ref: "emacs-23.4"},
-			want: want{version: "23.4", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-24.0.97",
-			args: args{ref: "emacs-24.0.97"},
-			want: want{version: "24.0.97", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-24.2",
-			args: args{ref: "emacs-24.2"},
-			want: want{version: "24.2", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-24.2.90",
-			args: args{ref: "emacs-24.2.90"},
-			want: want{version: "24.2.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-24.2.93",
-			args: args{ref: "emacs-24.2.93"},
-			want: want{version: "24.2.93", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-24.3",
-			args: args{ref: "emacs-24.3"},
-			want: want{version: "24.3", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-24.3-rc1",
-			args: args{ref: "emacs-24.3-rc1"},
-			want: want{version: "24.3-rc1", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-24.3.90",
-			args: args{ref: "emacs-24.3.90"},
-			want: want{version: "24.3.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-24.3.94",
-			args: args{ref: "emacs-24.3.94"},
-			want: want{version: "24.3.94", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-24.4",
-			args: args{ref: "emacs-24.4"},
-			want: want{version: "24.4", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-24.4-rc1",
-			args: args{ref: "emacs-24.4-rc1"},
-			want: want{version: "24.4-rc1", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-24.4.90",
-			args: args{ref: "emacs-24.4.90"},
-			want: want{version: "24.4.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-24.4.91",
-			args: args{ref: "emacs-24.4.91"},
-			want: want{version: "24.4.91", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-24.5",
-			args: args{ref: "emacs-24.5"},
-			want: want{version: "24.5", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-24.5-rc1",
-			args: args{ref: "emacs-24.5-rc1"},
-			want: want{version: "24.5-rc1", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-24.5-rc3",
-			args: args{ref: "emacs-24.5-rc3"},
-			want: want{version: "24.5-rc3", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-24.5-rc3-fixed",
-			args: args{ref: "emacs-24.5-rc3-fixed"},
-			want: want{version: "24.5-rc3-fixed", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-25.0.90",
-			args: args{ref: "emacs-25.0.90"},
-			want: want{version: "25.0.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-25.0.95",
-			args: args{ref: "emacs-25.0.95"},
-			want: want{version: "25.0.95", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-25.1",
-			args: args{ref: "emacs-25.1"},
-			want: want{version: "25.1", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-25.1-rc1",
-			args: args{ref: "emacs-25.1-rc1"},
-			want: want{version: "25.1-rc1", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-25.1-rc2",
-			args: args{ref: "emacs-25.1-rc2"},
-			want: want{version: "25.1-rc2", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-25.1.90",
-			args: args{ref: "emacs-25.1.90"},
-			want: want{version: "25.1.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-25.1.91",
-			args: args{ref: "emacs-25.1.91"},
-			want: want{version: "25.1.91", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-25.2",
-			args: args{ref: "emacs-25.2"},
-			want: want{version: "25.2", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-25.2-rc1",
-			args: args{ref: "emacs-25.2-rc1"},
-			want: want{version: "25.2-rc1", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-25.2-rc2",
-			args: args{ref: "emacs-25.2-rc2"},
-			want: want{version: "25.2-rc2", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-26.0.90",
-			args: args{ref: "emacs-26.0.90"},
-			want: want{version: "26.0.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-26.0.91",
-			args: args{ref: "emacs-26.0.91"},
-			want: want{version: "26.0.91", channel: release.Pretest, err: ""},
+			name:        "nightly",
+			opts:        &Options{Ref: "master"},
+			wantBuild:   "Emacs.2021-05-02.SHA.master.macOS-14.arm64",
+			wantRelease: "Emacs.2021-05-02.SHA.master",
 		},
 		{
-			name: "emacs-26.1",
-			args: args{ref: "emacs-26.1"},
-			want: want{version: "26.1", channel: release.Stable, err: ""},
+			name:        "stable",
+			opts:        &Options{Ref: "emacs-30.1"},
+			wantBuild:   "Emacs.2021-05-02.SHA.emacs-30-1.macOS-14.arm64",
+			wantRelease: "Emacs-30.1",
 		},
 		{
-			name: "emacs-26.1-rc1",
-			args: args{ref: "emacs-26.1-rc1"},
-			want: want{version: "26.1-rc1", channel: release.RC, err: ""},
+			name: "pretest",
+			opts: &Options{Ref: "emacs-30.0.90"},
+			wantBuild: "Emacs.2021-05-02.SHA.emacs-30-0-90-pretest." +
+				"macOS-14.arm64",
+			wantRelease: "Emacs-30.0.90-pretest",
 		},
 		{
-			name: "emacs-26.1.90",
-			args: args{ref: "emacs-26.1.90"},
-			want: want{version: "26.1.90", channel: release.Pretest, err: ""},
+			name:        "release candidate",
+			opts:        &Options{Ref: "emacs-30.1-rc1"},
+			wantBuild:   "Emacs.2021-05-02.SHA.emacs-30-1-rc1.macOS-14.arm64",
+			wantRelease: "Emacs-30.1-rc1",
 		},
 		{
-			name: "emacs-26.1.92",
-			args: args{ref: "emacs-26.1.92"},
-			want: want{version: "26.1.92", channel: release.Pretest, err: ""},
+			name:        "build variant",
+			opts:        &Options{Ref: "emacs-30.1", BuildVariant: 2},
+			wantBuild:   "Emacs.2021-05-02.SHA.emacs-30-1-2.macOS-14.arm64",
+			wantRelease: "Emacs-30.1-2",
 		},
 		{
-			name: "emacs-26.2",
-			args: args{ref: "emacs-26.2"},
-			want: want{version: "26.2", channel: release.Stable, err: ""},
+			name: "test build",
+			opts: &Options{Ref: "master", TestBuild: "my test"},
+			wantBuild: "Emacs.2021-05-02.SHA.master.macOS-14.arm64" +
+				".test.my-test",
+			wantRelease: "test-builds-my-test",
 		},
 		{
-			name: "emacs-26.2.90",
-			args: args{ref: "emacs-26.2.90"},
-			want: want{version: "26.2.90", channel: release.Pretest, err: ""},
+			name: "target arch",
+			opts: &Options{
+				Ref:    "master",
+				Target: &osinfo.Target{Arch: "x86_64"},
+			},
+			wantBuild:   "Emacs.2021-05-02.SHA.master.macOS-14.x86_64",
+			wantRelease: "Emacs.2021-05-02.SHA.master",
 		},
 		{
-			name: "emacs-26.3",
-			args: args{ref: "emacs-26.3"},
-			want: want{version: "26.3", channel: release.Stable, err: ""},
+			name: "target universal with older SDK",
+			opts: &Options{
+				Ref: "emacs-30.1",
+				Target: &osinfo.Target{
+					SDKVersion: "10.15",
+					Arch:       "universal",
+				},
+			},
+			wantBuild: "Emacs.2021-05-02.SHA.emacs-30-1." +
+				"macOS-10-15.universal",
+			wantRelease: "Emacs-30.1",
 		},
 		{
-			name: "emacs-26.3-rc1",
-			args: args{ref: "emacs-26.3-rc1"},
-			want: want{version: "26.3-rc1", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-27.0.90",
-			args: args{ref: "emacs-27.0.90"},
-			want: want{version: "27.0.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-27.0.91",
-			args: args{ref: "emacs-27.0.91"},
-			want: want{version: "27.0.91", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-27.1",
-			args: args{ref: "emacs-27.1"},
-			want: want{version: "27.1", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-27.1-rc1",
-			args: args{ref: "emacs-27.1-rc1"},
-			want: want{version: "27.1-rc1", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-27.1-rc2",
-			args: args{ref: "emacs-27.1-rc2"},
-			want: want{version: "27.1-rc2", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-27.1.90",
-			args: args{ref: "emacs-27.1.90"},
-			want: want{version: "27.1.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-27.1.91",
-			args: args{ref: "emacs-27.1.91"},
-			want: want{version: "27.1.91", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-27.2",
-			args: args{ref: "emacs-27.2"},
-			want: want{version: "27.2", channel: release.Stable, err: ""},
-		},
-		{
-			name: "emacs-27.2-rc1",
-			args: args{ref: "emacs-27.2-rc1"},
-			want: want{version: "27.2-rc1", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-27.2-rc2",
-			args: args{ref: "emacs-27.2-rc2"},
-			want: want{version: "27.2-rc2", channel: release.RC, err: ""},
-		},
-		{
-			name: "emacs-28.0.90",
-			args: args{ref: "emacs-28.0.90"},
-			want: want{version: "28.0.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-21.0.100",
-			args: args{ref: "emacs-pretest-21.0.100"},
-			want: want{version: "21.0.100", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-21.0.106",
-			args: args{ref: "emacs-pretest-21.0.106"},
-			want: want{version: "21.0.106", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-21.0.90",
-			args: args{ref: "emacs-pretest-21.0.90"},
-			want: want{version: "21.0.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-21.0.99",
-			args: args{ref: "emacs-pretest-21.0.99"},
-			want: want{version: "21.0.99", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-22.0.90",
-			args: args{ref: "emacs-pretest-22.0.90"},
-			want: want{version: "22.0.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-22.0.99",
-			args: args{ref: "emacs-pretest-22.0.99"},
-			want: want{version: "22.0.99", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-22.0.990",
-			args: args{ref: "emacs-pretest-22.0.990"},
-			want: want{version: "22.0.990", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-22.1.90",
-			args: args{ref: "emacs-pretest-22.1.90"},
-			want: want{version: "22.1.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-22.2.90",
-			args: args{ref: "emacs-pretest-22.2.90"},
-			want: want{version: "22.2.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-23.0.90",
-			args: args{ref: "emacs-pretest-23.0.90"},
-			want: want{version: "23.0.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-23.1.90",
-			args: args{ref: "emacs-pretest-23.1.90"},
-			want: want{version: "23.1.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-23.2.90",
-			args: args{ref: "emacs-pretest-23.2.90"},
-			want: want{version: "23.2.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-23.2.91",
-			args: args{ref: "emacs-pretest-23.2.91"},
-			want: want{version: "23.2.91", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-23.2.93",
-			args: args{ref: "emacs-pretest-23.2.93"},
-			want: want{version: "23.2.93", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-23.2.93.1",
-			args: args{ref: "emacs-pretest-23.2.93.1"},
-			want: want{version: "23.2.93.1", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-23.3.90",
-			args: args{ref: "emacs-pretest-23.3.90"},
-			want: want{version: "23.3.90", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-24.0.05",
-			args: args{ref: "emacs-pretest-24.0.05"},
-			want: want{version: "24.0.05", channel: release.Pretest, err: ""},
-		},
-		{
-			name: "emacs-pretest-24.0.90",
-			args: args{ref: "emacs-pretest-24.0.90"},
-			want: want{version: "24.0.90", channel: release.Pretest, err: ""},
+			name: "invalid target arch",
+			opts: &Options{
+				Ref:    "master",
+				Target: &osinfo.Target{Arch: "ppc"},
+			},
+			wantErr: osinfo.ErrInvalidArch,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, gotChannel, err := parseGitRef(tt.args.ref)
+			t.Parallel()
+
+			opts := *tt.opts
+			opts.EmacsRepo = "emacs-mirror/emacs"
+			opts.SourceDir = dir
+			opts.TarballDir = t.TempDir()
+			opts.OSInfo = host
 
-			assert.Equal(t, tt.want.version, got)
-			assert.Equal(t, tt.want.channel, gotChannel)
+			got, err := Create(context.Background(), &opts)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
 
-			if tt.want.err == "" {
-				assert.NoError(t, err)
-			} else {
-				assert.EqualError(t, err, tt.want.err)
+				return
 			}
+			require.NoError(t, err)
+
+			sha := got.Source.Commit.ShortSHA()
+			wantBuild := strings.ReplaceAll(tt.wantBuild, "SHA", sha)
+			assert.Equal(t, wantBuild, got.Build.Name)
+			assert.Equal(t,
+				strings.ReplaceAll(tt.wantRelease, "SHA", sha),
+				got.Release.Name,
+			)
+			assert.Equal(t, wantBuild+".dmg", got.Output.DiskImage)
+			assert.FileExists(t, got.Source.Tarball.Path)
+		})
+	}
+}
+
+func TestCreate_localRepo(t *testing.T) {
+	t.Parallel()
+
+	dir := newSourceRepo(t, "emacs-30.1")
+	tarballs := t.TempDir()
+
+	got, err := Create(context.Background(), &Options{
+		EmacsRepo:  dir,
+		TarballDir: tarballs,
+		Ref:        "emacs-30.1",
+		OSInfo: osinfo.Static(&osinfo.OSInfo{
+			Name:       "macOS",
+			Version:    "14.5",
+			SDKVersion: "14.4",
+			Arch:       "arm64",
+		}),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, repository.Local, got.Source.Repository.Type)
+	assert.Equal(t, "Emacs-30.1", got.Release.Name)
+	require.NotNil(t, got.Source.Tarball)
+	assert.Empty(t, got.Source.Tarball.URL)
+	assert.Equal(t, tarballs, filepath.Dir(got.Source.Tarball.Path))
+	assert.FileExists(t, got.Source.Tarball.Path)
+}
+
+func TestCreate_targetWithoutHost(t *testing.T) {
+	t.Parallel()
+
+	dir := newSourceRepo(t)
+	got, err := Create(context.Background(), &Options{
+		EmacsRepo:  "emacs-mirror/emacs",
+		SourceDir:  dir,
+		TarballDir: t.TempDir(),
+		Ref:        "master",
+		OSInfo: osinfo.ProviderFunc(
+			func(_ context.Context) (*osinfo.OSInfo, error) {
+				t.Error("host OS info used")
+
+				return nil, errors.New("not macOS")
+			},
+		),
+		Target: &osinfo.Target{Version: "14.5", Arch: osinfo.ARM64},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t,
+		"Emacs.2021-05-02."+got.Source.Commit.ShortSHA()+
+			".master.macOS-14.arm64",
+		got.Build.Name,
+	)
+	assert.Equal(t, "14.5", got.OS.Version)
+	assert.Empty(t, got.OS.SDKVersion)
+}
+
+func TestPlan_ForArch(t *testing.T) {
+	t.Parallel()
+
+	dir := newSourceRepo(t, "emacs-30.1")
+	create := func(arch string, testBuild string) *Plan {
+		t.Helper()
+
+		p, err := Create(context.Background(), &Options{
+			EmacsRepo:  "emacs-mirror/emacs",
+			SourceDir:  dir,
+			TarballDir: t.TempDir(),
+			Ref:        "emacs-30.1",
+			TestBuild:  testBuild,
+			OSInfo: osinfo.Static(&osinfo.OSInfo{
+				Name:       "macOS",
+				Version:    "14.5",
+				SDKVersion: "14.4",
+				Arch:       arch,
+			}),
 		})
+		require.NoError(t, err)
+
+		return p
 	}
+
+	for _, testBuild := range []string{"", "arm64"} {
+		arm64 := create("arm64", testBuild)
+		want := create("universal", testBuild)
+
+		got, err := arm64.ForArch("universal")
+		require.NoError(t, err)
+
+		assert.Equal(t, want.Build.Name, got.Build.Name)
+		assert.Equal(t, want.Output.DiskImage, got.Output.DiskImage)
+		assert.Equal(t, want.OS, got.OS)
+		assert.Equal(t, "arm64", arm64.OS.Arch, "original plan changed")
+	}
+
+	_, err := create("arm64", "").ForArch("ppc")
+	assert.ErrorIs(t, err, osinfo.ErrInvalidArch)
 }
@@ -3,18 +3,32 @@ package plan
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/jimeh/build-emacs-for-macos/pkg/osinfo"
+	"github.com/jimeh/build-emacs-for-macos/pkg/patch"
 	"github.com/jimeh/build-emacs-for-macos/pkg/release"
 	"github.com/jimeh/build-emacs-for-macos/pkg/source"
 	"gopkg.in/yaml.v3"
 )
 
+//nolint:golint
+var (
+	Err                   = errors.New("plan")
+	ErrInvalid            = fmt.Errorf("%w: invalid plan", Err)
+	ErrUnsupportedVersion = fmt.Errorf("%w: unsupported schema version", Err)
+)
+
 type Plan struct {
+	SchemaVersion int `yaml:"schema_version,omitempty" json:"schema_version,omitempty"`
+
 	Build   *Build         `yaml:"build,omitempty" json:"build,omitempty"`
 	Source  *source.Source `yaml:"source,omitempty" json:"source,omitempty"`
+	Patches []*patch.Patch `yaml:"patches,omitempty" json:"patches,omitempty"`
 	OS      *osinfo.OSInfo `yaml:"os,omitempty" json:"os,omitempty"`
 	Release *Release       `yaml:"release,omitempty" json:"release,omitempty"`
 	Output  *Output        `yaml:"output,omitempty" json:"output,omitempty"`
@@ -33,9 +47,38 @@ func Load(filename string) (*Plan, error) {
 		return nil, err
 	}
 
+	// Plans created before versioning was introduced have no schema version,
+	// and are compatible with version 1.
+	if p.SchemaVersion == 0 {
+		p.SchemaVersion = 1
+	}
+	if p.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf(
+			"%w: %s has version %d, newest supported is %d",
+			ErrUnsupportedVersion, filename, p.SchemaVersion, SchemaVersion,
+		)
+	}
+
 	return p, nil
 }
 
+// Save writes plan to given filename, in JSON format if the filename has a
+// ".json" extension, and YAML otherwise.
+func (s *Plan) Save(filename string) error {
+	var buf bytes.Buffer
+	var err error
+	if strings.HasSuffix(filename, ".json") {
+		err = s.WriteJSON(&buf)
+	} else {
+		err = s.WriteYAML(&buf)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, buf.Bytes(), 0o644) //nolint:gosec
+}
+
 // WriteYAML writes plan in YAML format to given io.Writer.
 func (s *Plan) WriteYAML(w io.Writer) error {
 	enc := yaml.NewEncoder(w)
@@ -74,8 +117,185 @@ func (s *Plan) JSON() (string, error) {
 	return buf.String(), nil
 }
 
+// ForArch returns a copy of the plan for a build of the same source targeting
+// given architecture, with build and output names changed to match those
+// Create would produce. It is used to derive the plan of a universal build
+// from the plan of one of the builds it is merged from.
+func (s *Plan) ForArch(arch string) (*Plan, error) {
+	if s.Build == nil || s.OS == nil || s.Output == nil {
+		return nil, fmt.Errorf(
+			"%w: build, os and output are required", ErrInvalid,
+		)
+	}
+
+	err := (&osinfo.Target{Arch: arch}).Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	osInfo := *s.OS
+	osInfo.Arch = arch
+	oldTarget := "." + targetName(s.OS)
+	newTarget := "." + targetName(&osInfo)
+
+	build := *s.Build
+	build.Name = replaceLast(build.Name, oldTarget, newTarget)
+
+	output := *s.Output
+	output.DiskImage = replaceLast(output.DiskImage, oldTarget, newTarget)
+	output.Archive = replaceLast(output.Archive, s.Build.Name, build.Name)
+
+	p := *s
+	p.Build = &build
+	p.OS = &osInfo
+	p.Output = &output
+
+	return &p, nil
+}
+
+func replaceLast(s, old, replacement string) string {
+	i := strings.LastIndex(s, old)
+	if old == "" || i < 0 {
+		return s
+	}
+
+	return s[:i] + replacement + s[i+len(old):]
+}
+
 type Build struct {
-	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	Name    string        `yaml:"name,omitempty" json:"name,omitempty"`
+	Profile string        `yaml:"profile,omitempty" json:"profile,omitempty"`
+	Options *BuildOptions `yaml:"options,omitempty" json:"options,omitempty"`
+}
+
+// BuildOptions controls which features Emacs is built with. Unset options use
+// the build script's defaults.
+type BuildOptions struct {
+	// NativeComp enables native compilation of Lisp files. When not set, it
+	// is enabled if supported by the Emacs version being built.
+	NativeComp    *bool `yaml:"native_comp,omitempty" json:"native_comp,omitempty"`
+	NativeFullAOT *bool `yaml:"native_full_aot,omitempty" json:"native_full_aot,omitempty"`
+
+	// RelinkEln re-links shared libraries referenced by bundled *.eln files.
+	RelinkEln *bool `yaml:"relink_eln,omitempty" json:"relink_eln,omitempty"`
+
+	TreeSitter *bool `yaml:"tree_sitter,omitempty" json:"tree_sitter,omitempty"`
+	XWidgets   *bool `yaml:"xwidgets,omitempty" json:"xwidgets,omitempty"`
+	Rsvg       *bool `yaml:"rsvg,omitempty" json:"rsvg,omitempty"`
+	DBus       *bool `yaml:"dbus,omitempty" json:"dbus,omitempty"`
+
+	// FDSetSize sets the max number of open files, ignored if below 1024.
+	FDSetSize int `yaml:"fd_setsize,omitempty" json:"fd_setsize,omitempty"`
+
+	Icon *Icon `yaml:"icon,omitempty" json:"icon,omitempty"`
+
+	// ConfigureArgs are passed to ./configure in addition to the flags
+	// derived from other options.
+	ConfigureArgs []string `yaml:"configure_args,omitempty" json:"configure_args,omitempty"`
+}
+
+// Icon replaces the default Emacs.app icon.
+type Icon struct {
+	// URI is a local path or URL to a .icns file.
+	URI string `yaml:"uri,omitempty" json:"uri,omitempty"`
+
+	// TahoeURI is a local path or URL to an Assets.car file with icons for
+	// macOS 26 and later, and TahoeName the name of the icon within it.
+	TahoeURI  string `yaml:"tahoe_uri,omitempty" json:"tahoe_uri,omitempty"`
+	TahoeName string `yaml:"tahoe_name,omitempty" json:"tahoe_name,omitempty"`
+}
+
+// DefaultBuildOptions returns the build options used when not specified by
+// flags or profiles. Native-comp is left unset, so the build script enables it
+// if supported.
+func DefaultBuildOptions() *BuildOptions {
+	t, f := true, false
+
+	return &BuildOptions{
+		NativeFullAOT: &f,
+		RelinkEln:     &t,
+		TreeSitter:    &t,
+		XWidgets:      &t,
+		Rsvg:          &t,
+		DBus:          &t,
+		FDSetSize:     10000,
+	}
+}
+
+// Merge returns a copy of build options, where options set in other take
+// precedence. Configure arguments of both are combined.
+func (s *BuildOptions) Merge(other *BuildOptions) *BuildOptions {
+	r := &BuildOptions{}
+	if s != nil {
+		*r = *s
+		r.ConfigureArgs = append([]string{}, s.ConfigureArgs...)
+		if s.Icon != nil {
+			icon := *s.Icon
+			r.Icon = &icon
+		}
+	}
+	if other == nil {
+		return r
+	}
+
+	for _, o := range []struct{ dst, src **bool }{
+		{&r.NativeComp, &other.NativeComp},
+		{&r.NativeFullAOT, &other.NativeFullAOT},
+		{&r.RelinkEln, &other.RelinkEln},
+		{&r.TreeSitter, &other.TreeSitter},
+		{&r.XWidgets, &other.XWidgets},
+		{&r.Rsvg, &other.Rsvg},
+		{&r.DBus, &other.DBus},
+	} {
+		if *o.src != nil {
+			*o.dst = *o.src
+		}
+	}
+
+	if other.FDSetSize != 0 {
+		r.FDSetSize = other.FDSetSize
+	}
+	r.ConfigureArgs = append(r.ConfigureArgs, other.ConfigureArgs...)
+	if len(r.ConfigureArgs) == 0 {
+		r.ConfigureArgs = nil
+	}
+
+	if other.Icon != nil {
+		if r.Icon == nil {
+			r.Icon = &Icon{}
+		}
+		if other.Icon.URI != "" {
+			r.Icon.URI = other.Icon.URI
+		}
+		if other.Icon.TahoeURI != "" {
+			r.Icon.TahoeURI = other.Icon.TahoeURI
+		}
+		if other.Icon.TahoeName != "" {
+			r.Icon.TahoeName = other.Icon.TahoeName
+		}
+	}
+
+	return r
+}
+
+// Validate checks build options for conflicting or incomplete settings.
+func (s *BuildOptions) Validate() error {
+	if s.Icon != nil && s.Icon.TahoeURI != "" && s.Icon.TahoeName == "" {
+		return fmt.Errorf(
+			"%w: icon tahoe_name is required when tahoe_uri is set",
+			ErrInvalid,
+		)
+	}
+
+	for _, arg := range s.ConfigureArgs {
+		if !strings.HasPrefix(arg, "-") {
+			return fmt.Errorf(
+				"%w: configure argument %q is not a flag", ErrInvalid, arg,
+			)
+		}
+	}
+
+	return nil
 }
 
 type Release struct {
@@ -89,4 +309,14 @@ type Release struct {
 type Output struct {
 	Directory string `yaml:"directory,omitempty" json:"directory,omitempty"`
 	DiskImage string `yaml:"disk_image,omitempty" json:"disk_image,omitempty"`
+
+	// Archive is the full path of the build archive, defaulting to
+	// "<directory>/<build name>.tbz" when not set.
+	Archive string `yaml:"archive,omitempty" json:"archive,omitempty"`
+
+	// Signed and Notarized are set by "emacs-builder package", reporting if
+	// Emacs.app was code signed, and if the disk image was notarized. Nil
+	// when the build has not been packaged with the plan.
+	Signed    *bool `yaml:"signed,omitempty" json:"signed,omitempty"`
+	Notarized *bool `yaml:"notarized,omitempty" json:"notarized,omitempty"`
 }
@@ -0,0 +1,69 @@
+package plan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+func TestBuildOptions_Merge(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  *BuildOptions
+		other *BuildOptions
+		want  *BuildOptions
+	}{
+		{
+			name: "nil",
+			want: &BuildOptions{},
+		},
+		{
+			name: "other takes precedence",
+			base: &BuildOptions{
+				NativeComp: boolPtr(true),
+				XWidgets:   boolPtr(true),
+				FDSetSize:  10000,
+				Icon:       &Icon{URI: "a.icns", TahoeName: "Emacs"},
+			},
+			other: &BuildOptions{
+				XWidgets:  boolPtr(false),
+				Rsvg:      boolPtr(false),
+				FDSetSize: 2048,
+				Icon:      &Icon{URI: "b.icns"},
+			},
+			want: &BuildOptions{
+				NativeComp: boolPtr(true),
+				XWidgets:   boolPtr(false),
+				Rsvg:       boolPtr(false),
+				FDSetSize:  2048,
+				Icon:       &Icon{URI: "b.icns", TahoeName: "Emacs"},
+			},
+		},
+		{
+			name:  "configure args are combined",
+			base:  &BuildOptions{ConfigureArgs: []string{"--a"}},
+			other: &BuildOptions{ConfigureArgs: []string{"--b"}},
+			want:  &BuildOptions{ConfigureArgs: []string{"--a", "--b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before *BuildOptions
+			if tt.base != nil {
+				b := *tt.base
+				before = &b
+			}
+
+			got := tt.base.Merge(tt.other)
+
+			assert.Equal(t, tt.want, got)
+			if tt.base != nil {
+				assert.Equal(t, before, tt.base, "base must not be modified")
+			}
+		})
+	}
+}
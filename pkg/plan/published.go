@@ -0,0 +1,50 @@
+package plan
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/gh"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+)
+
+// Published reports if the release of the plan already exists in given
+// release store with the plan's disk image.
+func (s *Plan) Published(
+	ctx context.Context,
+	store release.Store,
+) (bool, error) {
+	if s.Release == nil || s.Output == nil {
+		return false, nil
+	}
+
+	return release.Exists(ctx, &release.CheckOptions{
+		Store:       store,
+		ReleaseName: s.Release.Name,
+		AssetFiles:  []string{s.Output.DiskImage},
+	})
+}
+
+// WriteSkipOutputs reports if the build of the plan is skipped, as GitHub
+// Actions step outputs along with the plan's build, release and disk image
+// names, and the file the plan was written to.
+func (s *Plan) WriteSkipOutputs(skip bool, planFile string) error {
+	var buildName, releaseName, diskImage string
+	if s.Build != nil {
+		buildName = s.Build.Name
+	}
+	if s.Release != nil {
+		releaseName = s.Release.Name
+	}
+	if s.Output != nil {
+		diskImage = s.Output.DiskImage
+	}
+
+	return gh.Output(
+		"skip", strconv.FormatBool(skip),
+		"build-name", buildName,
+		"release", releaseName,
+		"disk-image", diskImage,
+		"plan", planFile,
+	)
+}
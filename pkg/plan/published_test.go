@@ -0,0 +1,101 @@
+package plan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan_Published(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := release.NewDirStore(t.TempDir(), "")
+	require.NoError(t, err)
+	dmg := filepath.Join(t.TempDir(), "Emacs.2024-06-22.abcdef0.master.dmg")
+	require.NoError(t, os.WriteFile(dmg, []byte("dmg"), 0o644))
+	_, err = store.Publish(ctx,
+		&release.Release{Name: "Emacs.2024-06-22.abcdef0.master"},
+		[]string{dmg}, &release.PublishOptions{},
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		plan *Plan
+		want bool
+	}{
+		{
+			name: "published",
+			plan: &Plan{
+				Release: &Release{Name: "Emacs.2024-06-22.abcdef0.master"},
+				Output: &Output{
+					DiskImage: "Emacs.2024-06-22.abcdef0.master.dmg",
+				},
+			},
+			want: true,
+		},
+		{
+			name: "missing disk image",
+			plan: &Plan{
+				Release: &Release{Name: "Emacs.2024-06-22.abcdef0.master"},
+				Output: &Output{
+					DiskImage: "Emacs.2024-06-22.abcdef0.master-1.dmg",
+				},
+			},
+			want: false,
+		},
+		{
+			name: "missing release",
+			plan: &Plan{
+				Release: &Release{Name: "Emacs.2024-06-23.1234567.master"},
+				Output: &Output{
+					DiskImage: "Emacs.2024-06-23.1234567.master.dmg",
+				},
+			},
+			want: false,
+		},
+		{
+			name: "no release",
+			plan: &Plan{},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.plan.Published(ctx, store)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPlan_WriteSkipOutputs(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "output")
+	t.Setenv("GITHUB_OUTPUT", filename)
+
+	p := &Plan{
+		Build:   &Build{Name: "Emacs.2024-06-22.abcdef0.master.macOS-14.arm64"},
+		Release: &Release{Name: "Emacs.2024-06-22.abcdef0.master"},
+		Output: &Output{
+			DiskImage: "Emacs.2024-06-22.abcdef0.master.macOS-14.arm64.dmg",
+		},
+	}
+	require.NoError(t, p.WriteSkipOutputs(true, "plan.yml"))
+
+	b, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t,
+		"skip=true\n"+
+			"build-name=Emacs.2024-06-22.abcdef0.master.macOS-14.arm64\n"+
+			"release=Emacs.2024-06-22.abcdef0.master\n"+
+			"disk-image=Emacs.2024-06-22.abcdef0.master.macOS-14.arm64.dmg\n"+
+			"plan=plan.yml\n",
+		string(b),
+	)
+}
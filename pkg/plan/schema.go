@@ -0,0 +1,184 @@
+package plan
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
+	"github.com/jimeh/build-emacs-for-macos/pkg/patch"
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	"github.com/jimeh/build-emacs-for-macos/pkg/source"
+)
+
+//go:generate go run ../../cmd/emacs-builder plan schema -o ../../schema/plan.schema.json
+
+// SchemaVersion is the current version of the plan format. It is increased
+// whenever existing fields change in meaning or are removed.
+const SchemaVersion = 1
+
+// SchemaID is the $id of the published JSON Schema.
+const SchemaID = "https://github.com/jimeh/build-emacs-for-macos/" +
+	"blob/main/schema/plan.schema.json"
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+
+	// enums lists the allowed values of string types.
+	enums = map[reflect.Type][]string{
+		reflect.TypeOf(release.Channel("")): {
+			string(release.Stable),
+			string(release.RC),
+			string(release.Pretest),
+			string(release.Nightly),
+		},
+		reflect.TypeOf(repository.Type("")): {
+			string(repository.GitHub),
+			string(repository.GitLab),
+			string(repository.Cgit),
+			string(repository.Local),
+		},
+	}
+
+	// patterns lists regular expressions string fields must match, keyed by
+	// struct type and field name.
+	patterns = map[reflect.Type]map[string]string{
+		reflect.TypeOf(commit.Commit{}): {"SHA": `^[0-9a-f]{40}$`},
+		reflect.TypeOf(source.Tarball{}): {
+			"SHA256": `^[0-9a-f]{64}$`,
+		},
+		reflect.TypeOf(patch.Patch{}): {"SHA256": `^[0-9a-f]{64}$`},
+	}
+)
+
+// field describes a serialized struct field.
+type field struct {
+	Name     string
+	Field    string
+	Type     reflect.Type
+	Required bool
+	Pattern  string
+}
+
+// fields returns the serialized fields of a struct type, based on their yaml
+// struct tags. Fields without omitempty are required.
+func fields(t reflect.Type) []*field {
+	var r []*field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("yaml")
+		if !sf.IsExported() || tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+
+		f := &field{
+			Name:     name,
+			Field:    sf.Name,
+			Type:     sf.Type,
+			Required: true,
+			Pattern:  patterns[t][sf.Name],
+		}
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				f.Required = false
+			}
+		}
+		r = append(r, f)
+	}
+
+	return r
+}
+
+// Schema returns the JSON Schema of the plan format.
+func Schema() map[string]interface{} {
+	s := typeSchema(reflect.TypeOf(Plan{}))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["$id"] = SchemaID
+	s["title"] = "emacs-builder build plan"
+
+	return s
+}
+
+// SchemaJSON returns the JSON Schema of the plan format as indented JSON.
+func SchemaJSON() ([]byte, error) {
+	b, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if values, ok := enums[t]; ok {
+		return map[string]interface{}{"type": "string", "enum": values}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem()),
+		}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{
+				"type":   "string",
+				"format": "date-time",
+			}
+		}
+
+		props := map[string]interface{}{}
+		required := []string{}
+		for _, f := range fields(t) {
+			fs := typeSchema(f.Type)
+			if f.Pattern != "" {
+				fs["pattern"] = f.Pattern
+			}
+			props[f.Name] = fs
+			if f.Required {
+				required = append(required, f.Name)
+			}
+		}
+
+		s := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+
+		return s
+	default:
+		return map[string]interface{}{}
+	}
+}
@@ -0,0 +1,61 @@
+package plan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaJSON(t *testing.T) {
+	want, err := os.ReadFile(
+		filepath.Join("..", "..", "schema", "plan.schema.json"),
+	)
+	require.NoError(t, err)
+
+	got, err := SchemaJSON()
+	require.NoError(t, err)
+
+	assert.Equal(t, string(want), string(got),
+		"schema/plan.schema.json is outdated, run \"make gen\"",
+	)
+}
+
+func TestLoad_schemaVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+		want    int
+		wantErr error
+	}{
+		{name: "unversioned", content: "build:\n  name: foo\n", want: 1},
+		{name: "current", content: "schema_version: 1\n", want: 1},
+		{
+			name:    "newer",
+			content: "schema_version: 2\n",
+			wantErr: ErrUnsupportedVersion,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(dir, tt.name+".yml")
+			require.NoError(t,
+				os.WriteFile(filename, []byte(tt.content), 0o644),
+			)
+
+			p, err := Load(filename)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, p.SchemaVersion)
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/release"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is a single issue found when validating a plan.
+type Problem struct {
+	Path    string `yaml:"path" json:"path"`
+	Message string `yaml:"message" json:"message"`
+}
+
+func (s *Problem) String() string {
+	if s.Path == "" {
+		return s.Message
+	}
+
+	return s.Path + ": " + s.Message
+}
+
+// Validate checks a plan in YAML or JSON format against the plan schema, and
+// for inconsistencies between fields. It returns all problems found, and only
+// returns an error if the plan could not be parsed at all.
+func Validate(data []byte) ([]*Problem, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	v := &validator{problems: []*Problem{}}
+	if len(doc.Content) == 0 {
+		v.add("", "plan is empty")
+
+		return v.problems, nil
+	}
+	v.node("", doc.Content[0], reflect.TypeOf(Plan{}), "")
+
+	// Only check semantics if the structure is sound, to avoid reporting
+	// the same problem twice.
+	if len(v.problems) == 0 {
+		p, err := decode(&doc)
+		if err != nil {
+			v.add("", err.Error())
+		} else {
+			v.plan(p)
+		}
+	}
+
+	return v.problems, nil
+}
+
+type validator struct {
+	problems []*Problem
+}
+
+func (s *validator) add(path, format string, args ...interface{}) {
+	s.problems = append(s.problems, &Problem{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (s *validator) node(
+	path string,
+	n *yaml.Node,
+	t reflect.Type,
+	pattern string,
+) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Tag == "!!null" {
+		return
+	}
+
+	if values, ok := enums[t]; ok {
+		s.enum(path, n, values)
+
+		return
+	}
+
+	switch {
+	case t == timeType:
+		if n.Tag != "!!timestamp" && n.Tag != "!!str" {
+			s.add(path, "must be a timestamp")
+		}
+	case t.Kind() == reflect.Struct:
+		s.mapping(path, n, t)
+	case t.Kind() == reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			s.add(path, "must be a list")
+
+			return
+		}
+		for i, item := range n.Content {
+			s.node(path+"["+strconv.Itoa(i)+"]", item, t.Elem(), "")
+		}
+	case t.Kind() == reflect.Bool:
+		if n.Tag != "!!bool" {
+			s.add(path, "must be true or false")
+		}
+	case t.Kind() == reflect.Int:
+		if n.Tag != "!!int" {
+			s.add(path, "must be an integer")
+		}
+	case t.Kind() == reflect.String:
+		if n.Kind != yaml.ScalarNode {
+			s.add(path, "must be a string")
+
+			return
+		}
+		if pattern != "" && !regexp.MustCompile(pattern).MatchString(n.Value) {
+			s.add(path, "malformed value %q, must match %s", n.Value, pattern)
+		}
+	}
+}
+
+func (s *validator) mapping(path string, n *yaml.Node, t reflect.Type) {
+	if n.Kind != yaml.MappingNode {
+		s.add(path, "must be a mapping")
+
+		return
+	}
+
+	known := map[string]*field{}
+	for _, f := range fields(t) {
+		known[f.Name] = f
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i].Value
+		f, ok := known[key]
+		if !ok {
+			s.add(join(path, key), "unknown key")
+
+			continue
+		}
+		seen[key] = true
+		s.node(join(path, key), n.Content[i+1], f.Type, f.Pattern)
+	}
+
+	for _, f := range fields(t) {
+		if f.Required && !seen[f.Name] {
+			s.add(join(path, f.Name), "missing required key")
+		}
+	}
+}
+
+func (s *validator) enum(path string, n *yaml.Node, values []string) {
+	for _, v := range values {
+		if n.Value == v {
+			return
+		}
+	}
+
+	s.add(path, "unknown value %q, must be one of: %s",
+		n.Value, strings.Join(values, ", "),
+	)
+}
+
+// plan checks for problems which depend on multiple fields.
+func (s *validator) plan(p *Plan) {
+	if p.SchemaVersion > SchemaVersion {
+		s.add("schema_version",
+			"version %d is newer than supported version %d",
+			p.SchemaVersion, SchemaVersion,
+		)
+	}
+
+	if p.Build == nil || p.Build.Name == "" {
+		s.add("build.name", "build name is required")
+	}
+	if p.Build != nil && p.Build.Options != nil {
+		err := p.Build.Options.Validate()
+		if err != nil {
+			s.add("build.options", "%s",
+				strings.TrimPrefix(err.Error(), ErrInvalid.Error()+": "),
+			)
+		}
+	}
+	if p.Source == nil || p.Source.Commit == nil {
+		s.add("source.commit", "source commit is required")
+	}
+	if p.Output == nil || p.Output.DiskImage == "" {
+		s.add("output.disk_image", "disk image name is required")
+	}
+
+	if p.Release == nil {
+		s.add("release", "release is required")
+
+		return
+	}
+	r := p.Release
+
+	if r.Name == "" {
+		s.add("release.name", "release name is required")
+	}
+	if r.Draft && r.Prerelease {
+		s.add("release", "release cannot be both a draft and a prerelease")
+	}
+
+	// Test builds are published to a shared release which may be a draft or
+	// prerelease regardless of channel.
+	if strings.HasPrefix(r.Name, "test-builds-") {
+		return
+	}
+
+	switch {
+	case r.Channel == "":
+		s.add("release.channel", "release channel is required")
+	case r.Channel == release.Stable && r.Prerelease:
+		s.add("release.prerelease",
+			"must be false for the %s channel", r.Channel,
+		)
+	case r.Channel != release.Stable && !r.Prerelease:
+		s.add("release.prerelease",
+			"must be true for the %s channel", r.Channel,
+		)
+	}
+}
+
+// decode decodes a plan via JSON, as YAML cannot decode the quoted timestamps
+// of plans in JSON format into time.Time values.
+func decode(doc *yaml.Node) (*Plan, error) {
+	var raw interface{}
+	err := doc.Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{}
+	err = json.Unmarshal(b, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
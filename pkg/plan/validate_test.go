@@ -0,0 +1,215 @@
+package plan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jimeh/undent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+var validPlan = undent.String(`
+	schema_version: 1
+	build:
+	  name: Emacs.2023-07-30.9e27b4e.master.macOS-13.arm64
+	  options:
+	    native_comp: true
+	    xwidgets: false
+	    fd_setsize: 10000
+	    icon:
+	      uri: https://example.com/emacs.icns
+	    configure_args:
+	      - --without-sqlite3
+	source:
+	  ref: master
+	  repository:
+	    type: github
+	    source: emacs-mirror/emacs
+	  commit:
+	    sha: 9e27b4e7b7f8ae9a3db0a5bd3bd2ac3f7a5a0c6b
+	    date: 2023-07-30T09:12:43Z
+	    author: Jane Doe <jane@example.com>
+	    committer: Jane Doe <jane@example.com>
+	    message: Fix the thing
+	  tarball:
+	    url: https://github.com/emacs-mirror/emacs/tarball/9e27b4e
+	os:
+	  name: macOS
+	  version: "13.4"
+	  sdk_version: "13.3"
+	  arch: arm64
+	release:
+	  name: Emacs.2023-07-30.9e27b4e.master
+	  prerelease: true
+	  channel: nightly
+	output:
+	  directory: /tmp/builds
+	  disk_image: Emacs.2023-07-30.9e27b4e.master.macOS-13.arm64.dmg
+	  archive: /tmp/builds/Emacs.2023-07-30.9e27b4e.master.macOS-13.arm64.tbz`,
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace []string
+		want    []string
+	}{
+		{
+			name: "valid",
+			want: []string{},
+		},
+		{
+			name: "valid test build",
+			replace: []string{
+				"  name: Emacs.2023-07-30.9e27b4e.master\n",
+				"  name: test-builds-foo\n  draft: true\n",
+				"  prerelease: true\n", "",
+			},
+			want: []string{},
+		},
+		{
+			name:    "missing schema version",
+			replace: []string{"schema_version: 1\n", ""},
+			want:    []string{},
+		},
+		{
+			name:    "newer schema version",
+			replace: []string{"schema_version: 1\n", "schema_version: 99\n"},
+			want: []string{
+				"schema_version: version 99 is newer than supported " +
+					"version 1",
+			},
+		},
+		{
+			name: "missing release name",
+			replace: []string{
+				"  name: Emacs.2023-07-30.9e27b4e.master\n", "",
+			},
+			want: []string{"release.name: missing required key"},
+		},
+		{
+			name: "empty release name",
+			replace: []string{
+				"  name: Emacs.2023-07-30.9e27b4e.master\n", "  name: \"\"\n",
+			},
+			want: []string{"release.name: release name is required"},
+		},
+		{
+			name:    "malformed sha",
+			replace: []string{"sha: 9e27b4e7", "sha: 9E27B4E7"},
+			want: []string{
+				"source.commit.sha: malformed value " +
+					"\"9E27B4E7b7f8ae9a3db0a5bd3bd2ac3f7a5a0c6b\", " +
+					"must match ^[0-9a-f]{40}$",
+			},
+		},
+		{
+			name: "unknown keys",
+			replace: []string{
+				"  disk_image:", "  dmg: foo.dmg\n  disk_image:",
+				"schema_version: 1\n", "schema_version: 1\nextra: true\n",
+			},
+			want: []string{
+				"extra: unknown key",
+				"output.dmg: unknown key",
+			},
+		},
+		{
+			name: "tahoe icon without name",
+			replace: []string{
+				"      uri: https://example.com/emacs.icns\n",
+				"      tahoe_uri: https://example.com/Assets.car\n",
+			},
+			want: []string{
+				"build.options: icon tahoe_name is required when " +
+					"tahoe_uri is set",
+			},
+		},
+		{
+			name:    "configure arg not a flag",
+			replace: []string{"- --without-sqlite3", "- without-sqlite3"},
+			want: []string{
+				"build.options: configure argument \"without-sqlite3\" " +
+					"is not a flag",
+			},
+		},
+		{
+			name:    "wrong option type",
+			replace: []string{"fd_setsize: 10000", "fd_setsize: lots"},
+			want:    []string{"build.options.fd_setsize: must be an integer"},
+		},
+		{
+			name:    "wrong types",
+			replace: []string{"prerelease: true", "prerelease: yes please"},
+			want:    []string{"release.prerelease: must be true or false"},
+		},
+		{
+			name:    "unknown channel",
+			replace: []string{"channel: nightly", "channel: weekly"},
+			want: []string{
+				"release.channel: unknown value \"weekly\", must be one " +
+					"of: stable, release-candidate, pretest, nightly",
+			},
+		},
+		{
+			name:    "stable prerelease",
+			replace: []string{"channel: nightly", "channel: stable"},
+			want: []string{
+				"release.prerelease: must be false for the stable channel",
+			},
+		},
+		{
+			name:    "nightly not prerelease",
+			replace: []string{"  prerelease: true\n", ""},
+			want: []string{
+				"release.prerelease: must be true for the nightly channel",
+			},
+		},
+		{
+			name: "draft and prerelease",
+			replace: []string{
+				"  prerelease: true\n", "  prerelease: true\n  draft: true\n",
+			},
+			want: []string{
+				"release: release cannot be both a draft and a prerelease",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := validPlan
+			if tt.replace != nil {
+				data = strings.NewReplacer(tt.replace...).Replace(data)
+			}
+
+			problems, err := Validate([]byte(data))
+			require.NoError(t, err)
+
+			got := []string{}
+			for _, p := range problems {
+				got = append(got, p.String())
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidate_json(t *testing.T) {
+	p := &Plan{}
+	require.NoError(t, yaml.Unmarshal([]byte(validPlan), p))
+
+	data, err := p.JSON()
+	require.NoError(t, err)
+
+	problems, err := Validate([]byte(data))
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestValidate_unparsable(t *testing.T) {
+	_, err := Validate([]byte("release: [\n"))
+
+	assert.ErrorIs(t, err, ErrInvalid)
+}
@@ -0,0 +1,182 @@
+// Package profile loads named build profiles, which hold the plan settings
+// of a build variant so they do not need to be repeated as command line
+// flags.
+package profile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	"gopkg.in/yaml.v3"
+)
+
+//nolint:golint
+var (
+	Err         = errors.New("profile")
+	ErrNotFound = fmt.Errorf("%w: not found", Err)
+	ErrInvalid  = fmt.Errorf("%w: invalid profile", Err)
+)
+
+var nameMatcher = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// Profile is a named set of plan settings, loaded from a "<name>.yml" file.
+type Profile struct {
+	Name        string `yaml:"-" json:"name"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+
+	// EmacsRepo is the repository to build Emacs from.
+	EmacsRepo string `yaml:"emacs_repo,omitempty" json:"emacs_repo,omitempty"`
+
+	// BuildVariant is appended to version strings, to keep releases of the
+	// profile apart from those of other profiles.
+	BuildVariant int `yaml:"build_variant,omitempty" json:"build_variant,omitempty"`
+
+	// TestBuild makes plans of the profile test builds.
+	TestBuild *TestBuild `yaml:"test_build,omitempty" json:"test_build,omitempty"`
+
+	// Options are the build options of the profile.
+	Options *plan.BuildOptions `yaml:"options,omitempty" json:"options,omitempty"`
+
+	file string
+}
+
+// TestBuild holds the test build settings of a profile.
+type TestBuild struct {
+	// Name of the test build, defaults to the profile name.
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+
+	// Type is the release type of the test build, "prerelease" or "draft".
+	Type plan.TestBuildType `yaml:"type,omitempty" json:"type,omitempty"`
+}
+
+// File returns the filename the profile was loaded from.
+func (s *Profile) File() string {
+	return s.file
+}
+
+// TestBuildName returns the name of test builds of the profile, or an empty
+// string if the profile is not for test builds.
+func (s *Profile) TestBuildName() string {
+	if s.TestBuild == nil {
+		return ""
+	}
+	if s.TestBuild.Name != "" {
+		return s.TestBuild.Name
+	}
+
+	return s.Name
+}
+
+// Apply applies the settings of the profile to plan options, taking
+// precedence over settings already in opts.
+func (s *Profile) Apply(opts *plan.Options) {
+	opts.Profile = s.Name
+	if s.EmacsRepo != "" {
+		opts.EmacsRepo = s.EmacsRepo
+	}
+	if s.BuildVariant != 0 {
+		opts.BuildVariant = s.BuildVariant
+	}
+	if s.TestBuild != nil {
+		opts.TestBuild = s.TestBuildName()
+		if s.TestBuild.Type != "" {
+			opts.TestBuildType = s.TestBuild.Type
+		}
+	}
+	opts.BuildOptions = opts.BuildOptions.Merge(s.Options)
+}
+
+func (s *Profile) validate() error {
+	if s.TestBuild != nil {
+		switch s.TestBuild.Type {
+		case "", plan.Prerelease, plan.Draft:
+		default:
+			return fmt.Errorf(
+				"test_build type must be %s or %s, got %q",
+				plan.Prerelease, plan.Draft, s.TestBuild.Type,
+			)
+		}
+	}
+
+	if s.BuildVariant < 0 {
+		return errors.New("build_variant must not be negative")
+	}
+
+	if s.Options != nil {
+		return s.Options.Validate()
+	}
+
+	return nil
+}
+
+// Load loads the profile of given name from dir. If name is a path to a YAML
+// file, it is loaded directly instead.
+func Load(dir, name string) (*Profile, error) {
+	filename := filepath.Join(dir, name+".yml")
+	if strings.HasSuffix(name, ".yml") || strings.HasSuffix(name, ".yaml") {
+		filename = name
+		name = strings.TrimSuffix(
+			filepath.Base(name), filepath.Ext(name),
+		)
+	} else if !nameMatcher.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q is not a valid name", ErrInvalid, name)
+	}
+
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	p := &Profile{Name: name, file: filename}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err = dec.Decode(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalid, filename, err)
+	}
+
+	err = p.validate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalid, filename, err)
+	}
+
+	return p, nil
+}
+
+// List loads all profiles in dir, sorted by name.
+func List(dir string) ([]*Profile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := []*Profile{}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".yml")
+		if e.IsDir() || name == e.Name() {
+			continue
+		}
+
+		p, err := Load(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	return profiles, nil
+}
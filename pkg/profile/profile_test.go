@@ -0,0 +1,184 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/plan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeProfiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		require.NoError(t, err)
+	}
+
+	return dir
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeProfiles(t, map[string]string{
+		"full.yml": "description: Everything\n" +
+			"emacs_repo: emacs-mirror/emacs\n" +
+			"build_variant: 2\n" +
+			"options:\n" +
+			"  native_comp: true\n" +
+			"  configure_args: [--without-sqlite3]\n",
+		"test.yml":    "test_build:\n  type: draft\n",
+		"named.yml":   "test_build:\n  name: feature-x\n",
+		"empty.yml":   "",
+		"typo.yml":    "options:\n  native_compile: true\n",
+		"badtype.yml": "test_build:\n  type: weekly\n",
+		"icon.yml": "options:\n" +
+			"  icon:\n    tahoe_uri: https://example.com/Assets.car\n",
+	})
+
+	empty := &Profile{Name: "empty", file: filepath.Join(dir, "empty.yml")}
+
+	tests := []struct {
+		name     string
+		profile  string
+		want     *Profile
+		wantTest string
+		wantErr  error
+	}{
+		{
+			name:    "full",
+			profile: "full",
+			want: &Profile{
+				Name:         "full",
+				Description:  "Everything",
+				EmacsRepo:    "emacs-mirror/emacs",
+				BuildVariant: 2,
+				Options: &plan.BuildOptions{
+					NativeComp:    boolPtr(true),
+					ConfigureArgs: []string{"--without-sqlite3"},
+				},
+				file: filepath.Join(dir, "full.yml"),
+			},
+		},
+		{
+			name:    "test build named after profile",
+			profile: "test",
+			want: &Profile{
+				Name:      "test",
+				TestBuild: &TestBuild{Type: plan.Draft},
+				file:      filepath.Join(dir, "test.yml"),
+			},
+			wantTest: "test",
+		},
+		{
+			name:    "test build with name",
+			profile: "named",
+			want: &Profile{
+				Name:      "named",
+				TestBuild: &TestBuild{Name: "feature-x"},
+				file:      filepath.Join(dir, "named.yml"),
+			},
+			wantTest: "feature-x",
+		},
+		{
+			name:    "empty",
+			profile: "empty",
+			want:    empty,
+		},
+		{
+			name:    "path to file",
+			profile: filepath.Join(dir, "empty.yml"),
+			want:    empty,
+		},
+		{name: "missing", profile: "nope", wantErr: ErrNotFound},
+		{name: "invalid name", profile: "../full", wantErr: ErrInvalid},
+		{name: "unknown key", profile: "typo", wantErr: ErrInvalid},
+		{name: "bad test type", profile: "badtype", wantErr: ErrInvalid},
+		{name: "invalid options", profile: "icon", wantErr: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(dir, tt.profile)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantTest, got.TestBuildName())
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := writeProfiles(t, map[string]string{
+		"b.yml":     "description: B\n",
+		"a.yml":     "description: A\n",
+		"README.md": "not a profile\n",
+	})
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "c.yml"), 0o755))
+
+	got, err := List(dir)
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, p := range got {
+		names = append(names, p.Name+": "+p.Description)
+	}
+	assert.Equal(t, []string{"a: A", "b: B"}, names)
+}
+
+func TestRepositoryProfiles(t *testing.T) {
+	profiles, err := List(filepath.Join("..", "..", "profiles"))
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, profiles)
+	for _, p := range profiles {
+		assert.NotEmpty(t, p.Description, p.Name)
+	}
+}
+
+func TestProfile_Apply(t *testing.T) {
+	opts := &plan.Options{
+		EmacsRepo:     "emacs-mirror/emacs",
+		TestBuildType: plan.Prerelease,
+		BuildOptions: &plan.BuildOptions{
+			XWidgets:      boolPtr(true),
+			ConfigureArgs: []string{"--a"},
+		},
+	}
+
+	p := &Profile{
+		Name:         "xw",
+		BuildVariant: 3,
+		TestBuild:    &TestBuild{Type: plan.Draft},
+		Options: &plan.BuildOptions{
+			NativeComp:    boolPtr(false),
+			XWidgets:      boolPtr(false),
+			ConfigureArgs: []string{"--b"},
+		},
+	}
+	p.Apply(opts)
+
+	assert.Equal(t, &plan.Options{
+		EmacsRepo:     "emacs-mirror/emacs",
+		Profile:       "xw",
+		BuildVariant:  3,
+		TestBuild:     "xw",
+		TestBuildType: plan.Draft,
+		BuildOptions: &plan.BuildOptions{
+			NativeComp:    boolPtr(false),
+			XWidgets:      boolPtr(false),
+			ConfigureArgs: []string{"--a", "--b"},
+		},
+	}, opts)
+}
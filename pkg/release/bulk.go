@@ -1,38 +1,257 @@
 package release
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"regexp"
+	"text/template"
 
 	"github.com/google/go-github/v35/github"
 	"github.com/hashicorp/go-hclog"
 	"github.com/jimeh/build-emacs-for-macos/pkg/gh"
 	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	"github.com/jimeh/build-emacs-for-macos/pkg/source"
 )
 
 type BulkOptions struct {
-	Repository  *repository.Repository
+	Repository *repository.Repository
+
+	// NamePattern is a regexp pattern which release names must match.
 	NamePattern string
-	Prerelease  *bool
+
+	// Channels limits changes to releases with tag names which are versions
+	// of given channels. When empty, releases are not filtered by channel.
+	Channels []Channel
+
+	// Prerelease changes the prerelease flag of releases when set.
+	Prerelease *bool
+
+	// Draft changes the draft state of releases when set. Setting it to false
+	// publishes draft releases.
+	Draft *bool
+
+	// TitleTemplate is a Go template which release titles are set to. It is
+	// rendered with the release's Name, Tag, Title, and Version, which is nil
+	// if the tag is not a valid version.
+	TitleTemplate string
+
+	// Sources maps release tag names to the source they were built from, as
+	// found in build plans. The bodies of releases found in Sources are
+	// regenerated, keeping their build log link.
+	Sources map[string]*source.Source
+
+	// BodyTemplate and Changelog are used to regenerate release bodies, like
+	// the PublishOptions of the same name.
+	BodyTemplate string
+	Changelog    bool
+
+	// Store is used to find previous releases for changelogs. Defaults to
+	// GitHub Releases of Repository.
+	Store Store
+
+	// RemoveAssets is a regexp pattern of asset filenames to delete.
+	RemoveAssets string
+
+	// RenameAssets is a regexp pattern of asset filenames to rename to
+	// RenameAssetsTo, which may refer to submatches like "$1".
+	RenameAssets   string
+	RenameAssetsTo string
+
 	DryRun      bool
 	GithubToken string
 }
 
+// bulkEdit holds the changes to make to a single release.
+type bulkEdit struct {
+	release *github.RepositoryRelease
+	changes []interface{}
+	remove  []*github.ReleaseAsset
+	rename  map[*github.ReleaseAsset]string
+}
+
+type bulker struct {
+	opts          *BulkOptions
+	store         Store
+	nameMatcher   *regexp.Regexp
+	titleTemplate *template.Template
+	removeMatcher *regexp.Regexp
+	renameMatcher *regexp.Regexp
+	channels      map[Channel]bool
+}
+
+func newBulker(opts *BulkOptions) (*bulker, error) {
+	b := &bulker{opts: opts, channels: map[Channel]bool{}}
+	if opts.Store != nil {
+		b.store = &listCachingStore{Store: opts.Store}
+	}
+
+	var err error
+	b.nameMatcher, err = regexp.Compile(opts.NamePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.TitleTemplate != "" {
+		b.titleTemplate, err = template.New("title").Parse(opts.TitleTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Body templates are parsed when rendering, but checked up front.
+	if opts.BodyTemplate != "" {
+		_, err = ParseBodyTemplate(opts.BodyTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.RemoveAssets != "" {
+		b.removeMatcher, err = regexp.Compile(opts.RemoveAssets)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.RenameAssets != "" {
+		b.renameMatcher, err = regexp.Compile(opts.RenameAssets)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range opts.Channels {
+		b.channels[c] = true
+	}
+
+	return b, nil
+}
+
+// match reports if a release is selected by the name pattern and channels.
+func (s *bulker) match(r *github.RepositoryRelease) bool {
+	if !s.nameMatcher.MatchString(r.GetName()) {
+		return false
+	}
+	if len(s.channels) == 0 {
+		return true
+	}
+
+	v, err := ParseVersion(r.GetTagName())
+
+	return err == nil && s.channels[v.Channel]
+}
+
+// edit returns the changes to make to a release, applied to a copy of it.
+func (s *bulker) edit(
+	ctx context.Context,
+	r *github.RepositoryRelease,
+) (*bulkEdit, error) {
+	rel := *r
+	e := &bulkEdit{release: &rel, rename: map[*github.ReleaseAsset]string{}}
+
+	if s.opts.Prerelease != nil && rel.GetPrerelease() != *s.opts.Prerelease {
+		e.changes = append(e.changes, "prerelease", *s.opts.Prerelease)
+		rel.Prerelease = s.opts.Prerelease
+	}
+
+	if s.opts.Draft != nil && rel.GetDraft() != *s.opts.Draft {
+		e.changes = append(e.changes, "draft", *s.opts.Draft)
+		rel.Draft = s.opts.Draft
+	}
+
+	if s.titleTemplate != nil {
+		title, err := s.title(&rel)
+		if err != nil {
+			return nil, err
+		}
+		if title != rel.GetName() {
+			e.changes = append(e.changes, "title", title)
+			rel.Name = &title
+		}
+	}
+
+	if src, ok := s.opts.Sources[rel.GetTagName()]; ok {
+		body, err := releaseBody(ctx, s.store, &PublishOptions{
+			Repository:   s.opts.Repository,
+			ReleaseName:  rel.GetTagName(),
+			Source:       src,
+			BodyTemplate: s.opts.BodyTemplate,
+			Changelog:    s.opts.Changelog,
+			GithubToken:  s.opts.GithubToken,
+		}, bodyBuildLogURL(rel.GetBody()))
+		if err != nil {
+			return nil, err
+		}
+		if body != "" && body != rel.GetBody() {
+			e.changes = append(e.changes, "body", "regenerated")
+			rel.Body = &body
+		}
+	}
+
+	for _, a := range rel.Assets {
+		name := a.GetName()
+		switch {
+		case s.removeMatcher != nil && s.removeMatcher.MatchString(name):
+			e.remove = append(e.remove, a)
+		case s.renameMatcher != nil && s.renameMatcher.MatchString(name):
+			newName := s.renameMatcher.ReplaceAllString(
+				name, s.opts.RenameAssetsTo,
+			)
+			if newName != name {
+				e.rename[a] = newName
+			}
+		}
+	}
+
+	return e, nil
+}
+
+func (s *bulker) title(r *github.RepositoryRelease) (string, error) {
+	data := struct {
+		Name    string
+		Tag     string
+		Title   string
+		Version *Version
+	}{
+		Name:  r.GetName(),
+		Tag:   r.GetTagName(),
+		Title: r.GetName(),
+	}
+	if v, err := ParseVersion(r.GetTagName()); err == nil {
+		data.Version = v
+	}
+
+	var buf bytes.Buffer
+	err := s.titleTemplate.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("%w: title template: %w", Err, err)
+	}
+
+	return buf.String(), nil
+}
+
 func Bulk(ctx context.Context, opts *BulkOptions) error {
 	logger := hclog.FromContext(ctx).Named("release")
 	gh := gh.New(ctx, opts.GithubToken)
+	owner, repo := opts.Repository.Owner(), opts.Repository.Name()
 
-	nameMatcher, err := regexp.Compile(opts.NamePattern)
+	b, err := newBulker(opts)
 	if err != nil {
 		return err
 	}
+	if b.store == nil {
+		b.store = &listCachingStore{
+			Store: NewGitHubStore(ctx, opts.Repository, opts.GithubToken),
+		}
+	}
 
 	nextPage := 1
 	lastPage := 1
 
 	for nextPage <= lastPage {
 		releases, resp, err := gh.Repositories.ListReleases(
-			ctx, opts.Repository.Owner(), opts.Repository.Name(),
+			ctx, owner, repo,
 			&github.ListOptions{
 				Page:    nextPage,
 				PerPage: 100,
@@ -46,32 +265,26 @@ func Bulk(ctx context.Context, opts *BulkOptions) error {
 		lastPage = resp.LastPage
 
 		for _, r := range releases {
-			if !nameMatcher.MatchString(r.GetName()) {
+			if !b.match(r) {
 				continue
 			}
 
-			logger.Info("match found", "release", r.GetName())
+			logArgs := []interface{}{"release", r.GetName()}
+			if v, err := ParseVersion(r.GetTagName()); err == nil {
+				logArgs = append(logArgs,
+					"version", v.String(), "channel", v.Channel,
+				)
+			}
+			logger.Info("match found", logArgs...)
 
-			var changes []interface{}
-			if opts.Prerelease != nil && r.GetPrerelease() != *opts.Prerelease {
-				changes = append(changes, "prerelease", *opts.Prerelease)
-				r.Prerelease = opts.Prerelease
+			e, err := b.edit(ctx, r)
+			if err != nil {
+				return err
 			}
 
-			if len(changes) > 0 {
-				changes = append(
-					[]interface{}{"release", r.GetName()}, changes...,
-				)
-				logger.Info("modifying", changes...)
-				if !opts.DryRun {
-					_, _, err = gh.Repositories.EditRelease(
-						ctx, opts.Repository.Owner(), opts.Repository.Name(),
-						r.GetID(), r,
-					)
-					if err != nil {
-						return err
-					}
-				}
+			err = applyBulkEdit(ctx, gh, opts, e)
+			if err != nil {
+				return err
 			}
 		}
 
@@ -82,3 +295,84 @@ func Bulk(ctx context.Context, opts *BulkOptions) error {
 
 	return nil
 }
+
+// listCachingStore is a Store which only lists releases once, as changelogs
+// of every edited release need the list of all releases.
+type listCachingStore struct {
+	Store
+	releases []*Release
+}
+
+func (s *listCachingStore) List(ctx context.Context) ([]*Release, error) {
+	if s.releases == nil {
+		releases, err := s.Store.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.releases = releases
+	}
+
+	return s.releases, nil
+}
+
+func applyBulkEdit(
+	ctx context.Context,
+	gh *github.Client,
+	opts *BulkOptions,
+	e *bulkEdit,
+) error {
+	logger := hclog.FromContext(ctx).Named("release")
+	owner, repo := opts.Repository.Owner(), opts.Repository.Name()
+	r := e.release
+
+	if len(e.changes) > 0 {
+		changes := append(
+			[]interface{}{"release", r.GetName()}, e.changes...,
+		)
+		logger.Info("modifying", changes...)
+		if !opts.DryRun {
+			_, _, err := gh.Repositories.EditRelease(
+				ctx, owner, repo, r.GetID(), r,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, a := range e.remove {
+		logger.Info("removing asset",
+			"release", r.GetName(), "asset", a.GetName(),
+		)
+		if !opts.DryRun {
+			_, err := gh.Repositories.DeleteReleaseAsset(
+				ctx, owner, repo, a.GetID(),
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, a := range r.Assets {
+		newName, ok := e.rename[a]
+		if !ok {
+			continue
+		}
+
+		logger.Info("renaming asset",
+			"release", r.GetName(), "asset", a.GetName(), "name", newName,
+		)
+		if !opts.DryRun {
+			_, _, err := gh.Repositories.EditReleaseAsset(
+				ctx, owner, repo, a.GetID(),
+				&github.ReleaseAsset{Name: &newName},
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,319 @@
+package release
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	"github.com/jimeh/build-emacs-for-macos/pkg/source"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulker_match(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     *BulkOptions
+		release  string
+		expected bool
+	}{
+		{
+			name:     "empty pattern",
+			opts:     &BulkOptions{},
+			release:  "Emacs-29.4",
+			expected: true,
+		},
+		{
+			name:     "pattern mismatch",
+			opts:     &BulkOptions{NamePattern: `^Emacs\.`},
+			release:  "Emacs-29.4",
+			expected: false,
+		},
+		{
+			name:     "channel match",
+			opts:     &BulkOptions{Channels: []Channel{Stable}},
+			release:  "Emacs-29.4",
+			expected: true,
+		},
+		{
+			name:     "channel mismatch",
+			opts:     &BulkOptions{Channels: []Channel{Nightly}},
+			release:  "Emacs-29.4",
+			expected: false,
+		},
+		{
+			name: "multiple channels",
+			opts: &BulkOptions{
+				Channels: []Channel{Stable, Nightly},
+			},
+			release:  "Emacs.2024-06-30.abcdef0.master",
+			expected: true,
+		},
+		{
+			name:     "channel with invalid version",
+			opts:     &BulkOptions{Channels: []Channel{Nightly}},
+			release:  "Emacs.test-build",
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := newBulker(tt.opts)
+			require.NoError(t, err)
+
+			got := b.match(&github.RepositoryRelease{
+				Name:    github.String(tt.release),
+				TagName: github.String(tt.release),
+			})
+
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestBulker_edit(t *testing.T) {
+	repo, err := repository.NewGitHub("emacs-mirror/emacs")
+	require.NoError(t, err)
+	builds, err := repository.NewGitHub("jimeh/emacs-builds")
+	require.NoError(t, err)
+
+	sha := "abcdef0123456789abcdef0123456789abcdef01"
+	src := &source.Source{
+		Ref:        "master",
+		Repository: repo,
+		Commit:     &commit.Commit{SHA: sha},
+	}
+	oldBody := "### Build Details\n\n" +
+		"- Build Log: " + builds.ActionRunURL("42") + " (available for 90 days)"
+
+	tests := []struct {
+		name        string
+		opts        *BulkOptions
+		release     *github.RepositoryRelease
+		wantChanges []interface{}
+		wantRemove  []string
+		wantRename  map[string]string
+		wantTitle   string
+		wantBody    string
+	}{
+		{
+			name: "no changes",
+			opts: &BulkOptions{Prerelease: boolPtr(true)},
+			release: &github.RepositoryRelease{
+				Name:       github.String("Emacs-30.0.90"),
+				TagName:    github.String("Emacs-30.0.90"),
+				Prerelease: github.Bool(true),
+			},
+			wantTitle: "Emacs-30.0.90",
+		},
+		{
+			name: "prerelease and draft",
+			opts: &BulkOptions{
+				Prerelease: boolPtr(false),
+				Draft:      boolPtr(false),
+			},
+			release: &github.RepositoryRelease{
+				Name:       github.String("Emacs-29.4"),
+				TagName:    github.String("Emacs-29.4"),
+				Prerelease: github.Bool(true),
+				Draft:      github.Bool(true),
+			},
+			wantChanges: []interface{}{"prerelease", false, "draft", false},
+			wantTitle:   "Emacs-29.4",
+		},
+		{
+			name: "title template",
+			opts: &BulkOptions{
+				TitleTemplate: "Emacs {{ .Version }} ({{ .Version.Channel }})",
+			},
+			release: &github.RepositoryRelease{
+				Name:    github.String("Emacs.2024-06-30.abcdef0.master"),
+				TagName: github.String("Emacs.2024-06-30.abcdef0.master"),
+			},
+			wantChanges: []interface{}{
+				"title", "Emacs 2024-06-30.abcdef0.master (nightly)",
+			},
+			wantTitle: "Emacs 2024-06-30.abcdef0.master (nightly)",
+		},
+		{
+			name: "title template with invalid version",
+			opts: &BulkOptions{
+				TitleTemplate: "{{ with .Version }}{{ . }}" +
+					"{{ else }}{{ .Title }}{{ end }}",
+			},
+			release: &github.RepositoryRelease{
+				Name:    github.String("Test Build"),
+				TagName: github.String("Emacs.test-build"),
+			},
+			wantTitle: "Test Build",
+		},
+		{
+			name: "body regenerated with build log kept",
+			opts: &BulkOptions{
+				Sources: map[string]*source.Source{
+					"Emacs.2024-06-30.abcdef0.master": src,
+				},
+			},
+			release: &github.RepositoryRelease{
+				Name:    github.String("Emacs.2024-06-30.abcdef0.master"),
+				TagName: github.String("Emacs.2024-06-30.abcdef0.master"),
+				Body:    github.String(oldBody),
+			},
+			wantChanges: []interface{}{"body", "regenerated"},
+			wantTitle:   "Emacs.2024-06-30.abcdef0.master",
+			wantBody: "### Build Details\n\n" +
+				"- Source: https://github.com/emacs-mirror/emacs/" +
+				"tree/master\n" +
+				"- Commit: https://github.com/emacs-mirror/emacs/commit/" +
+				sha + " (`" + sha + "`)\n" +
+				"- Tarball: https://github.com/emacs-mirror/emacs/tarball/" +
+				sha + "\n" +
+				"- Build Log: https://github.com/jimeh/emacs-builds/" +
+				"actions/runs/42 (available for 90 days)",
+		},
+		{
+			name: "assets",
+			opts: &BulkOptions{
+				RemoveAssets:   `\.sha256$`,
+				RenameAssets:   `^Emacs\.(.+)\.dmg$`,
+				RenameAssetsTo: "Emacs-$1.dmg",
+			},
+			release: &github.RepositoryRelease{
+				Name:    github.String("Emacs-29.4"),
+				TagName: github.String("Emacs-29.4"),
+				Assets: []*github.ReleaseAsset{
+					{Name: github.String("Emacs.29.4.arm64.dmg")},
+					{Name: github.String("Emacs.29.4.arm64.dmg.sha256")},
+					{Name: github.String("Emacs-29.4.tar.gz")},
+				},
+			},
+			wantRemove: []string{"Emacs.29.4.arm64.dmg.sha256"},
+			wantRename: map[string]string{
+				"Emacs.29.4.arm64.dmg": "Emacs-29.4.arm64.dmg",
+			},
+			wantTitle: "Emacs-29.4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := newBulker(tt.opts)
+			require.NoError(t, err)
+
+			orig := *tt.release
+			e, err := b.edit(context.Background(), tt.release)
+			require.NoError(t, err)
+
+			assert.Equal(t, orig, *tt.release, "release was modified")
+			assert.Equal(t, tt.wantChanges, e.changes)
+			assert.Equal(t, tt.wantTitle, e.release.GetName())
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, e.release.GetBody())
+			}
+
+			var remove []string
+			for _, a := range e.remove {
+				remove = append(remove, a.GetName())
+			}
+			assert.Equal(t, tt.wantRemove, remove)
+
+			rename := map[string]string{}
+			for a, name := range e.rename {
+				rename[a.GetName()] = name
+			}
+			if tt.wantRename == nil {
+				tt.wantRename = map[string]string{}
+			}
+			assert.Equal(t, tt.wantRename, rename)
+		})
+	}
+}
+
+func TestNewBulker_invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *BulkOptions
+	}{
+		{name: "name pattern", opts: &BulkOptions{NamePattern: "("}},
+		{name: "title template", opts: &BulkOptions{TitleTemplate: "{{"}},
+		{name: "remove assets", opts: &BulkOptions{RemoveAssets: "["}},
+		{name: "rename assets", opts: &BulkOptions{RenameAssets: "["}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newBulker(tt.opts)
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestBulker_edit_changelog(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Jane Doe",
+			"GIT_AUTHOR_EMAIL=jane@example.com",
+			"GIT_COMMITTER_NAME=Jane Doe",
+			"GIT_COMMITTER_EMAIL=jane@example.com",
+		)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "--quiet", "--initial-branch=master")
+	git("commit", "--quiet", "--allow-empty", "-m", "First")
+	base := git("rev-parse", "HEAD")
+	git("commit", "--quiet", "--allow-empty", "-m", "Second")
+	head := git("rev-parse", "HEAD")
+
+	repo, err := repository.NewLocal(dir)
+	require.NoError(t, err)
+
+	store, err := NewDirStore(t.TempDir(), "")
+	require.NoError(t, err)
+	dmg := filepath.Join(t.TempDir(), "Emacs.dmg")
+	require.NoError(t, os.WriteFile(dmg, []byte("dmg"), 0o644))
+	prev := "Emacs.2024-06-29." + base[:7] + ".master"
+	_, err = store.Publish(ctx, &Release{Name: prev}, []string{dmg},
+		&PublishOptions{},
+	)
+	require.NoError(t, err)
+
+	name := "Emacs.2024-06-30." + head[:7] + ".master"
+	b, err := newBulker(&BulkOptions{
+		Sources: map[string]*source.Source{name: {
+			Ref:        "master",
+			Repository: repo,
+			Commit:     &commit.Commit{SHA: head},
+		}},
+		BodyTemplate: "{{ .Changes.Previous }}:" +
+			"{{ range .Changes.Commits }} {{ .Commit.Message }}{{ end }}",
+		Changelog: true,
+		Store:     store,
+	})
+	require.NoError(t, err)
+
+	e, err := b.edit(ctx, &github.RepositoryRelease{
+		Name:    github.String(name),
+		TagName: github.String(name),
+		Body:    github.String("old"),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []interface{}{"body", "regenerated"}, e.changes)
+	assert.Equal(t, prev+": Second", e.release.GetBody())
+}
@@ -2,21 +2,30 @@ package release
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
 	"path/filepath"
 	"strings"
 
 	"github.com/hashicorp/go-hclog"
-	"github.com/jimeh/build-emacs-for-macos/pkg/gh"
 	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
 )
 
+//nolint:golint
+var (
+	ErrNotFound      = fmt.Errorf("%w: not found", Err)
+	ErrMissingAssets = fmt.Errorf("%w: missing assets", Err)
+)
+
 type CheckOptions struct {
-	// Repository is the GitHub repository to check.
+	// Store is the release store to check. Defaults to GitHub Releases of
+	// Repository.
+	Store Store
+
+	// Repository is the GitHub repository to check, when Store is not set.
 	Repository *repository.Repository
 
-	// ReleaseName is the name of the GitHub Release to check.
+	// ReleaseName is the name of the release to check.
 	ReleaseName string
 
 	// AssetFiles is a list of files which must all exist in the release for
@@ -27,22 +36,18 @@ type CheckOptions struct {
 	GithubToken string
 }
 
-// Check checks if a GitHub repository has a Release by given name, and if the
+// Check checks if a release store has a release by given name, and if the
 // release contains assets with given filenames.
 func Check(ctx context.Context, opts *CheckOptions) error {
 	logger := hclog.FromContext(ctx).Named("release")
-	gh := gh.New(ctx, opts.GithubToken)
 
-	repo := opts.Repository
+	store := opts.Store
+	if store == nil {
+		store = NewGitHubStore(ctx, opts.Repository, opts.GithubToken)
+	}
 
-	release, resp, err := gh.Repositories.GetReleaseByTag(
-		ctx, repo.Owner(), repo.Name(), opts.ReleaseName,
-	)
+	release, err := store.Get(ctx, opts.ReleaseName)
 	if err != nil {
-		if resp.StatusCode == http.StatusNotFound {
-			return fmt.Errorf("release %s does not exist", opts.ReleaseName)
-		}
-
 		return err
 	}
 
@@ -52,13 +57,9 @@ func Check(ctx context.Context, opts *CheckOptions) error {
 	for _, filename := range opts.AssetFiles {
 		filename = filepath.Base(filename)
 		missingMap[filename] = true
-		for _, a := range release.Assets {
-			if a.GetName() == filename {
-				logger.Info("asset exists", "filename", filename)
-				delete(missingMap, filename)
-
-				break
-			}
+		if release.Asset(filename) != nil {
+			logger.Info("asset exists", "filename", filename)
+			delete(missingMap, filename)
 		}
 	}
 
@@ -74,7 +75,21 @@ func Check(ctx context.Context, opts *CheckOptions) error {
 	logger.Error("missing assets", "filenames", missing)
 
 	return fmt.Errorf(
-		"release %s is missing assets:\n- %s",
-		opts.ReleaseName, strings.Join(missing, "\n-"),
+		"%w: %s:\n- %s",
+		ErrMissingAssets, opts.ReleaseName, strings.Join(missing, "\n-"),
 	)
 }
+
+// Exists reports if a release and all given asset files exist, like Check,
+// but only returns an error if the check itself failed.
+func Exists(ctx context.Context, opts *CheckOptions) (bool, error) {
+	err := Check(ctx, opts)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrMissingAssets):
+		return false, nil
+	default:
+		return false, err
+	}
+}
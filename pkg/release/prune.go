@@ -0,0 +1,361 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/gh"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+)
+
+// RetentionPolicy decides which releases of a channel are kept when pruning.
+// Releases not kept by any rule are deleted.
+type RetentionPolicy struct {
+	// KeepLast is the number of most recent releases kept per channel.
+	KeepLast int
+
+	// WeeklyAfter keeps the most recent release of each week, for releases
+	// older than given age. Disabled when zero.
+	WeeklyAfter time.Duration
+
+	// MonthlyAfter keeps the most recent release of each month, for releases
+	// older than given age. It takes precedence over WeeklyAfter for
+	// releases older than both. Disabled when zero.
+	MonthlyAfter time.Duration
+}
+
+// Candidate is a release which may be pruned.
+type Candidate struct {
+	ID      int64
+	Name    string
+	Tag     string
+	Date    time.Time
+	Assets  int
+	Version *Version
+}
+
+// Decision is the outcome of applying a retention policy to a release.
+type Decision struct {
+	Release *Candidate
+	Keep    bool
+	Reason  string
+}
+
+// Apply decides which releases to keep and which to delete, treating each
+// channel separately. Releases named in protected are always kept. Decisions
+// are returned ordered by channel, and then from newest to oldest.
+func (s *RetentionPolicy) Apply(
+	now time.Time,
+	releases []*Candidate,
+	protected map[string]bool,
+) []*Decision {
+	channels := map[Channel][]*Candidate{}
+	for _, r := range releases {
+		c := r.Version.Channel
+		channels[c] = append(channels[c], r)
+	}
+
+	names := make([]string, 0, len(channels))
+	for c := range channels {
+		names = append(names, string(c))
+	}
+	sort.Strings(names)
+
+	decisions := []*Decision{}
+	for _, c := range names {
+		decisions = append(
+			decisions, s.applyChannel(now, channels[Channel(c)], protected)...,
+		)
+	}
+
+	return decisions
+}
+
+func (s *RetentionPolicy) applyChannel(
+	now time.Time,
+	releases []*Candidate,
+	protected map[string]bool,
+) []*Decision {
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].Version.Compare(releases[j].Version) > 0
+	})
+
+	decisions := make([]*Decision, 0, len(releases))
+	seen := map[string]bool{}
+	for i, r := range releases {
+		d := &Decision{Release: r, Keep: true}
+		decisions = append(decisions, d)
+
+		age := now.Sub(r.Date)
+		var period string
+		switch {
+		case s.MonthlyAfter > 0 && age >= s.MonthlyAfter:
+			period = "month " + r.Date.Format("2006-01")
+		case s.WeeklyAfter > 0 && age >= s.WeeklyAfter:
+			year, week := r.Date.ISOWeek()
+			period = fmt.Sprintf("week %d-W%02d", year, week)
+		}
+
+		switch {
+		case protected[r.Name] || protected[r.Tag]:
+			d.Reason = "referenced by cask"
+		case i < s.KeepLast:
+			d.Reason = fmt.Sprintf("within last %d", s.KeepLast)
+		case period != "" && !seen[period]:
+			d.Reason = "latest of " + period
+		default:
+			d.Keep = false
+			d.Reason = "not retained"
+		}
+
+		// Releases kept for any reason represent their period, so a
+		// protected release does not cause another one to be kept.
+		if d.Keep && period != "" {
+			seen[period] = true
+		}
+	}
+
+	return decisions
+}
+
+type PruneOptions struct {
+	// Repository is the GitHub repository to prune releases from.
+	Repository *repository.Repository
+
+	// Channels are the release channels to prune, defaults to nightly.
+	Channels []Channel
+
+	// Policy decides which releases are kept.
+	Policy *RetentionPolicy
+
+	// TapRepository is a GitHub repository of a Homebrew tap. Releases
+	// referenced by the casks in its Casks directory are never deleted.
+	TapRepository *repository.Repository
+
+	// TapRef is the git ref to read casks from, defaults to the tap
+	// repository's default branch.
+	TapRef string
+
+	// DryRun lists releases which would be deleted without deleting them.
+	DryRun bool
+
+	// Output receives a table of releases which are, or would be deleted.
+	Output io.Writer
+
+	// GitHubToken is the OAuth token used to talk to the GitHub API.
+	GithubToken string
+}
+
+// Prune deletes releases and their tags from a GitHub repository, according
+// to a retention policy. Releases which are drafts, or have names which are
+// not valid versions, like test builds, are never deleted.
+func Prune(ctx context.Context, opts *PruneOptions) error {
+	logger := hclog.FromContext(ctx).Named("release")
+	gh := gh.New(ctx, opts.GithubToken)
+	repo := opts.Repository
+
+	channels := opts.Channels
+	if len(channels) == 0 {
+		channels = []Channel{Nightly}
+	}
+
+	protected := map[string]bool{}
+	if opts.TapRepository != nil {
+		refs, err := caskReleaseReferences(
+			ctx, gh, opts.TapRepository, opts.TapRef, repo,
+		)
+		if err != nil {
+			return err
+		}
+		logger.Info("found releases referenced by casks",
+			"tap", opts.TapRepository.Source, "count", len(refs),
+		)
+		for _, name := range refs {
+			protected[name] = true
+		}
+	}
+
+	candidates, err := pruneCandidates(ctx, gh, repo, channels)
+	if err != nil {
+		return err
+	}
+
+	decisions := opts.Policy.Apply(time.Now(), candidates, protected)
+	var deletions []*Decision
+	for _, d := range decisions {
+		logger.Debug("retention decision",
+			"release", d.Release.Name, "keep", d.Keep, "reason", d.Reason,
+		)
+		if !d.Keep {
+			deletions = append(deletions, d)
+		}
+	}
+	logger.Info("applied retention policy",
+		"releases", len(decisions), "delete", len(deletions),
+	)
+
+	if opts.Output != nil {
+		err = writePruneTable(opts.Output, deletions)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+
+	for _, d := range deletions {
+		r := d.Release
+		logger.Info("deleting release", "release", r.Name, "tag", r.Tag)
+		_, err = gh.Repositories.DeleteRelease(
+			ctx, repo.Owner(), repo.Name(), r.ID,
+		)
+		if err != nil {
+			return err
+		}
+
+		resp, err := gh.Git.DeleteRef(
+			ctx, repo.Owner(), repo.Name(), "tags/"+r.Tag,
+		)
+		if err != nil &&
+			(resp == nil || resp.StatusCode != http.StatusUnprocessableEntity) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pruneCandidates lists all published releases of given channels.
+func pruneCandidates(
+	ctx context.Context,
+	gh *github.Client,
+	repo *repository.Repository,
+	channels []Channel,
+) ([]*Candidate, error) {
+	include := map[Channel]bool{}
+	for _, c := range channels {
+		include[c] = true
+	}
+
+	candidates := []*Candidate{}
+	opts := &github.ListOptions{Page: 1, PerPage: 100}
+	for {
+		releases, resp, err := gh.Repositories.ListReleases(
+			ctx, repo.Owner(), repo.Name(), opts,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range releases {
+			if r.GetDraft() {
+				continue
+			}
+			v, err := ParseVersion(r.GetName())
+			if err != nil || !include[v.Channel] {
+				continue
+			}
+
+			date := r.GetPublishedAt().Time
+			if date.IsZero() {
+				date = r.GetCreatedAt().Time
+			}
+			candidates = append(candidates, &Candidate{
+				ID:      r.GetID(),
+				Name:    r.GetName(),
+				Tag:     r.GetTagName(),
+				Date:    date,
+				Assets:  len(r.Assets),
+				Version: v,
+			})
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return candidates, nil
+}
+
+// caskReleaseReferences returns the names of releases in the builds
+// repository which casks in the tap repository download assets from.
+func caskReleaseReferences(
+	ctx context.Context,
+	gh *github.Client,
+	tap *repository.Repository,
+	ref string,
+	builds *repository.Repository,
+) ([]string, error) {
+	getOpts := &github.RepositoryContentGetOptions{Ref: ref}
+	_, dir, _, err := gh.Repositories.GetContents(
+		ctx, tap.Owner(), tap.Name(), "Casks", getOpts,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := []string{}
+	for _, entry := range dir {
+		if entry.GetType() != "file" ||
+			path.Ext(entry.GetName()) != ".rb" {
+			continue
+		}
+
+		file, _, _, err := gh.Repositories.GetContents(
+			ctx, tap.Owner(), tap.Name(), entry.GetPath(), getOpts,
+		)
+		if err != nil {
+			return nil, err
+		}
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, err
+		}
+
+		refs = append(refs, CaskReleaseNames(content, builds)...)
+	}
+
+	return refs, nil
+}
+
+// CaskReleaseNames returns the tag names of releases of given GitHub
+// repository, which a cask file downloads assets from.
+func CaskReleaseNames(cask string, repo *repository.Repository) []string {
+	matcher := regexp.MustCompile(
+		`github\.com/` + regexp.QuoteMeta(repo.Source) +
+			`/releases/download/([^/"'\s]+)/`,
+	)
+
+	names := []string{}
+	for _, m := range matcher.FindAllStringSubmatch(cask, -1) {
+		names = append(names, m[1])
+	}
+
+	return names
+}
+
+func writePruneTable(w io.Writer, deletions []*Decision) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RELEASE\tCHANNEL\tDATE\tASSETS")
+	for _, d := range deletions {
+		r := d.Release
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n",
+			r.Name, r.Version.Channel, r.Date.Format("2006-01-02"), r.Assets,
+		)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,150 @@
+package release
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionPolicy_Apply(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	// Daily nightlies from 2024-01-01 up to and including 2024-06-30, plus
+	// a couple of stable releases.
+	var releases []*Candidate
+	for d := 0; d < 182; d++ {
+		date := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC).AddDate(0, 0, d)
+		name := fmt.Sprintf(
+			"Emacs.%s.%07x.master", date.Format("2006-01-02"), d,
+		)
+		v, err := ParseVersion(name)
+		require.NoError(t, err)
+		releases = append(releases, &Candidate{
+			Name: name, Tag: name, Date: date, Version: v,
+		})
+	}
+	for _, name := range []string{"Emacs-29.3", "Emacs-29.4"} {
+		v, err := ParseVersion(name)
+		require.NoError(t, err)
+		releases = append(releases, &Candidate{
+			Name: name, Tag: name, Date: now.AddDate(-1, 0, 0), Version: v,
+		})
+	}
+
+	policy := &RetentionPolicy{
+		KeepLast:     7,
+		WeeklyAfter:  30 * 24 * time.Hour,
+		MonthlyAfter: 90 * 24 * time.Hour,
+	}
+	protected := map[string]bool{
+		"Emacs.2024-06-10.00000a1.master": true,
+		"Emacs-29.3":                      true,
+	}
+
+	decisions := policy.Apply(now, releases, protected)
+	require.Len(t, decisions, len(releases))
+
+	kept := map[string]string{}
+	for _, d := range decisions {
+		if d.Keep {
+			kept[d.Release.Name] = d.Reason
+		}
+	}
+
+	assert.Equal(t, map[string]string{
+		// Channels are pruned separately.
+		"Emacs-29.4": "within last 7",
+		"Emacs-29.3": "referenced by cask",
+
+		// Last 7 nightlies.
+		"Emacs.2024-06-30.00000b5.master": "within last 7",
+		"Emacs.2024-06-29.00000b4.master": "within last 7",
+		"Emacs.2024-06-28.00000b3.master": "within last 7",
+		"Emacs.2024-06-27.00000b2.master": "within last 7",
+		"Emacs.2024-06-26.00000b1.master": "within last 7",
+		"Emacs.2024-06-25.00000b0.master": "within last 7",
+		"Emacs.2024-06-24.00000af.master": "within last 7",
+
+		// Referenced by a cask.
+		"Emacs.2024-06-10.00000a1.master": "referenced by cask",
+
+		// Latest of each week older than 30 days.
+		"Emacs.2024-05-31.0000097.master": "latest of week 2024-W22",
+		"Emacs.2024-05-26.0000092.master": "latest of week 2024-W21",
+		"Emacs.2024-05-19.000008b.master": "latest of week 2024-W20",
+		"Emacs.2024-05-12.0000084.master": "latest of week 2024-W19",
+		"Emacs.2024-05-05.000007d.master": "latest of week 2024-W18",
+		"Emacs.2024-04-28.0000076.master": "latest of week 2024-W17",
+		"Emacs.2024-04-21.000006f.master": "latest of week 2024-W16",
+		"Emacs.2024-04-14.0000068.master": "latest of week 2024-W15",
+		"Emacs.2024-04-07.0000061.master": "latest of week 2024-W14",
+
+		// Latest of each month older than 90 days.
+		"Emacs.2024-04-01.000005b.master": "latest of month 2024-04",
+		"Emacs.2024-03-31.000005a.master": "latest of month 2024-03",
+		"Emacs.2024-02-29.000003b.master": "latest of month 2024-02",
+		"Emacs.2024-01-31.000001e.master": "latest of month 2024-01",
+	}, kept)
+}
+
+func TestRetentionPolicy_Apply_keepLastOnly(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	var releases []*Candidate
+	for _, name := range []string{
+		"Emacs.2024-06-01.0000001.master",
+		"Emacs.2024-06-03.0000003.master",
+		"Emacs.2024-06-02.0000002.master",
+	} {
+		v, err := ParseVersion(name)
+		require.NoError(t, err)
+		releases = append(releases, &Candidate{
+			Name: name, Tag: name, Date: v.Date, Version: v,
+		})
+	}
+
+	decisions := (&RetentionPolicy{KeepLast: 2}).Apply(now, releases, nil)
+
+	got := []string{}
+	for _, d := range decisions {
+		got = append(got, fmt.Sprintf("%s %t", d.Release.Name, d.Keep))
+	}
+	assert.Equal(t, []string{
+		"Emacs.2024-06-03.0000003.master true",
+		"Emacs.2024-06-02.0000002.master true",
+		"Emacs.2024-06-01.0000001.master false",
+	}, got)
+}
+
+func TestCaskReleaseNames(t *testing.T) {
+	repo, err := repository.NewGitHub("jimeh/emacs-builds")
+	require.NoError(t, err)
+
+	cask := `cask "emacs-app-nightly" do
+  version "2024-06-30.abcdef0.master"
+
+  on_arm do
+    url "https://github.com/jimeh/emacs-builds/releases/download/` +
+		`Emacs.2024-06-30.abcdef0.master/` +
+		`Emacs.2024-06-30.abcdef0.master.macOS-14.arm64.dmg"
+  end
+  on_intel do
+    url "https://github.com/jimeh/emacs-builds/releases/download/` +
+		`Emacs.2024-06-29.1234567.master/` +
+		`Emacs.2024-06-29.1234567.master.macOS-13.x86_64.dmg"
+  end
+
+  homepage "https://github.com/jimeh/other-builds/releases/download/` +
+		`Emacs-29.4/Emacs.dmg"
+end
+`
+
+	assert.Equal(t, []string{
+		"Emacs.2024-06-30.abcdef0.master",
+		"Emacs.2024-06-29.1234567.master",
+	}, CaskReleaseNames(cask, repo))
+}
@@ -3,15 +3,13 @@ package release
 import (
 	"context"
 	"fmt"
-	"net/http"
 	"os"
 	"path/filepath"
 
-	"github.com/google/go-github/v35/github"
 	"github.com/hashicorp/go-hclog"
-	"github.com/jimeh/build-emacs-for-macos/pkg/gh"
 	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
 	"github.com/jimeh/build-emacs-for-macos/pkg/source"
+	"github.com/jimeh/build-emacs-for-macos/pkg/sparkle"
 )
 
 type releaseType int
@@ -24,7 +22,12 @@ const (
 )
 
 type PublishOptions struct {
-	// Repository is the GitHub repository to publish the release on.
+	// Store is where the release is published to. Defaults to GitHub Releases
+	// of Repository.
+	Store Store
+
+	// Repository is the GitHub repository to publish the release on, when
+	// Store is not set.
 	Repository *repository.Repository
 
 	// CommitRef is the git commit ref to create the release tag on.
@@ -46,25 +49,48 @@ type PublishOptions struct {
 	// details.
 	Source *source.Source
 
+	// Plan is the build plan the asset files were built from. It is made
+	// available to body templates.
+	Plan any
+
+	// BodyTemplate is a Go template which the release body is rendered from
+	// instead of the default template, with BodyData.
+	BodyTemplate string
+
+	// Changelog adds the source commits since the previous release of the
+	// same channel to the release body.
+	Changelog bool
+
 	// AssetFiles is a list of files which must all exist in the release for
 	// the check to pass.
 	AssetFiles []string
 
-	// AssetSizeCheck causes a file size check for any existing asset files on a
-	// release which have the same filename as a asset we want to upload. If the
-	// size of the local and remote files are the same, the existing asset file
-	// is left in place. When this is false, given asset files will always be
-	// uploaded, replacing any asset files with the same filename.
+	// AssetSizeCheck causes existing asset files on a release which have the
+	// same filename as a asset we want to upload to be verified. If the size
+	// of the local and remote files are the same, and the SHA256 checksum of
+	// the local file matches the remote *.sha256 asset, or the digest of the
+	// downloaded remote file if there is no *.sha256 asset, the existing asset
+	// file is left in place. When this is false, given asset files will always
+	// be uploaded, replacing any asset files with the same filename.
 	AssetSizeCheck bool
 
+	// UploadConcurrency is the maximum number of assets uploaded in parallel,
+	// defaults to DefaultUploadConcurrency.
+	UploadConcurrency int
+
+	// UploadRetries is the number of times failed requests are retried when
+	// uploading assets, defaults to DefaultUploadRetries. Server errors,
+	// secondary rate limits and network errors are retried with exponential
+	// backoff. Negative values disable retries.
+	UploadRetries int
+
 	// GitHubToken is the OAuth token used to talk to the GitHub API.
 	GithubToken string
 }
 
-// Publish creates and publishes a GitHub release.
+// Publish creates and publishes a release to a store, GitHub by default.
 func Publish(ctx context.Context, opts *PublishOptions) error {
 	logger := hclog.FromContext(ctx).Named("release")
-	gh := gh.New(ctx, opts.GithubToken)
 
 	files, err := publishFileList(opts.AssetFiles)
 	if err != nil {
@@ -80,158 +106,36 @@ func Publish(ctx context.Context, opts *PublishOptions) error {
 	prerelease := opts.ReleaseType == Prerelease
 	draft := opts.ReleaseType == Draft
 
-	body := ""
-	if opts.Source != nil {
-		body, err = releaseBody(opts)
-		if err != nil {
-			return err
-		}
-		logger.Debug("rendered release body", "content", body)
+	store := opts.Store
+	if store == nil {
+		store = NewGitHubStore(ctx, opts.Repository, opts.GithubToken)
 	}
 
-	created := false
-	logger.Info("checking release", "tag", tagName)
-	release, resp, err := gh.Repositories.GetReleaseByTag(
-		ctx, opts.Repository.Owner(), opts.Repository.Name(), tagName,
-	)
-	if err != nil {
-		if resp.StatusCode != http.StatusNotFound {
-			return err
-		}
-
-		created = true
-		logger.Info("creating release", "tag", tagName, "name", name)
-
-		release, _, err = gh.Repositories.CreateRelease(
-			ctx, opts.Repository.Owner(), opts.Repository.Name(),
-			&github.RepositoryRelease{
-				Name:            &name,
-				TagName:         &tagName,
-				TargetCommitish: &opts.CommitRef,
-				Prerelease:      boolPtr(false),
-				Draft:           boolPtr(true),
-				Body:            &body,
-			},
+	body := ""
+	if opts.Source != nil {
+		// If running within GitHub Actions, provide link to build log.
+		body, err = releaseBody(
+			ctx, store, opts, actionsBuildLogURL(opts.Repository),
 		)
 		if err != nil {
 			return err
 		}
+		logger.Debug("rendered release body", "content", body)
 	}
 
-	err = uploadReleaseAssets(ctx, gh, release, files, opts)
+	rel, err := store.Publish(ctx, &Release{
+		Name:       tagName,
+		Title:      name,
+		Body:       body,
+		CommitRef:  opts.CommitRef,
+		Draft:      draft,
+		Prerelease: !draft && prerelease,
+	}, files, opts)
 	if err != nil {
 		return err
 	}
 
-	changed := false
-	if release.GetName() != name {
-		release.Name = &name
-		changed = true
-	}
-
-	if body != "" && release.GetBody() != body {
-		release.Body = &body
-		changed = true
-	}
-
-	if release.GetDraft() != draft {
-		release.Draft = &draft
-		changed = true
-	}
-
-	if !draft && release.GetPrerelease() != prerelease {
-		release.Prerelease = &prerelease
-		changed = true
-	}
-
-	if changed {
-		logger.Info("updating release attributes", "url", release.GetHTMLURL())
-		release, _, err = gh.Repositories.EditRelease(
-			ctx, opts.Repository.Owner(), opts.Repository.Name(),
-			release.GetID(), release,
-		)
-		if err != nil {
-			return err
-		}
-	}
-
-	if created {
-		logger.Info("release created", "url", release.GetHTMLURL())
-	} else {
-		logger.Info("release updated", "url", release.GetHTMLURL())
-	}
-
-	return nil
-}
-
-func uploadReleaseAssets(
-	ctx context.Context,
-	gh *github.Client,
-	release *github.RepositoryRelease,
-	fileNames []string,
-	opts *PublishOptions,
-) error {
-	logger := hclog.FromContext(ctx).Named("release")
-
-	for _, fileName := range fileNames {
-		logger.Debug("processing asset", "file", filepath.Base(fileName))
-
-		fileIO, err := os.Open(fileName)
-		if err != nil {
-			return err
-		}
-		defer fileIO.Close()
-
-		fileInfo, err := fileIO.Stat()
-		if err != nil {
-			return err
-		}
-
-		fileBaseName := filepath.Base(fileName)
-		assetExists := false
-
-		for _, a := range release.Assets {
-			if a.GetName() != fileBaseName {
-				continue
-			}
-
-			if opts.AssetSizeCheck && a.GetSize() == int(fileInfo.Size()) {
-				logger.Info("asset exists with correct size",
-					"file", fileBaseName,
-					"local_size", byteCountIEC(fileInfo.Size()),
-					"remote_size", byteCountIEC(int64(a.GetSize())),
-				)
-				assetExists = true
-			} else {
-				logger.Info(
-					"deleting existing asset", "file", fileBaseName,
-				)
-				_, err = gh.Repositories.DeleteReleaseAsset(
-					ctx, opts.Repository.Owner(), opts.Repository.Name(),
-					a.GetID(),
-				)
-				if err != nil {
-					return err
-				}
-			}
-		}
-
-		if !assetExists {
-			logger.Info("uploading asset",
-				"file", fileBaseName,
-				"size", byteCountIEC(fileInfo.Size()),
-			)
-			_, _, err = gh.Repositories.UploadReleaseAsset(
-				ctx, opts.Repository.Owner(), opts.Repository.Name(),
-				release.GetID(),
-				&github.UploadOptions{Name: fileBaseName},
-				fileIO,
-			)
-			if err != nil {
-				return err
-			}
-		}
-	}
+	logger.Info("release published", "name", rel.Name, "url", rel.URL)
 
 	return nil
 }
@@ -254,17 +158,19 @@ func publishFileList(files []string) ([]string, error) {
 		}
 
 		results[file] = struct{}{}
-		sumFile := file + ".sha256"
 
-		_, err = os.Stat(sumFile)
-		if err != nil {
-			if os.IsNotExist(err) {
-				continue
-			}
+		// Include checksum and update signature files of each asset.
+		for _, suffix := range []string{".sha256", sparkle.SignatureSuffix} {
+			_, err = os.Stat(file + suffix)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
 
-			return nil, err
+				return nil, err
+			}
+			results[file+suffix] = struct{}{}
 		}
-		results[sumFile] = struct{}{}
 	}
 
 	var output []string
@@ -2,9 +2,16 @@ package release
 
 import (
 	"bytes"
+	"context"
 	"os"
+	"regexp"
 	"strings"
 	"text/template"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	"github.com/jimeh/build-emacs-for-macos/pkg/source"
 )
 
 var tplFuncs = template.FuncMap{
@@ -15,7 +22,7 @@ var tplFuncs = template.FuncMap{
 	},
 }
 
-var bodyTpl = template.Must(template.New("body").Funcs(tplFuncs).Parse(`
+var bodyTpl = template.Must(ParseBodyTemplate(`
 {{- $t := "` + "`" + `" -}}
 ### Build Details
 
@@ -31,29 +38,146 @@ var bodyTpl = template.Must(template.New("body").Funcs(tplFuncs).Parse(`
 {{- end }}
 {{- with .BuildLogURL }}
 - Build Log: {{ . }} (available for 90 days)
+{{- end }}
+{{- with .Changes }}
+
+### Changes since previous build
+
+Since {{ with .PreviousURL }}[{{ $.Changes.Previous }}]({{ . }})
+{{- else }}{{ .Previous }}{{ end }}
+{{- with .CompareURL }} ([compare]({{ . }})){{ end }}:
+{{ range .Commits }}
+- {{ if .URL }}[{{ $t }}{{ .Commit.ShortSHA }}{{ $t }}]({{ .URL }})
+  {{- else }}{{ $t }}{{ .Commit.ShortSHA }}{{ $t }}{{ end }}
+  {{- " " }}{{ .Commit.Subject }}
+{{- else }}
+- No changes.
+{{- end }}
+{{- if .Truncated }}
+- Older changes not shown.
+{{- end }}
 {{- end }}`,
 ))
 
-type bodyData struct {
+var (
+	buildLogMatcher = regexp.MustCompile(`(?m)^- Build Log: (\S+)`)
+	commitMatcher   = regexp.MustCompile(
+		"(?m)^- Commit: \\S+ \\(`([0-9a-f]{7,40})`\\)",
+	)
+)
+
+// BodyData is the data release body templates are rendered with.
+type BodyData struct {
 	SourceURL   string
 	CommitSHA   string
 	CommitURL   string
 	BuildLogURL string
 	TarballURL  string
+
+	// Source is the source the release was built from.
+	Source *source.Source
+
+	// Plan is the build plan the release was built from, if available.
+	Plan any
+
+	// Changes lists the commits since the previous release of the same
+	// channel, if available.
+	Changes *Changes
 }
 
-func releaseBody(opts *PublishOptions) (string, error) {
-	src := opts.Source
+// Changes describes the commits between two releases.
+type Changes struct {
+	// Previous is the name of the previous release.
+	Previous    string
+	PreviousURL string
+
+	// CompareURL shows the changes in the source repository.
+	CompareURL string
+
+	// Commits are listed newest first.
+	Commits []*Change
+
+	// Truncated is true when there are more commits than listed.
+	Truncated bool
+}
+
+// Change is a commit within Changes.
+type Change struct {
+	Commit *commit.Commit
+	URL    string
+}
+
+// ParseBodyTemplate parses a Go template used to render release bodies with
+// BodyData.
+func ParseBodyTemplate(text string) (*template.Template, error) {
+	return template.New("body").Funcs(tplFuncs).Parse(text)
+}
+
+// actionsBuildLogURL returns the URL of the current GitHub Actions run, if
+// running within GitHub Actions.
+func actionsBuildLogURL(repo *repository.Repository) string {
+	if repo == nil {
+		return ""
+	}
+	if id := os.Getenv("GITHUB_RUN_ID"); id != "" {
+		return repo.ActionRunURL(id)
+	}
+
+	return ""
+}
+
+// releaseBody renders the body of a release, with a link to given build log
+// URL if not empty.
+func releaseBody(
+	ctx context.Context,
+	store Store,
+	opts *PublishOptions,
+	buildLogURL string,
+) (string, error) {
+	logger := hclog.FromContext(ctx).Named("release")
 
-	if src.Repository == nil || src.Commit == nil {
+	data := newBodyData(opts.Source, buildLogURL)
+	if data == nil {
 		return "", nil
 	}
+	data.Plan = opts.Plan
 
-	data := &bodyData{
-		SourceURL:  src.Repository.TreeURL(src.Ref),
-		CommitSHA:  src.Commit.SHA,
-		CommitURL:  src.Repository.CommitURL(src.Commit.SHA),
-		TarballURL: src.Repository.TarballURL(src.Commit.SHA),
+	if opts.Changelog {
+		var err error
+		data.Changes, err = releaseChanges(ctx, store, opts)
+		if err != nil {
+			logger.Warn("unable to list changes since previous release",
+				"error", err,
+			)
+		}
+	}
+
+	tpl := bodyTpl
+	if opts.BodyTemplate != "" {
+		var err error
+		tpl, err = ParseBodyTemplate(opts.BodyTemplate)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return renderBody(tpl, data)
+}
+
+// newBodyData returns the body data describing the build of given source, or
+// nil if source details are incomplete.
+func newBodyData(src *source.Source, buildLogURL string) *BodyData {
+	if src == nil || src.Repository == nil || src.Commit == nil {
+		return nil
+	}
+
+	data := &BodyData{
+		SourceURL:   src.Repository.TreeURL(src.Ref),
+		CommitSHA:   src.Commit.SHA,
+		CommitURL:   src.Repository.CommitURL(src.Commit.SHA),
+		TarballURL:  src.Repository.TarballURL(src.Commit.SHA),
+		BuildLogURL: buildLogURL,
+		Source:      src,
 	}
 
 	// If available, use the exact value from the build plan.
@@ -61,18 +185,139 @@ func releaseBody(opts *PublishOptions) (string, error) {
 		data.TarballURL = src.Tarball.URL
 	}
 
-	// If running within GitHub Actions, provide link to build log.
-	if opts.Repository != nil {
-		if id := os.Getenv("GITHUB_RUN_ID"); id != "" {
-			data.BuildLogURL = opts.Repository.ActionRunURL(id)
-		}
+	return data
+}
+
+// renderBody renders a release body, which is empty when data is nil.
+func renderBody(tpl *template.Template, data *BodyData) (string, error) {
+	if data == nil {
+		return "", nil
 	}
 
 	var buf bytes.Buffer
-	err := bodyTpl.Execute(&buf, data)
+	err := tpl.Execute(&buf, data)
 	if err != nil {
 		return "", err
 	}
 
 	return buf.String(), nil
 }
+
+// releaseChanges returns the source commits between the previous release of
+// the same channel and the release being published. It returns nil if there
+// is no previous release, or the release name is not a version.
+func releaseChanges(
+	ctx context.Context,
+	store Store,
+	opts *PublishOptions,
+) (*Changes, error) {
+	// Releases which are not versions, like test builds, have no previous
+	// release.
+	v, err := ParseVersion(opts.ReleaseName)
+	if err != nil {
+		return nil, nil
+	}
+
+	releases, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prev := previousRelease(releases, v)
+	if prev == nil {
+		return nil, nil
+	}
+
+	base := releaseCommitSHA(prev)
+	if base == "" {
+		return nil, nil
+	}
+
+	src := opts.Source
+	provider, err := repository.NewProvider(
+		ctx, src.Repository,
+		&repository.ProviderOptions{GitHubToken: opts.GithubToken},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	commits, err := provider.Commits(ctx, base, src.Commit.SHA)
+	if err != nil {
+		return nil, err
+	}
+
+	return newChanges(prev, src.Repository, base, src.Commit.SHA, commits), nil
+}
+
+func newChanges(
+	prev *Release,
+	repo *repository.Repository,
+	base, head string,
+	commits []*commit.Commit,
+) *Changes {
+	changes := &Changes{
+		Previous:    prev.Name,
+		PreviousURL: prev.URL,
+		CompareURL:  repo.CompareURL(base, head),
+		Commits:     make([]*Change, 0, len(commits)),
+		Truncated:   len(commits) >= repository.MaxCommits,
+	}
+
+	for _, c := range commits {
+		changes.Commits = append(changes.Commits, &Change{
+			Commit: c,
+			URL:    repo.CommitURL(c.SHA),
+		})
+	}
+
+	return changes
+}
+
+// previousRelease returns the newest published release which precedes given
+// version, with the same channel and variant. For nightly builds it must also
+// be built from the same git ref.
+func previousRelease(releases []*Release, v *Version) *Release {
+	var prev *Release
+	var prevVersion *Version
+	for _, r := range releases {
+		if r.Draft {
+			continue
+		}
+
+		rv, err := ParseVersion(r.Name)
+		if err != nil || rv.Channel != v.Channel || rv.Variant != v.Variant ||
+			(v.Nightly() && rv.Ref != v.Ref) || rv.Compare(v) >= 0 {
+			continue
+		}
+
+		if prevVersion == nil || rv.Compare(prevVersion) > 0 {
+			prev, prevVersion = r, rv
+		}
+	}
+
+	return prev
+}
+
+// releaseCommitSHA returns the source commit SHA a release was built from, as
+// listed in its body, or as part of its name for nightly builds.
+func releaseCommitSHA(r *Release) string {
+	if m := commitMatcher.FindStringSubmatch(r.Body); m != nil {
+		return m[1]
+	}
+
+	if v, err := ParseVersion(r.Name); err == nil && v.Nightly() {
+		return v.SHA
+	}
+
+	return ""
+}
+
+// bodyBuildLogURL returns the build log URL of a rendered release body.
+func bodyBuildLogURL(body string) string {
+	if m := buildLogMatcher.FindStringSubmatch(body); m != nil {
+		return m[1]
+	}
+
+	return ""
+}
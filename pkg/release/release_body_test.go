@@ -0,0 +1,232 @@
+package release
+
+import (
+	"testing"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	"github.com/jimeh/build-emacs-for-macos/pkg/source"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderBody(t *testing.T) {
+	repo, err := repository.NewGitHub("emacs-mirror/emacs")
+	require.NoError(t, err)
+
+	src := &source.Source{
+		Ref:        "master",
+		Repository: repo,
+		Commit: &commit.Commit{
+			SHA: "bbbbbbb123456789abcdef0123456789abcdef01",
+		},
+	}
+	prev := &Release{
+		Name: "Emacs.2024-06-29.aaaaaaa.master",
+		URL: "https://github.com/jimeh/emacs-builds/releases/tag/" +
+			"Emacs.2024-06-29.aaaaaaa.master",
+	}
+	commits := []*commit.Commit{
+		{SHA: "bbbbbbb123456789abcdef0123456789abcdef01", Message: "Fix b\n"},
+		{
+			SHA:     "ccccccc123456789abcdef0123456789abcdef01",
+			Message: "Add c\n\nMore.",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		data     *BodyData
+		want     string
+	}{
+		{
+			name: "nil data",
+			want: "",
+		},
+		{
+			name: "default with changes",
+			data: func() *BodyData {
+				d := newBodyData(src, "https://example.com/log")
+				d.Changes = newChanges(
+					prev, repo, "aaaaaaa", src.Commit.SHA, commits,
+				)
+
+				return d
+			}(),
+			want: "### Build Details\n\n" +
+				"- Source: https://github.com/emacs-mirror/emacs/" +
+				"tree/master\n" +
+				"- Commit: https://github.com/emacs-mirror/emacs/commit/" +
+				"bbbbbbb123456789abcdef0123456789abcdef01 " +
+				"(`bbbbbbb123456789abcdef0123456789abcdef01`)\n" +
+				"- Tarball: https://github.com/emacs-mirror/emacs/tarball/" +
+				"bbbbbbb123456789abcdef0123456789abcdef01\n" +
+				"- Build Log: https://example.com/log " +
+				"(available for 90 days)\n" +
+				"\n" +
+				"### Changes since previous build\n\n" +
+				"Since [Emacs.2024-06-29.aaaaaaa.master](https://github.com/" +
+				"jimeh/emacs-builds/releases/tag/" +
+				"Emacs.2024-06-29.aaaaaaa.master) ([compare](https://" +
+				"github.com/emacs-mirror/emacs/compare/aaaaaaa..." +
+				"bbbbbbb123456789abcdef0123456789abcdef01)):\n\n" +
+				"- [`bbbbbbb`](https://github.com/emacs-mirror/emacs/commit/" +
+				"bbbbbbb123456789abcdef0123456789abcdef01) Fix b\n" +
+				"- [`ccccccc`](https://github.com/emacs-mirror/emacs/commit/" +
+				"ccccccc123456789abcdef0123456789abcdef01) Add c",
+		},
+		{
+			name: "default without changes since previous",
+			data: func() *BodyData {
+				d := newBodyData(src, "")
+				d.Changes = newChanges(
+					prev, repo, "aaaaaaa", src.Commit.SHA, nil,
+				)
+				d.SourceURL = ""
+				d.CommitURL = ""
+				d.TarballURL = ""
+
+				return d
+			}(),
+			want: "### Build Details\n\n\n\n" +
+				"### Changes since previous build\n\n" +
+				"Since [Emacs.2024-06-29.aaaaaaa.master](https://github.com/" +
+				"jimeh/emacs-builds/releases/tag/" +
+				"Emacs.2024-06-29.aaaaaaa.master) ([compare](https://" +
+				"github.com/emacs-mirror/emacs/compare/aaaaaaa..." +
+				"bbbbbbb123456789abcdef0123456789abcdef01)):\n\n" +
+				"- No changes.",
+		},
+		{
+			name: "custom template with plan",
+			template: "{{ .Plan.Name }} built from {{ .Source.Ref }}" +
+				"{{ range .Changes.Commits }}\n" +
+				"* {{ .Commit.Subject }}{{ end }}",
+			data: func() *BodyData {
+				d := newBodyData(src, "")
+				d.Plan = struct{ Name string }{Name: "Emacs.test"}
+				d.Changes = newChanges(
+					prev, repo, "aaaaaaa", src.Commit.SHA, commits,
+				)
+
+				return d
+			}(),
+			want: "Emacs.test built from master\n* Fix b\n* Add c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := bodyTpl
+			if tt.template != "" {
+				tpl, err = ParseBodyTemplate(tt.template)
+				require.NoError(t, err)
+			}
+
+			got, err := renderBody(tpl, tt.data)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPreviousRelease(t *testing.T) {
+	releases := []*Release{}
+	for _, name := range []string{
+		"Emacs-29.2",
+		"Emacs-29.4",
+		"Emacs-29.3",
+		"Emacs-30.0.90",
+		"Emacs.2024-06-27.1111111.master",
+		"Emacs.2024-06-28.2222222.master",
+		"Emacs.2024-06-29.3333333.emacs-30",
+		"Emacs.2024-06-29.4444444.master-variant",
+		"Emacs.test-build",
+	} {
+		releases = append(releases, &Release{Name: name})
+	}
+	releases = append(releases, &Release{
+		Name:  "Emacs.2024-06-29.5555555.master",
+		Draft: true,
+	})
+
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{version: "Emacs-29.4", want: "Emacs-29.3"},
+		{version: "Emacs-30.1", want: "Emacs-29.4"},
+		{version: "Emacs-29.2", want: ""},
+		{version: "Emacs-30.0.91", want: "Emacs-30.0.90"},
+		{
+			version: "Emacs.2024-06-30.6666666.master",
+			want:    "Emacs.2024-06-28.2222222.master",
+		},
+		{
+			version: "Emacs.2024-06-28.2222222.master",
+			want:    "Emacs.2024-06-27.1111111.master",
+		},
+		{
+			version: "Emacs.2024-06-30.6666666.emacs-30",
+			want:    "Emacs.2024-06-29.3333333.emacs-30",
+		},
+		{
+			version: "Emacs.2024-06-30.6666666.master-variant",
+			want:    "Emacs.2024-06-29.4444444.master-variant",
+		},
+		{version: "Emacs.2024-06-30.6666666.emacs-29", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			v, err := ParseVersion(tt.version)
+			require.NoError(t, err)
+
+			got := previousRelease(releases, v)
+
+			if tt.want == "" {
+				assert.Nil(t, got)
+			} else {
+				require.NotNil(t, got)
+				assert.Equal(t, tt.want, got.Name)
+			}
+		})
+	}
+}
+
+func TestReleaseCommitSHA(t *testing.T) {
+	tests := []struct {
+		name    string
+		release *Release
+		want    string
+	}{
+		{
+			name: "from body",
+			release: &Release{
+				Name: "Emacs-29.4",
+				Body: "### Build Details\n\n" +
+					"- Commit: https://github.com/emacs-mirror/emacs/" +
+					"commit/6a299b3c (`6a299b3caceb2c73b932ba73849738fa`)\n",
+			},
+			want: "6a299b3caceb2c73b932ba73849738fa",
+		},
+		{
+			name: "from nightly name",
+			release: &Release{
+				Name: "Emacs.2024-06-30.abcdef0.master",
+				Body: "Custom body.",
+			},
+			want: "abcdef0",
+		},
+		{
+			name:    "unknown",
+			release: &Release{Name: "Emacs-29.4"},
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, releaseCommitSHA(tt.release))
+		})
+	}
+}
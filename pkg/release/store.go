@@ -0,0 +1,156 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+)
+
+// ErrInvalidStore is returned when a release store is misconfigured.
+var ErrInvalidStore = fmt.Errorf("%w: invalid store", Err)
+
+// Store is where releases and their asset files are published to.
+type Store interface {
+	// Get returns the release with given name, or an error wrapping
+	// ErrNotFound if it does not exist.
+	Get(ctx context.Context, name string) (*Release, error)
+
+	// List returns all releases in the store, in no particular order.
+	List(ctx context.Context) ([]*Release, error)
+
+	// Publish creates or updates a release with the attributes of given
+	// release, and uploads given asset files to it, replacing any existing
+	// assets with the same filenames. It returns the published release.
+	Publish(
+		ctx context.Context,
+		rel *Release,
+		files []string,
+		opts *PublishOptions,
+	) (*Release, error)
+
+	// Open returns the content of an asset of given release.
+	Open(ctx context.Context, rel *Release, asset *Asset) (io.ReadCloser, error)
+}
+
+// Release is a release within a Store.
+type Release struct {
+	// Name is the name of the release, which for GitHub is its git tag.
+	Name string `json:"name"`
+
+	// Title is the human readable title of the release.
+	Title      string `json:"title,omitempty"`
+	Body       string `json:"body,omitempty"`
+	CommitRef  string `json:"commit_ref,omitempty"`
+	Draft      bool   `json:"draft,omitempty"`
+	Prerelease bool   `json:"prerelease,omitempty"`
+
+	// PublishedAt is when the release was first published, nil for drafts.
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+
+	// URL is where the release can be viewed.
+	URL string `json:"-"`
+
+	Assets []*Asset `json:"assets,omitempty"`
+}
+
+// Asset returns the asset with given filename, or nil if the release has no
+// such asset.
+func (s *Release) Asset(filename string) *Asset {
+	for _, a := range s.Assets {
+		if a.Name == filename {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// Asset is a file attached to a Release.
+type Asset struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+
+	// SHA256 is the checksum of the asset, if known.
+	SHA256 string `json:"sha256,omitempty"`
+
+	// URL is where the asset can be downloaded from.
+	URL string `json:"-"`
+
+	// id is the ID of GitHub assets.
+	id int64
+}
+
+type StoreOptions struct {
+	// Repository is the GitHub repository releases are stored in when using
+	// the GitHub store.
+	Repository *repository.Repository
+
+	// GitHubToken is the OAuth token used to talk to the GitHub API.
+	GithubToken string
+}
+
+// OpenStore returns the release store described by given URI:
+//
+//   - An empty string or "github" uses GitHub Releases of the repository
+//     given in options.
+//   - "s3://<bucket>/<prefix>" uses an S3-compatible bucket, with optional
+//     "endpoint", "region" and "public-url" query parameters, see S3Options.
+//     Credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+//     AWS_SESSION_TOKEN environment variables.
+//   - "file:///<path>" or "file:<relative-path>" uses a local directory,
+//     optionally with a "public-url" query parameter which asset download
+//     URLs are based on.
+func OpenStore(
+	ctx context.Context,
+	uri string,
+	opts *StoreOptions,
+) (Store, error) {
+	if opts == nil {
+		opts = &StoreOptions{}
+	}
+
+	if uri == "" || uri == "github" {
+		if opts.Repository == nil {
+			return nil, fmt.Errorf(
+				"%w: github store requires a repository", ErrInvalidStore,
+			)
+		}
+
+		return NewGitHubStore(ctx, opts.Repository, opts.GithubToken), nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidStore, err)
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return NewS3Store(s3Options(u, os.Getenv))
+	case "file":
+		dir := u.Path
+		if u.Opaque != "" {
+			dir = u.Opaque
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidStore, uri)
+		}
+
+		return NewDirStore(dir, u.Query().Get("public-url"))
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidStore, uri)
+	}
+}
+
+// validName reports if given release or asset name can be used as a single
+// path segment.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\\")
+}
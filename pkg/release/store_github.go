@@ -0,0 +1,223 @@
+package release
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/gh"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+)
+
+// ErrDownload is returned when an asset could not be downloaded.
+var ErrDownload = fmt.Errorf("%w: failed to download asset", Err)
+
+// GitHubStore stores releases as GitHub Releases of a repository.
+type GitHubStore struct {
+	gh   *github.Client
+	repo *repository.Repository
+}
+
+var _ Store = (*GitHubStore)(nil)
+
+// NewGitHubStore returns a store of the GitHub Releases in given repository.
+func NewGitHubStore(
+	ctx context.Context,
+	repo *repository.Repository,
+	githubToken string,
+) *GitHubStore {
+	return &GitHubStore{
+		gh:   gh.New(ctx, githubToken),
+		repo: repo,
+	}
+}
+
+func (s *GitHubStore) Get(ctx context.Context, name string) (*Release, error) {
+	rel, resp, err := s.gh.Repositories.GetReleaseByTag(
+		ctx, s.repo.Owner(), s.repo.Name(), name,
+	)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+
+		return nil, err
+	}
+
+	return s.release(rel), nil
+}
+
+func (s *GitHubStore) List(ctx context.Context) ([]*Release, error) {
+	var releases []*Release
+	opts := &github.ListOptions{Page: 1, PerPage: 100}
+	for {
+		page, resp, err := s.gh.Repositories.ListReleases(
+			ctx, s.repo.Owner(), s.repo.Name(), opts,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range page {
+			releases = append(releases, s.release(rel))
+		}
+
+		if resp.NextPage == 0 {
+			return releases, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// Publish creates the release as a draft if it does not exist, uploads the
+// asset files, and then updates the release's attributes. This ensures
+// releases are not visible before all assets are uploaded.
+func (s *GitHubStore) Publish(
+	ctx context.Context,
+	rel *Release,
+	files []string,
+	opts *PublishOptions,
+) (*Release, error) {
+	logger := hclog.FromContext(ctx).Named("release")
+
+	created := false
+	logger.Info("checking release", "tag", rel.Name)
+	release, resp, err := s.gh.Repositories.GetReleaseByTag(
+		ctx, s.repo.Owner(), s.repo.Name(), rel.Name,
+	)
+	if err != nil {
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return nil, err
+		}
+
+		created = true
+		logger.Info("creating release", "tag", rel.Name, "name", rel.Title)
+
+		release, _, err = s.gh.Repositories.CreateRelease(
+			ctx, s.repo.Owner(), s.repo.Name(),
+			&github.RepositoryRelease{
+				Name:            &rel.Title,
+				TagName:         &rel.Name,
+				TargetCommitish: &rel.CommitRef,
+				Prerelease:      boolPtr(false),
+				Draft:           boolPtr(true),
+				Body:            &rel.Body,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = newUploader(ctx, s.gh, s.repo, release, opts).Upload(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	if release.GetName() != rel.Title {
+		release.Name = &rel.Title
+		changed = true
+	}
+
+	if rel.Body != "" && release.GetBody() != rel.Body {
+		release.Body = &rel.Body
+		changed = true
+	}
+
+	if release.GetDraft() != rel.Draft {
+		release.Draft = &rel.Draft
+		changed = true
+	}
+
+	if !rel.Draft && release.GetPrerelease() != rel.Prerelease {
+		release.Prerelease = &rel.Prerelease
+		changed = true
+	}
+
+	if changed {
+		logger.Info("updating release attributes", "url", release.GetHTMLURL())
+		release, _, err = s.gh.Repositories.EditRelease(
+			ctx, s.repo.Owner(), s.repo.Name(), release.GetID(), release,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if created {
+		logger.Info("release created", "url", release.GetHTMLURL())
+	} else {
+		logger.Info("release updated", "url", release.GetHTMLURL())
+	}
+
+	return s.release(release), nil
+}
+
+func (s *GitHubStore) Open(
+	ctx context.Context,
+	_ *Release,
+	asset *Asset,
+) (io.ReadCloser, error) {
+	httpClient := &http.Client{Timeout: 60 * time.Second}
+
+	r, downloadURL, err := s.gh.Repositories.DownloadReleaseAsset(
+		ctx, s.repo.Owner(), s.repo.Name(), asset.id, httpClient,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if r == nil && downloadURL != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		//nolint:bodyclose
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		r = resp.Body
+	}
+
+	if r == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDownload, asset.Name)
+	}
+
+	return r, nil
+}
+
+// release converts a GitHub release to a Release.
+func (s *GitHubStore) release(r *github.RepositoryRelease) *Release {
+	rel := &Release{
+		Name:       r.GetTagName(),
+		Title:      r.GetName(),
+		Body:       r.GetBody(),
+		CommitRef:  r.GetTargetCommitish(),
+		Draft:      r.GetDraft(),
+		Prerelease: r.GetPrerelease(),
+		URL:        r.GetHTMLURL(),
+	}
+	if rel.URL == "" {
+		rel.URL = s.repo.ReleaseURL(rel.Name)
+	}
+	if t := r.PublishedAt; t != nil {
+		rel.PublishedAt = &t.Time
+	}
+
+	for _, a := range r.Assets {
+		rel.Assets = append(rel.Assets, &Asset{
+			Name: a.GetName(),
+			Size: int64(a.GetSize()),
+			URL:  a.GetBrowserDownloadURL(),
+			id:   a.GetID(),
+		})
+	}
+
+	return rel
+}
@@ -0,0 +1,378 @@
+package release
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// releaseFile is the name of the file within each release directory of an
+// ObjectStore which describes the release.
+const releaseFile = "release.json"
+
+// blobStore is a minimal store of files, organized by slash separated keys.
+type blobStore interface {
+	// get returns the content of given key, or an error wrapping ErrNotFound
+	// if it does not exist.
+	get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// put writes size bytes from r to given key, where sum is the hex
+	// encoded SHA256 checksum of the content.
+	put(
+		ctx context.Context,
+		key string,
+		r io.ReadSeeker,
+		size int64,
+		sum string,
+	) error
+
+	// dirs returns the names of all top-level directories.
+	dirs(ctx context.Context) ([]string, error)
+
+	// url returns the URL which given key can be downloaded from.
+	url(key string) string
+}
+
+// ObjectStore stores releases as plain files, within a directory per release
+// which has a release.json file describing the release and its assets. The
+// release.json file is written after all assets are uploaded.
+type ObjectStore struct {
+	blobs blobStore
+}
+
+var _ Store = (*ObjectStore)(nil)
+
+// NewDirStore returns a store of releases within a local directory. When
+// publicURL is not empty, asset URLs are relative to it, rather than file://
+// URLs.
+func NewDirStore(dir, publicURL string) (*ObjectStore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := &dirBlobs{root: abs}
+	if publicURL != "" {
+		blobs.publicURL, err = url.Parse(publicURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidStore, err)
+		}
+	}
+
+	return &ObjectStore{blobs: blobs}, nil
+}
+
+func (s *ObjectStore) Get(ctx context.Context, name string) (*Release, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("%w: release %q", ErrInvalidName, name)
+	}
+
+	r, err := s.blobs.get(ctx, path.Join(name, releaseFile))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	rel := &Release{}
+	err = json.NewDecoder(r).Decode(rel)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	rel.Name = name
+
+	rel.URL = s.blobs.url(path.Join(name, releaseFile))
+	for _, a := range rel.Assets {
+		a.URL = s.blobs.url(path.Join(name, a.Name))
+	}
+
+	return rel, nil
+}
+
+func (s *ObjectStore) List(ctx context.Context) ([]*Release, error) {
+	names, err := s.blobs.dirs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []*Release
+	for _, name := range names {
+		rel, err := s.Get(ctx, name)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidName) {
+			// Not a release, or a release which was never fully published.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		releases = append(releases, rel)
+	}
+
+	return releases, nil
+}
+
+// Publish uploads given asset files, and then writes the release's metadata.
+// Existing assets which are not part of files are kept.
+func (s *ObjectStore) Publish(
+	ctx context.Context,
+	rel *Release,
+	files []string,
+	opts *PublishOptions,
+) (*Release, error) {
+	logger := hclog.FromContext(ctx).Named("release")
+
+	if !validName(rel.Name) {
+		return nil, fmt.Errorf("%w: release %q", ErrInvalidName, rel.Name)
+	}
+	for _, f := range files {
+		name := filepath.Base(f)
+		if !validName(name) || name == releaseFile {
+			return nil, fmt.Errorf("%w: asset %q", ErrInvalidName, name)
+		}
+	}
+
+	logger.Info("checking release", "name", rel.Name)
+	existing, err := s.Get(ctx, rel.Name)
+	if errors.Is(err, ErrNotFound) {
+		logger.Info("creating release", "name", rel.Name)
+		existing = &Release{Name: rel.Name}
+	} else if err != nil {
+		return nil, err
+	}
+
+	assets := map[string]*Asset{}
+	for _, a := range existing.Assets {
+		assets[a.Name] = a
+	}
+
+	var mux sync.Mutex
+	upload := func(ctx context.Context, f string) error {
+		a, err := s.upload(
+			ctx, rel.Name, f, existing.Asset(filepath.Base(f)), opts,
+		)
+		if err != nil {
+			return err
+		}
+
+		mux.Lock()
+		defer mux.Unlock()
+		assets[a.Name] = a
+
+		return nil
+	}
+
+	concurrency := opts.UploadConcurrency
+	if concurrency < 1 {
+		concurrency = DefaultUploadConcurrency
+	}
+	err = parallel(ctx, concurrency, files, upload)
+	if err != nil {
+		return nil, err
+	}
+
+	published := *rel
+	published.Assets = make([]*Asset, 0, len(assets))
+	for _, a := range assets {
+		published.Assets = append(published.Assets, a)
+	}
+	sort.Slice(published.Assets, func(i, j int) bool {
+		return published.Assets[i].Name < published.Assets[j].Name
+	})
+	if published.Body == "" {
+		published.Body = existing.Body
+	}
+	published.PublishedAt = existing.PublishedAt
+	if published.Draft {
+		published.PublishedAt = nil
+	} else if published.PublishedAt == nil {
+		now := time.Now().UTC()
+		published.PublishedAt = &now
+	}
+
+	b, err := json.MarshalIndent(&published, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("writing release metadata", "name", rel.Name)
+	err = s.blobs.put(
+		ctx, path.Join(rel.Name, releaseFile), bytes.NewReader(b),
+		int64(len(b)), fmt.Sprintf("%x", sha256.Sum256(b)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Get(ctx, rel.Name)
+}
+
+// upload uploads given file to a release, unless the existing asset has
+// the same size and checksum and opts.AssetSizeCheck is enabled.
+func (s *ObjectStore) upload(
+	ctx context.Context,
+	name string,
+	filename string,
+	existing *Asset,
+	opts *PublishOptions,
+) (*Asset, error) {
+	logger := hclog.FromContext(ctx).Named("release")
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	sum, err := fileSHA256(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Asset{
+		Name:   filepath.Base(filename),
+		Size:   info.Size(),
+		SHA256: sum,
+	}
+
+	if opts.AssetSizeCheck && existing != nil &&
+		existing.Size == a.Size && existing.SHA256 == a.SHA256 {
+		logger.Info("asset exists with matching checksum",
+			"file", a.Name, "size", byteCountIEC(a.Size), "sha256", sum,
+		)
+
+		return a, nil
+	}
+
+	logger.Info("uploading asset",
+		"file", a.Name, "size", byteCountIEC(a.Size),
+	)
+	err = s.blobs.put(ctx, path.Join(name, a.Name), f, a.Size, a.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (s *ObjectStore) Open(
+	ctx context.Context,
+	rel *Release,
+	asset *Asset,
+) (io.ReadCloser, error) {
+	if !validName(rel.Name) || !validName(asset.Name) {
+		return nil, fmt.Errorf(
+			"%w: %s/%s", ErrInvalidName, rel.Name, asset.Name,
+		)
+	}
+
+	return s.blobs.get(ctx, path.Join(rel.Name, asset.Name))
+}
+
+// dirBlobs stores files in a local directory.
+type dirBlobs struct {
+	root      string
+	publicURL *url.URL
+}
+
+func (s *dirBlobs) get(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// put writes the file to a temporary file first, which is renamed once
+// complete, so partially written files are never visible.
+func (s *dirBlobs) put(
+	_ context.Context,
+	key string,
+	r io.ReadSeeker,
+	_ int64,
+	_ string,
+) error {
+	filename := s.path(key)
+	dir := filepath.Dir(filename)
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(f.Name(), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+func (s *dirBlobs) dirs(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(s.root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func (s *dirBlobs) url(key string) string {
+	if s.publicURL != nil {
+		return s.publicURL.JoinPath(key).String()
+	}
+
+	u := &url.URL{Scheme: "file", Path: filepath.ToSlash(s.path(key))}
+
+	return u.String()
+}
+
+func (s *dirBlobs) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
@@ -0,0 +1,212 @@
+package release
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeAssetFiles(
+	t *testing.T,
+	dir string,
+	files map[string]string,
+) []string {
+	t.Helper()
+
+	var filenames []string
+	for name, content := range files {
+		f := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(f, []byte(content), 0o644))
+		filenames = append(filenames, f)
+	}
+
+	return filenames
+}
+
+// testObjectStore publishes releases to given store, and verifies them.
+func testObjectStore(t *testing.T, store *ObjectStore) {
+	t.Helper()
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	_, err := store.Get(ctx, "Emacs-29.4")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	releases, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, releases)
+
+	files := writeAssetFiles(t, dir, map[string]string{
+		"a.dmg": "aaaa",
+		"b.dmg": "bbbb",
+	})
+	rel, err := store.Publish(ctx, &Release{
+		Name:  "Emacs-29.4",
+		Title: "Emacs 29.4",
+		Body:  "Body.",
+	}, files, &PublishOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Emacs-29.4", rel.Name)
+	assert.Equal(t, "Emacs 29.4", rel.Title)
+	assert.Equal(t, "Body.", rel.Body)
+	require.NotNil(t, rel.PublishedAt)
+	publishedAt := *rel.PublishedAt
+	require.Len(t, rel.Assets, 2)
+	assert.Equal(t, "a.dmg", rel.Assets[0].Name)
+	assert.Equal(t, int64(4), rel.Assets[0].Size)
+	assert.Equal(t, sha256Hex("aaaa"), rel.Assets[0].SHA256)
+	assert.Equal(t, "b.dmg", rel.Assets[1].Name)
+
+	// Publishing again replaces given assets, keeps other assets, and keeps
+	// the body when no body is given.
+	files = writeAssetFiles(t, dir, map[string]string{
+		"b.dmg": "BBBBBB",
+		"c.dmg": "cccc",
+	})
+	_, err = store.Publish(ctx, &Release{
+		Name:       "Emacs-29.4",
+		Title:      "Emacs 29.4",
+		Prerelease: true,
+	}, files, &PublishOptions{AssetSizeCheck: true})
+	require.NoError(t, err)
+
+	rel, err = store.Get(ctx, "Emacs-29.4")
+	require.NoError(t, err)
+	assert.Equal(t, "Body.", rel.Body)
+	assert.True(t, rel.Prerelease)
+	assert.Equal(t, publishedAt, *rel.PublishedAt)
+	require.Len(t, rel.Assets, 3)
+	assert.Equal(t, int64(6), rel.Asset("b.dmg").Size)
+	assert.Equal(t, sha256Hex("cccc"), rel.Asset("c.dmg").SHA256)
+
+	for name, want := range map[string]string{
+		"a.dmg": "aaaa",
+		"b.dmg": "BBBBBB",
+	} {
+		r, err := store.Open(ctx, rel, rel.Asset(name))
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		assert.Equal(t, want, string(got))
+	}
+
+	_, err = store.Publish(ctx, &Release{Name: "Emacs-30.1"}, nil,
+		&PublishOptions{},
+	)
+	require.NoError(t, err)
+
+	releases, err = store.List(ctx)
+	require.NoError(t, err)
+	var names []string
+	for _, r := range releases {
+		names = append(names, r.Name)
+	}
+	assert.ElementsMatch(t, []string{"Emacs-29.4", "Emacs-30.1"}, names)
+
+	// Check works with any store.
+	err = Check(ctx, &CheckOptions{
+		Store:       store,
+		ReleaseName: "Emacs-29.4",
+		AssetFiles:  []string{"/builds/a.dmg", "c.dmg"},
+	})
+	require.NoError(t, err)
+
+	err = Check(ctx, &CheckOptions{
+		Store:       store,
+		ReleaseName: "Emacs-30.1",
+		AssetFiles:  []string{"a.dmg"},
+	})
+	assert.ErrorIs(t, err, ErrMissingAssets)
+
+	_, err = store.Publish(ctx, &Release{Name: "../Emacs"}, nil,
+		&PublishOptions{},
+	)
+	assert.ErrorIs(t, err, ErrInvalidName)
+}
+
+func TestDirStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDirStore(dir, "")
+	require.NoError(t, err)
+
+	testObjectStore(t, store)
+
+	rel, err := store.Get(context.Background(), "Emacs-29.4")
+	require.NoError(t, err)
+	assert.Equal(t,
+		"file://"+filepath.ToSlash(filepath.Join(dir, "Emacs-29.4", "a.dmg")),
+		rel.Asset("a.dmg").URL,
+	)
+	assert.FileExists(t, filepath.Join(dir, "Emacs-29.4", "release.json"))
+}
+
+func TestDirStore_publicURL(t *testing.T) {
+	store, err := NewDirStore(t.TempDir(), "https://example.com/builds/")
+	require.NoError(t, err)
+
+	f := writeAssetFiles(t, t.TempDir(), map[string]string{"a b.dmg": "a"})
+	rel, err := store.Publish(context.Background(), &Release{
+		Name: "Emacs-29.4",
+	}, f, &PublishOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t,
+		"https://example.com/builds/Emacs-29.4/release.json", rel.URL,
+	)
+	assert.Equal(t,
+		"https://example.com/builds/Emacs-29.4/a%20b.dmg",
+		rel.Asset("a b.dmg").URL,
+	)
+}
+
+func TestOpenStore(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		uri     string
+		opts    *StoreOptions
+		want    Store
+		wantErr error
+	}{
+		{
+			name:    "github without repository",
+			uri:     "github",
+			wantErr: ErrInvalidStore,
+		},
+		{
+			name: "file",
+			uri:  "file://" + filepath.ToSlash(dir),
+			want: &ObjectStore{blobs: &dirBlobs{root: dir}},
+		},
+		{
+			name:    "s3 without bucket",
+			uri:     "s3:///prefix",
+			wantErr: ErrInvalidStore,
+		},
+		{
+			name:    "unknown scheme",
+			uri:     "ftp://example.com/",
+			wantErr: ErrInvalidStore,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OpenStore(context.Background(), tt.uri, tt.opts)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
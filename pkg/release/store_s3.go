@@ -0,0 +1,410 @@
+package release
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrS3 is returned when a request to S3 fails.
+var ErrS3 = fmt.Errorf("%w: s3 request failed", Err)
+
+// emptySHA256 is the SHA256 checksum of an empty request body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb924" +
+	"27ae41e4649b934ca495991b7852b855"
+
+type S3Options struct {
+	// Bucket is the name of the bucket to store releases in.
+	Bucket string
+
+	// Prefix is an optional key prefix which releases are stored under.
+	Prefix string
+
+	// Endpoint is the URL of the S3-compatible service, like
+	// "https://minio.example.com:9000". Defaults to AWS S3 within Region.
+	// Requests always use path-style addressing.
+	Endpoint string
+
+	// Region is the region of the bucket, defaults to "us-east-1".
+	Region string
+
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials used
+	// to sign requests. Requests are anonymous when no access key is set.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// PublicURL is the URL which the prefix of the bucket is publicly
+	// available at, like a CDN in front of the bucket. Asset URLs are based
+	// on the bucket's URL when empty.
+	PublicURL string
+
+	// HTTPClient is used for all requests, defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewS3Store returns a store of releases within an S3-compatible bucket, like
+// AWS S3 or MinIO. Assets are uploaded with a single PUT request each, so
+// assets are limited to 5 GiB.
+func NewS3Store(opts *S3Options) (*ObjectStore, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("%w: s3 bucket is required", ErrInvalidStore)
+	}
+
+	blobs := &s3Blobs{
+		client:          opts.HTTPClient,
+		bucket:          opts.Bucket,
+		prefix:          strings.Trim(opts.Prefix, "/"),
+		region:          opts.Region,
+		accessKeyID:     opts.AccessKeyID,
+		secretAccessKey: opts.SecretAccessKey,
+		sessionToken:    opts.SessionToken,
+		now:             time.Now,
+	}
+	if blobs.client == nil {
+		blobs.client = http.DefaultClient
+	}
+	if blobs.region == "" {
+		blobs.region = "us-east-1"
+	}
+	if blobs.prefix != "" {
+		blobs.prefix += "/"
+	}
+
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + blobs.region + ".amazonaws.com"
+	}
+
+	var err error
+	blobs.endpoint, err = url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidStore, err)
+	}
+
+	if opts.PublicURL != "" {
+		blobs.publicURL, err = url.Parse(opts.PublicURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidStore, err)
+		}
+	}
+
+	return &ObjectStore{blobs: blobs}, nil
+}
+
+// s3Options returns the options of a "s3://<bucket>/<prefix>" URI, which
+// supports "endpoint", "region" and "public-url" query parameters.
+// Credentials and the default region are read from the standard AWS
+// environment variables.
+func s3Options(u *url.URL, getenv func(string) string) *S3Options {
+	q := u.Query()
+	opts := &S3Options{
+		Bucket:          u.Host,
+		Prefix:          u.Path,
+		Endpoint:        q.Get("endpoint"),
+		Region:          q.Get("region"),
+		AccessKeyID:     getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    getenv("AWS_SESSION_TOKEN"),
+		PublicURL:       q.Get("public-url"),
+	}
+	if opts.Region == "" {
+		opts.Region = getenv("AWS_REGION")
+	}
+	if opts.Region == "" {
+		opts.Region = getenv("AWS_DEFAULT_REGION")
+	}
+
+	return opts
+}
+
+// s3Blobs stores files in an S3-compatible bucket.
+type s3Blobs struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	publicURL *url.URL
+
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+
+	now func() time.Time
+}
+
+func (s *s3Blobs) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	//nolint:bodyclose
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key), nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *s3Blobs) put(
+	ctx context.Context,
+	key string,
+	r io.ReadSeeker,
+	size int64,
+	sum string,
+) error {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(key), r, size, sum)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	CommonPrefixes        []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+func (s *s3Blobs) dirs(ctx context.Context) ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		u := *s.endpoint
+		u.Path = path.Join(u.Path, "/", s.bucket)
+		q := url.Values{
+			"list-type": {"2"},
+			"prefix":    {s.prefix},
+			"delimiter": {"/"},
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+
+		result, err := s.list(ctx, &u)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range result.CommonPrefixes {
+			name := strings.TrimPrefix(p.Prefix, s.prefix)
+			names = append(names, strings.TrimSuffix(name, "/"))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Blobs) list(
+	ctx context.Context,
+	u *url.URL,
+) (*s3ListResult, error) {
+	resp, err := s.do(ctx, http.MethodGet, u, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &s3ListResult{}
+	err = xml.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrS3, err)
+	}
+
+	return result, nil
+}
+
+func (s *s3Blobs) url(key string) string {
+	if s.publicURL != nil {
+		return s.publicURL.JoinPath(key).String()
+	}
+
+	return s.objectURL(key).String()
+}
+
+// objectURL returns the path-style URL of given key.
+func (s *s3Blobs) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.Path = path.Join(u.Path, "/", s.bucket, s.prefix+key)
+	u.RawPath = awsEscape(u.Path, false)
+
+	return &u
+}
+
+// do sends a signed request, and returns the response if it was successful.
+// Errors for missing keys wrap ErrNotFound.
+func (s *s3Blobs) do(
+	ctx context.Context,
+	method string,
+	u *url.URL,
+	body io.Reader,
+	size int64,
+	sum string,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if sum == "" {
+		sum = emptySHA256
+	}
+	s.sign(req, sum, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusMultipleChoices {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, u.Path)
+	}
+
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	_ = xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&s3Err)
+
+	return nil, fmt.Errorf("%w: %s %s: %s %s %s",
+		ErrS3, method, u.Path, resp.Status, s3Err.Code, s3Err.Message,
+	)
+}
+
+// sign signs a request with AWS Signature Version 4, where payloadHash is
+// the hex encoded SHA256 checksum of the request body. All headers set on
+// the request are signed. Requests are not signed without credentials.
+func (s *s3Blobs) sign(req *http.Request, payloadHash string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	if s.accessKeyID == "" {
+		return
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		trimmed := make([]string, 0, len(values))
+		for _, v := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalURI := req.URL.EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		awsCanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	for _, v := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func awsCanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string{}, q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes all characters except unreserved characters, as
+// required by AWS Signature Version 4.
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,473 @@
+package release
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+)
+
+// ErrInvalidChecksum is returned when a *.sha256 release asset does not
+// contain a SHA256 checksum.
+var ErrInvalidChecksum = fmt.Errorf("%w: invalid checksum", Err)
+
+// Defaults for uploading release assets.
+const (
+	DefaultUploadConcurrency = 4
+	DefaultUploadRetries     = 5
+)
+
+// maxRetryDelay caps the exponential backoff between retries.
+const maxRetryDelay = time.Minute
+
+// uploader uploads asset files to a GitHub release.
+type uploader struct {
+	gh      *github.Client
+	logger  hclog.Logger
+	repo    *repository.Repository
+	release *github.RepositoryRelease
+
+	// concurrency is the maximum number of assets processed in parallel.
+	concurrency int
+
+	// retries is the number of times failed requests are retried.
+	retries int
+
+	// backoff is the delay before the first retry, which doubles for each
+	// following retry.
+	backoff time.Duration
+
+	// verify leaves existing assets in place when their size and checksum
+	// matches the local file.
+	verify bool
+
+	// downloadClient is used to follow redirects when downloading assets.
+	downloadClient *http.Client
+}
+
+func newUploader(
+	ctx context.Context,
+	gh *github.Client,
+	repo *repository.Repository,
+	release *github.RepositoryRelease,
+	opts *PublishOptions,
+) *uploader {
+	concurrency := opts.UploadConcurrency
+	if concurrency < 1 {
+		concurrency = DefaultUploadConcurrency
+	}
+	retries := opts.UploadRetries
+	if retries < 0 {
+		retries = 0
+	} else if retries == 0 {
+		retries = DefaultUploadRetries
+	}
+
+	return &uploader{
+		gh:             gh,
+		logger:         hclog.FromContext(ctx).Named("release"),
+		repo:           repo,
+		release:        release,
+		concurrency:    concurrency,
+		retries:        retries,
+		backoff:        2 * time.Second,
+		verify:         opts.AssetSizeCheck,
+		downloadClient: http.DefaultClient,
+	}
+}
+
+// Upload uploads given files to the release, replacing any existing assets
+// with the same filenames. Existing assets are first verified when enabled,
+// and all verification happens before any asset is replaced, so checksum
+// assets are never compared while they are being replaced.
+func (s *uploader) Upload(ctx context.Context, files []string) error {
+	existing := map[string]*github.ReleaseAsset{}
+	for _, a := range s.release.Assets {
+		existing[a.GetName()] = a
+	}
+
+	skip := map[string]bool{}
+	var mux sync.Mutex
+	verify := func(ctx context.Context, f string) error {
+		ok, err := s.verified(ctx, f, existing)
+		if err != nil || !ok {
+			return err
+		}
+
+		mux.Lock()
+		defer mux.Unlock()
+		skip[f] = true
+
+		return nil
+	}
+	upload := func(ctx context.Context, f string) error {
+		if skip[f] {
+			return nil
+		}
+
+		return s.replace(ctx, f, existing[filepath.Base(f)])
+	}
+
+	if s.verify {
+		err := parallel(ctx, s.concurrency, files, verify)
+		if err != nil {
+			return err
+		}
+	}
+
+	return parallel(ctx, s.concurrency, files, upload)
+}
+
+// parallel calls f for each file, with at most concurrency calls running at a
+// time. Once a call fails, no further calls are started, and the errors of all
+// failed calls are returned.
+func parallel(
+	ctx context.Context,
+	concurrency int,
+	files []string,
+	f func(context.Context, string) error,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan string)
+	var errs []error
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < min(concurrency, len(files)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				err := f(ctx, file)
+				if err != nil {
+					mux.Lock()
+					errs = append(errs, err)
+					mux.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for _, file := range files {
+		select {
+		case jobs <- file:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// verified reports if an existing asset matches the size and content of
+// given local file.
+func (s *uploader) verified(
+	ctx context.Context,
+	filename string,
+	existing map[string]*github.ReleaseAsset,
+) (bool, error) {
+	name := filepath.Base(filename)
+	a, ok := existing[name]
+	if !ok || a.GetState() != "uploaded" {
+		return false, nil
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return false, err
+	}
+	if int64(a.GetSize()) != info.Size() {
+		return false, nil
+	}
+
+	local, err := fileSHA256(filename)
+	if err != nil {
+		return false, err
+	}
+
+	var remote string
+	if sum, ok := existing[name+".sha256"]; ok && !isChecksumFile(name) {
+		remote, err = s.downloadChecksum(ctx, sum)
+	} else {
+		remote, err = s.downloadDigest(ctx, a)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if local != remote {
+		s.logger.Info("asset exists with different content",
+			"file", name, "local_sha256", local, "remote_sha256", remote,
+		)
+
+		return false, nil
+	}
+
+	s.logger.Info("asset exists with matching checksum",
+		"file", name, "size", byteCountIEC(info.Size()), "sha256", local,
+	)
+
+	return true, nil
+}
+
+// replace deletes given existing asset if any, and uploads the file.
+func (s *uploader) replace(
+	ctx context.Context,
+	filename string,
+	existing *github.ReleaseAsset,
+) error {
+	name := filepath.Base(filename)
+
+	if existing != nil {
+		s.logger.Info("deleting existing asset", "file", name)
+		err := s.deleteAsset(ctx, existing.GetID())
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.retry(ctx, "upload "+name, func(attempt int) error {
+		// A failed upload may leave a partial asset behind, which must be
+		// removed before the asset can be uploaded again.
+		if attempt > 0 {
+			err := s.deletePartial(ctx, name)
+			if err != nil {
+				return err
+			}
+		}
+
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		s.logger.Info("uploading asset",
+			"file", name, "size", byteCountIEC(info.Size()),
+		)
+		_, _, err = s.gh.Repositories.UploadReleaseAsset(
+			ctx, s.repo.Owner(), s.repo.Name(), s.release.GetID(),
+			&github.UploadOptions{Name: name}, f,
+		)
+
+		return err
+	})
+}
+
+func (s *uploader) deleteAsset(ctx context.Context, id int64) error {
+	return s.retry(ctx, "delete asset", func(int) error {
+		resp, err := s.gh.Repositories.DeleteReleaseAsset(
+			ctx, s.repo.Owner(), s.repo.Name(), id,
+		)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+
+		return err
+	})
+}
+
+// deletePartial deletes any asset with given name from the release.
+func (s *uploader) deletePartial(ctx context.Context, name string) error {
+	opts := &github.ListOptions{Page: 1, PerPage: 100}
+	for {
+		var assets []*github.ReleaseAsset
+		var resp *github.Response
+		err := s.retry(ctx, "list assets", func(int) error {
+			var err error
+			assets, resp, err = s.gh.Repositories.ListReleaseAssets(
+				ctx, s.repo.Owner(), s.repo.Name(), s.release.GetID(), opts,
+			)
+
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, a := range assets {
+			if a.GetName() != name {
+				continue
+			}
+
+			s.logger.Info("deleting partially uploaded asset",
+				"file", name, "state", a.GetState(),
+			)
+
+			return s.deleteAsset(ctx, a.GetID())
+		}
+
+		if resp.NextPage == 0 {
+			return nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// downloadChecksum returns the SHA256 checksum within a *.sha256 asset.
+func (s *uploader) downloadChecksum(
+	ctx context.Context,
+	a *github.ReleaseAsset,
+) (string, error) {
+	var sum string
+	err := s.download(ctx, a, func(r io.Reader) error {
+		b, err := io.ReadAll(io.LimitReader(r, 1024))
+		if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(string(b))
+		if len(fields) == 0 || len(fields[0]) != 64 {
+			return fmt.Errorf("%w: %s", ErrInvalidChecksum, a.GetName())
+		}
+		sum = fields[0]
+
+		return nil
+	})
+
+	return sum, err
+}
+
+// downloadDigest downloads an asset and returns its SHA256 checksum.
+func (s *uploader) downloadDigest(
+	ctx context.Context,
+	a *github.ReleaseAsset,
+) (string, error) {
+	s.logger.Info("downloading asset to verify checksum",
+		"file", a.GetName(), "size", byteCountIEC(int64(a.GetSize())),
+	)
+
+	var sum string
+	err := s.download(ctx, a, func(r io.Reader) error {
+		h := sha256.New()
+		_, err := io.Copy(h, r)
+		if err != nil {
+			return err
+		}
+		sum = fmt.Sprintf("%x", h.Sum(nil))
+
+		return nil
+	})
+
+	return sum, err
+}
+
+func (s *uploader) download(
+	ctx context.Context,
+	a *github.ReleaseAsset,
+	read func(io.Reader) error,
+) error {
+	return s.retry(ctx, "download "+a.GetName(), func(int) error {
+		r, _, err := s.gh.Repositories.DownloadReleaseAsset(
+			ctx, s.repo.Owner(), s.repo.Name(), a.GetID(), s.downloadClient,
+		)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		return read(r)
+	})
+}
+
+// retry calls f until it succeeds, returns an error which is not retryable,
+// or the retry limit is reached.
+func (s *uploader) retry(
+	ctx context.Context,
+	operation string,
+	f func(attempt int) error,
+) error {
+	for attempt := 0; ; attempt++ {
+		err := f(attempt)
+		if err == nil || ctx.Err() != nil || attempt >= s.retries {
+			return err
+		}
+
+		delay, ok := retryDelay(err, attempt, s.backoff)
+		if !ok {
+			return err
+		}
+
+		s.logger.Warn("request failed, retrying",
+			"operation", operation, "attempt", attempt+1,
+			"delay", delay, "error", err,
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryDelay reports if a failed request should be retried, and how long to
+// wait before doing so. Server errors, secondary rate limits and network
+// errors are retried, with exponential backoff unless the response specifies
+// how long to wait.
+func retryDelay(
+	err error,
+	attempt int,
+	backoff time.Duration,
+) (time.Duration, bool) {
+	delay := min(backoff<<attempt, maxRetryDelay)
+
+	var abuseErr *github.AbuseRateLimitError
+	var respErr *github.ErrorResponse
+	var netErr net.Error
+	switch {
+	case errors.As(err, &abuseErr):
+		if abuseErr.RetryAfter != nil {
+			return *abuseErr.RetryAfter, true
+		}
+
+		return delay, true
+	case errors.As(err, &respErr):
+		return delay, respErr.Response != nil &&
+			respErr.Response.StatusCode >= http.StatusInternalServerError
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return delay, true
+	}
+
+	return 0, false
+}
+
+func isChecksumFile(name string) bool {
+	return strings.HasSuffix(name, ".sha256")
+}
+
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
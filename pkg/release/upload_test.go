@@ -0,0 +1,372 @@
+package release
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAssets is a minimal fake of the GitHub release assets API.
+type fakeAssets struct {
+	mux       sync.Mutex
+	nextID    int64
+	assets    map[int64]*github.ReleaseAsset
+	content   map[int64]string
+	failures  map[string]int
+	uploads   map[string]int
+	active    int
+	maxActive int
+}
+
+func newFakeAssets(existing map[string]string) *fakeAssets {
+	s := &fakeAssets{
+		assets:   map[int64]*github.ReleaseAsset{},
+		content:  map[int64]string{},
+		failures: map[string]int{},
+		uploads:  map[string]int{},
+	}
+	for name, content := range existing {
+		s.add(name, content, "uploaded")
+	}
+
+	return s
+}
+
+func (s *fakeAssets) add(name, content, state string) *github.ReleaseAsset {
+	s.nextID++
+	a := &github.ReleaseAsset{
+		ID:    github.Int64(s.nextID),
+		Name:  github.String(name),
+		Size:  github.Int(len(content)),
+		State: github.String(state),
+	}
+	s.assets[s.nextID] = a
+	s.content[s.nextID] = content
+
+	return a
+}
+
+func (s *fakeAssets) list() []*github.ReleaseAsset {
+	assets := []*github.ReleaseAsset{}
+	for _, a := range s.assets {
+		assets = append(assets, a)
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].GetName() < assets[j].GetName()
+	})
+
+	return assets
+}
+
+func (s *fakeAssets) files() map[string]string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	files := map[string]string{}
+	for id, a := range s.assets {
+		files[a.GetName()] = s.content[id]
+	}
+
+	return files
+}
+
+func (s *fakeAssets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const assetsPath = "/repos/jimeh/emacs-builds/releases/assets/"
+	const uploadPath = "/uploads/repos/jimeh/emacs-builds/releases/1/assets"
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == uploadPath:
+		s.upload(w, r)
+
+		return
+	case r.URL.Path == "/repos/jimeh/emacs-builds/releases/1/assets":
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		_ = json.NewEncoder(w).Encode(s.list())
+
+		return
+	}
+
+	id, err := strconv.ParseInt(
+		strings.TrimPrefix(r.URL.Path, assetsPath), 10, 64,
+	)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err != nil || s.assets[id] == nil {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		_, _ = io.WriteString(w, s.content[id])
+	case http.MethodDelete:
+		delete(s.assets, id)
+		delete(s.content, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *fakeAssets) upload(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	body, _ := io.ReadAll(r.Body)
+
+	s.mux.Lock()
+	s.active++
+	s.maxActive = max(s.maxActive, s.active)
+	s.uploads[name]++
+	s.mux.Unlock()
+
+	// Give concurrent uploads a chance to overlap.
+	time.Sleep(10 * time.Millisecond)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.active--
+
+	for _, a := range s.assets {
+		if a.GetName() == name {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+
+			return
+		}
+	}
+
+	if s.failures[name] > 0 {
+		s.failures[name]--
+		// Failed uploads leave a partial asset behind.
+		s.add(name, "", "starter")
+		w.WriteHeader(http.StatusBadGateway)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(s.add(name, string(body), "uploaded"))
+}
+
+func sha256Hex(s string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
+
+func TestUploader_Upload(t *testing.T) {
+	dir := t.TempDir()
+	local := map[string]string{
+		"a.dmg":        "aaaa",
+		"a.dmg.sha256": sha256Hex("aaaa") + "  a.dmg",
+		"b.dmg":        "bbbb",
+		"c.dmg":        "cccc",
+		"d.dmg":        "dddd",
+		"e.dmg":        "eeee",
+	}
+	var files []string
+	for name, content := range local {
+		f := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(f, []byte(content), 0o644))
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	fake := newFakeAssets(map[string]string{
+		// Matches local file, verified by checksum asset.
+		"a.dmg":        "aaaa",
+		"a.dmg.sha256": sha256Hex("aaaa") + "  a.dmg",
+		// Same size but different content, verified by digest.
+		"b.dmg": "BBBB",
+		// Different size.
+		"d.dmg": "ddd",
+		// Not part of upload.
+		"z.dmg": "zzzz",
+	})
+	fake.failures["c.dmg"] = 2
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	client.UploadURL, _ = url.Parse(srv.URL + "/uploads/")
+	repo, err := repository.NewGitHub("jimeh/emacs-builds")
+	require.NoError(t, err)
+
+	u := &uploader{
+		gh:     client,
+		logger: hclog.NewNullLogger(),
+		repo:   repo,
+		release: &github.RepositoryRelease{
+			ID: github.Int64(1), Assets: fake.list(),
+		},
+		concurrency:    2,
+		retries:        3,
+		backoff:        time.Millisecond,
+		verify:         true,
+		downloadClient: srv.Client(),
+	}
+
+	err = u.Upload(context.Background(), files)
+	require.NoError(t, err)
+
+	want := map[string]string{"z.dmg": "zzzz"}
+	for name, content := range local {
+		want[name] = content
+	}
+	assert.Equal(t, want, fake.files())
+	assert.Equal(t, map[string]int{
+		"b.dmg": 1,
+		"c.dmg": 3,
+		"d.dmg": 1,
+		"e.dmg": 1,
+	}, fake.uploads)
+	assert.LessOrEqual(t, fake.maxActive, 2)
+}
+
+func TestUploader_Upload_failure(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "a.dmg")
+	require.NoError(t, os.WriteFile(f, []byte("aaaa"), 0o644))
+
+	fake := newFakeAssets(nil)
+	fake.failures["a.dmg"] = 10
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	client.UploadURL, _ = url.Parse(srv.URL + "/uploads/")
+	repo, err := repository.NewGitHub("jimeh/emacs-builds")
+	require.NoError(t, err)
+
+	u := &uploader{
+		gh:          client,
+		logger:      hclog.NewNullLogger(),
+		repo:        repo,
+		release:     &github.RepositoryRelease{ID: github.Int64(1)},
+		concurrency: 2,
+		retries:     2,
+		backoff:     time.Millisecond,
+	}
+
+	err = u.Upload(context.Background(), []string{f})
+
+	var respErr *github.ErrorResponse
+	require.True(t, errors.As(err, &respErr))
+	assert.Equal(t, http.StatusBadGateway, respErr.Response.StatusCode)
+	assert.Equal(t, 3, fake.uploads["a.dmg"])
+}
+
+func TestRetryDelay(t *testing.T) {
+	retryAfter := 42 * time.Second
+	response := func(code int) *http.Response {
+		return &http.Response{
+			StatusCode: code,
+			Request:    &http.Request{Method: "GET", URL: &url.URL{}},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		attempt   int
+		wantDelay time.Duration
+		wantOK    bool
+	}{
+		{
+			name:      "server error",
+			err:       &github.ErrorResponse{Response: response(502)},
+			wantDelay: time.Second,
+			wantOK:    true,
+		},
+		{
+			name:      "server error backoff",
+			err:       &github.ErrorResponse{Response: response(500)},
+			attempt:   3,
+			wantDelay: 8 * time.Second,
+			wantOK:    true,
+		},
+		{
+			name:      "backoff is capped",
+			err:       &github.ErrorResponse{Response: response(503)},
+			attempt:   10,
+			wantDelay: maxRetryDelay,
+			wantOK:    true,
+		},
+		{
+			name: "client error",
+			err:  &github.ErrorResponse{Response: response(422)},
+		},
+		{
+			name: "secondary rate limit with retry after",
+			err: &github.AbuseRateLimitError{
+				Response:   response(403),
+				RetryAfter: &retryAfter,
+			},
+			attempt:   2,
+			wantDelay: retryAfter,
+			wantOK:    true,
+		},
+		{
+			name: "secondary rate limit",
+			err: &github.AbuseRateLimitError{
+				Response: response(403),
+			},
+			attempt:   1,
+			wantDelay: 2 * time.Second,
+			wantOK:    true,
+		},
+		{
+			name: "network error",
+			err: &url.Error{
+				Op: "Post", URL: "https://example.com",
+				Err: &timeoutError{},
+			},
+			wantDelay: time.Second,
+			wantOK:    true,
+		},
+		{
+			name:      "unexpected EOF",
+			err:       fmt.Errorf("upload: %w", io.ErrUnexpectedEOF),
+			wantDelay: time.Second,
+			wantOK:    true,
+		},
+		{
+			name: "other error",
+			err:  os.ErrNotExist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := retryDelay(tt.err, tt.attempt, time.Second)
+
+			assert.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				assert.Equal(t, tt.wantDelay, delay)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
@@ -1,44 +1,345 @@
 package release
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/sanitize"
 )
 
 // Errors
 var (
-	Err             = errors.New("release")
-	ErrInvalidName  = fmt.Errorf("%w: invalid name", Err)
-	ErrEmptyVersion = fmt.Errorf("%w: empty version", Err)
-	ErrNotStableRef = fmt.Errorf(
+	Err               = errors.New("release")
+	ErrInvalidName    = fmt.Errorf("%w: invalid name", Err)
+	ErrInvalidVersion = fmt.Errorf("%w: invalid version", Err)
+	ErrEmptyVersion   = fmt.Errorf("%w: empty version", Err)
+	ErrNotStableRef   = fmt.Errorf(
 		"%w: git ref is not stable tagged release", Err,
 	)
 )
 
+const (
+	// pretestPatch is the lowest patch level of pretest versions, which
+	// precede the release of version <major>.<minor+1>.
+	pretestPatch = 90
+
+	nightlyDateFormat = "2006-01-02"
+)
+
 var (
-	stableVersion  = regexp.MustCompile(`^\d+\.\d+(?:[a-z]+)?(-\d+)?$`)
-	pretestVersion = regexp.MustCompile(`-pretest(-\d+)?$`)
-	stableGitRef   = regexp.MustCompile(`^emacs-(\d+\.\d+(?:[a-z]+)?)$`)
+	numberedVersion = regexp.MustCompile(
+		`^(\d+)\.(\d+)(?:\.(\d+))?((?:\.\d+)*[a-z]*)` +
+			`(?:-rc(\d+))?((?:-[a-z]+)*?)(-pretest)?(?:-(\d+))?$`,
+	)
+	nightlyVersion = regexp.MustCompile(
+		`^(\d{4}-\d{2}-\d{2})\.([0-9a-f]{7,40})\.(.+)$`,
+	)
+	gitTag = regexp.MustCompile(
+		`^emacs-(pretest-)?` +
+			`(\d+\.\d+(?:\.\d+)*[a-z]*(?:-rc\d+)?(?:-[a-z]+)*)$`,
+	)
 )
 
+// Version is the version of a Emacs build, as found in git tags and release
+// names. Numbered versions are stable releases, release candidates and
+// pretests, while nightly versions identify builds of a commit on a branch.
+type Version struct {
+	Channel Channel
+
+	// Major, Minor and Patch are the numeric parts of numbered versions.
+	// Pretests have a patch level of 90 or more.
+	Major int
+	Minor int
+	Patch int
+
+	// Suffix holds any text directly following the version number, like the
+	// "b" of "23.3b" or the ".1" of "23.2.93.1".
+	Suffix string
+
+	// RC is the release candidate number, zero when not a release candidate.
+	RC int
+
+	// Extra holds any text following the release candidate number, like the
+	// "-fixed" of "24.5-rc3-fixed".
+	Extra string
+
+	// Date, SHA and Ref identify the commit and git ref nightly versions are
+	// built from, and may be set for numbered versions too, see Absolute.
+	// SHA is abbreviated and Ref sanitized. When parsing nightly versions,
+	// build variants can not be told apart from refs ending with a number, so
+	// they remain part of Ref.
+	Date time.Time
+	SHA  string
+	Ref  string
+
+	// Variant is the build variant, which tells builds of the same version
+	// with different build options apart.
+	Variant int
+
+	// number is the version number as originally written, like "24.0.05".
+	number string
+}
+
+// ParseVersion parses a version, like "29.4", "30.1-rc1",
+// "30.0.93-pretest-1" or "2021-07-01.1b88404.master". Release names with a
+// "Emacs-" or "Emacs." prefix are also accepted.
+func ParseVersion(s string) (*Version, error) {
+	if s == "" {
+		return nil, ErrEmptyVersion
+	}
+
+	version := strings.TrimPrefix(strings.TrimPrefix(s, "Emacs-"), "Emacs.")
+
+	if m := nightlyVersion.FindStringSubmatch(version); m != nil {
+		date, err := time.Parse(nightlyDateFormat, m[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidVersion, s, err)
+		}
+
+		return &Version{
+			Channel: Nightly,
+			Date:    date,
+			SHA:     m[2],
+			Ref:     m[3],
+		}, nil
+	}
+
+	m := numberedVersion.FindStringSubmatch(version)
+	if m == nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidVersion, s)
+	}
+
+	v := &Version{
+		Major:  atoi(m[1]),
+		Minor:  atoi(m[2]),
+		Patch:  atoi(m[3]),
+		Suffix: m[4],
+		RC:     atoi(m[5]),
+		Extra:  m[6],
+		number: m[1] + "." + m[2],
+	}
+	if m[3] != "" {
+		v.number += "." + m[3]
+	}
+	if m[8] != "" {
+		v.Variant = atoi(m[8])
+	}
+
+	switch {
+	case m[7] != "" || v.Patch >= pretestPatch:
+		v.Channel = Pretest
+	case m[5] != "":
+		v.Channel = RC
+	default:
+		v.Channel = Stable
+	}
+
+	return v, nil
+}
+
+// ParseGitRef returns the version built from given git ref of the Emacs
+// repository. Release tags like "emacs-29.4", "emacs-30.1-rc1" and
+// "emacs-30.0.93" give numbered versions, while all other refs give a nightly
+// version without a date or SHA, which need to be set from the commit which
+// is built.
+func ParseGitRef(ref string) (*Version, error) {
+	m := gitTag.FindStringSubmatch(ref)
+	if m == nil {
+		return &Version{Channel: Nightly, Ref: sanitize.String(ref)}, nil
+	}
+
+	v, err := ParseVersion(m[2])
+	if err != nil {
+		return nil, err
+	}
+	if m[1] != "" {
+		v.Channel = Pretest
+	}
+
+	return v, nil
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+
+	return n
+}
+
+// Nightly reports if s is a nightly version.
+func (s *Version) Nightly() bool {
+	return s.Channel == Nightly
+}
+
+// Prerelease reports if s is anything other than a stable release.
+func (s *Version) Prerelease() bool {
+	return s.Channel != Stable
+}
+
+// Number returns the version number, without any suffix, release candidate,
+// pretest or variant parts. It is empty for nightly versions.
+func (s *Version) Number() string {
+	if s.Nightly() {
+		return ""
+	}
+	if s.number != "" {
+		return s.number
+	}
+
+	n := fmt.Sprintf("%d.%d", s.Major, s.Minor)
+	if s.Patch != 0 {
+		n += fmt.Sprintf(".%d", s.Patch)
+	}
+
+	return n
+}
+
+// String returns the version in the form used by release names.
+func (s *Version) String() string {
+	var b strings.Builder
+
+	if s.Nightly() {
+		b.WriteString(s.Date.Format(nightlyDateFormat))
+		b.WriteString("." + s.SHA + "." + s.Ref)
+	} else {
+		b.WriteString(s.Number() + s.Suffix)
+		if s.RC != 0 {
+			b.WriteString("-rc" + strconv.Itoa(s.RC))
+		}
+		b.WriteString(s.Extra)
+		if s.Channel == Pretest {
+			b.WriteString("-pretest")
+		}
+	}
+
+	if s.Variant != 0 {
+		b.WriteString("-" + strconv.Itoa(s.Variant))
+	}
+
+	return b.String()
+}
+
+// Absolute returns the version in the form used by build names, which
+// identifies the exact commit built from its Date, SHA and Ref, like
+// "2024-06-22.abcdef0.emacs-29-4". It is the same as String for nightly
+// versions, while pretest and variant suffixes are kept for numbered ones.
+func (s *Version) Absolute() string {
+	var b strings.Builder
+
+	b.WriteString(s.Date.Format(nightlyDateFormat))
+	b.WriteString("." + s.SHA + "." + s.Ref)
+	if s.Channel == Pretest {
+		b.WriteString("-pretest")
+	}
+	if s.Variant != 0 {
+		b.WriteString("-" + strconv.Itoa(s.Variant))
+	}
+
+	return b.String()
+}
+
+// ReleaseName returns the name of the GitHub release of the version, like
+// "Emacs-29.4" or "Emacs.2021-07-01.1b88404.master".
+func (s *Version) ReleaseName() string {
+	if s.Nightly() {
+		return "Emacs." + s.String()
+	}
+
+	return "Emacs-" + s.String()
+}
+
+// Compare returns -1, 0 or +1 depending on whether s sorts before, the same
+// as, or after other.
+//
+// Numbered versions are ordered by version number, with pretests and release
+// candidates preceding the stable release of the same number. Nightly
+// versions sort after all numbered versions, ordered by date. Builds of the
+// same version are ordered by variant.
+func (s *Version) Compare(other *Version) int {
+	if c := compareBool(s.Nightly(), other.Nightly()); c != 0 {
+		return c
+	}
+
+	var c int
+	if s.Nightly() {
+		c = cmp.Or(
+			s.Date.Compare(other.Date),
+			strings.Compare(s.Ref, other.Ref),
+			strings.Compare(s.SHA, other.SHA),
+		)
+	} else {
+		c = cmp.Or(
+			cmp.Compare(s.Major, other.Major),
+			cmp.Compare(s.Minor, other.Minor),
+			cmp.Compare(s.Patch, other.Patch),
+			strings.Compare(s.Suffix, other.Suffix),
+			cmp.Compare(channelRank(s.Channel), channelRank(other.Channel)),
+			compareRC(s.RC, other.RC),
+			strings.Compare(s.Extra, other.Extra),
+		)
+	}
+
+	return cmp.Or(c, cmp.Compare(s.Variant, other.Variant))
+}
+
+// channelRank orders channels of versions sharing the same version number.
+func channelRank(c Channel) int {
+	switch c {
+	case Pretest:
+		return 0
+	case RC:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// compareRC orders release candidates before final releases, which have a
+// RC number of zero.
+func compareRC(a, b int) int {
+	if a == 0 || b == 0 {
+		return compareBool(a == 0, b == 0)
+	}
+
+	return cmp.Compare(a, b)
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// VersionToName returns the release name of given version. Versions which
+// can not be parsed are assumed to be nightly versions.
 func VersionToName(version string) (string, error) {
 	if version == "" {
 		return "", ErrEmptyVersion
 	}
 
-	if stableVersion.MatchString(version) ||
-		pretestVersion.MatchString(version) {
-		return "Emacs-" + version, nil
+	if v, err := ParseVersion(version); err == nil {
+		return v.ReleaseName(), nil
 	}
 
 	return "Emacs." + version, nil
 }
 
+// GitRefToStableVersion returns the version of given git tag of a stable
+// release.
 func GitRefToStableVersion(ref string) (string, error) {
-	if m := stableGitRef.FindStringSubmatch(ref); len(m) > 1 {
-		return m[1], nil
+	v, err := ParseGitRef(ref)
+	if err != nil || v.Channel != Stable {
+		return "", fmt.Errorf("%w: \"%s\"", ErrNotStableRef, ref)
 	}
 
-	return "", fmt.Errorf("%w: \"%s\"", ErrNotStableRef, ref)
+	return v.String(), nil
 }
@@ -2,8 +2,11 @@ package release
 
 import (
 	"testing"
+	"time"
 
+	"github.com/jimeh/build-emacs-for-macos/pkg/sanitize"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestVersionToName(t *testing.T) {
@@ -79,6 +82,13 @@ func TestVersionToName(t *testing.T) {
 			},
 			want: "Emacs-23.3b-1",
 		},
+		{
+			name: "unparsable",
+			args: args{
+				version: "foo-bar",
+			},
+			want: "Emacs.foo-bar",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -171,3 +181,606 @@ func TestGitRefToStableVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestParseGitRef(t *testing.T) {
+	type args struct {
+		ref string
+	}
+	type want struct {
+		version string
+		channel Channel
+	}
+	tests := []struct {
+		name string
+		args args
+		want want
+	}{
+		{
+			name: "master",
+			args: args{ref: "master"},
+			want: want{version: "", channel: Nightly},
+		},
+		{
+			name: "emacs-28",
+			args: args{ref: "emacs-28"},
+			want: want{version: "", channel: Nightly},
+		},
+		{
+			name: "emacs-27",
+			args: args{ref: "emacs-27"},
+			want: want{version: "", channel: Nightly},
+		},
+		{
+			name: "emacs-26",
+			args: args{ref: "emacs-26"},
+			want: want{version: "", channel: Nightly},
+		},
+		{
+			name: "emacs-24",
+			args: args{ref: "emacs-24"},
+			want: want{version: "", channel: Nightly},
+		},
+		{
+			name: "feature/native-comp",
+			args: args{ref: "feature/native-comp"},
+			want: want{version: "", channel: Nightly},
+		},
+		{
+			name: "feature/pgtk",
+			args: args{ref: "feature/pgtk"},
+			want: want{version: "", channel: Nightly},
+		},
+		{
+			name: "emacs-19.34",
+			args: args{ref: "emacs-19.34"},
+			want: want{version: "19.34", channel: Stable},
+		},
+		{
+			name: "emacs-20.4",
+			args: args{ref: "emacs-20.4"},
+			want: want{version: "20.4", channel: Stable},
+		},
+		{
+			name: "emacs-22.3",
+			args: args{ref: "emacs-22.3"},
+			want: want{version: "22.3", channel: Stable},
+		},
+		{
+			name: "emacs-23.4",
+			args: args{ref: "emacs-23.4"},
+			want: want{version: "23.4", channel: Stable},
+		},
+		{
+			name: "emacs-24.0.97",
+			args: args{ref: "emacs-24.0.97"},
+			want: want{version: "24.0.97-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-24.2",
+			args: args{ref: "emacs-24.2"},
+			want: want{version: "24.2", channel: Stable},
+		},
+		{
+			name: "emacs-24.2.90",
+			args: args{ref: "emacs-24.2.90"},
+			want: want{version: "24.2.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-24.2.93",
+			args: args{ref: "emacs-24.2.93"},
+			want: want{version: "24.2.93-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-24.3",
+			args: args{ref: "emacs-24.3"},
+			want: want{version: "24.3", channel: Stable},
+		},
+		{
+			name: "emacs-24.3-rc1",
+			args: args{ref: "emacs-24.3-rc1"},
+			want: want{version: "24.3-rc1", channel: RC},
+		},
+		{
+			name: "emacs-24.3.90",
+			args: args{ref: "emacs-24.3.90"},
+			want: want{version: "24.3.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-24.3.94",
+			args: args{ref: "emacs-24.3.94"},
+			want: want{version: "24.3.94-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-24.4",
+			args: args{ref: "emacs-24.4"},
+			want: want{version: "24.4", channel: Stable},
+		},
+		{
+			name: "emacs-24.4-rc1",
+			args: args{ref: "emacs-24.4-rc1"},
+			want: want{version: "24.4-rc1", channel: RC},
+		},
+		{
+			name: "emacs-24.4.90",
+			args: args{ref: "emacs-24.4.90"},
+			want: want{version: "24.4.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-24.4.91",
+			args: args{ref: "emacs-24.4.91"},
+			want: want{version: "24.4.91-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-24.5",
+			args: args{ref: "emacs-24.5"},
+			want: want{version: "24.5", channel: Stable},
+		},
+		{
+			name: "emacs-24.5-rc1",
+			args: args{ref: "emacs-24.5-rc1"},
+			want: want{version: "24.5-rc1", channel: RC},
+		},
+		{
+			name: "emacs-24.5-rc3",
+			args: args{ref: "emacs-24.5-rc3"},
+			want: want{version: "24.5-rc3", channel: RC},
+		},
+		{
+			name: "emacs-24.5-rc3-fixed",
+			args: args{ref: "emacs-24.5-rc3-fixed"},
+			want: want{version: "24.5-rc3-fixed", channel: RC},
+		},
+		{
+			name: "emacs-25.0.90",
+			args: args{ref: "emacs-25.0.90"},
+			want: want{version: "25.0.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-25.0.95",
+			args: args{ref: "emacs-25.0.95"},
+			want: want{version: "25.0.95-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-25.1",
+			args: args{ref: "emacs-25.1"},
+			want: want{version: "25.1", channel: Stable},
+		},
+		{
+			name: "emacs-25.1-rc1",
+			args: args{ref: "emacs-25.1-rc1"},
+			want: want{version: "25.1-rc1", channel: RC},
+		},
+		{
+			name: "emacs-25.1-rc2",
+			args: args{ref: "emacs-25.1-rc2"},
+			want: want{version: "25.1-rc2", channel: RC},
+		},
+		{
+			name: "emacs-25.1.90",
+			args: args{ref: "emacs-25.1.90"},
+			want: want{version: "25.1.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-25.1.91",
+			args: args{ref: "emacs-25.1.91"},
+			want: want{version: "25.1.91-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-25.2",
+			args: args{ref: "emacs-25.2"},
+			want: want{version: "25.2", channel: Stable},
+		},
+		{
+			name: "emacs-25.2-rc1",
+			args: args{ref: "emacs-25.2-rc1"},
+			want: want{version: "25.2-rc1", channel: RC},
+		},
+		{
+			name: "emacs-25.2-rc2",
+			args: args{ref: "emacs-25.2-rc2"},
+			want: want{version: "25.2-rc2", channel: RC},
+		},
+		{
+			name: "emacs-26.0.90",
+			args: args{ref: "emacs-26.0.90"},
+			want: want{version: "26.0.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-26.0.91",
+			args: args{ref: "emacs-26.0.91"},
+			want: want{version: "26.0.91-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-26.1",
+			args: args{ref: "emacs-26.1"},
+			want: want{version: "26.1", channel: Stable},
+		},
+		{
+			name: "emacs-26.1-rc1",
+			args: args{ref: "emacs-26.1-rc1"},
+			want: want{version: "26.1-rc1", channel: RC},
+		},
+		{
+			name: "emacs-26.1.90",
+			args: args{ref: "emacs-26.1.90"},
+			want: want{version: "26.1.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-26.1.92",
+			args: args{ref: "emacs-26.1.92"},
+			want: want{version: "26.1.92-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-26.2",
+			args: args{ref: "emacs-26.2"},
+			want: want{version: "26.2", channel: Stable},
+		},
+		{
+			name: "emacs-26.2.90",
+			args: args{ref: "emacs-26.2.90"},
+			want: want{version: "26.2.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-26.3",
+			args: args{ref: "emacs-26.3"},
+			want: want{version: "26.3", channel: Stable},
+		},
+		{
+			name: "emacs-26.3-rc1",
+			args: args{ref: "emacs-26.3-rc1"},
+			want: want{version: "26.3-rc1", channel: RC},
+		},
+		{
+			name: "emacs-27.0.90",
+			args: args{ref: "emacs-27.0.90"},
+			want: want{version: "27.0.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-27.0.91",
+			args: args{ref: "emacs-27.0.91"},
+			want: want{version: "27.0.91-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-27.1",
+			args: args{ref: "emacs-27.1"},
+			want: want{version: "27.1", channel: Stable},
+		},
+		{
+			name: "emacs-27.1-rc1",
+			args: args{ref: "emacs-27.1-rc1"},
+			want: want{version: "27.1-rc1", channel: RC},
+		},
+		{
+			name: "emacs-27.1-rc2",
+			args: args{ref: "emacs-27.1-rc2"},
+			want: want{version: "27.1-rc2", channel: RC},
+		},
+		{
+			name: "emacs-27.1.90",
+			args: args{ref: "emacs-27.1.90"},
+			want: want{version: "27.1.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-27.1.91",
+			args: args{ref: "emacs-27.1.91"},
+			want: want{version: "27.1.91-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-27.2",
+			args: args{ref: "emacs-27.2"},
+			want: want{version: "27.2", channel: Stable},
+		},
+		{
+			name: "emacs-27.2-rc1",
+			args: args{ref: "emacs-27.2-rc1"},
+			want: want{version: "27.2-rc1", channel: RC},
+		},
+		{
+			name: "emacs-27.2-rc2",
+			args: args{ref: "emacs-27.2-rc2"},
+			want: want{version: "27.2-rc2", channel: RC},
+		},
+		{
+			name: "emacs-28.0.90",
+			args: args{ref: "emacs-28.0.90"},
+			want: want{version: "28.0.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-21.0.100",
+			args: args{ref: "emacs-pretest-21.0.100"},
+			want: want{version: "21.0.100-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-21.0.106",
+			args: args{ref: "emacs-pretest-21.0.106"},
+			want: want{version: "21.0.106-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-21.0.90",
+			args: args{ref: "emacs-pretest-21.0.90"},
+			want: want{version: "21.0.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-21.0.99",
+			args: args{ref: "emacs-pretest-21.0.99"},
+			want: want{version: "21.0.99-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-22.0.90",
+			args: args{ref: "emacs-pretest-22.0.90"},
+			want: want{version: "22.0.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-22.0.99",
+			args: args{ref: "emacs-pretest-22.0.99"},
+			want: want{version: "22.0.99-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-22.0.990",
+			args: args{ref: "emacs-pretest-22.0.990"},
+			want: want{version: "22.0.990-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-22.1.90",
+			args: args{ref: "emacs-pretest-22.1.90"},
+			want: want{version: "22.1.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-22.2.90",
+			args: args{ref: "emacs-pretest-22.2.90"},
+			want: want{version: "22.2.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-23.0.90",
+			args: args{ref: "emacs-pretest-23.0.90"},
+			want: want{version: "23.0.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-23.1.90",
+			args: args{ref: "emacs-pretest-23.1.90"},
+			want: want{version: "23.1.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-23.2.90",
+			args: args{ref: "emacs-pretest-23.2.90"},
+			want: want{version: "23.2.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-23.2.91",
+			args: args{ref: "emacs-pretest-23.2.91"},
+			want: want{version: "23.2.91-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-23.2.93",
+			args: args{ref: "emacs-pretest-23.2.93"},
+			want: want{version: "23.2.93-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-23.2.93.1",
+			args: args{ref: "emacs-pretest-23.2.93.1"},
+			want: want{version: "23.2.93.1-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-23.3.90",
+			args: args{ref: "emacs-pretest-23.3.90"},
+			want: want{version: "23.3.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-24.0.05",
+			args: args{ref: "emacs-pretest-24.0.05"},
+			want: want{version: "24.0.05-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-pretest-24.0.90",
+			args: args{ref: "emacs-pretest-24.0.90"},
+			want: want{version: "24.0.90-pretest", channel: Pretest},
+		},
+		{
+			name: "emacs-23.3b",
+			args: args{ref: "emacs-23.3b"},
+			want: want{version: "23.3b", channel: Stable},
+		},
+		{
+			name: "emacs-30.1-rc1",
+			args: args{ref: "emacs-30.1-rc1"},
+			want: want{version: "30.1-rc1", channel: RC},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseGitRef(tt.args.ref)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.channel, got.Channel)
+			if tt.want.channel == Nightly {
+				assert.Equal(t, sanitize.String(tt.args.ref), got.Ref)
+			} else {
+				assert.Equal(t, tt.want.version, got.String())
+			}
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		want     *Version
+		wantName string
+		wantErr  error
+	}{
+		{
+			name:    "empty",
+			version: "",
+			wantErr: ErrEmptyVersion,
+		},
+		{
+			name:    "invalid",
+			version: "test-builds-foo",
+			wantErr: ErrInvalidVersion,
+		},
+		{
+			name:    "stable",
+			version: "29.4",
+			want: &Version{
+				Channel: Stable, Major: 29, Minor: 4, number: "29.4",
+			},
+			wantName: "Emacs-29.4",
+		},
+		{
+			name:    "stable release name with variant",
+			version: "Emacs-29.4-2",
+			want: &Version{
+				Channel: Stable, Major: 29, Minor: 4, Variant: 2,
+				number: "29.4",
+			},
+			wantName: "Emacs-29.4-2",
+		},
+		{
+			name:    "stable with letter",
+			version: "23.3b",
+			want: &Version{
+				Channel: Stable, Major: 23, Minor: 3, Suffix: "b",
+				number: "23.3",
+			},
+			wantName: "Emacs-23.3b",
+		},
+		{
+			name:    "release candidate",
+			version: "Emacs-30.1-rc1",
+			want: &Version{
+				Channel: RC, Major: 30, Minor: 1, RC: 1, number: "30.1",
+			},
+			wantName: "Emacs-30.1-rc1",
+		},
+		{
+			name:    "pretest",
+			version: "30.0.93-pretest-1",
+			want: &Version{
+				Channel: Pretest, Major: 30, Minor: 0, Patch: 93, Variant: 1,
+				number: "30.0.93",
+			},
+			wantName: "Emacs-30.0.93-pretest-1",
+		},
+		{
+			name:    "pretest patch level",
+			version: "30.0.90",
+			want: &Version{
+				Channel: Pretest, Major: 30, Minor: 0, Patch: 90,
+				number: "30.0.90",
+			},
+			wantName: "Emacs-30.0.90-pretest",
+		},
+		{
+			name:    "nightly",
+			version: "Emacs.2021-07-01.1b88404.master",
+			want: &Version{
+				Channel: Nightly,
+				Date:    time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC),
+				SHA:     "1b88404",
+				Ref:     "master",
+			},
+			wantName: "Emacs.2021-07-01.1b88404.master",
+		},
+		{
+			name:    "nightly of branch",
+			version: "2024-06-01.abcdef0.emacs-30",
+			want: &Version{
+				Channel: Nightly,
+				Date:    time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+				SHA:     "abcdef0",
+				Ref:     "emacs-30",
+			},
+			wantName: "Emacs.2024-06-01.abcdef0.emacs-30",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVersion(tt.version)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantName, got.ReleaseName())
+		})
+	}
+}
+
+func TestVersion_Compare(t *testing.T) {
+	// Versions in ascending order.
+	ordered := []string{
+		"23.3",
+		"23.3a",
+		"23.3b",
+		"24.5-rc3",
+		"24.5-rc3-fixed",
+		"24.5",
+		"24.5-1",
+		"30.0.90-pretest",
+		"30.0.93-pretest",
+		"30.0.93-pretest-1",
+		"30.1-rc1",
+		"30.1-rc2",
+		"30.1",
+		"30.1-1",
+		"30.1-2",
+		"30.2",
+		"2021-07-01.1b88404.master",
+		"2024-06-01.abcdef0.emacs-30",
+		"2024-06-01.abcdef0.master",
+		"2024-06-02.1234567.master",
+	}
+
+	versions := make([]*Version, 0, len(ordered))
+	for _, s := range ordered {
+		v, err := ParseVersion(s)
+		require.NoError(t, err)
+		versions = append(versions, v)
+	}
+
+	for i, a := range versions {
+		for j, b := range versions {
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+
+			assert.Equal(t, want, a.Compare(b), "%s <=> %s", a, b)
+		}
+	}
+}
+
+func TestVersion_Absolute(t *testing.T) {
+	tests := []struct {
+		ref     string
+		variant int
+		want    string
+	}{
+		{ref: "master", want: "2024-06-22.abcdef0.master"},
+		{ref: "master", variant: 1, want: "2024-06-22.abcdef0.master-1"},
+		{ref: "emacs-29.4", want: "2024-06-22.abcdef0.emacs-29-4"},
+		{
+			ref:     "emacs-30.0.93",
+			variant: 2,
+			want:    "2024-06-22.abcdef0.emacs-30-0-93-pretest-2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			v, err := ParseGitRef(tt.ref)
+			require.NoError(t, err)
+			v.Date = time.Date(2024, 6, 22, 0, 0, 0, 0, time.UTC)
+			v.SHA = "abcdef0"
+			v.Ref = sanitize.String(tt.ref)
+			v.Variant = tt.variant
+
+			assert.Equal(t, tt.want, v.Absolute())
+		})
+	}
+}
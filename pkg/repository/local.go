@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalRef returns the ref checked out in a local git repository. An emacs-*
+// release tag pointing at HEAD is preferred over the current branch name, so
+// release builds can be planned from a checkout of the tag.
+func LocalRef(ctx context.Context, dir string) (string, error) {
+	out, err := git(ctx, dir,
+		"tag", "--points-at", "HEAD", "--list", "emacs-*",
+		"--sort=-version:refname",
+	)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrLocal, err)
+	}
+	if tags := strings.Fields(out); len(tags) > 0 {
+		return tags[0], nil
+	}
+
+	out, err = git(ctx, dir, "symbolic-ref", "--quiet", "--short", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf(
+			"%w: HEAD is detached and not tagged, a ref must be specified",
+			ErrLocal,
+		)
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// Archive writes a gzipped tarball of ref in a local git repository to
+// filename, with all files placed within a top-level directory named prefix.
+func Archive(
+	ctx context.Context,
+	dir, ref, prefix, filename string,
+) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := filename + ".tmp"
+	_, err = git(ctx, dir,
+		"archive", "--format=tar.gz", "--prefix="+prefix+"/",
+		"--output="+tmpFile, ref,
+	)
+	if err != nil {
+		_ = os.Remove(tmpFile)
+
+		return fmt.Errorf("%w: %w", ErrLocal, err)
+	}
+
+	return os.Rename(tmpFile, filename)
+}
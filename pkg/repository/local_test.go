@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalRef(t *testing.T) {
+	ctx := context.Background()
+	dir, run := newGitRepo(t)
+
+	got, err := LocalRef(ctx, dir)
+	require.NoError(t, err)
+	assert.Equal(t, "master", got)
+
+	run("tag", "emacs-28.0.90")
+	run("tag", "emacs-28.1")
+	run("tag", "other")
+
+	got, err = LocalRef(ctx, dir)
+	require.NoError(t, err)
+	assert.Equal(t, "emacs-28.1", got)
+
+	run("tag", "-d", "emacs-28.1", "emacs-28.0.90")
+	run("checkout", "--quiet", "--detach")
+
+	_, err = LocalRef(ctx, dir)
+	assert.ErrorIs(t, err, ErrLocal)
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	dir, _ := newGitRepo(t)
+	filename := filepath.Join(t.TempDir(), "tarballs", "emacs-abc.tgz")
+
+	err := Archive(ctx, dir, "master", "emacs-abc", filename)
+	require.NoError(t, err)
+
+	out, err := exec.Command("tar", "-tzf", filename).Output()
+	require.NoError(t, err)
+	assert.Equal(t, "emacs-abc/\nemacs-abc/README\n", string(out))
+
+	err = Archive(ctx, dir, "nope", "emacs-abc", filename+"2")
+	assert.ErrorIs(t, err, ErrLocal)
+	assert.NoFileExists(t, filename+"2.tmp")
+}
@@ -0,0 +1,457 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
+	"github.com/jimeh/build-emacs-for-macos/pkg/gh"
+)
+
+// ErrUnsupported is returned when a repository type has no provider.
+var ErrUnsupported = fmt.Errorf("%w: unsupported repository type", Err)
+
+// MaxCommits is the maximum number of commits returned by Provider.Commits.
+const MaxCommits = 250
+
+// Provider looks up information about commits in a repository.
+type Provider interface {
+	// Commit returns the commit given ref points to.
+	Commit(ctx context.Context, ref string) (*commit.Commit, error)
+
+	// Commits returns the commits reachable from head but not from base,
+	// newest first, up to MaxCommits.
+	Commits(ctx context.Context, base, head string) ([]*commit.Commit, error)
+}
+
+type ProviderOptions struct {
+	// GitHubToken is used for GitHub API requests. Falls back to the
+	// GITHUB_TOKEN environment variable.
+	GitHubToken string
+
+	// GitLabToken is used for GitLab API requests. Falls back to the
+	// GITLAB_TOKEN environment variable.
+	GitLabToken string
+
+	// HTTPClient is used for GitLab API requests. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewProvider returns a Provider for the given repository based on its type.
+func NewProvider(
+	ctx context.Context,
+	repo *Repository,
+	opts *ProviderOptions,
+) (Provider, error) {
+	if opts == nil {
+		opts = &ProviderOptions{}
+	}
+
+	switch repo.Type {
+	case GitHub:
+		return &gitHubProvider{
+			repo:   repo,
+			client: gh.New(ctx, opts.GitHubToken),
+		}, nil
+	case GitLab:
+		token := opts.GitLabToken
+		if token == "" {
+			token = os.Getenv("GITLAB_TOKEN")
+		}
+		client := opts.HTTPClient
+		if client == nil {
+			client = http.DefaultClient
+		}
+
+		return &gitLabProvider{repo: repo, token: token, client: client}, nil
+	case Cgit:
+		return &cgitProvider{repo: repo}, nil
+	case Local:
+		return &localProvider{repo: repo}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, repo.Type)
+	}
+}
+
+type gitHubProvider struct {
+	repo   *Repository
+	client *github.Client
+}
+
+func (s *gitHubProvider) Commit(
+	ctx context.Context,
+	ref string,
+) (*commit.Commit, error) {
+	rc, _, err := s.client.Repositories.GetCommit(
+		ctx, s.repo.Owner(), s.repo.Name(), ref,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return commit.New(rc), nil
+}
+
+func (s *gitHubProvider) Commits(
+	ctx context.Context,
+	base, head string,
+) ([]*commit.Commit, error) {
+	comp, err := s.compare(ctx, base, head, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compared commits are listed oldest first, so the newest commits are on
+	// the last pages.
+	pages := (comp.GetTotalCommits() + gitHubComparePerPage - 1) /
+		gitHubComparePerPage
+	var commits []*commit.Commit
+	for page := pages; page >= 1 && len(commits) < MaxCommits; page-- {
+		pageComp := comp
+		if page != 1 {
+			pageComp, err = s.compare(ctx, base, head, page)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for i := len(pageComp.Commits) - 1; i >= 0; i-- {
+			if len(commits) >= MaxCommits {
+				break
+			}
+			commits = append(commits, commit.New(pageComp.Commits[i]))
+		}
+	}
+
+	return commits, nil
+}
+
+// gitHubComparePerPage is the number of commits per page of comparisons.
+const gitHubComparePerPage = 100
+
+// compare returns given page of the comparison of two commits. The go-github
+// client does not support paginating comparisons.
+func (s *gitHubProvider) compare(
+	ctx context.Context,
+	base, head string,
+	page int,
+) (*github.CommitsComparison, error) {
+	u := fmt.Sprintf("repos/%s/%s/compare/%s...%s?%s",
+		s.repo.Owner(), s.repo.Name(),
+		url.PathEscape(base), url.PathEscape(head),
+		url.Values{
+			"per_page": []string{strconv.Itoa(gitHubComparePerPage)},
+			"page":     []string{strconv.Itoa(page)},
+		}.Encode(),
+	)
+	req, err := s.client.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	comp := &github.CommitsComparison{}
+	_, err = s.client.Do(ctx, req, comp)
+	if err != nil {
+		return nil, err
+	}
+
+	return comp, nil
+}
+
+type gitLabProvider struct {
+	repo   *Repository
+	token  string
+	client *http.Client
+}
+
+type gitLabCommit struct {
+	ID             string     `json:"id"`
+	AuthorName     string     `json:"author_name"`
+	AuthorEmail    string     `json:"author_email"`
+	CommitterName  string     `json:"committer_name"`
+	CommitterEmail string     `json:"committer_email"`
+	CommittedDate  *time.Time `json:"committed_date"`
+	Message        string     `json:"message"`
+}
+
+func (c *gitLabCommit) commit() *commit.Commit {
+	return &commit.Commit{
+		SHA:       c.ID,
+		Date:      c.CommittedDate,
+		Author:    fmt.Sprintf("%s <%s>", c.AuthorName, c.AuthorEmail),
+		Committer: fmt.Sprintf("%s <%s>", c.CommitterName, c.CommitterEmail),
+		Message:   c.Message,
+	}
+}
+
+func (s *gitLabProvider) Commit(
+	ctx context.Context,
+	ref string,
+) (*commit.Commit, error) {
+	var c gitLabCommit
+	err := s.get(ctx, "/repository/commits/"+url.PathEscape(ref), &c)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.commit(), nil
+}
+
+func (s *gitLabProvider) Commits(
+	ctx context.Context,
+	base, head string,
+) ([]*commit.Commit, error) {
+	var comp struct {
+		Commits []*gitLabCommit `json:"commits"`
+	}
+	err := s.get(ctx, "/repository/compare?"+url.Values{
+		"from": []string{base},
+		"to":   []string{head},
+	}.Encode(), &comp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compared commits are listed oldest first.
+	commits := make([]*commit.Commit, 0, len(comp.Commits))
+	for i := len(comp.Commits) - 1; i >= 0 && len(commits) < MaxCommits; i-- {
+		commits = append(commits, comp.Commits[i].commit())
+	}
+
+	return commits, nil
+}
+
+// get performs a GET request against given path of the project's API, and
+// decodes the JSON response into v.
+func (s *gitLabProvider) get(ctx context.Context, path string, v any) error {
+	u := s.repo.Host() + "/api/v4/projects/" +
+		url.PathEscape(s.repo.path()) + path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if s.token != "" {
+		req.Header.Set("PRIVATE-TOKEN", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: GET %s: %s", ErrGitLab, u, resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrGitLab, err)
+	}
+
+	return nil
+}
+
+// cgitProvider looks up commits with a shallow, tree-less git fetch, as cgit
+// does not offer an API.
+type cgitProvider struct {
+	repo *Repository
+}
+
+func (s *cgitProvider) Commit(
+	ctx context.Context,
+	ref string,
+) (*commit.Commit, error) {
+	dir, err := os.MkdirTemp("", "emacs-builder-cgit-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = git(ctx, dir, "init", "--quiet", "--bare")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCgit, err)
+	}
+
+	_, err = git(ctx, dir,
+		"fetch", "--quiet", "--depth=1", "--filter=tree:0",
+		s.repo.CloneURL(), ref,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCgit, err)
+	}
+
+	return gitLog(ctx, dir, "FETCH_HEAD")
+}
+
+func (s *cgitProvider) Commits(
+	ctx context.Context,
+	base, head string,
+) ([]*commit.Commit, error) {
+	dir, err := os.MkdirTemp("", "emacs-builder-cgit-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = git(ctx, dir, "init", "--quiet", "--bare")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCgit, err)
+	}
+
+	// History is fetched no deeper than the number of commits returned, so
+	// comparing far apart commits does not fetch the whole repository.
+	for _, f := range []struct {
+		ref   string
+		name  string
+		depth int
+	}{
+		{ref: base, name: "base", depth: 1},
+		{ref: head, name: "head", depth: MaxCommits + 1},
+	} {
+		_, err = git(ctx, dir,
+			"fetch", "--quiet", "--depth="+strconv.Itoa(f.depth),
+			"--filter=tree:0", s.repo.CloneURL(),
+			"+"+f.ref+":refs/compare/"+f.name,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCgit, err)
+		}
+	}
+
+	commits, err := gitLogs(ctx, dir,
+		"--max-count="+strconv.Itoa(MaxCommits),
+		"refs/compare/base..refs/compare/head",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCgit, err)
+	}
+
+	return commits, nil
+}
+
+type localProvider struct {
+	repo *Repository
+}
+
+func (s *localProvider) Commit(
+	ctx context.Context,
+	ref string,
+) (*commit.Commit, error) {
+	c, err := gitLog(ctx, s.repo.Source, ref)
+	if err == nil {
+		return c, nil
+	}
+
+	// Branches in fresh clones often only exist as remote-tracking refs.
+	c, err2 := gitLog(ctx, s.repo.Source, "refs/remotes/origin/"+ref)
+	if err2 != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLocal, err)
+	}
+
+	return c, nil
+}
+
+func (s *localProvider) Commits(
+	ctx context.Context,
+	base, head string,
+) ([]*commit.Commit, error) {
+	commits, err := gitLogs(ctx, s.repo.Source,
+		"--max-count="+strconv.Itoa(MaxCommits), base+".."+head,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLocal, err)
+	}
+
+	return commits, nil
+}
+
+// gitLogFormat separates fields with NUL bytes, the message is last as it may
+// contain anything but NUL bytes. Used with -z, commits are terminated by NUL
+// bytes too.
+const gitLogFormat = "%H%x00%an <%ae>%x00%cn <%ce>%x00%cI%x00%B"
+
+const gitLogFields = 5
+
+func gitLog(ctx context.Context, dir, ref string) (*commit.Commit, error) {
+	commits, err := gitLogs(ctx, dir, "-1", ref)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("%w: unexpected git log output", Err)
+	}
+
+	return commits[0], nil
+}
+
+// gitLogs returns the commits listed by git log with given arguments.
+func gitLogs(
+	ctx context.Context,
+	dir string,
+	args ...string,
+) ([]*commit.Commit, error) {
+	args = append([]string{"log", "-z", "--format=" + gitLogFormat}, args...)
+	out, err := git(ctx, dir, append(args, "--")...)
+	if err != nil {
+		return nil, err
+	}
+
+	if out == "" {
+		return []*commit.Commit{}, nil
+	}
+
+	parts := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	if len(parts)%gitLogFields != 0 {
+		return nil, fmt.Errorf("%w: unexpected git log output", Err)
+	}
+
+	commits := make([]*commit.Commit, 0, len(parts)/gitLogFields)
+	for i := 0; i < len(parts); i += gitLogFields {
+		date, err := time.Parse(time.RFC3339, parts[i+3])
+		if err != nil {
+			return nil, err
+		}
+
+		commits = append(commits, &commit.Commit{
+			SHA:       parts[i],
+			Date:      &date,
+			Author:    parts[i+1],
+			Committer: parts[i+2],
+			Message:   strings.TrimRight(parts[i+4], "\n"),
+		})
+	}
+
+	return commits, nil
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(
+		ctx, "git", append([]string{"-C", dir}, args...)...,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s",
+				args[0], strings.TrimSpace(string(exitErr.Stderr)),
+			)
+		}
+
+		return "", err
+	}
+
+	return string(out), nil
+}
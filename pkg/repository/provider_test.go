@@ -0,0 +1,255 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitLabProvider_Commit(t *testing.T) {
+	var gotPath, gotToken string
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.EscapedPath()
+			gotToken = r.Header.Get("PRIVATE-TOKEN")
+			if !strings.HasSuffix(gotPath, "/emacs-28") {
+				http.NotFound(w, r)
+
+				return
+			}
+			_, _ = w.Write([]byte(`{
+				"id": "7a475d7e4a7b3a1f3f1d2c3b4a596877665544aa",
+				"author_name": "Jane Doe",
+				"author_email": "jane@example.com",
+				"committer_name": "John Doe",
+				"committer_email": "john@example.com",
+				"committed_date": "2021-05-01T10:20:30Z",
+				"message": "Fix things\n"
+			}`))
+		},
+	))
+	defer srv.Close()
+
+	repo, err := Parse("gitlab:" + srv.URL + "/foo/emacs")
+	require.NoError(t, err)
+
+	p, err := NewProvider(context.Background(), repo, &ProviderOptions{
+		GitLabToken: "secret",
+	})
+	require.NoError(t, err)
+
+	got, err := p.Commit(context.Background(), "emacs-28")
+	require.NoError(t, err)
+
+	date := time.Date(2021, 5, 1, 10, 20, 30, 0, time.UTC)
+	assert.Equal(t,
+		"/api/v4/projects/foo%2Femacs/repository/commits/emacs-28", gotPath,
+	)
+	assert.Equal(t, "secret", gotToken)
+	assert.Equal(t, "7a475d7e4a7b3a1f3f1d2c3b4a596877665544aa", got.SHA)
+	assert.Equal(t, "Jane Doe <jane@example.com>", got.Author)
+	assert.Equal(t, "John Doe <john@example.com>", got.Committer)
+	assert.Equal(t, "Fix things\n", got.Message)
+	assert.True(t, date.Equal(*got.Date))
+
+	_, err = p.Commit(context.Background(), "nope")
+	assert.ErrorIs(t, err, ErrGitLab)
+}
+
+func TestLocalProvider_Commit(t *testing.T) {
+	dir, run := newGitRepo(t)
+	run("tag", "emacs-28.1")
+
+	repo, err := Parse(dir)
+	require.NoError(t, err)
+	p, err := NewProvider(context.Background(), repo, nil)
+	require.NoError(t, err)
+
+	for _, ref := range []string{"master", "emacs-28.1", "HEAD"} {
+		got, err := p.Commit(context.Background(), ref)
+		require.NoError(t, err)
+
+		date := time.Date(2021, 5, 2, 11, 22, 33, 0, time.UTC)
+		assert.Len(t, got.SHA, 40)
+		assert.Equal(t, "Jane Doe <jane@example.com>", got.Author)
+		assert.Equal(t, "John Doe <john@example.com>", got.Committer)
+		assert.Equal(t, "Initial commit\n\nWith a body.", got.Message)
+		assert.True(t, date.Equal(*got.Date))
+	}
+
+	_, err = p.Commit(context.Background(), "nope")
+	assert.ErrorIs(t, err, ErrLocal)
+}
+
+// newGitRepo creates a git repository with a single commit on the master
+// branch, and returns its path and a function to run git commands within it.
+func newGitRepo(t *testing.T) (string, func(args ...string)) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Jane Doe",
+			"GIT_AUTHOR_EMAIL=jane@example.com",
+			"GIT_AUTHOR_DATE=2021-05-01T10:20:30Z",
+			"GIT_COMMITTER_NAME=John Doe",
+			"GIT_COMMITTER_EMAIL=john@example.com",
+			"GIT_COMMITTER_DATE=2021-05-02T11:22:33Z",
+		)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	run("init", "--quiet", "--initial-branch=master")
+	require.NoError(t,
+		os.WriteFile(filepath.Join(dir, "README"), []byte("hi\n"), 0o644),
+	)
+	run("add", "README")
+	run("commit", "--quiet", "-m", "Initial commit\n\nWith a body.")
+
+	return dir, run
+}
+
+func TestGitLabProvider_Commits(t *testing.T) {
+	var gotPath, gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.EscapedPath()
+			gotQuery = r.URL.RawQuery
+			_, _ = w.Write([]byte(`{"commits": [
+				{"id": "1111111111111111111111111111111111111111",
+				 "author_name": "Jane Doe", "message": "First\n"},
+				{"id": "2222222222222222222222222222222222222222",
+				 "author_name": "John Doe", "message": "Second\n"}
+			]}`))
+		},
+	))
+	defer srv.Close()
+
+	repo, err := Parse("gitlab:" + srv.URL + "/foo/emacs")
+	require.NoError(t, err)
+	p, err := NewProvider(context.Background(), repo, nil)
+	require.NoError(t, err)
+
+	got, err := p.Commits(context.Background(), "emacs-29.3", "emacs-29.4")
+	require.NoError(t, err)
+
+	assert.Equal(t, "/api/v4/projects/foo%2Femacs/repository/compare", gotPath)
+	assert.Equal(t, "from=emacs-29.3&to=emacs-29.4", gotQuery)
+	require.Len(t, got, 2)
+	assert.Equal(t, "2222222222222222222222222222222222222222", got[0].SHA)
+	assert.Equal(t, "1111111111111111111111111111111111111111", got[1].SHA)
+	assert.Equal(t, "John Doe <>", got[0].Author)
+}
+
+func TestProvider_Commits_git(t *testing.T) {
+	dir, run := newGitRepo(t)
+	run("tag", "emacs-28.1")
+	run("commit", "--quiet", "--allow-empty", "-m", "Second commit")
+	run("commit", "--quiet", "--allow-empty", "-m", "Third commit\n\nBody.")
+	run("tag", "emacs-28.2")
+
+	local, err := Parse(dir)
+	require.NoError(t, err)
+
+	for _, repo := range []*Repository{
+		local,
+		{Type: Cgit, Source: "file://" + dir},
+	} {
+		t.Run(string(repo.Type), func(t *testing.T) {
+			p, err := NewProvider(context.Background(), repo, nil)
+			require.NoError(t, err)
+
+			got, err := p.Commits(
+				context.Background(), "emacs-28.1", "emacs-28.2",
+			)
+			require.NoError(t, err)
+
+			var messages []string
+			for _, c := range got {
+				assert.Len(t, c.SHA, 40)
+				assert.Equal(t, "Jane Doe <jane@example.com>", c.Author)
+				messages = append(messages, c.Message)
+			}
+			assert.Equal(t,
+				[]string{"Third commit\n\nBody.", "Second commit"}, messages,
+			)
+
+			got, err = p.Commits(
+				context.Background(), "emacs-28.2", "emacs-28.2",
+			)
+			require.NoError(t, err)
+			assert.Empty(t, got)
+		})
+	}
+}
+
+func TestGitHubProvider_Commits(t *testing.T) {
+	const total = 320
+	var pages []string
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t,
+				"/repos/emacs-mirror/emacs/compare/emacs-29.3...master",
+				r.URL.Path,
+			)
+			assert.Equal(t, "100", r.URL.Query().Get("per_page"))
+			page, err := strconv.Atoi(r.URL.Query().Get("page"))
+			require.NoError(t, err)
+			pages = append(pages, strconv.Itoa(page))
+
+			var commits []map[string]any
+			for i := (page-1)*100 + 1; i <= total && i <= page*100; i++ {
+				commits = append(commits, map[string]any{
+					"sha": fmt.Sprintf("%040d", i),
+					"commit": map[string]any{
+						"committer": map[string]any{
+							"date": "2024-06-22T00:00:00Z",
+						},
+					},
+				})
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]any{
+				"total_commits": total,
+				"commits":       commits,
+			}))
+		},
+	))
+	defer srv.Close()
+
+	repo, err := NewGitHub("emacs-mirror/emacs")
+	require.NoError(t, err)
+	client := github.NewClient(srv.Client())
+	client.BaseURL, err = url.Parse(srv.URL + "/")
+	require.NoError(t, err)
+	p := &gitHubProvider{repo: repo, client: client}
+
+	got, err := p.Commits(context.Background(), "emacs-29.3", "master")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1", "4", "3", "2"}, pages)
+	require.Len(t, got, MaxCommits)
+	assert.Equal(t, fmt.Sprintf("%040d", total), got[0].SHA)
+	assert.Equal(t, fmt.Sprintf("%040d", total-MaxCommits+1),
+		got[MaxCommits-1].SHA,
+	)
+}
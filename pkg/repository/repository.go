@@ -3,6 +3,9 @@ package repository
 import (
 	"errors"
 	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -10,22 +13,96 @@ import (
 var (
 	Err       = errors.New("repository")
 	ErrGitHub = fmt.Errorf("%w: github", Err)
+	ErrGitLab = fmt.Errorf("%w: gitlab", Err)
+	ErrCgit   = fmt.Errorf("%w: cgit", Err)
+	ErrLocal  = fmt.Errorf("%w: local", Err)
+	ErrParse  = fmt.Errorf("%w: unable to parse", Err)
 )
 
-const GitHubBaseURL = "https://github.com/"
+const (
+	GitHubBaseURL = "https://github.com/"
+	GitLabBaseURL = "https://gitlab.com/"
+	SavannahURL   = "https://git.savannah.gnu.org/cgit/emacs.git"
+
+	savannahHost = "git.savannah.gnu.org"
+)
 
 // Type is a repository type
 type Type string
 
-const GitHub Type = "github"
+const (
+	GitHub Type = "github"
+	GitLab Type = "gitlab"
+	Cgit   Type = "cgit"
+	Local  Type = "local"
+)
 
 // Repository represents basic information about a repository with helper
 // methods to get various pieces of information from it.
+//
+// For GitHub repositories Source is in "owner/name" format. For GitLab and
+// cgit repositories Source is the full web URL of the repository, and for
+// local repositories it is the absolute path to the git checkout.
 type Repository struct {
 	Type   Type   `yaml:"type,omitempty" json:"type,omitempty"`
 	Source string `yaml:"source,omitempty" json:"source,omitempty"`
 }
 
+// Parse returns a Repository based on given string. The repository type is
+// selected by an optional scheme prefix, or detected from the value itself:
+//
+//   - "owner/name", "github:owner/name", "https://github.com/owner/name"
+//   - "gitlab:group/name", "https://gitlab.example.com/group/name"
+//   - "savannah", "cgit:https://host/cgit/name.git"
+//   - "file:///path/to/emacs", "/path/to/emacs", "./emacs"
+func Parse(s string) (*Repository, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("%w: empty repository", ErrParse)
+	case s == "savannah":
+		return NewCgit(SavannahURL)
+	case strings.HasPrefix(s, "github:"):
+		return NewGitHub(strings.TrimPrefix(s, "github:"))
+	case strings.HasPrefix(s, "gitlab:"):
+		s = strings.TrimPrefix(s, "gitlab:")
+		if !strings.Contains(s, "://") {
+			s = GitLabBaseURL + s
+		}
+
+		return NewGitLab(s)
+	case strings.HasPrefix(s, "cgit:"):
+		return NewCgit(strings.TrimPrefix(s, "cgit:"))
+	case strings.HasPrefix(s, "file://"):
+		return NewLocal(strings.TrimPrefix(s, "file://"))
+	case strings.HasPrefix(s, "/"), strings.HasPrefix(s, "."),
+		strings.HasPrefix(s, "~"):
+		return NewLocal(s)
+	}
+
+	if !strings.Contains(s, "://") {
+		return NewGitHub(s)
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrParse, err)
+	}
+
+	switch {
+	case u.Host == "github.com":
+		path := strings.Trim(u.Path, "/")
+
+		return NewGitHub(strings.TrimSuffix(path, ".git"))
+	case strings.Contains(u.Host, "gitlab"):
+		return NewGitLab(s)
+	case strings.Contains(u.Path, "/cgit/"),
+		u.Host == savannahHost:
+		return NewCgit(s)
+	}
+
+	return nil, fmt.Errorf("%w: unknown repository type: %s", ErrParse, s)
+}
+
 func NewGitHub(ownerAndName string) (*Repository, error) {
 	parts := strings.Split(ownerAndName, "/")
 	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
@@ -41,10 +118,90 @@ func NewGitHub(ownerAndName string) (*Repository, error) {
 	}, nil
 }
 
+func NewGitLab(repoURL string) (*Repository, error) {
+	u, err := url.Parse(strings.TrimSuffix(repoURL, ".git"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrGitLab, err)
+	}
+
+	path := strings.Trim(u.Path, "/")
+	if u.Scheme == "" || u.Host == "" || !strings.Contains(path, "/") {
+		return nil, fmt.Errorf(
+			"%w: repository must be given as "+
+				"\"https://host/group/name\" URL",
+			ErrGitLab,
+		)
+	}
+
+	return &Repository{
+		Type:   GitLab,
+		Source: u.Scheme + "://" + u.Host + "/" + path,
+	}, nil
+}
+
+func NewCgit(repoURL string) (*Repository, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCgit, err)
+	}
+
+	path := strings.Trim(u.Path, "/")
+	if u.Scheme == "" || u.Host == "" || path == "" {
+		return nil, fmt.Errorf(
+			"%w: repository must be given as \"https://host/path\" URL",
+			ErrCgit,
+		)
+	}
+
+	// Savannah's cgit web interface and git server live under different
+	// paths, normalize to the web interface.
+	if u.Host == savannahHost {
+		path = strings.TrimPrefix(path, "git/")
+		path = "cgit/" + strings.TrimPrefix(path, "cgit/")
+	}
+
+	return &Repository{
+		Type:   Cgit,
+		Source: u.Scheme + "://" + u.Host + "/" + path,
+	}, nil
+}
+
+func NewLocal(path string) (*Repository, error) {
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrLocal, err)
+		}
+		path = filepath.Join(home, path[2:])
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLocal, err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLocal, err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrLocal, path)
+	}
+
+	return &Repository{
+		Type:   Local,
+		Source: path,
+	}, nil
+}
+
 func (s *Repository) Owner() string {
 	switch s.Type {
 	case GitHub:
 		return strings.SplitN(s.Source, "/", 2)[0]
+	case GitLab:
+		path := s.path()
+
+		return path[:strings.LastIndex(path, "/")]
 	default:
 		return ""
 	}
@@ -54,6 +211,42 @@ func (s *Repository) Name() string {
 	switch s.Type {
 	case GitHub:
 		return strings.SplitN(s.Source, "/", 2)[1]
+	case GitLab, Cgit:
+		path := s.path()
+
+		name := path[strings.LastIndex(path, "/")+1:]
+
+		return strings.TrimSuffix(name, ".git")
+	case Local:
+		return filepath.Base(s.Source)
+	default:
+		return ""
+	}
+}
+
+// path returns the URL path of GitLab and cgit repositories, without leading
+// or trailing slashes.
+func (s *Repository) path() string {
+	u, err := url.Parse(s.Source)
+	if err != nil {
+		return ""
+	}
+
+	return strings.Trim(u.Path, "/")
+}
+
+// Host returns the base URL of the server hosting the repository.
+func (s *Repository) Host() string {
+	switch s.Type {
+	case GitHub:
+		return strings.TrimSuffix(GitHubBaseURL, "/")
+	case GitLab, Cgit:
+		u, err := url.Parse(s.Source)
+		if err != nil {
+			return ""
+		}
+
+		return u.Scheme + "://" + u.Host
 	default:
 		return ""
 	}
@@ -63,6 +256,8 @@ func (s *Repository) URL() string {
 	switch s.Type {
 	case GitHub:
 		return GitHubBaseURL + s.Source
+	case GitLab, Cgit, Local:
+		return s.Source
 	default:
 		return ""
 	}
@@ -72,6 +267,16 @@ func (s *Repository) CloneURL() string {
 	switch s.Type {
 	case GitHub:
 		return GitHubBaseURL + s.Source + ".git"
+	case GitLab:
+		return s.Source + ".git"
+	case Cgit:
+		if strings.HasPrefix(s.Source, "https://"+savannahHost+"/cgit/") {
+			return strings.Replace(s.Source, "/cgit/", "/git/", 1)
+		}
+
+		return s.Source
+	case Local:
+		return s.Source
 	default:
 		return ""
 	}
@@ -85,6 +290,11 @@ func (s *Repository) TarballURL(ref string) string {
 	switch s.Type {
 	case GitHub:
 		return GitHubBaseURL + s.Source + "/tarball/" + ref
+	case GitLab:
+		return s.Source + "/-/archive/" + ref + "/" +
+			s.Name() + "-" + ref + ".tar.gz"
+	case Cgit:
+		return s.Source + "/snapshot/" + s.Name() + "-" + ref + ".tar.gz"
 	default:
 		return ""
 	}
@@ -98,6 +308,10 @@ func (s *Repository) CommitURL(ref string) string {
 	switch s.Type {
 	case GitHub:
 		return GitHubBaseURL + s.Source + "/commit/" + ref
+	case GitLab:
+		return s.Source + "/-/commit/" + ref
+	case Cgit:
+		return s.Source + "/commit/?id=" + url.QueryEscape(ref)
 	default:
 		return ""
 	}
@@ -111,6 +325,29 @@ func (s *Repository) TreeURL(ref string) string {
 	switch s.Type {
 	case GitHub:
 		return GitHubBaseURL + s.Source + "/tree/" + ref
+	case GitLab:
+		return s.Source + "/-/tree/" + ref
+	case Cgit:
+		return s.Source + "/tree/?h=" + url.QueryEscape(ref)
+	default:
+		return ""
+	}
+}
+
+// CompareURL returns a URL showing the commits between base and head.
+func (s *Repository) CompareURL(base, head string) string {
+	if base == "" || head == "" {
+		return ""
+	}
+
+	switch s.Type {
+	case GitHub:
+		return GitHubBaseURL + s.Source + "/compare/" + base + "..." + head
+	case GitLab:
+		return s.Source + "/-/compare/" + base + "..." + head
+	case Cgit:
+		return s.Source + "/log/?qt=range&q=" +
+			url.QueryEscape(base+".."+head)
 	default:
 		return ""
 	}
@@ -124,6 +361,8 @@ func (s *Repository) ActionRunURL(runID string) string {
 	switch s.Type {
 	case GitHub:
 		return GitHubBaseURL + s.Source + "/actions/runs/" + runID
+	case GitLab:
+		return s.Source + "/-/pipelines/" + runID
 	default:
 		return ""
 	}
@@ -136,6 +375,8 @@ func (s *Repository) ReleaseURL(releaseName string) string {
 	switch s.Type {
 	case GitHub:
 		return GitHubBaseURL + s.Source + "/releases/tag/" + releaseName
+	case GitLab:
+		return s.Source + "/-/releases/" + releaseName
 	default:
 		return ""
 	}
@@ -52,3 +52,206 @@ func TestRepository_ReleaseURL(t *testing.T) {
 		})
 	}
 }
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		s       string
+		want    *Repository
+		wantErr error
+	}{
+		{
+			name: "owner/name",
+			s:    "emacs-mirror/emacs",
+			want: &Repository{Type: GitHub, Source: "emacs-mirror/emacs"},
+		},
+		{
+			name: "github scheme",
+			s:    "github:emacs-mirror/emacs",
+			want: &Repository{Type: GitHub, Source: "emacs-mirror/emacs"},
+		},
+		{
+			name: "github URL",
+			s:    "https://github.com/emacs-mirror/emacs.git",
+			want: &Repository{Type: GitHub, Source: "emacs-mirror/emacs"},
+		},
+		{
+			name: "gitlab scheme",
+			s:    "gitlab:foo/sub/emacs",
+			want: &Repository{
+				Type:   GitLab,
+				Source: "https://gitlab.com/foo/sub/emacs",
+			},
+		},
+		{
+			name: "gitlab URL",
+			s:    "https://gitlab.example.com/foo/emacs.git",
+			want: &Repository{
+				Type:   GitLab,
+				Source: "https://gitlab.example.com/foo/emacs",
+			},
+		},
+		{
+			name: "savannah",
+			s:    "savannah",
+			want: &Repository{Type: Cgit, Source: SavannahURL},
+		},
+		{
+			name: "savannah git URL",
+			s:    "https://git.savannah.gnu.org/git/emacs.git",
+			want: &Repository{Type: Cgit, Source: SavannahURL},
+		},
+		{
+			name: "cgit scheme",
+			s:    "cgit:https://git.example.com/repos/emacs.git",
+			want: &Repository{
+				Type:   Cgit,
+				Source: "https://git.example.com/repos/emacs.git",
+			},
+		},
+		{
+			name: "cgit URL",
+			s:    "https://git.example.com/cgit/emacs.git/",
+			want: &Repository{
+				Type:   Cgit,
+				Source: "https://git.example.com/cgit/emacs.git",
+			},
+		},
+		{
+			name: "file URL",
+			s:    "file://" + dir,
+			want: &Repository{Type: Local, Source: dir},
+		},
+		{
+			name: "absolute path",
+			s:    dir,
+			want: &Repository{Type: Local, Source: dir},
+		},
+		{
+			name:    "missing local path",
+			s:       dir + "/nope",
+			wantErr: ErrLocal,
+		},
+		{
+			name:    "invalid github",
+			s:       "emacs",
+			wantErr: ErrGitHub,
+		},
+		{
+			name:    "unknown URL",
+			s:       "https://example.com/emacs.git",
+			wantErr: ErrParse,
+		},
+		{
+			name:    "empty",
+			s:       "",
+			wantErr: ErrParse,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.s)
+
+			assert.Equal(t, tt.want, got)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRepository_URLs(t *testing.T) {
+	type want struct {
+		owner      string
+		name       string
+		url        string
+		cloneURL   string
+		tarballURL string
+		commitURL  string
+		treeURL    string
+		compareURL string
+	}
+	tests := []struct {
+		name string
+		repo *Repository
+		want want
+	}{
+		{
+			name: "GitHub",
+			repo: &Repository{Type: GitHub, Source: "foo/emacs"},
+			want: want{
+				owner:      "foo",
+				name:       "emacs",
+				url:        "https://github.com/foo/emacs",
+				cloneURL:   "https://github.com/foo/emacs.git",
+				tarballURL: "https://github.com/foo/emacs/tarball/abc",
+				commitURL:  "https://github.com/foo/emacs/commit/abc",
+				treeURL:    "https://github.com/foo/emacs/tree/abc",
+				compareURL: "https://github.com/foo/emacs/compare/abc...def",
+			},
+		},
+		{
+			name: "GitLab",
+			repo: &Repository{
+				Type:   GitLab,
+				Source: "https://gitlab.com/foo/bar/emacs",
+			},
+			want: want{
+				owner:    "foo/bar",
+				name:     "emacs",
+				url:      "https://gitlab.com/foo/bar/emacs",
+				cloneURL: "https://gitlab.com/foo/bar/emacs.git",
+				tarballURL: "https://gitlab.com/foo/bar/emacs/-/archive/abc/" +
+					"emacs-abc.tar.gz",
+				commitURL: "https://gitlab.com/foo/bar/emacs/-/commit/abc",
+				treeURL:   "https://gitlab.com/foo/bar/emacs/-/tree/abc",
+				compareURL: "https://gitlab.com/foo/bar/emacs/-/compare/" +
+					"abc...def",
+			},
+		},
+		{
+			name: "cgit",
+			repo: &Repository{Type: Cgit, Source: SavannahURL},
+			want: want{
+				name:     "emacs",
+				url:      "https://git.savannah.gnu.org/cgit/emacs.git",
+				cloneURL: "https://git.savannah.gnu.org/git/emacs.git",
+				tarballURL: "https://git.savannah.gnu.org/cgit/emacs.git/" +
+					"snapshot/emacs-abc.tar.gz",
+				commitURL: "https://git.savannah.gnu.org/cgit/emacs.git/" +
+					"commit/?id=abc",
+				treeURL: "https://git.savannah.gnu.org/cgit/emacs.git/" +
+					"tree/?h=abc",
+				compareURL: "https://git.savannah.gnu.org/cgit/emacs.git/" +
+					"log/?qt=range&q=abc..def",
+			},
+		},
+		{
+			name: "local",
+			repo: &Repository{Type: Local, Source: "/src/emacs"},
+			want: want{
+				name:     "emacs",
+				url:      "/src/emacs",
+				cloneURL: "/src/emacs",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want.owner, tt.repo.Owner())
+			assert.Equal(t, tt.want.name, tt.repo.Name())
+			assert.Equal(t, tt.want.url, tt.repo.URL())
+			assert.Equal(t, tt.want.cloneURL, tt.repo.CloneURL())
+			assert.Equal(t, tt.want.tarballURL, tt.repo.TarballURL("abc"))
+			assert.Equal(t, tt.want.commitURL, tt.repo.CommitURL("abc"))
+			assert.Equal(t, tt.want.treeURL, tt.repo.TreeURL("abc"))
+			assert.Equal(t,
+				tt.want.compareURL, tt.repo.CompareURL("abc", "def"),
+			)
+		})
+	}
+}
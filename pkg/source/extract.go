@@ -0,0 +1,143 @@
+package source
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath is returned when a tarball entry would be written outside of
+// the target directory.
+var ErrUnsafePath = fmt.Errorf("%w: unsafe path in tarball", Err)
+
+// Extract extracts a gzipped tarball into dir, stripping the top-level
+// directory all entries are placed within, like tarballs from GitHub, GitLab
+// and cgit. The tarball is extracted into a temporary directory first, so dir
+// only exists once extraction has completed successfully.
+func Extract(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", Err, archive, err)
+	}
+	defer gz.Close()
+
+	tmpDir := dir + ".tmp"
+	err = os.RemoveAll(tmpDir)
+	if err != nil {
+		return err
+	}
+
+	err = extractTar(tar.NewReader(gz), tmpDir)
+	if err != nil {
+		_ = os.RemoveAll(tmpDir)
+
+		return err
+	}
+
+	return os.Rename(tmpDir, dir)
+}
+
+func extractTar(tr *tar.Reader, dir string) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: %w", Err, err)
+		}
+
+		rel, ok := stripComponent(hdr.Name)
+		if !ok {
+			continue
+		}
+		if !filepath.IsLocal(rel) {
+			return fmt.Errorf("%w: %s", ErrUnsafePath, hdr.Name)
+		}
+		target := filepath.Join(dir, rel)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0o755)
+		case tar.TypeReg:
+			err = extractFile(tr, hdr, target)
+		case tar.TypeSymlink:
+			linked := filepath.Join(filepath.Dir(rel), hdr.Linkname)
+			if filepath.IsAbs(hdr.Linkname) || !filepath.IsLocal(linked) {
+				return fmt.Errorf("%w: %s -> %s",
+					ErrUnsafePath, hdr.Name, hdr.Linkname,
+				)
+			}
+			err = os.Symlink(hdr.Linkname, target)
+		case tar.TypeLink:
+			linkRel, ok := stripComponent(hdr.Linkname)
+			if !ok || !filepath.IsLocal(linkRel) {
+				return fmt.Errorf("%w: %s -> %s",
+					ErrUnsafePath, hdr.Name, hdr.Linkname,
+				)
+			}
+			err = os.Link(filepath.Join(dir, linkRel), target)
+		default:
+			// Skip pax global headers, which GitHub uses to store the commit
+			// SHA, and any special files.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, hdr *tar.Header, target string) error {
+	err := os.MkdirAll(filepath.Dir(target), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(
+		target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, hdr.FileInfo().Mode().Perm(),
+	)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+// stripComponent removes the top-level directory from a tarball entry name. It
+// returns false for the top-level directory itself.
+func stripComponent(name string) (string, bool) {
+	name = strings.TrimPrefix(name, "./")
+	i := strings.Index(name, "/")
+	if i < 0 || i == len(name)-1 {
+		return "", false
+	}
+
+	return strings.TrimSuffix(name[i+1:], "/"), true
+}
@@ -0,0 +1,355 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+//nolint:golint
+var (
+	Err               = errors.New("source")
+	ErrNoTarball      = fmt.Errorf("%w: no tarball", Err)
+	ErrDigestMismatch = fmt.Errorf("%w: SHA256 digest mismatch", Err)
+	ErrDownload       = fmt.Errorf("%w: download failed", Err)
+)
+
+type FetchOptions struct {
+	// CacheDir is where downloaded tarballs are stored, named by their
+	// SHA256 digest so they can be shared across builds.
+	CacheDir string
+
+	// SourcesDir is where tarballs are extracted into.
+	SourcesDir string
+
+	// Retries is the number of times a failed download is retried.
+	Retries int
+
+	// RetryWait is the wait before the first retry, which doubles with each
+	// following retry. Defaults to 2 seconds.
+	RetryWait time.Duration
+
+	// GithubToken is sent when downloading tarballs from GitHub.
+	GithubToken string
+
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// FetchResult describes the location of a fetched source tarball.
+type FetchResult struct {
+	Archive   string
+	Directory string
+}
+
+// Fetch downloads the tarball of given source into the cache, verifies or
+// records its SHA256 digest in src.Tarball.SHA256, and extracts it into the
+// sources directory.
+func Fetch(
+	ctx context.Context,
+	src *Source,
+	opts *FetchOptions,
+) (*FetchResult, error) {
+	logger := hclog.FromContext(ctx).Named("source")
+
+	if src.Tarball == nil || (src.Tarball.URL == "" && src.Tarball.Path == "") {
+		return nil, ErrNoTarball
+	}
+
+	f := &fetcher{
+		logger:  logger,
+		opts:    opts,
+		client:  opts.HTTPClient,
+		tarball: src.Tarball,
+	}
+	if f.client == nil {
+		f.client = http.DefaultClient
+	}
+
+	var archive string
+	var err error
+	if src.Tarball.Path != "" {
+		archive, err = f.local()
+	} else {
+		archive, err = f.remote(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(opts.SourcesDir, src.ArchiveName())
+	if _, err := os.Stat(dir); err == nil {
+		logger.Info("source directory exists, using it", "dir", dir)
+	} else {
+		logger.Info("extracting tarball", "dir", dir)
+		err = Extract(archive, dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &FetchResult{Archive: archive, Directory: dir}, nil
+}
+
+type fetcher struct {
+	logger  hclog.Logger
+	opts    *FetchOptions
+	client  *http.Client
+	tarball *Tarball
+}
+
+func (s *fetcher) local() (string, error) {
+	s.logger.Info("using local tarball", "file", s.tarball.Path)
+
+	err := s.verify(s.tarball.Path)
+	if err != nil {
+		return "", err
+	}
+
+	return s.tarball.Path, nil
+}
+
+func (s *fetcher) remote(ctx context.Context) (string, error) {
+	if s.tarball.SHA256 != "" {
+		cached := s.cachePath(s.tarball.SHA256)
+		if _, err := os.Stat(cached); err == nil {
+			s.logger.Info("using cached tarball", "file", cached)
+
+			return cached, s.verify(cached)
+		}
+	}
+
+	partialDir := filepath.Join(s.opts.CacheDir, "partial")
+	err := os.MkdirAll(partialDir, 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	urlSum := sha256.Sum256([]byte(s.tarball.URL))
+	partial := filepath.Join(partialDir, hex.EncodeToString(urlSum[:]))
+
+	s.logger.Info("downloading tarball", "url", s.tarball.URL)
+	err = s.downloadWithRetries(ctx, partial)
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := fileSHA256(partial)
+	if err != nil {
+		return "", err
+	}
+
+	if s.tarball.SHA256 != "" && digest != s.tarball.SHA256 {
+		_ = os.Remove(partial)
+
+		return "", fmt.Errorf(
+			"%w: downloaded tarball is %s, expected %s",
+			ErrDigestMismatch, digest, s.tarball.SHA256,
+		)
+	}
+	s.tarball.SHA256 = digest
+
+	cached := s.cachePath(digest)
+	err = os.MkdirAll(filepath.Dir(cached), 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(partial, cached)
+	if err != nil {
+		return "", err
+	}
+	s.logger.Info("cached tarball", "file", cached, "sha256", digest)
+
+	return cached, nil
+}
+
+func (s *fetcher) cachePath(digest string) string {
+	return filepath.Join(s.opts.CacheDir, "sha256", digest+".tgz")
+}
+
+// verify checks the SHA256 digest of given file against the recorded digest,
+// or records it if none has been recorded yet.
+func (s *fetcher) verify(filename string) error {
+	digest, err := fileSHA256(filename)
+	if err != nil {
+		return err
+	}
+
+	if s.tarball.SHA256 == "" {
+		s.tarball.SHA256 = digest
+
+		return nil
+	}
+
+	if digest != s.tarball.SHA256 {
+		return fmt.Errorf(
+			"%w: %s is %s, expected %s",
+			ErrDigestMismatch, filename, digest, s.tarball.SHA256,
+		)
+	}
+
+	return nil
+}
+
+func (s *fetcher) downloadWithRetries(
+	ctx context.Context,
+	filename string,
+) error {
+	wait := s.opts.RetryWait
+	if wait == 0 {
+		wait = 2 * time.Second
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = s.download(ctx, filename)
+		if err == nil {
+			return nil
+		}
+
+		var statusErr *statusError
+		if attempt >= s.opts.Retries ||
+			(errors.As(err, &statusErr) && !statusErr.retryable()) {
+			return err
+		}
+
+		s.logger.Warn("download failed, retrying",
+			"error", err, "attempt", attempt+1, "wait", wait,
+		)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// download fetches the tarball into filename, resuming from the end of the
+// file if it already exists and the server supports range requests.
+func (s *fetcher) download(ctx context.Context, filename string) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := fi.Size()
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, s.tarball.URL, nil,
+	)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		s.logger.Info("resuming download", "offset", offset)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if s.opts.GithubToken != "" && isGitHub(s.tarball.URL) {
+		req.Header.Set("Authorization", "token "+s.opts.GithubToken)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		_, err = f.Seek(offset, io.SeekStart)
+	case http.StatusOK:
+		err = f.Truncate(0)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is either complete, or longer than the tarball.
+		// It is only trusted when its digest is verified afterwards.
+		if s.tarball.SHA256 != "" {
+			return nil
+		}
+
+		s.logger.Warn("can not verify partial download, restarting")
+		err = f.Truncate(0)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		f.Close()
+
+		return s.download(ctx, filename)
+	default:
+		return &statusError{url: s.tarball.URL, status: resp.StatusCode}
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDownload, err)
+	}
+
+	return f.Close()
+}
+
+type statusError struct {
+	url    string
+	status int
+}
+
+func (s *statusError) Error() string {
+	return fmt.Sprintf(
+		"%s: GET %s: %d %s",
+		ErrDownload, s.url, s.status, http.StatusText(s.status),
+	)
+}
+
+func (s *statusError) Unwrap() error {
+	return ErrDownload
+}
+
+func (s *statusError) retryable() bool {
+	return s.status >= 500 ||
+		s.status == http.StatusTooManyRequests ||
+		s.status == http.StatusRequestTimeout
+}
+
+func isGitHub(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	return u.Host == "github.com" || u.Host == "api.github.com"
+}
+
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,359 @@
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
+	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	name     string
+	typ      byte
+	body     string
+	linkname string
+}
+
+func buildTarball(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typ,
+			Linkname: e.linkname,
+			Mode:     0o644,
+			Size:     int64(len(e.body)),
+			ModTime:  time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		}
+		if e.typ == tar.TypeDir {
+			hdr.Mode = 0o755
+		}
+		if e.typ == tar.TypeXGlobalHeader {
+			hdr = &tar.Header{
+				Name:       e.name,
+				Typeflag:   e.typ,
+				PAXRecords: map[string]string{"comment": "abc"},
+			}
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		if e.body != "" {
+			_, err := tw.Write([]byte(e.body))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	return buf.Bytes()
+}
+
+func sum(b []byte) string {
+	s := sha256.Sum256(b)
+
+	return hex.EncodeToString(s[:])
+}
+
+var emacsTarball = []tarEntry{
+	{name: "pax_global_header", typ: tar.TypeXGlobalHeader},
+	{name: "emacs-mirror-emacs-abc1234/", typ: tar.TypeDir},
+	{name: "emacs-mirror-emacs-abc1234/README", typ: tar.TypeReg, body: "hi\n"},
+	{name: "emacs-mirror-emacs-abc1234/src/", typ: tar.TypeDir},
+	{
+		name: "emacs-mirror-emacs-abc1234/src/emacs.c",
+		typ:  tar.TypeReg,
+		body: "int main() {}\n",
+	},
+	{
+		name:     "emacs-mirror-emacs-abc1234/src/README",
+		typ:      tar.TypeSymlink,
+		linkname: "../README",
+	},
+}
+
+type tarballServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	content  []byte
+	requests []*http.Request
+	failures int
+}
+
+func newTarballServer(t *testing.T, content []byte) *tarballServer {
+	t.Helper()
+
+	s := &tarballServer{content: content}
+	s.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			s.mu.Lock()
+			s.requests = append(s.requests, r)
+			fail := s.failures > 0
+			if fail {
+				s.failures--
+			}
+			s.mu.Unlock()
+
+			if fail {
+				w.WriteHeader(http.StatusBadGateway)
+
+				return
+			}
+			http.ServeContent(
+				w, r, "emacs.tgz", time.Time{}, bytes.NewReader(s.content),
+			)
+		},
+	))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func newTestSource(tarballURL string) *Source {
+	return &Source{
+		Repository: &repository.Repository{
+			Type:   repository.GitHub,
+			Source: "emacs-mirror/emacs",
+		},
+		Commit:  &commit.Commit{SHA: "abc1234def5678"},
+		Tarball: &Tarball{URL: tarballURL},
+	}
+}
+
+func newFetchOptions(t *testing.T) *FetchOptions {
+	t.Helper()
+	dir := t.TempDir()
+
+	return &FetchOptions{
+		CacheDir:   filepath.Join(dir, "cache"),
+		SourcesDir: filepath.Join(dir, "sources"),
+		Retries:    2,
+		RetryWait:  time.Millisecond,
+	}
+}
+
+func TestFetch(t *testing.T) {
+	content := buildTarball(t, emacsTarball)
+	srv := newTarballServer(t, content)
+	opts := newFetchOptions(t)
+	src := newTestSource(srv.URL + "/tarball/abc1234def5678")
+
+	got, err := Fetch(context.Background(), src, opts)
+	require.NoError(t, err)
+
+	assert.Equal(t, sum(content), src.Tarball.SHA256)
+	assert.Equal(t,
+		filepath.Join(opts.CacheDir, "sha256", sum(content)+".tgz"),
+		got.Archive,
+	)
+	assert.Equal(t,
+		filepath.Join(opts.SourcesDir, "emacs-mirror-emacs-abc1234"),
+		got.Directory,
+	)
+
+	b, err := os.ReadFile(filepath.Join(got.Directory, "src/emacs.c"))
+	require.NoError(t, err)
+	assert.Equal(t, "int main() {}\n", string(b))
+	link, err := os.Readlink(filepath.Join(got.Directory, "src/README"))
+	require.NoError(t, err)
+	assert.Equal(t, "../README", link)
+	assert.NoFileExists(t, filepath.Join(opts.SourcesDir, "pax_global_header"))
+
+	// Fetching again uses the cache without any requests.
+	require.NoError(t, os.RemoveAll(got.Directory))
+	_, err = Fetch(context.Background(), src, opts)
+	require.NoError(t, err)
+	assert.Len(t, srv.requests, 1)
+	assert.FileExists(t, filepath.Join(got.Directory, "README"))
+
+	// A cached tarball which no longer matches the recorded digest fails.
+	require.NoError(t, os.WriteFile(got.Archive, []byte("oops"), 0o644))
+	_, err = Fetch(context.Background(), src, opts)
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+}
+
+func TestFetch_resumeAndRetry(t *testing.T) {
+	content := buildTarball(t, emacsTarball)
+	srv := newTarballServer(t, content)
+	srv.failures = 2
+	opts := newFetchOptions(t)
+	src := newTestSource(srv.URL + "/tarball/abc1234def5678")
+
+	partial := filepath.Join(
+		opts.CacheDir, "partial", sum([]byte(src.Tarball.URL)),
+	)
+	require.NoError(t, os.MkdirAll(filepath.Dir(partial), 0o755))
+	require.NoError(t, os.WriteFile(partial, content[:100], 0o644))
+
+	_, err := Fetch(context.Background(), src, opts)
+	require.NoError(t, err)
+
+	require.Len(t, srv.requests, 3)
+	assert.Equal(t, "bytes=100-", srv.requests[2].Header.Get("Range"))
+	assert.Equal(t, sum(content), src.Tarball.SHA256)
+	assert.NoFileExists(t, partial)
+}
+
+func TestFetch_resumeComplete(t *testing.T) {
+	content := buildTarball(t, emacsTarball)
+
+	tests := []struct {
+		name         string
+		partial      []byte
+		sha256       string
+		wantRequests int
+	}{
+		{
+			name:         "verified",
+			partial:      content,
+			sha256:       sum(content),
+			wantRequests: 1,
+		},
+		{
+			name:         "unverified",
+			partial:      content,
+			wantRequests: 2,
+		},
+		{
+			name:         "unverified and too long",
+			partial:      append(append([]byte{}, content...), "junk"...),
+			wantRequests: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTarballServer(t, content)
+			opts := newFetchOptions(t)
+			src := newTestSource(srv.URL + "/tarball/abc1234def5678")
+			src.Tarball.SHA256 = tt.sha256
+
+			partial := filepath.Join(
+				opts.CacheDir, "partial", sum([]byte(src.Tarball.URL)),
+			)
+			require.NoError(t, os.MkdirAll(filepath.Dir(partial), 0o755))
+			require.NoError(t, os.WriteFile(partial, tt.partial, 0o644))
+
+			_, err := Fetch(context.Background(), src, opts)
+			require.NoError(t, err)
+
+			require.Len(t, srv.requests, tt.wantRequests)
+			assert.NotEmpty(t, srv.requests[0].Header.Get("Range"))
+			if tt.wantRequests > 1 {
+				assert.Empty(t, srv.requests[1].Header.Get("Range"))
+			}
+			assert.Equal(t, sum(content), src.Tarball.SHA256)
+		})
+	}
+}
+
+func TestFetch_errors(t *testing.T) {
+	content := buildTarball(t, emacsTarball)
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		srv := newTarballServer(t, content)
+		src := newTestSource(srv.URL + "/tarball/abc1234def5678")
+		src.Tarball.SHA256 = sum([]byte("something else"))
+
+		_, err := Fetch(context.Background(), src, newFetchOptions(t))
+		assert.ErrorIs(t, err, ErrDigestMismatch)
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		srv := newTarballServer(t, content)
+		srv.failures = 5
+		src := newTestSource(srv.URL + "/tarball/abc1234def5678")
+
+		_, err := Fetch(context.Background(), src, newFetchOptions(t))
+		assert.ErrorIs(t, err, ErrDownload)
+		assert.Len(t, srv.requests, 3)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+		src := newTestSource(srv.URL + "/tarball/abc1234def5678")
+
+		_, err := Fetch(context.Background(), src, newFetchOptions(t))
+		assert.ErrorIs(t, err, ErrDownload)
+	})
+
+	t.Run("no tarball", func(t *testing.T) {
+		src := newTestSource("")
+
+		_, err := Fetch(context.Background(), src, newFetchOptions(t))
+		assert.ErrorIs(t, err, ErrNoTarball)
+	})
+}
+
+func TestFetch_localPath(t *testing.T) {
+	content := buildTarball(t, emacsTarball)
+	opts := newFetchOptions(t)
+	archive := filepath.Join(t.TempDir(), "emacs-mirror-emacs-abc1234.tgz")
+	require.NoError(t, os.WriteFile(archive, content, 0o644))
+
+	src := newTestSource("")
+	src.Tarball.Path = archive
+
+	got, err := Fetch(context.Background(), src, opts)
+	require.NoError(t, err)
+	assert.Equal(t, archive, got.Archive)
+	assert.Equal(t, sum(content), src.Tarball.SHA256)
+	assert.FileExists(t, filepath.Join(got.Directory, "src/emacs.c"))
+}
+
+func TestExtract_unsafe(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{
+			name: "parent directory",
+			entries: []tarEntry{
+				{name: "emacs/../../evil", typ: tar.TypeReg, body: "x"},
+			},
+		},
+		{
+			name: "absolute symlink",
+			entries: []tarEntry{
+				{name: "emacs/link", typ: tar.TypeSymlink, linkname: "/etc"},
+			},
+		},
+		{
+			name: "escaping symlink",
+			entries: []tarEntry{
+				{name: "emacs/link", typ: tar.TypeSymlink, linkname: "../.."},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "evil.tgz")
+			require.NoError(t, os.WriteFile(
+				archive, buildTarball(t, tt.entries), 0o644,
+			))
+
+			err := Extract(archive, filepath.Join(dir, "out"))
+
+			assert.ErrorIs(t, err, ErrUnsafePath)
+			assert.NoDirExists(t, filepath.Join(dir, "out"))
+			assert.NoDirExists(t, filepath.Join(dir, "out.tmp"))
+		})
+	}
+}
@@ -3,6 +3,7 @@ package source
 import (
 	"github.com/jimeh/build-emacs-for-macos/pkg/commit"
 	"github.com/jimeh/build-emacs-for-macos/pkg/repository"
+	"github.com/jimeh/build-emacs-for-macos/pkg/sanitize"
 )
 
 type Source struct {
@@ -12,6 +13,27 @@ type Source struct {
 	Tarball    *Tarball               `yaml:"tarball,omitempty" json:"tarball,omitempty"`
 }
 
+// ArchiveName returns the name used for source tarballs and the directory they
+// are extracted into, matching the naming used by the build script, for
+// example "emacs-mirror-emacs-0a1b2c3".
+func (s *Source) ArchiveName() string {
+	name := "emacs"
+	if s.Repository != nil {
+		name = s.Repository.Name()
+		if owner := s.Repository.Owner(); owner != "" {
+			name = owner + "/" + name
+		}
+	}
+
+	if s.Commit != nil && len(s.Commit.SHA) >= 7 {
+		name += "-" + s.Commit.ShortSHA()
+	}
+
+	return sanitize.String(name)
+}
+
 type Tarball struct {
-	URL string `yaml:"url,omitempty" json:"url,omitempty"`
+	URL    string `yaml:"url,omitempty" json:"url,omitempty"`
+	Path   string `yaml:"path,omitempty" json:"path,omitempty"`
+	SHA256 string `yaml:"sha256,omitempty" json:"sha256,omitempty"`
 }
@@ -0,0 +1,231 @@
+// Package sparkle signs and verifies update archives with Ed25519 keys, in
+// the format used by Sparkle's sign_update tool and appcasts.
+package sparkle
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//nolint:golint
+var (
+	Err                 = errors.New("sparkle")
+	ErrInvalidKey       = fmt.Errorf("%w: invalid key", Err)
+	ErrInvalidSignature = fmt.Errorf("%w: invalid signature", Err)
+	ErrVerification     = fmt.Errorf("%w: verification failed", Err)
+)
+
+// SignatureSuffix is appended to filenames to form the filename of their
+// signature file.
+const SignatureSuffix = ".edsig"
+
+// GenerateKey generates a new Ed25519 key pair.
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// EncodePrivateKey returns the base64 encoded seed of given private key, the
+// format exported by Sparkle's generate_keys tool.
+func EncodePrivateKey(key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(key.Seed())
+}
+
+// EncodePublicKey returns given public key base64 encoded, as used for the
+// SUPublicEDKey value in an application's Info.plist.
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// ParsePrivateKey parses a base64 encoded private key, either a 32 byte seed,
+// or a 64 byte seed followed by its public key.
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		key := ed25519.NewKeyFromSeed(b[:ed25519.SeedSize])
+		if !bytes.Equal(key, b) {
+			return nil, fmt.Errorf(
+				"%w: public key does not match private key", ErrInvalidKey,
+			)
+		}
+
+		return key, nil
+	default:
+		return nil, fmt.Errorf(
+			"%w: unexpected private key length %d", ErrInvalidKey, len(b),
+		)
+	}
+}
+
+// ParsePublicKey parses a base64 encoded public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf(
+			"%w: unexpected public key length %d", ErrInvalidKey, len(b),
+		)
+	}
+
+	return ed25519.PublicKey(b), nil
+}
+
+// LoadPrivateKey reads a base64 encoded private key from given file.
+func LoadPrivateKey(filename string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePrivateKey(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return key, nil
+}
+
+// Signature is the Ed25519 signature of a file, along with the file's length.
+type Signature struct {
+	// EdSignature is the base64 encoded signature.
+	EdSignature string
+	Length      int64
+}
+
+var signatureMatcher = regexp.MustCompile(
+	`sparkle:edSignature="([^"]*)"\s+length="(\d+)"`,
+)
+
+// ParseSignature parses a signature in the format produced by
+// Signature.String.
+func ParseSignature(s string) (*Signature, error) {
+	m := signatureMatcher.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSignature, s)
+	}
+
+	length, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	return &Signature{EdSignature: m[1], Length: length}, nil
+}
+
+// LoadSignature reads a signature from given file.
+func LoadSignature(filename string) (*Signature, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := ParseSignature(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return sig, nil
+}
+
+// String returns the signature as appcast enclosure attributes, in the same
+// format as Sparkle's sign_update tool.
+func (s *Signature) String() string {
+	return fmt.Sprintf(
+		`sparkle:edSignature="%s" length="%d"`, s.EdSignature, s.Length,
+	)
+}
+
+// Save writes the signature to given filename.
+func (s *Signature) Save(filename string) error {
+	return os.WriteFile( //nolint:gosec
+		filename, []byte(s.String()+"\n"), 0o644,
+	)
+}
+
+// Sign returns the signature of the content read from r.
+func Sign(r io.Reader, key ed25519.PrivateKey) (*Signature, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signature{
+		EdSignature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, b)),
+		Length:      int64(len(b)),
+	}, nil
+}
+
+// SignFile returns the signature of given file.
+func SignFile(filename string, key ed25519.PrivateKey) (*Signature, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Sign(f, key)
+}
+
+// Verify verifies the content read from r against given signature. It returns
+// an error wrapping ErrVerification if the content does not match.
+func Verify(r io.Reader, key ed25519.PublicKey, sig *Signature) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if int64(len(b)) != sig.Length {
+		return fmt.Errorf(
+			"%w: length is %d, expected %d", ErrVerification, len(b),
+			sig.Length,
+		)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(sig.EdSignature)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	if !ed25519.Verify(key, b, raw) {
+		return fmt.Errorf("%w: signature does not match", ErrVerification)
+	}
+
+	return nil
+}
+
+// VerifyFile verifies given file against given signature.
+func VerifyFile(
+	filename string,
+	key ed25519.PublicKey,
+	sig *Signature,
+) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = Verify(f, key, sig)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return nil
+}
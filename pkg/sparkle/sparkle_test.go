@@ -0,0 +1,208 @@
+package sparkle
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc8032 returns the key of the first test vector in RFC 8032, section 7.1.
+func rfc8032(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	seed, err := hex.DecodeString(
+		"9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60",
+	)
+	require.NoError(t, err)
+
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+func TestSign(t *testing.T) {
+	key := rfc8032(t)
+
+	sig, err := Sign(strings.NewReader(""), key)
+	require.NoError(t, err)
+
+	want, err := hex.DecodeString(
+		"e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e06522490155" +
+			"5fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b",
+	)
+	require.NoError(t, err)
+	assert.Equal(t, &Signature{
+		EdSignature: base64.StdEncoding.EncodeToString(want),
+		Length:      0,
+	}, sig)
+	assert.Equal(t,
+		`sparkle:edSignature="`+sig.EdSignature+`" length="0"`, sig.String(),
+	)
+}
+
+func TestVerify(t *testing.T) {
+	key := rfc8032(t)
+	pub := key.Public().(ed25519.PublicKey)
+	other, _, err := GenerateKey()
+	require.NoError(t, err)
+
+	sig, err := Sign(strings.NewReader("hello world"), key)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		content string
+		key     ed25519.PublicKey
+		sig     *Signature
+		wantErr error
+	}{
+		{
+			name:    "valid",
+			content: "hello world",
+			key:     pub,
+			sig:     sig,
+		},
+		{
+			name:    "modified content",
+			content: "hello World",
+			key:     pub,
+			sig:     sig,
+			wantErr: ErrVerification,
+		},
+		{
+			name:    "wrong length",
+			content: "hello world!",
+			key:     pub,
+			sig:     sig,
+			wantErr: ErrVerification,
+		},
+		{
+			name:    "wrong key",
+			content: "hello world",
+			key:     other,
+			sig:     sig,
+			wantErr: ErrVerification,
+		},
+		{
+			name:    "malformed signature",
+			content: "hello world",
+			key:     pub,
+			sig:     &Signature{EdSignature: "%%%", Length: 11},
+			wantErr: ErrInvalidSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(strings.NewReader(tt.content), tt.key, tt.sig)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key := rfc8032(t)
+
+	tests := []struct {
+		name    string
+		s       string
+		want    ed25519.PrivateKey
+		wantErr error
+	}{
+		{
+			name: "seed",
+			s:    EncodePrivateKey(key) + "\n",
+			want: key,
+		},
+		{
+			name: "seed and public key",
+			s:    base64.StdEncoding.EncodeToString(key),
+			want: key,
+		},
+		{
+			name: "mismatched public key",
+			s: base64.StdEncoding.EncodeToString(
+				append(key.Seed(), make([]byte, 32)...),
+			),
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "wrong length",
+			s:       base64.StdEncoding.EncodeToString([]byte("short")),
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "not base64",
+			s:       "not a key!",
+			wantErr: ErrInvalidKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePrivateKey(tt.s)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub := rfc8032(t).Public().(ed25519.PublicKey)
+
+	got, err := ParsePublicKey(EncodePublicKey(pub))
+	require.NoError(t, err)
+	assert.Equal(t, pub, got)
+	assert.Equal(t,
+		"d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a",
+		hex.EncodeToString(got),
+	)
+
+	_, err = ParsePublicKey(EncodePrivateKey(rfc8032(t)) + "AAAA")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestSignFile(t *testing.T) {
+	key := rfc8032(t)
+	file := filepath.Join(t.TempDir(), "Emacs.dmg")
+	require.NoError(t, os.WriteFile(file, []byte("hello world"), 0o644))
+
+	sig, err := SignFile(file, key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), sig.Length)
+
+	require.NoError(t, sig.Save(file+SignatureSuffix))
+	loaded, err := LoadSignature(file + SignatureSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, sig, loaded)
+
+	pub := key.Public().(ed25519.PublicKey)
+	assert.NoError(t, VerifyFile(file, pub, loaded))
+
+	require.NoError(t, os.WriteFile(file, []byte("hello World"), 0o644))
+	assert.ErrorIs(t, VerifyFile(file, pub, loaded), ErrVerification)
+}
+
+func TestParseSignature(t *testing.T) {
+	sig, err := ParseSignature(
+		`sparkle:edSignature="c2lnbmF0dXJl" length="1024"` + "\n",
+	)
+	require.NoError(t, err)
+	assert.Equal(t, &Signature{EdSignature: "c2lnbmF0dXJl", Length: 1024}, sig)
+
+	_, err = ParseSignature("c2lnbmF0dXJl")
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}